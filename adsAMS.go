@@ -0,0 +1,216 @@
+package benthosADS
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ADS command IDs carried in the AMS header.
+const (
+	adsCmdReadDeviceInfo     uint16 = 1
+	adsCmdRead               uint16 = 2
+	adsCmdWrite              uint16 = 3
+	adsCmdReadState          uint16 = 4
+	adsCmdWriteControl       uint16 = 5
+	adsCmdAddNotification    uint16 = 6
+	adsCmdDeleteNotification uint16 = 7
+	adsCmdDeviceNotification uint16 = 8
+	adsCmdReadWrite          uint16 = 9
+)
+
+// AMS header state flags.
+const (
+	amsFlagResponse   uint16 = 0x0001
+	amsFlagADSCommand uint16 = 0x0004
+)
+
+// AMS/TCP header commands (the "reserved" field). Plain AMS packets use 0; the others are
+// used between ADS clients and a local router.
+const (
+	amsTCPCommand       uint16 = 0x0000
+	amsTCPPortClose     uint16 = 0x1000
+	amsTCPPortConnect   uint16 = 0x1001
+	amsTCPGetLocalNetID uint16 = 0x1008
+)
+
+const (
+	amsTCPHeaderLen = 6
+	amsHeaderLen    = 32
+	amsMaxPacketLen = 16 << 20
+)
+
+// AMS router error codes, returned in the AMS header.
+const (
+	amsErrTargetPortNotFound    uint32 = 0x06
+	amsErrTargetMachineNotFound uint32 = 0x07
+	amsErrClientTimeout         uint32 = 0x745
+)
+
+// ADS device error codes returned by servers.
+const (
+	adsErrDeviceError          uint32 = 0x700
+	adsErrDeviceSrvNotSupp     uint32 = 0x701
+	adsErrDeviceInvalidGroup   uint32 = 0x702
+	adsErrDeviceInvalidOffset  uint32 = 0x703
+	adsErrDeviceInvalidAccess  uint32 = 0x704
+	adsErrDeviceInvalidSize    uint32 = 0x705
+	adsErrDeviceInvalidData    uint32 = 0x706
+	adsErrDeviceNotReady       uint32 = 0x707
+	adsErrDeviceSymbolNotFound uint32 = 0x710
+	adsErrDeviceNotifyHandle   uint32 = 0x714
+	adsErrDeviceTimeout        uint32 = 0x719
+)
+
+// amsNetID is a 6 byte AMS net ID such as 192.168.1.100.1.1.
+type amsNetID [6]byte
+
+func parseAMSNetID(s string) (amsNetID, error) {
+	var id amsNetID
+	if err := validateAMSNetID(s); err != nil {
+		return id, err
+	}
+	for i, p := range strings.Split(s, ".") {
+		v, _ := strconv.Atoi(p)
+		id[i] = byte(v)
+	}
+	return id, nil
+}
+
+func (id amsNetID) String() string {
+	return fmt.Sprintf("%d.%d.%d.%d.%d.%d", id[0], id[1], id[2], id[3], id[4], id[5])
+}
+
+// amsAddr is an AMS net ID and port.
+type amsAddr struct {
+	netID amsNetID
+	port  uint16
+}
+
+func (a amsAddr) String() string {
+	return fmt.Sprintf("%s:%d", a.netID, a.port)
+}
+
+// amsPacket is one AMS/TCP frame. tcpCommand is 0 for regular AMS packets, whose header
+// fields are decoded; for router commands only data is used.
+type amsPacket struct {
+	tcpCommand uint16
+	target     amsAddr
+	source     amsAddr
+	command    uint16
+	stateFlags uint16
+	errorCode  uint32
+	invokeID   uint32
+	data       []byte
+}
+
+func (p *amsPacket) isResponse() bool {
+	return p.stateFlags&amsFlagResponse != 0
+}
+
+// readAMSPacket reads one AMS/TCP frame from r.
+func readAMSPacket(r io.Reader) (*amsPacket, error) {
+	var hdr [amsTCPHeaderLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	p := &amsPacket{tcpCommand: le.Uint16(hdr[0:])}
+	length := le.Uint32(hdr[2:])
+	if length > amsMaxPacketLen {
+		return nil, fmt.Errorf("AMS packet of %d bytes exceeds limit", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	if p.tcpCommand != amsTCPCommand {
+		p.data = body
+		return p, nil
+	}
+	if len(body) < amsHeaderLen {
+		return nil, fmt.Errorf("AMS packet too short: %d bytes", len(body))
+	}
+	copy(p.target.netID[:], body[0:6])
+	p.target.port = le.Uint16(body[6:])
+	copy(p.source.netID[:], body[8:14])
+	p.source.port = le.Uint16(body[14:])
+	p.command = le.Uint16(body[16:])
+	p.stateFlags = le.Uint16(body[18:])
+	dataLen := le.Uint32(body[20:])
+	p.errorCode = le.Uint32(body[24:])
+	p.invokeID = le.Uint32(body[28:])
+	if int(dataLen) > len(body)-amsHeaderLen {
+		return nil, errors.New("AMS data length exceeds packet")
+	}
+	p.data = body[amsHeaderLen : amsHeaderLen+int(dataLen)]
+	return p, nil
+}
+
+// marshal encodes the packet including its AMS/TCP header.
+func (p *amsPacket) marshal() []byte {
+	le := binary.LittleEndian
+	if p.tcpCommand != amsTCPCommand {
+		buf := make([]byte, amsTCPHeaderLen+len(p.data))
+		le.PutUint16(buf[0:], p.tcpCommand)
+		le.PutUint32(buf[2:], uint32(len(p.data)))
+		copy(buf[amsTCPHeaderLen:], p.data)
+		return buf
+	}
+	buf := make([]byte, amsTCPHeaderLen+amsHeaderLen+len(p.data))
+	le.PutUint32(buf[2:], uint32(amsHeaderLen+len(p.data)))
+	h := buf[amsTCPHeaderLen:]
+	copy(h[0:6], p.target.netID[:])
+	le.PutUint16(h[6:], p.target.port)
+	copy(h[8:14], p.source.netID[:])
+	le.PutUint16(h[14:], p.source.port)
+	le.PutUint16(h[16:], p.command)
+	le.PutUint16(h[18:], p.stateFlags)
+	le.PutUint32(h[20:], uint32(len(p.data)))
+	le.PutUint32(h[24:], p.errorCode)
+	le.PutUint32(h[28:], p.invokeID)
+	copy(h[amsHeaderLen:], p.data)
+	return buf
+}
+
+// response builds the reply to request p with the given AMS error code and ADS payload.
+func (p *amsPacket) response(errorCode uint32, data []byte) *amsPacket {
+	return &amsPacket{
+		target:     p.source,
+		source:     p.target,
+		command:    p.command,
+		stateFlags: amsFlagResponse | amsFlagADSCommand,
+		errorCode:  errorCode,
+		invokeID:   p.invokeID,
+		data:       data,
+	}
+}
+
+// adsResult encodes an ADS result code followed by optional payload, the layout used by
+// every ADS response.
+func adsResult(code uint32, payload ...[]byte) []byte {
+	buf := binary.LittleEndian.AppendUint32(nil, code)
+	for _, p := range payload {
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+// amsConn is a TCP connection carrying AMS packets. Writes are serialised so responses
+// and notifications from several goroutines never interleave.
+type amsConn struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+}
+
+func (c *amsConn) send(p *amsPacket) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(p.marshal())
+	return err
+}
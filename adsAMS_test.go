@@ -0,0 +1,124 @@
+package benthosADS
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAMSPacketRoundTrip(t *testing.T) {
+	plc, _ := parseAMSNetID("5.80.201.232.1.1")
+	host, _ := parseAMSNetID("192.168.1.20.1.1")
+	packets := []*amsPacket{
+		{
+			target:     amsAddr{netID: plc, port: 851},
+			source:     amsAddr{netID: host, port: 32905},
+			command:    adsCmdRead,
+			stateFlags: amsFlagADSCommand,
+			invokeID:   7,
+			data:       []byte{0x40, 0x40, 0, 0, 0x10, 0, 0, 0, 2, 0, 0, 0},
+		},
+		{
+			target:     amsAddr{netID: host, port: 32905},
+			source:     amsAddr{netID: plc, port: 851},
+			command:    adsCmdDeviceNotification,
+			stateFlags: amsFlagResponse | amsFlagADSCommand,
+			errorCode:  amsErrClientTimeout,
+			invokeID:   0xFFFFFFFF,
+			data:       []byte{},
+		},
+		{tcpCommand: amsTCPGetLocalNetID, data: host[:]},
+		{tcpCommand: amsTCPPortConnect, data: []byte{0, 0}},
+	}
+	var stream bytes.Buffer
+	for _, p := range packets {
+		stream.Write(p.marshal())
+	}
+	for i, want := range packets {
+		got, err := readAMSPacket(&stream)
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("packet %d = %+v, want %+v", i, got, want)
+		}
+	}
+	if _, err := readAMSPacket(&stream); !errors.Is(err, io.EOF) {
+		t.Errorf("end of stream: error = %v, want EOF", err)
+	}
+}
+
+func TestAMSPacketLayout(t *testing.T) {
+	p := &amsPacket{
+		target:     amsAddr{netID: amsNetID{1, 2, 3, 4, 1, 1}, port: 851},
+		source:     amsAddr{netID: amsNetID{10, 0, 0, 9, 1, 1}, port: 0x8000},
+		command:    adsCmdReadState,
+		stateFlags: amsFlagADSCommand,
+		invokeID:   0x01020304,
+	}
+	want := []byte{
+		0, 0, 32, 0, 0, 0, // AMS/TCP header: command 0, length 32
+		1, 2, 3, 4, 1, 1, 0x53, 0x03, // target
+		10, 0, 0, 9, 1, 1, 0x00, 0x80, // source
+		4, 0, 4, 0, // command, state flags
+		0, 0, 0, 0, 0, 0, 0, 0, // data length, error code
+		4, 3, 2, 1, // invoke ID
+	}
+	if got := p.marshal(); !bytes.Equal(got, want) {
+		t.Errorf("marshal = % x\nwant      % x", got, want)
+	}
+
+	r := p.response(0, adsResult(adsErrDeviceSymbolNotFound))
+	if r.target != p.source || r.source != p.target || !r.isResponse() || r.invokeID != p.invokeID {
+		t.Errorf("response = %+v", r)
+	}
+	if !bytes.Equal(r.data, []byte{0x10, 0x07, 0, 0}) {
+		t.Errorf("response data = % x", r.data)
+	}
+}
+
+func TestReadAMSPacketErrors(t *testing.T) {
+	valid := (&amsPacket{command: adsCmdRead, data: []byte{1, 2, 3, 4}}).marshal()
+	tests := map[string]struct {
+		data []byte
+		want string
+	}{
+		"too long": {func() []byte {
+			b := append([]byte(nil), valid...)
+			binary.LittleEndian.PutUint32(b[2:], amsMaxPacketLen+1)
+			return b
+		}(), "exceeds limit"},
+		"too short": {func() []byte {
+			b := append([]byte(nil), valid[:amsTCPHeaderLen+20]...)
+			binary.LittleEndian.PutUint32(b[2:], 20)
+			return b
+		}(), "too short"},
+		"data length past end": {func() []byte {
+			b := append([]byte(nil), valid...)
+			binary.LittleEndian.PutUint32(b[amsTCPHeaderLen+20:], 5)
+			return b
+		}(), "exceeds packet"},
+		"truncated body": {valid[:len(valid)-1], "unexpected EOF"},
+	}
+	for name, tt := range tests {
+		if _, err := readAMSPacket(bytes.NewReader(tt.data)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", name, err, tt.want)
+		}
+	}
+}
+
+func TestParseAMSNetID(t *testing.T) {
+	id, err := parseAMSNetID("192.168.1.100.1.1")
+	if err != nil || id != (amsNetID{192, 168, 1, 100, 1, 1}) || id.String() != "192.168.1.100.1.1" {
+		t.Errorf("parseAMSNetID = %v, %v", id, err)
+	}
+	for _, s := range []string{"", "192.168.1.100", "192.168.1.100.1.1.1", "192.168.1.256.1.1", "a.b.c.d.e.f", "1.2.3.4.-1.1"} {
+		if _, err := parseAMSNetID(s); err == nil {
+			t.Errorf("parseAMSNetID(%q): expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package benthosADS
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	adsLib "github.com/RuneRoven/go-ads/v2"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// CLICommand returns the ads command for the Benthos CLI. Its subcommands inspect a PLC with
+// the connection settings of the ads components, given as flags or read from a config file:
+//
+//	service.RunCLI(ctx, service.CLIOptAddCommand(benthosADS.CLICommand()))
+func CLICommand() *cli.Command {
+	return &cli.Command{
+		Name:  "ads",
+		Usage: "Browse, read and write the symbols of a Beckhoff PLC",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "browse",
+				Usage:     "List the PLC's symbols with their types",
+				ArgsUsage: "[pattern...]",
+				Flags: cliFlags(
+					&cli.IntFlag{Name: "members", Usage: "Also list struct members, this many levels deep"},
+				),
+				Action: cliBrowse,
+			},
+			{
+				Name:      "read",
+				Usage:     "Read the current value of symbols",
+				ArgsUsage: "<symbol>...",
+				Flags: cliFlags(
+					&cli.BoolFlag{Name: "loadSymbols", Usage: "Download the symbol table first, required for struct and array symbols"},
+				),
+				Action: cliRead,
+			},
+			{
+				Name:      "watch",
+				Usage:     "Subscribe to symbols and print every change until interrupted",
+				ArgsUsage: "<symbol>...",
+				Flags: cliFlags(
+					&cli.BoolFlag{Name: "loadSymbols", Usage: "Download the symbol table first, required for struct and array symbols"},
+					&cli.IntFlag{Name: "cycleTime", Usage: "Interval in milliseconds the PLC checks the symbols for changes", Value: 100},
+					&cli.IntFlag{Name: "maxDelay", Usage: "Max delay in milliseconds before the PLC sends a change", Value: 100},
+					&cli.BoolFlag{Name: "cyclic", Usage: "Print a sample every cycle instead of only changes"},
+				),
+				Action: cliWatch,
+			},
+			{
+				Name:      "write",
+				Usage:     "Write a value to a symbol and print the value read back",
+				ArgsUsage: "<symbol> <value>",
+				Flags:     cliFlags(),
+				Action:    cliWrite,
+			},
+			{
+				Name:  "generate",
+				Usage: "Write an ads input config for the PLC's symbols",
+				Flags: cliFlags(
+					&cli.StringSliceFlag{Name: "include", Usage: "Symbol name patterns to include, all symbols if not set"},
+					&cli.StringSliceFlag{Name: "exclude", Usage: "Symbol name patterns to leave out"},
+					&cli.StringSliceFlag{Name: "type", Usage: "Type patterns to include, matched on the type and its base type"},
+					&cli.StringSliceFlag{Name: "attribute", Usage: "Include symbols with this PLC attribute, as name or name=value"},
+					&cli.IntFlag{Name: "maxSize", Usage: "Leave out symbols larger than this many bytes"},
+					&cli.StringFlag{Name: "readType", Usage: "readType of the generated input, notification or interval", Value: "notification"},
+					&cli.StringFlag{Name: "output", Usage: "File to write the config to instead of stdout"},
+				),
+				Action: cliGenerate,
+			},
+			{
+				Name:      "diff",
+				Usage:     "Compare symbols with the PLC's symbol table and a saved snapshot",
+				ArgsUsage: "[symbol...]",
+				Flags: cliFlags(
+					&cli.StringFlag{Name: "snapshot", Usage: "Snapshot file to compare types and sizes with"},
+					&cli.StringFlag{Name: "save", Usage: "Save a snapshot of the symbols to this file"},
+				),
+				Action: cliDiff,
+			},
+			{
+				Name:   "info",
+				Usage:  "Show the PLC's state, device info and symbol table size",
+				Flags:  cliFlags(),
+				Action: cliInfo,
+			},
+		},
+	}
+}
+
+// cliFlags returns the connection flags followed by the subcommand's own flags. The
+// connection flags carry the names of the adsConnFields they set.
+func cliFlags(extra ...cli.Flag) []cli.Flag {
+	flags := []cli.Flag{
+		&cli.StringFlag{Name: "config", Usage: "YAML file with connection fields, e.g. the ads input section of a pipeline; flags override it"},
+		&cli.StringFlag{Name: "targetIP", Usage: "IP address or hostname of the PLC"},
+		&cli.StringFlag{Name: "targetAMS", Usage: "AMS net ID of the PLC"},
+		&cli.IntFlag{Name: "targetPort", Usage: "TCP port of the PLC ADS gateway (default 48898)"},
+		&cli.IntFlag{Name: "runtimePort", Usage: "Runtime port, 801 for TwinCAT 2, 851 for TwinCAT 3 (default 801)"},
+		&cli.StringFlag{Name: "hostAMS", Usage: "Local AMS net ID (default auto)"},
+		&cli.IntFlag{Name: "hostPort", Usage: "Local AMS port (default 10500)"},
+		&cli.IntFlag{Name: "requestTimeout", Usage: "Timeout for ADS requests in milliseconds (default 5000)"},
+		&cli.StringFlag{Name: "routeUsername", Usage: "Username for route registration on the PLC"},
+		&cli.StringFlag{Name: "routePassword", Usage: "Password for route registration on the PLC"},
+		&cli.StringFlag{Name: "routePasswordFile", Usage: "File to read the route password from"},
+		&cli.StringFlag{Name: "routePasswordEnv", Usage: "Environment variable to read the route password from"},
+		&cli.StringFlag{Name: "routeHostAddress", Usage: "Address the PLC should use to reach this client"},
+		&cli.StringFlag{Name: "routeName", Usage: "Name of the registered route"},
+		&cli.StringFlag{Name: "routeLifetime", Usage: "permanent or temporary (default permanent)"},
+	}
+	return append(flags, extra...)
+}
+
+// cliConn is an open connection of a CLI subcommand.
+type cliConn struct {
+	adsConnConfig
+	session *adsLib.Session
+	log     *service.Logger
+}
+
+// cliConnFields returns the connection fields of the config file overridden by the flags given
+// on the command line, so everything else keeps the file's value or the field default.
+func cliConnFields(c *cli.Context) (map[string]any, error) {
+	fields := map[string]any{}
+	if path := c.String("config"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err = yaml.Unmarshal(data, &fields); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if fields == nil {
+			fields = map[string]any{}
+		}
+	}
+	for _, f := range cliFlags() {
+		name := f.Names()[0]
+		if name == "config" || !c.IsSet(name) {
+			continue
+		}
+		if _, ok := f.(*cli.IntFlag); ok {
+			fields[name] = c.Int(name)
+		} else {
+			fields[name] = c.String(name)
+		}
+	}
+	return fields, nil
+}
+
+// cliConnect parses the connection fields like the ads components parse their config and
+// opens a session.
+func cliConnect(c *cli.Context) (*cliConn, error) {
+	fields, err := cliConnFields(c)
+	if err != nil {
+		return nil, err
+	}
+	data, err := yaml.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := service.NewConfigSpec().Fields(adsConnFields()...).ParseYAML(string(data), nil)
+	if err != nil {
+		return nil, err
+	}
+	mgr := service.MockResources()
+	connConf, err := parseAdsConnConfig(conf, mgr)
+	if err != nil {
+		return nil, err
+	}
+	// A pipeline's ads input may list its PLCs under targets, which the CLI doesn't use.
+	if err = connConf.validateTarget(); err != nil {
+		return nil, err
+	}
+	conn := &cliConn{adsConnConfig: connConf, log: mgr.Logger()}
+	if conn.session, err = conn.openSession(c.Context, conn.log); err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", conn.targetAMS, err)
+	}
+	return conn, nil
+}
+
+func (conn *cliConn) close() {
+	_ = conn.session.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn.releaseRoute(ctx, conn.log)
+}
+
+// cliLoadSymbols downloads the symbol table into the session when --loadSymbols is set.
+func (conn *cliConn) cliLoadSymbols(c *cli.Context) error {
+	if !c.Bool("loadSymbols") {
+		return nil
+	}
+	if err := conn.session.LoadSymbols(c.Context); err != nil {
+		return fmt.Errorf("loading symbols: %w", err)
+	}
+	return nil
+}
+
+func cliBrowse(c *cli.Context) error {
+	conn, err := cliConnect(c)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	info, err := readUploadInfo(c.Context, conn.session)
+	if err != nil {
+		return err
+	}
+	table, err := uploadSymbolTable(c.Context, conn.session, info)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	if c.NArg() == 0 {
+		for _, sym := range table.Symbols {
+			names = append(names, sym.Name)
+		}
+		sort.Strings(names)
+	}
+	for _, pattern := range c.Args().Slice() {
+		matched, err := table.expand(pattern)
+		if err != nil {
+			return err
+		}
+		names = append(names, matched...)
+	}
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SYMBOL\tTYPE\tSIZE")
+	for _, name := range names {
+		sym, ok := table.symbol(name)
+		if !ok {
+			return fmt.Errorf("symbol %s not found", name)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\n", sym.Name, sym.DataType, sym.Size)
+		if len(sym.Arrays) == 0 {
+			printMembers(w, table, sym.DataType, "  ", c.Int("members"))
+		}
+	}
+	return w.Flush()
+}
+
+// printMembers lists the members of a struct type, indented below their parent.
+func printMembers(w io.Writer, t *symbolTable, typeName, indent string, depth int) {
+	if depth <= 0 {
+		return
+	}
+	dt, ok := t.dataType(t.baseTypeName(typeName))
+	if !ok {
+		return
+	}
+	for _, sub := range dt.SubItems {
+		fmt.Fprintf(w, "%s.%s\t%s\t%d\n", indent, sub.Name, sub.DataType, sub.Size)
+		if len(sub.Arrays) == 0 {
+			printMembers(w, t, sub.DataType, indent+"  ", depth-1)
+		}
+	}
+}
+
+func cliRead(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return errors.New("read needs at least one symbol")
+	}
+	conn, err := cliConnect(c)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+	if err = conn.cliLoadSymbols(c); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+	failed := 0
+	for _, name := range c.Args().Slice() {
+		value, err := conn.session.ReadFromSymbol(c.Context, name)
+		if err != nil {
+			fmt.Fprintf(w, "%s\terror: %v\n", name, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\n", name, value)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d symbols could not be read", failed, c.NArg())
+	}
+	return nil
+}
+
+func cliWatch(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return errors.New("watch needs at least one symbol")
+	}
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	conn, err := cliConnect(c)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+	if err = conn.cliLoadSymbols(c); err != nil {
+		return err
+	}
+
+	mode := adsLib.TransModeServerOnChange
+	if c.Bool("cyclic") {
+		mode = adsLib.TransModeServerCycle
+	}
+	configs := make([]adsLib.NotificationConfig, c.NArg())
+	for i, name := range c.Args().Slice() {
+		configs[i] = adsLib.NotificationConfig{
+			SymbolName:       name,
+			MaxDelay:         time.Duration(c.Int("maxDelay")) * time.Millisecond,
+			CycleTime:        time.Duration(c.Int("cycleTime")) * time.Millisecond,
+			TransmissionMode: mode,
+		}
+	}
+	updates := make(chan *adsLib.Update, 1000)
+	results, err := conn.session.AddSymbolNotifications(ctx, configs, updates)
+	if err != nil {
+		return fmt.Errorf("adding notifications: %w", err)
+	}
+	var handles []uint32
+	for i, r := range results {
+		switch {
+		case r.Skipped != nil:
+			fmt.Fprintf(c.App.ErrWriter, "%s: %v\n", configs[i].SymbolName, r.Skipped)
+		case r.Error != adsLib.ReturnCodeNoErrors:
+			fmt.Fprintf(c.App.ErrWriter, "%s: ADS error %v\n", configs[i].SymbolName, r.Error)
+		default:
+			handles = append(handles, r.Handle)
+		}
+	}
+	if len(handles) == 0 {
+		return errors.New("no symbol could be subscribed")
+	}
+	defer func() {
+		dctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = conn.session.DeleteNotifications(dctx, handles)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case u := <-updates:
+			if u == nil {
+				continue
+			}
+			fmt.Fprintf(c.App.Writer, "%s  %s  %s\n", time.Now().Format("15:04:05.000"), u.Variable, u.Value)
+		}
+	}
+}
+
+func cliWrite(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return errors.New("write needs a symbol and a value")
+	}
+	name, value := c.Args().Get(0), c.Args().Get(1)
+	conn, err := cliConnect(c)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	if err = conn.session.WriteToSymbol(c.Context, name, value); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	readBack, err := conn.session.ReadFromSymbol(c.Context, name)
+	if err != nil {
+		return fmt.Errorf("reading back %s: %w", name, err)
+	}
+	fmt.Fprintf(c.App.Writer, "%s\t%s\n", name, readBack)
+	return nil
+}
+
+func cliInfo(c *cli.Context) error {
+	conn, err := cliConnect(c)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Target\t%s:%d\n", conn.targetAMS, conn.runtimePort)
+	if conn.targetIP != "" {
+		fmt.Fprintf(w, "Address\t%s\n", conn.targetIP)
+	}
+
+	state, err := conn.session.ReadState(c.Context)
+	if err != nil {
+		fmt.Fprintf(w, "State\terror: %v\n", err)
+	} else {
+		fmt.Fprintf(w, "State\t%s (device state %d)\n", adsStateName(state.ADSState), state.DeviceState)
+	}
+
+	// Device info comes from the PLC's UDP service, which isn't reachable over MQTT.
+	if conn.mqtt == nil {
+		if dev, err := conn.deviceInfo(c.Context); err != nil {
+			fmt.Fprintf(w, "Device\terror: %v\n", err)
+		} else {
+			fmt.Fprintf(w, "Hostname\t%s\n", dev.Hostname)
+			fmt.Fprintf(w, "TwinCAT\t%s\n", dev.TwinCATVersion)
+			fmt.Fprintf(w, "OS\t%s %s\n", dev.OS, dev.OSVersion)
+		}
+	}
+
+	info, err := readUploadInfo(c.Context, conn.session)
+	if err != nil {
+		fmt.Fprintf(w, "Symbols\terror: %v\n", err)
+	} else {
+		fmt.Fprintf(w, "Symbols\t%d (%d bytes)\n", info.Symbols, info.SymbolSize)
+		fmt.Fprintf(w, "Datatypes\t%d (%d bytes)\n", info.DataTypes, info.DataTypeSize)
+		fmt.Fprintf(w, "Symbol version\t%d\n", info.Version)
+	}
+	return w.Flush()
+}
+
+// deviceInfo asks the PLC's UDP service for its hostname and versions.
+func (conn *cliConn) deviceInfo(ctx context.Context) (discoveredDevice, error) {
+	host, err := conn.resolveTarget(ctx)
+	if err != nil {
+		return discoveredDevice{}, err
+	}
+	var sender amsNetID
+	if conn.hostAMS != "" && conn.hostAMS != "auto" {
+		sender, _ = parseAMSNetID(conn.hostAMS)
+	}
+	req := marshalUDPRequest(adsUDPServiceInfo, 0, sender, nil)
+	timeout := conn.requestTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	reply, err := udpRequest(ctx, host, req, adsUDPServiceInfo, timeout)
+	if err != nil {
+		return discoveredDevice{}, err
+	}
+	return describeDevice(reply, time.Now().UTC().Format(time.RFC3339Nano)), nil
+}
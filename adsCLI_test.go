@@ -0,0 +1,81 @@
+package benthosADS
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestCLIConnFields(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	config := write("ads.yaml", "targetIP: 192.168.3.10\ntargetAMS: 192.168.3.10.1.1\nruntimePort: 851\nsymbols: [ MAIN.counter ]\n")
+	empty := write("empty.yaml", "")
+	invalid := write("invalid.yaml", "targetIP: [ 192.168.3.10\n")
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    map[string]any
+		wantErr string
+	}{
+		{
+			name: "flags only",
+			args: []string{"--targetIP", "10.0.0.2", "--targetAMS", "10.0.0.2.1.1", "--targetPort", "48899", "--routePasswordEnv", "PLC_PASSWORD"},
+			want: map[string]any{"targetIP": "10.0.0.2", "targetAMS": "10.0.0.2.1.1", "targetPort": 48899, "routePasswordEnv": "PLC_PASSWORD"},
+		},
+		{
+			name: "config only, other fields are kept",
+			args: []string{"--config", config},
+			want: map[string]any{"targetIP": "192.168.3.10", "targetAMS": "192.168.3.10.1.1", "runtimePort": 851, "symbols": []any{"MAIN.counter"}},
+		},
+		{
+			name: "flags override the config",
+			args: []string{"--config", config, "--targetIP", "plc.local", "--runtimePort", "801"},
+			want: map[string]any{"targetIP": "plc.local", "targetAMS": "192.168.3.10.1.1", "runtimePort": 801, "symbols": []any{"MAIN.counter"}},
+		},
+		{
+			name: "empty config",
+			args: []string{"--config", empty, "--hostAMS", "auto"},
+			want: map[string]any{"hostAMS": "auto"},
+		},
+		{name: "nothing set", want: map[string]any{}},
+		{name: "missing config", args: []string{"--config", filepath.Join(dir, "missing.yaml")}, wantErr: "missing.yaml"},
+		{name: "invalid config", args: []string{"--config", invalid}, wantErr: invalid + ": yaml:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]any
+			app := &cli.App{
+				Flags: cliFlags(),
+				Action: func(c *cli.Context) (err error) {
+					got, err = cliConnFields(c)
+					return err
+				},
+			}
+			err := app.Run(append([]string{"ads"}, tt.args...))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package benthosADS
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	adsLib "github.com/RuneRoven/go-ads/v2"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// adsCaptureFormat identifies capture files in their header record.
+const adsCaptureFormat = "benthosADS-capture"
+
+// adsCaptureField configures recording of the ads input's messages for ads_replay.
+func adsCaptureField() *service.ConfigField {
+	return service.NewObjectField("capture",
+		service.NewStringField("path").Description("File the messages are recorded to. Each run appends a gzip member, so restarts and reconnects extend the capture."),
+	).Description("Record every message the input emits (value, symbol and type metadata, receive time) so ads_replay can reproduce it offline. " +
+		"Values read by address, i.e. direct addresses and structs or arrays decoded from symbolFile or symbolCacheDir, also record the PLC's value bytes, and their notifications the PLC's sample time. " +
+		"Other symbols are read by name through go-ads, which passes on neither, so their records hold only the rendered value and the receive time.").Optional()
+}
+
+// captureRecord is one line of a capture file. The first line of each gzip member is a header
+// with Format set; every other line is a message of batch Batch. Values that aren't valid
+// UTF-8 are stored base64 encoded in Raw. Data and PLCTime hold the value bytes and sample
+// time the PLC sent, for messages whose source passes them on.
+type captureRecord struct {
+	Format  string            `json:"format,omitempty"`
+	Version int               `json:"version,omitempty"`
+	Time    int64             `json:"t"`
+	PLCTime int64             `json:"pt,omitempty"`
+	Batch   uint64            `json:"b,omitempty"`
+	Value   string            `json:"v,omitempty"`
+	Raw     []byte            `json:"raw,omitempty"`
+	Data    []byte            `json:"d,omitempty"`
+	Meta    map[string]string `json:"m,omitempty"`
+
+	run int // gzip member the record was read from, counted by captureReader
+}
+
+// value returns the message content of the record.
+func (r *captureRecord) value() []byte {
+	if r.Raw != nil {
+		return r.Raw
+	}
+	return []byte(r.Value)
+}
+
+// captureSource is what the PLC sent for a message, recorded next to the rendered value.
+type captureSource struct {
+	data    []byte
+	plcTime time.Time
+}
+
+// captureSourceKey is the message context key of a message's captureSource.
+type captureSourceKey struct{}
+
+// withCaptureSource attaches src to msg for adsCapture.write. The context isn't part of the
+// message content or metadata, so the pipeline doesn't see it.
+func withCaptureSource(msg *service.Message, src captureSource) *service.Message {
+	return msg.WithContext(context.WithValue(msg.Context(), captureSourceKey{}, src))
+}
+
+// adsCapture writes the batches of one ads input, shared by its targets.
+type adsCapture struct {
+	mu     sync.Mutex
+	file   *os.File
+	gz     *gzip.Writer
+	enc    *json.Encoder
+	batch  uint64
+	closed bool
+	held   map[*adsLib.Update]captureSource // sources of updates still in a notification channel
+}
+
+func parseAdsCapture(conf *service.ParsedConfig) (*adsCapture, error) {
+	if !conf.Contains("capture") {
+		return nil, nil
+	}
+	path, err := conf.FieldString("capture", "path")
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, errors.New("capture.path must not be empty")
+	}
+	return openAdsCapture(path)
+}
+
+func openAdsCapture(path string) (*adsCapture, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("capture: %w", err)
+	}
+	c := &adsCapture{file: f, gz: gzip.NewWriter(f)}
+	c.enc = json.NewEncoder(c.gz)
+	if err = c.enc.Encode(captureRecord{Format: adsCaptureFormat, Version: 2, Time: time.Now().UnixNano()}); err == nil {
+		err = c.gz.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("capture: %w", err)
+	}
+	return c, nil
+}
+
+// hold keeps the value bytes and sample time of update until the message built from it is
+// recorded. Notification callbacks call it before handing update to the input.
+func (c *adsCapture) hold(update *adsLib.Update, data []byte, plcTime time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	if c.held == nil {
+		c.held = map[*adsLib.Update]captureSource{}
+	}
+	c.held[update] = captureSource{data: append([]byte(nil), data...), plcTime: plcTime}
+}
+
+// take returns and forgets the source held for update.
+func (c *adsCapture) take(update *adsLib.Update) (captureSource, bool) {
+	if c == nil {
+		return captureSource{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	src, ok := c.held[update]
+	delete(c.held, update)
+	return src, ok
+}
+
+// write records a batch. Each batch is flushed, so a capture stays readable up to the last
+// batch if the process dies.
+func (c *adsCapture) write(batch service.MessageBatch) error {
+	if c == nil || len(batch) == 0 {
+		return nil
+	}
+	now := time.Now().UnixNano()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.batch++
+	for _, msg := range batch {
+		data, err := msg.AsBytes()
+		if err != nil {
+			return err
+		}
+		rec := captureRecord{Time: now, Batch: c.batch, Meta: map[string]string{}}
+		if utf8.Valid(data) {
+			rec.Value = string(data)
+		} else {
+			rec.Raw = data
+		}
+		if src, ok := msg.Context().Value(captureSourceKey{}).(captureSource); ok {
+			rec.Data = src.data
+			if !src.plcTime.IsZero() {
+				rec.PLCTime = src.plcTime.UnixNano()
+			}
+		}
+		_ = msg.MetaWalk(func(k, v string) error {
+			rec.Meta[k] = v
+			return nil
+		})
+		if err = c.enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return c.gz.Flush()
+}
+
+func (c *adsCapture) close() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.held = nil
+	err := c.gz.Close()
+	if cerr := c.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// captureOffset counts the bytes the gzip reader consumes. gzip reads through io.ByteReader
+// without buffering ahead, so the count is the file offset of the decompressor.
+type captureOffset struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (o *captureOffset) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	o.n += int64(n)
+	return n, err
+}
+
+func (o *captureOffset) ReadByte() (byte, error) {
+	b, err := o.r.ReadByte()
+	if err == nil {
+		o.n++
+	}
+	return b, err
+}
+
+// captureReader reads the records of a capture file, skipping the headers. It reads one gzip
+// member at a time: when a run was cut off mid-write, the reader returns the records written
+// up to the cut and resumes at the next member, which the following run appended.
+type captureReader struct {
+	file    *os.File
+	src     *captureOffset
+	gz      *gzip.Reader
+	scanner *bufio.Scanner
+	start   int64 // file offset of the current member
+	run     int
+	damaged int // members that ended early or were unreadable
+}
+
+func openCaptureReader(path string) (*captureReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &captureReader{file: f, src: &captureOffset{r: bufio.NewReader(f)}}
+	if r.gz, err = gzip.NewReader(r.src); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	r.startMember()
+	first, err := r.readLine()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if first.Format != adsCaptureFormat {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a benthosADS capture", path)
+	}
+	return r, nil
+}
+
+// startMember prepares reading the member the gzip reader was just reset to.
+func (r *captureReader) startMember() {
+	r.gz.Multistream(false)
+	r.scanner = bufio.NewScanner(r.gz)
+	r.scanner.Buffer(make([]byte, 64*1024), 16<<20)
+}
+
+// nextMember moves to the member following a complete one.
+func (r *captureReader) nextMember() error {
+	r.start = r.src.n
+	err := r.gz.Reset(r.src)
+	if errors.Is(err, io.EOF) {
+		return io.EOF
+	}
+	if err != nil {
+		r.damaged++
+		return r.resync(r.start + 1)
+	}
+	r.startMember()
+	return nil
+}
+
+// resync looks for the next member from offset from on, skipping damaged data. A member only
+// counts when it decompresses to a capture header, so gzip magic bytes that happen to occur in
+// compressed data are passed over.
+func (r *captureReader) resync(from int64) error {
+	for {
+		if _, err := r.file.Seek(from, io.SeekStart); err != nil {
+			return err
+		}
+		r.src.r.Reset(r.file)
+		r.src.n = from
+		pos, err := r.findMagic()
+		if err != nil {
+			return io.EOF // nothing readable left
+		}
+		if _, err = r.file.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		r.src.r.Reset(r.file)
+		r.src.n = pos
+		if r.gz.Reset(r.src) == nil {
+			r.start = pos
+			r.startMember()
+			if r.scanner.Scan() {
+				var header captureRecord
+				if json.Unmarshal(r.scanner.Bytes(), &header) == nil && header.Format == adsCaptureFormat {
+					r.run++
+					return nil
+				}
+			}
+		}
+		from = pos + 1
+	}
+}
+
+// findMagic returns the offset of the next gzip header start.
+func (r *captureReader) findMagic() (int64, error) {
+	var last [2]byte
+	for {
+		b, err := r.src.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if last[0] == 0x1f && last[1] == 0x8b && b == 8 { // ID1, ID2, CM deflate
+			return r.src.n - 3, nil
+		}
+		last[0], last[1] = last[1], b
+	}
+}
+
+func (r *captureReader) readLine() (*captureRecord, error) {
+	for {
+		if r.scanner.Scan() {
+			var rec captureRecord
+			err := json.Unmarshal(r.scanner.Bytes(), &rec)
+			if err == nil {
+				if rec.Format != "" {
+					r.run++
+				}
+				rec.run = r.run
+				return &rec, nil
+			}
+			if r.scanner.Scan() {
+				return nil, err
+			}
+			// The truncated last line of a member that was cut off.
+		}
+		if r.scanner.Err() == nil {
+			if err := r.nextMember(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// The member was cut off or is corrupt; its complete records have been returned.
+		r.damaged++
+		if err := r.resync(r.start + 1); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// next returns the next message record, or io.EOF at the end of the capture.
+func (r *captureReader) next() (*captureRecord, error) {
+	for {
+		rec, err := r.readLine()
+		if err != nil || rec.Format == "" {
+			return rec, err
+		}
+	}
+}
+
+func (r *captureReader) close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,219 @@
+package benthosADS
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	adsLib "github.com/RuneRoven/go-ads/v2"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// readCapture returns the message records of a capture file.
+func readCapture(t *testing.T, path string) ([]*captureRecord, int) {
+	t.Helper()
+	r, err := openCaptureReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+	var records []*captureRecord
+	for {
+		rec, err := r.next()
+		if errors.Is(err, io.EOF) {
+			return records, r.damaged
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+}
+
+func TestCaptureRecordsPLCSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "line.capture")
+	c, err := openAdsCapture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sampled := time.Date(2026, 3, 1, 8, 0, 0, 125000000, time.UTC)
+	update := &adsLib.Update{Variable: "%MW10", Value: "-2"}
+	c.hold(update, []byte{0xFE, 0xFF}, sampled)
+
+	notified := service.NewMessage([]byte("-2"))
+	notified.MetaSet("symbol_name", "%MW10")
+	src, ok := c.take(update)
+	if !ok {
+		t.Fatal("source of the update was not held")
+	}
+	named := service.NewMessage([]byte("1450.5"))
+	named.MetaSet("symbol_name", "MAIN.fSpeed")
+	if err = c.write(service.MessageBatch{withCaptureSource(notified, src), named}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = c.take(update); ok {
+		t.Error("source still held after it was taken")
+	}
+	if err = c.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, damaged := readCapture(t, path)
+	if damaged != 0 || len(records) != 2 {
+		t.Fatalf("read %d records, %d damaged", len(records), damaged)
+	}
+	if got := records[0]; got.Value != "-2" || !reflect.DeepEqual(got.Data, []byte{0xFE, 0xFF}) ||
+		got.PLCTime != sampled.UnixNano() || got.Meta["symbol_name"] != "%MW10" {
+		t.Errorf("notification record = %+v", *got)
+	}
+	if got := records[1]; got.Value != "1450.5" || got.Data != nil || got.PLCTime != 0 || got.Time == 0 {
+		t.Errorf("named record = %+v", *got)
+	}
+}
+
+func TestCaptureReaderRecoversTruncatedRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "line.capture")
+	writeRun := func(values ...string) {
+		t.Helper()
+		c, err := openAdsCapture(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range values {
+			if err = c.write(service.MessageBatch{service.NewMessage([]byte(v))}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = c.close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeRun("1", "2")
+	// A run killed mid-write: flushed batches, but no gzip trailer and a cut last block.
+	c, err := openAdsCapture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"3", "4"} {
+		if err = c.write(service.MessageBatch{service.NewMessage([]byte(v))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := c.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.write(service.MessageBatch{service.NewMessage([]byte("5"))}); err != nil {
+		t.Fatal(err)
+	}
+	c.file.Close()
+	if err = os.Truncate(path, info.Size()+3); err != nil {
+		t.Fatal(err)
+	}
+	writeRun("6")
+
+	records, damaged := readCapture(t, path)
+	var got []string
+	for _, rec := range records {
+		got = append(got, string(rec.value()))
+	}
+	if want := []string{"1", "2", "3", "4", "6"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+	if damaged != 1 {
+		t.Errorf("damaged = %d, want 1", damaged)
+	}
+	if records[2].run != 2 || records[4].run != 3 {
+		t.Errorf("runs = %d, %d, want 2, 3", records[2].run, records[4].run)
+	}
+}
+
+func TestCaptureReaderSkipsGarbage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "line.capture")
+	c, err := openAdsCapture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.write(service.MessageBatch{service.NewMessage([]byte("1"))}); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A partial gzip header followed by bytes that look like one but aren't a capture.
+	if _, err = f.Write([]byte{0x1f, 0x8b, 0x08, 0, 0, 0x1f, 0x8b, 0x08, 0xff}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	c, err = openAdsCapture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.write(service.MessageBatch{service.NewMessage([]byte("2"))}); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, damaged := readCapture(t, path)
+	if len(records) != 2 || string(records[1].value()) != "2" || damaged == 0 {
+		t.Errorf("read %d records, %d damaged", len(records), damaged)
+	}
+}
+
+func TestReplaySkipsDowntimeBetweenRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "line.capture")
+	for i, values := range [][]string{{"1", "2"}, {"3"}} {
+		if i > 0 {
+			time.Sleep(200 * time.Millisecond) // the recording pipeline was down
+		}
+		c, err := openAdsCapture(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range values {
+			if err = c.write(service.MessageBatch{service.NewMessage([]byte(v))}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = c.close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// At speed 0.01 the downtime alone would take 20 seconds to replay.
+	r := &adsReplay{path: path, speed: 0.01, log: service.MockResources().Logger(), done: make(chan struct{})}
+	defer r.Close(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	var got []string
+	for range 3 {
+		batch, _, err := r.ReadBatch(ctx)
+		if err != nil {
+			t.Fatalf("ReadBatch after %v: %v", time.Since(start), err)
+		}
+		b, _ := batch[0].AsBytes()
+		got = append(got, string(b))
+	}
+	if want := []string{"1", "2", "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("replay took %v, it waited for the downtime between runs", elapsed)
+	}
+}
@@ -0,0 +1,418 @@
+package benthosADS
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	adsLib "github.com/RuneRoven/go-ads/v2"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// adsConnConfig holds everything needed to open an ADS session to a single PLC.
+// It is shared by every ADS component so connection fields parse and behave identically.
+type adsConnConfig struct {
+	targetIP       string
+	targetAMS      string
+	targetPort     int
+	runtimePort    int
+	hostAMS        string
+	hostPort       int
+	requestTimeout time.Duration
+
+	// Route registration settings
+	routeUsername    string
+	routePassword    string
+	routeHostAddress string
+	routeCredentials []routeCredential
+	routeName        string
+	routeLifetime    string
+	listRoutes       bool
+	heldRoute        *heldRoute // temporary route held by this component, see releaseRoute
+
+	// Components with the same label and target share one session (see adsSessionPool).
+	connectionLabel string
+
+	// Secure ADS settings, nil for plain ADS.
+	tls *adsTLSConfig
+	// ADS-over-MQTT settings, nil unless packets go through a broker.
+	mqtt *adsMQTTConfig
+
+	adsLogger *slog.Logger
+}
+
+// adsConnFields returns the config fields describing an ADS connection.
+func adsConnFields() []*service.ConfigField {
+	fields := []*service.ConfigField{
+		service.NewStringField("targetIP").Description("IP address (IPv4 or IPv6) or hostname of the Beckhoff PLC. Hostnames are resolved on every connect.").Default(""),
+		service.NewStringField("targetAMS").Description("Target AMS net ID.").Default(""),
+		service.NewIntField("targetPort").Description("TCP port of the PLC ADS gateway.").Default(48898),
+		service.NewIntField("runtimePort").Description("Target runtime port. 801 for TwinCAT 2, 851 for TwinCAT 3.").Default(801),
+		service.NewStringField("hostAMS").Description("Local AMS net ID. 'auto' derives it from the outbound TCP source IP.").Default("auto"),
+		service.NewIntField("hostPort").Description("AMS source port used in protocol headers. Any arbitrary value works.").Default(10500),
+		service.NewStringField("logLevel").Description("Log level for ADS connection. Default disabled.").Default("disabled"),
+		service.NewIntField("requestTimeout").Description("Timeout for individual ADS requests in milliseconds.").Default(5000),
+		service.NewStringField("routeUsername").Description("Username for UDP route registration on the PLC. If set with routePassword, a route will be registered before connecting.").Default(""),
+		service.NewStringField("routePassword").Description("Password for UDP route registration on the PLC.").Default("").Secret(),
+	}
+	fields = append(fields, credentialSourceFields("routeUsername")...)
+	fields = append(fields, credentialSourceFields("routePassword")...)
+	return append(fields,
+		service.NewStringField("routeHostAddress").Description("The address the PLC should use to reach this client. Auto-detected from outbound connection if empty.").Default(""),
+		routeCredentialsField(),
+		service.NewStringField("routeName").Description("Name of the registered route. Defaults to benthosADS-<client IP>. A fixed name makes a client whose IP changed replace its old route instead of adding another.").Default(""),
+		service.NewStringField("routeLifetime").Description("permanent keeps the registered route on the PLC; temporary removes it when the last component using it closes.").Default(routeLifetimePermanent),
+		service.NewBoolField("listRoutes").Description("Log the PLC's route table after registering the route and warn about leftover benthosADS routes.").Default(false),
+		adsTLSField(),
+		adsMQTTField(),
+		service.NewStringField("connectionLabel").Description("Share one connection between all ADS components with the same label and target. The first component to connect opens the session with its settings; the connection closes when the last user closes.").Default(""),
+	)
+}
+
+// parseAdsConnConfig reads and validates the fields declared by adsConnFields.
+func parseAdsConnConfig(conf *service.ParsedConfig, mgr *service.Resources) (adsConnConfig, error) {
+	var c adsConnConfig
+
+	logLevel, err := conf.FieldString("logLevel")
+	if err != nil {
+		return c, err
+	}
+	c.adsLogger = slog.New(&benthosLogHandler{
+		logger: mgr.Logger(),
+		level:  slogLevelFromString(logLevel),
+	})
+
+	if c.targetIP, err = conf.FieldString("targetIP"); err != nil {
+		return c, err
+	}
+	if c.targetAMS, err = conf.FieldString("targetAMS"); err != nil {
+		return c, err
+	}
+	if c.mqtt, err = parseAdsMQTTConfig(conf); err != nil {
+		return c, err
+	}
+	// The ads input may list its PLCs under targets instead; each target is validated separately.
+	if c.targetIP != "" || c.targetAMS != "" || !conf.Contains("targets") {
+		if err = c.validateTarget(); err != nil {
+			return c, err
+		}
+	}
+
+	if c.targetPort, err = conf.FieldInt("targetPort"); err != nil {
+		return c, err
+	}
+
+	if c.runtimePort, err = conf.FieldInt("runtimePort"); err != nil {
+		return c, err
+	}
+	if c.runtimePort < 0 || c.runtimePort > 65535 {
+		return c, fmt.Errorf("runtimePort %d out of range 0–65535", c.runtimePort)
+	}
+
+	if c.hostAMS, err = conf.FieldString("hostAMS"); err != nil {
+		return c, err
+	}
+	if c.hostAMS != "auto" && c.hostAMS != "" {
+		if err = validateAMSNetID(c.hostAMS); err != nil {
+			return c, fmt.Errorf("hostAMS: %w", err)
+		}
+	}
+
+	if c.hostPort, err = conf.FieldInt("hostPort"); err != nil {
+		return c, err
+	}
+	if c.hostPort < 0 || c.hostPort > 65535 {
+		return c, fmt.Errorf("hostPort %d out of range 0–65535", c.hostPort)
+	}
+
+	requestTimeoutInt, err := conf.FieldInt("requestTimeout")
+	if err != nil {
+		return c, err
+	}
+	c.requestTimeout = time.Duration(requestTimeoutInt) * time.Millisecond
+
+	if c.routeUsername, _, err = parseCredential(conf, "routeUsername"); err != nil {
+		return c, err
+	}
+	if c.routePassword, _, err = parseCredential(conf, "routePassword"); err != nil {
+		return c, err
+	}
+	if c.routeCredentials, err = parseRouteCredentials(conf); err != nil {
+		return c, err
+	}
+	if c.routeUsername == "" && c.routePassword == "" {
+		c.matchRouteCredentials("")
+	}
+	if c.routeHostAddress, err = conf.FieldString("routeHostAddress"); err != nil {
+		return c, err
+	}
+	if c.routeName, err = conf.FieldString("routeName"); err != nil {
+		return c, err
+	}
+	if c.routeLifetime, err = conf.FieldString("routeLifetime"); err != nil {
+		return c, err
+	}
+	if c.routeLifetime != routeLifetimePermanent && c.routeLifetime != routeLifetimeTemporary {
+		return c, fmt.Errorf("routeLifetime %q must be permanent or temporary", c.routeLifetime)
+	}
+	if c.listRoutes, err = conf.FieldBool("listRoutes"); err != nil {
+		return c, err
+	}
+
+	if c.connectionLabel, err = conf.FieldString("connectionLabel"); err != nil {
+		return c, err
+	}
+
+	if c.tls, err = parseAdsTLSConfig(conf, mgr.Logger()); err != nil {
+		return c, err
+	}
+	if c.mqtt != nil && c.tls != nil {
+		return c, errors.New("mqtt and tls can't both be enabled; secure the broker connection with an ssl:// broker URL")
+	}
+
+	// Derive hostAMS from routeHostAddress when set to "auto",
+	// matching the same convenience shortcut as the integrated plugin.
+	if c.hostAMS == "auto" && c.routeHostAddress != "" {
+		if validateIP(c.routeHostAddress) != nil {
+			return c, fmt.Errorf("hostAMS: 'auto' needs an IPv4 routeHostAddress, got %q; set hostAMS explicitly", c.routeHostAddress)
+		}
+		c.hostAMS = c.routeHostAddress + ".1.1"
+	}
+	if c.mqtt != nil && (c.hostAMS == "" || c.hostAMS == "auto") {
+		return c, errors.New("hostAMS: must be set explicitly with mqtt, there is no TCP connection to derive it from")
+	}
+	return c, nil
+}
+
+// validateTarget checks the PLC address fields, which have no usable defaults. Over MQTT the
+// PLC is addressed by its AMS net ID alone.
+func (c *adsConnConfig) validateTarget() error {
+	c.targetIP = strings.Trim(c.targetIP, "[]")
+	if c.mqtt == nil || c.targetIP != "" {
+		if err := validateHost(c.targetIP); err != nil {
+			return fmt.Errorf("targetIP: %w", err)
+		}
+	}
+	if err := validateAMSNetID(c.targetAMS); err != nil {
+		return fmt.Errorf("targetAMS: %w", err)
+	}
+	return nil
+}
+
+// openSession creates and connects a new ADS session, registering a route first when
+// route credentials are configured. The caller owns the returned session and must Close it.
+func (c *adsConnConfig) openSession(ctx context.Context, log *service.Logger) (*adsLib.Session, error) {
+	targetIP := c.targetIP
+	var err error
+	if c.mqtt == nil {
+		// Resolve on every connect so reconnects follow DNS changes (e.g. a moved Kubernetes service).
+		if targetIP, err = c.resolveTarget(ctx); err != nil {
+			log.Errorf("Failed to resolve %s: %v", c.targetIP, err)
+			return nil, err
+		}
+		if targetIP != c.targetIP {
+			log.Infof("Resolved %s to %s", c.targetIP, targetIP)
+		}
+		if net.ParseIP(targetIP).To4() == nil {
+			if c.hostAMS == "" || c.hostAMS == "auto" {
+				log.Warnf("Target %s is IPv6; hostAMS 'auto' can't be derived from an IPv6 address, set hostAMS explicitly", targetIP)
+			}
+		}
+	}
+
+	var connOpts []adsLib.SessionOption
+	if c.adsLogger != nil {
+		connOpts = append(connOpts, adsLib.WithLogger(c.adsLogger))
+		adsLib.SetDefaultLogger(c.adsLogger)
+	}
+
+	// Secure ADS runs on its own port; the session itself connects through a loopback tunnel.
+	dialPort := c.targetPort
+	if c.tls != nil {
+		dialPort = c.tls.port
+	}
+	hostAMS := c.hostAMS
+
+	if c.mqtt != nil && c.routeUsername != "" {
+		log.Warnf("Route registration is skipped with mqtt; add the route to the PLC's MQTT configuration instead")
+	}
+	if c.tls != nil && c.routeUsername != "" {
+		// Route registration runs over cleartext UDP and would send routePassword unencrypted.
+		log.Warnf("Route registration is skipped with tls, it would send routePassword unencrypted; add the client certificate to the PLC's routes instead")
+	}
+	var routeName string
+	var routeNetID amsNetID
+	if c.mqtt == nil && c.tls == nil && c.routeUsername != "" && c.routePassword != "" {
+		hostAddr := c.routeHostAddress
+		if hostAddr == "" {
+			if hostAddr, err = detectLocalAddress(targetIP, dialPort); err != nil {
+				log.Errorf("Failed to auto-detect local address: %v", err)
+				return nil, err
+			}
+		}
+		if isLikelyContainerIP(hostAddr) {
+			log.Warnf("Auto-detected IP %s looks like a container IP. Set routeHostAddress to the Docker host's IP for route registration to work.", hostAddr)
+		}
+		routeName = c.routeName
+		if routeName == "" {
+			routeName = fmt.Sprintf("benthosADS-%s", hostAddr)
+		}
+		log.Infof("Route will be registered on PLC %s: name=%s, clientIP=%s", targetIP, routeName, hostAddr)
+		if !c.managesRoutes() {
+			connOpts = append(connOpts, adsLib.WithRoute(routeName, c.routeUsername, c.routePassword))
+			connOpts = append(connOpts, adsLib.WithHostIP(hostAddr))
+		} else {
+			// go-ads doesn't manage existing routes, so register it directly.
+			if hostAMS == "" || hostAMS == "auto" {
+				hostAMS = hostAddr + ".1.1"
+			}
+			if routeNetID, err = parseAMSNetID(hostAMS); err != nil {
+				return nil, fmt.Errorf("hostAMS: %w", err)
+			}
+			register := func() error {
+				return addRoute(ctx, targetIP, routeName, routeNetID, hostAddr, c.routeUsername, c.routePassword)
+			}
+			if err = register(); err != nil {
+				log.Errorf("Route registration on %s failed: %v", targetIP, err)
+				return nil, err
+			}
+			if c.managesRoutes() {
+				if merr := c.manageRoutes(ctx, targetIP, routeName, routeNetID, register, log); merr != nil {
+					log.Warnf("Failed to read the route table of %s: %v", targetIP, merr)
+				}
+			}
+		}
+	}
+	if c.tls != nil && (hostAMS == "" || hostAMS == "auto") {
+		// Through the tunnel go-ads only sees the loopback address, so derive the NetID here.
+		hostAddr, derr := detectLocalAddress(targetIP, dialPort)
+		if derr != nil {
+			log.Errorf("Failed to auto-detect local address: %v", derr)
+			return nil, derr
+		}
+		hostAMS = hostAddr + ".1.1"
+	}
+
+	targetAMS, err := adsLib.NewAMSAddress(c.targetAMS, uint16(c.runtimePort))
+	if err != nil {
+		log.Errorf("Invalid target AMS %q: %v", c.targetAMS, err)
+		return nil, err
+	}
+
+	if hostAMS != "" && hostAMS != "auto" {
+		localAMS, lerr := adsLib.NewAMSAddress(hostAMS, uint16(c.hostPort))
+		if lerr != nil {
+			log.Errorf("Invalid local AMS %q: %v", hostAMS, lerr)
+			return nil, lerr
+		}
+		connOpts = append(connOpts, adsLib.WithLocalAMS(localAMS))
+	}
+	if c.requestTimeout > 0 {
+		connOpts = append(connOpts, adsLib.WithRequestTimeout(c.requestTimeout))
+	}
+
+	endpoint := adsLib.AMSEndpoint{
+		IP:   targetIP,
+		Port: c.targetPort,
+		AMS:  targetAMS,
+	}
+	var tunnel adsTunnel
+	switch {
+	case c.tls != nil:
+		if tunnel, err = openTLSTunnel(ctx, c.tls, targetIP, c.targetIP); err != nil {
+			log.Errorf("Secure ADS connection to %s failed: %v", c.targetIP, err)
+			return nil, err
+		}
+		log.Infof("Secure ADS connection to %s:%d established", targetIP, c.tls.port)
+	case c.mqtt != nil:
+		if tunnel, err = openMQTTTunnel(ctx, c.mqtt, hostAMS, log); err != nil {
+			log.Errorf("ADS-over-MQTT connection failed: %v", err)
+			return nil, err
+		}
+	}
+	if tunnel != nil {
+		endpoint.IP, endpoint.Port = "127.0.0.1", tunnel.port()
+	}
+
+	// Use Background ctx for session lifetime — Benthos passes a per-call ctx to Connect
+	// that would tear the session down as soon as Connect returns. Teardown is driven by Close().
+	session, err := adsLib.NewSession(context.Background(), endpoint, connOpts...)
+	if err != nil {
+		log.Errorf("Failed to create session: %v", err)
+		if tunnel != nil {
+			tunnel.close()
+		}
+		return nil, err
+	}
+
+	log.Infof("Connecting to PLC")
+	if err = session.Connect(ctx); err != nil {
+		log.Errorf("Failed to connect to PLC at %s (%s): %v", c.targetIP, targetIP, err)
+		_ = session.Close()
+		if tunnel != nil {
+			tunnel.close()
+		}
+		return nil, err
+	}
+	if tunnel != nil {
+		go closeTunnelWith(tunnel, session)
+	}
+	if routeName != "" && c.managesRoutes() {
+		c.holdRoute(targetIP, routeName, routeNetID)
+	}
+	return session, nil
+}
+
+// managesRoutes reports whether the route is registered and maintained by the plugin itself
+// rather than by go-ads, which only adds missing routes.
+func (c *adsConnConfig) managesRoutes() bool {
+	return c.routeName != "" || c.routeLifetime == routeLifetimeTemporary || c.listRoutes
+}
+
+// detectLocalAddress returns the local IP address used to reach targetIP, which is the
+// address the PLC sees for this client and must associate with its route.
+func detectLocalAddress(targetIP string, port int) (string, error) {
+	tcpNet, udpNet := "tcp4", "udp4"
+	if net.ParseIP(targetIP).To4() == nil {
+		tcpNet, udpNet = "tcp6", "udp6"
+	}
+	// Use TCP connect to guarantee same source IP as the actual ADS connection.
+	tcpConn, dialErr := net.DialTimeout(tcpNet, net.JoinHostPort(targetIP, strconv.Itoa(port)), 3*time.Second)
+	if dialErr != nil {
+		// PLC unreachable — fall back to UDP routing lookup (no packet sent).
+		udpConn, udpErr := net.Dial(udpNet, net.JoinHostPort(targetIP, strconv.Itoa(adsUDPPort)))
+		if udpErr != nil {
+			return "", dialErr
+		}
+		defer udpConn.Close()
+		return udpConn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+	}
+	defer tcpConn.Close()
+	return tcpConn.LocalAddr().(*net.TCPAddr).IP.String(), nil
+}
+
+// resolveTarget returns targetIP as an IP address, resolving hostnames via DNS.
+// IPv4 results are preferred because route registration and AMS NetIDs are IPv4 based.
+func (c *adsConnConfig) resolveTarget(ctx context.Context) (string, error) {
+	if ip := net.ParseIP(c.targetIP); ip != nil {
+		return ip.String(), nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, c.targetIP)
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("no addresses found for %s", c.targetIP)
+	}
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			return a.IP.String(), nil
+		}
+	}
+	return addrs[0].IP.String(), nil
+}
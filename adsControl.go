@@ -0,0 +1,245 @@
+package benthosADS
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	adsLib "github.com/RuneRoven/go-ads/v2"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// ADS states as defined by the Beckhoff ADS specification (ADSSTATE_*).
+const (
+	adsStateInvalid      uint16 = 0
+	adsStateIdle         uint16 = 1
+	adsStateReset        uint16 = 2
+	adsStateInit         uint16 = 3
+	adsStateStart        uint16 = 4
+	adsStateRun          uint16 = 5
+	adsStateStop         uint16 = 6
+	adsStateSaveCfg      uint16 = 7
+	adsStateLoadCfg      uint16 = 8
+	adsStatePowerFailure uint16 = 9
+	adsStatePowerGood    uint16 = 10
+	adsStateError        uint16 = 11
+	adsStateShutdown     uint16 = 12
+	adsStateSuspend      uint16 = 13
+	adsStateResume       uint16 = 14
+	adsStateConfig       uint16 = 15
+	adsStateReconfig     uint16 = 16
+)
+
+var adsStateNames = map[uint16]string{
+	adsStateInvalid:      "invalid",
+	adsStateIdle:         "idle",
+	adsStateReset:        "reset",
+	adsStateInit:         "init",
+	adsStateStart:        "start",
+	adsStateRun:          "run",
+	adsStateStop:         "stop",
+	adsStateSaveCfg:      "saveConfig",
+	adsStateLoadCfg:      "loadConfig",
+	adsStatePowerFailure: "powerFailure",
+	adsStatePowerGood:    "powerGood",
+	adsStateError:        "error",
+	adsStateShutdown:     "shutdown",
+	adsStateSuspend:      "suspend",
+	adsStateResume:       "resume",
+	adsStateConfig:       "config",
+	adsStateReconfig:     "reconfig",
+}
+
+// adsControlCommands maps the command names accepted by ads_control to the ADS state
+// written with WriteControl.
+var adsControlCommands = map[string]uint16{
+	"run":   adsStateRun,
+	"stop":  adsStateStop,
+	"reset": adsStateReset,
+}
+
+func adsStateName(state uint16) string {
+	if name, ok := adsStateNames[state]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", state)
+}
+
+type adsControlProc struct {
+	adsConnConfig
+
+	command     *service.InterpolatedString
+	allowed     map[string]bool
+	deviceState uint16
+	dryRun      bool
+	log         *service.Logger
+
+	mu      sync.Mutex
+	handler *adsLib.Session
+}
+
+var adsControlConf = service.NewConfigSpec().
+	Summary("Changes the ADS state of a Beckhoff PLC runtime (run, stop, reset) using ADS WriteControl.").
+	Description("Each message selects a command which is sent to the configured runtime port. The message is replaced " +
+		"by a JSON report of the ADS and device state read back from the PLC afterwards. These commands stop or restart " +
+		"machine control, so only commands listed in allowedCommands are sent and everything else fails the message.").
+	Fields(adsConnFields()...).
+	Field(service.NewInterpolatedStringField("command").Description("Command to send: run, stop or reset.").Default("${! content() }")).
+	Field(service.NewStringListField("allowedCommands").Description("Commands this processor may send. Commands not listed are rejected without contacting the PLC.")).
+	Field(service.NewIntField("deviceState").Description("Device state sent along with the ADS state in the WriteControl request.").Default(0)).
+	Field(service.NewBoolField("dryRun").Description("Only log and report the command that would be sent; the PLC state is read but never changed.").Default(false))
+
+func newAdsControlProc(conf *service.ParsedConfig, mgr *service.Resources) (service.Processor, error) {
+	connConf, err := parseAdsConnConfig(conf, mgr)
+	if err != nil {
+		return nil, err
+	}
+
+	command, err := conf.FieldInterpolatedString("command")
+	if err != nil {
+		return nil, err
+	}
+
+	allowedList, err := conf.FieldStringList("allowedCommands")
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[string]bool, len(allowedList))
+	for _, c := range allowedList {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if _, ok := adsControlCommands[c]; !ok {
+			return nil, fmt.Errorf("allowedCommands: unknown command %q (must be run, stop or reset)", c)
+		}
+		allowed[c] = true
+	}
+
+	deviceState, err := conf.FieldInt("deviceState")
+	if err != nil {
+		return nil, err
+	}
+	if deviceState < 0 || deviceState > 65535 {
+		return nil, fmt.Errorf("deviceState %d out of range 0–65535", deviceState)
+	}
+
+	dryRun, err := conf.FieldBool("dryRun")
+	if err != nil {
+		return nil, err
+	}
+
+	return &adsControlProc{
+		adsConnConfig: connConf,
+		command:       command,
+		allowed:       allowed,
+		deviceState:   uint16(deviceState),
+		dryRun:        dryRun,
+		log:           mgr.Logger(),
+	}, nil
+}
+
+func init() {
+	err := service.RegisterProcessor(
+		"ads_control", adsControlConf,
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.Processor, error) {
+			return newAdsControlProc(conf, mgr)
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// session returns the current ADS session, connecting on first use or after a connection loss.
+func (p *adsControlProc) session(ctx context.Context) (*adsLib.Session, error) {
+	if p.handler != nil && !p.handler.IsClosed() {
+		return p.handler, nil
+	}
+	if p.handler != nil {
+		p.releaseSession(p.handler, p.log)
+		p.handler = nil
+	}
+	handler, err := p.acquireSession(ctx, p.log)
+	if err != nil {
+		return nil, err
+	}
+	p.handler = handler
+	return handler, nil
+}
+
+type adsControlReport struct {
+	Command      string `json:"command"`
+	DryRun       bool   `json:"dry_run"`
+	ADSState     string `json:"ads_state"`
+	ADSStateCode uint16 `json:"ads_state_code"`
+	DeviceState  uint16 `json:"device_state"`
+}
+
+func (p *adsControlProc) Process(ctx context.Context, msg *service.Message) (service.MessageBatch, error) {
+	command, err := p.command.TryString(msg)
+	if err != nil {
+		return nil, fmt.Errorf("command interpolation: %w", err)
+	}
+	command = strings.ToLower(strings.TrimSpace(command))
+
+	state, ok := adsControlCommands[command]
+	if !ok {
+		return nil, fmt.Errorf("unknown command %q (must be run, stop or reset)", command)
+	}
+	if !p.allowed[command] {
+		p.log.Warnf("Rejected ADS control command %q: not in allowedCommands", command)
+		return nil, fmt.Errorf("command %q is not in allowedCommands", command)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	handler, err := p.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.dryRun {
+		p.log.Infof("Dry run: would set ADS state %s (device state %d) on %s:%d", adsStateName(state), p.deviceState, p.targetAMS, p.runtimePort)
+	} else {
+		p.log.Warnf("Setting ADS state %s (device state %d) on %s:%d", adsStateName(state), p.deviceState, p.targetAMS, p.runtimePort)
+		if err = handler.WriteControl(ctx, state, p.deviceState, nil); err != nil {
+			if handler.IsClosed() {
+				p.handler = nil
+				go p.releaseSession(handler, p.log)
+			}
+			return nil, fmt.Errorf("write control %s: %w", command, err)
+		}
+	}
+
+	info, err := handler.ReadState(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read state after %s: %w", command, err)
+	}
+
+	report, err := json.Marshal(adsControlReport{
+		Command:      command,
+		DryRun:       p.dryRun,
+		ADSState:     adsStateName(info.ADSState),
+		ADSStateCode: info.ADSState,
+		DeviceState:  info.DeviceState,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := msg.Copy()
+	out.SetBytes(report)
+	out.MetaSet("ads_state", adsStateName(info.ADSState))
+	return service.MessageBatch{out}, nil
+}
+
+func (p *adsControlProc) Close(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.handler != nil {
+		p.releaseSession(p.handler, p.log)
+		p.handler = nil
+	}
+	p.releaseRoute(ctx, p.log)
+	return nil
+}
@@ -0,0 +1,164 @@
+package benthosADS
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RuneRoven/benthosADS/adstest"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// newServerControl builds an ads_control processor for srv from the given extra config lines
+// and closes it at the end of the test.
+func newServerControl(t *testing.T, srv *adstest.Server, extra string) *adsControlProc {
+	t.Helper()
+	conf, err := adsControlConf.ParseYAML(fmt.Sprintf(`
+targetIP: 127.0.0.1
+targetPort: %d
+targetAMS: %s
+runtimePort: 851
+hostAMS: 10.0.0.9.1.1
+%s`, srv.Addr().Port, srv.NetID(), extra), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := newAdsControlProc(conf, service.MockResources())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = proc.Close(context.Background()) })
+	return proc.(*adsControlProc)
+}
+
+// control sends command through proc and decodes the report.
+func control(t *testing.T, proc *adsControlProc, command string) (adsControlReport, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var report adsControlReport
+	batch, err := proc.Process(ctx, service.NewMessage([]byte(command)))
+	if err != nil {
+		return report, err
+	}
+	if len(batch) != 1 {
+		t.Fatalf("got %d messages, want 1", len(batch))
+	}
+	data, err := batch[0].AsBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := batch[0].MetaGet("ads_state"); state != report.ADSState {
+		t.Errorf("ads_state metadata = %q, want %q", state, report.ADSState)
+	}
+	return report, nil
+}
+
+func TestControlConfig(t *testing.T) {
+	for _, tt := range []struct {
+		name, yaml, wantErr string
+	}{
+		{"unknown allowed command", "allowedCommands: [ stop, halt ]", `unknown command "halt"`},
+		{"deviceState out of range", "allowedCommands: [ stop ]\ndeviceState: 70000", "deviceState 70000 out of range"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := adsControlConf.ParseYAML("targetIP: 127.0.0.1\ntargetAMS: 127.0.0.1.1.1\n"+tt.yaml, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = newAdsControlProc(conf, service.MockResources()); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestControlRejectsCommandsNotAllowed(t *testing.T) {
+	srv := newTestServer(t)
+	proc := newServerControl(t, srv, "allowedCommands: [ stop ]")
+	for command, wantErr := range map[string]string{
+		"run":  `command "run" is not in allowedCommands`,
+		"halt": `unknown command "halt"`,
+	} {
+		if _, err := control(t, proc, command); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("%s: error = %v, want it to contain %q", command, err, wantErr)
+		}
+	}
+	if n := srv.Connections(); n != 0 {
+		t.Errorf("%d connections to the PLC, want none for rejected commands", n)
+	}
+}
+
+func TestControlSetsStateAndReadsItBack(t *testing.T) {
+	srv := newTestServer(t)
+	proc := newServerControl(t, srv, "allowedCommands: [ run, stop ]")
+
+	report, err := control(t, proc, " STOP\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := adsControlReport{Command: "stop", ADSState: "stop", ADSStateCode: adstest.StateStop}
+	if report != want {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+
+	if report, err = control(t, proc, "run"); err != nil {
+		t.Fatal(err)
+	}
+	if report.ADSState != "run" || report.ADSStateCode != adstest.StateRun {
+		t.Errorf("after run: report = %+v", report)
+	}
+	if n := srv.Requests(adstest.CmdWriteControl); n != 2 {
+		t.Errorf("%d WriteControl requests, want 2", n)
+	}
+}
+
+func TestControlDryRun(t *testing.T) {
+	srv := newTestServer(t)
+	proc := newServerControl(t, srv, "allowedCommands: [ stop ]\ndryRun: true")
+
+	report, err := control(t, proc, "stop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := adsControlReport{Command: "stop", DryRun: true, ADSState: "run", ADSStateCode: adstest.StateRun}
+	if report != want {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+	if n := srv.Requests(adstest.CmdWriteControl); n != 0 {
+		t.Errorf("%d WriteControl requests in a dry run, want none", n)
+	}
+	if n := srv.Requests(adstest.CmdReadState); n == 0 {
+		t.Error("dry run did not read the state")
+	}
+}
+
+func TestControlErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		fault   adstest.Fault
+		wantErr string
+	}{
+		{"write control fails", adstest.Fault{Command: adstest.CmdWriteControl, ErrorCode: adstest.ErrInvalidAccess}, "write control stop"},
+		{"read back fails", adstest.Fault{Command: adstest.CmdReadState, ErrorCode: adstest.ErrInvalidAccess, Count: 1}, "read state after stop"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			proc := newServerControl(t, srv, "allowedCommands: [ stop ]")
+			// Connect first, so the fault only hits the request under test.
+			if _, err := proc.session(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			srv.InjectFault(tt.fault)
+			if _, err := control(t, proc, "stop"); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package benthosADS
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// credentialSourceFields returns the File and Env variants of a credential field. They read
+// the value from a file or an environment variable, so it stays out of the config.
+func credentialSourceFields(field string) []*service.ConfigField {
+	return []*service.ConfigField{
+		service.NewStringField(field + "File").Description("File to read " + field + " from, e.g. a Docker or Kubernetes secret. A trailing newline is removed.").Optional().Advanced(),
+		service.NewStringField(field + "Env").Description("Environment variable to read " + field + " from.").Optional().Advanced(),
+	}
+}
+
+// parseCredential returns the value of a credential field given literally, as <field>File or
+// as <field>Env, and whether any of them is set. Setting more than one is an error.
+func parseCredential(conf *service.ParsedConfig, field string) (value string, set bool, err error) {
+	var sources []string
+	if conf.Contains(field) {
+		if value, err = conf.FieldString(field); err != nil {
+			return "", false, err
+		}
+		if value != "" {
+			sources = append(sources, field)
+		}
+	}
+	if conf.Contains(field + "File") {
+		p, err := conf.FieldString(field + "File")
+		if err != nil {
+			return "", false, err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return "", false, fmt.Errorf("%sFile: %w", field, err)
+		}
+		value = strings.TrimRight(string(data), "\r\n")
+		sources = append(sources, field+"File")
+	}
+	if conf.Contains(field + "Env") {
+		name, err := conf.FieldString(field + "Env")
+		if err != nil {
+			return "", false, err
+		}
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", false, fmt.Errorf("%sEnv: environment variable %s is not set", field, name)
+		}
+		value = v
+		sources = append(sources, field+"Env")
+	}
+	if len(sources) > 1 {
+		return "", false, fmt.Errorf("%s: set only one of %s", field, strings.Join(sources, ", "))
+	}
+	return value, len(sources) == 1, nil
+}
+
+// routeCredentialsField describes credentials shared by many PLCs, matched by name, IP or NetID.
+func routeCredentialsField() *service.ConfigField {
+	fields := []*service.ConfigField{
+		service.NewStringField("match").Description("Glob pattern matched against the target name, targetIP and targetAMS, e.g. 'line1-*' or '192.168.3.*'."),
+		service.NewStringField("routeUsername").Description("Username for UDP route registration.").Optional(),
+		service.NewStringField("routePassword").Description("Password for UDP route registration.").Secret().Optional(),
+	}
+	fields = append(fields, credentialSourceFields("routeUsername")...)
+	fields = append(fields, credentialSourceFields("routePassword")...)
+	return service.NewObjectListField("routeCredentials", fields...).Description("Route credentials for PLCs without their own routeUsername/routePassword. The first matching entry is used.").Optional()
+}
+
+// routeCredential is one entry of routeCredentials.
+type routeCredential struct {
+	match    string
+	username string
+	password string
+}
+
+func parseRouteCredentials(conf *service.ParsedConfig) ([]routeCredential, error) {
+	if !conf.Contains("routeCredentials") {
+		return nil, nil
+	}
+	entries, err := conf.FieldObjectList("routeCredentials")
+	if err != nil {
+		return nil, err
+	}
+	creds := make([]routeCredential, 0, len(entries))
+	for i, e := range entries {
+		var rc routeCredential
+		if rc.match, err = e.FieldString("match"); err != nil {
+			return nil, err
+		}
+		if _, err = path.Match(rc.match, ""); err != nil {
+			return nil, fmt.Errorf("routeCredentials[%d].match %q: %w", i, rc.match, err)
+		}
+		if rc.username, _, err = parseCredential(e, "routeUsername"); err != nil {
+			return nil, fmt.Errorf("routeCredentials[%d]: %w", i, err)
+		}
+		if rc.password, _, err = parseCredential(e, "routePassword"); err != nil {
+			return nil, fmt.Errorf("routeCredentials[%d]: %w", i, err)
+		}
+		creds = append(creds, rc)
+	}
+	return creds, nil
+}
+
+// matchRouteCredentials sets the route credentials from the first routeCredentials entry
+// matching name, targetIP or targetAMS. It reports whether an entry matched.
+func (c *adsConnConfig) matchRouteCredentials(name string) bool {
+	for _, rc := range c.routeCredentials {
+		for _, s := range []string{name, c.targetIP, c.targetAMS} {
+			if s == "" {
+				continue
+			}
+			if ok, _ := path.Match(rc.match, s); ok {
+				c.routeUsername, c.routePassword = rc.username, rc.password
+				return true
+			}
+		}
+	}
+	return false
+}
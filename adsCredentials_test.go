@@ -0,0 +1,59 @@
+package benthosADS
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+func TestParseCredential(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(file, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ADS_TEST_PASSWORD", "from-env")
+
+	spec := service.NewConfigSpec().
+		Field(service.NewStringField("routePassword").Default("").Secret()).
+		Fields(credentialSourceFields("routePassword")...)
+	tests := []struct {
+		name    string
+		yaml    string
+		want    string
+		set     bool
+		wantErr string
+	}{
+		{"unset", `{}`, "", false, ""},
+		{"literal", `routePassword: secret`, "secret", true, ""},
+		{"literal that looks like a reference", `routePassword: 'file:/etc/passwd'`, "file:/etc/passwd", true, ""},
+		{"file", `routePasswordFile: ` + file, "from-file", true, ""},
+		{"env", `routePasswordEnv: ADS_TEST_PASSWORD`, "from-env", true, ""},
+		{"missing file", `routePasswordFile: ` + file + `.missing`, "", false, "routePasswordFile"},
+		{"missing env", `routePasswordEnv: ADS_TEST_UNSET`, "", false, "ADS_TEST_UNSET is not set"},
+		{"two sources", "routePassword: secret\nroutePasswordEnv: ADS_TEST_PASSWORD", "", false, "set only one of routePassword, routePasswordEnv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := spec.ParseYAML(tt.yaml, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, set, err := parseCredential(conf, "routePassword")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || set != tt.set {
+				t.Errorf("parseCredential = %q, %v, want %q, %v", got, set, tt.want, tt.set)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package benthosADS
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// Tags of the UDP info response that only discovery uses.
+const (
+	adsUDPTagOSVersion   uint16 = 3
+	adsUDPTagTCVersion   uint16 = 4
+	adsUDPTagFingerprint uint16 = 18
+)
+
+// adsDiscoveryReplyLimit is the largest info response read; replies are a few hundred bytes.
+const adsDiscoveryReplyLimit = 4096
+
+var adsDiscoveryConf = service.NewConfigSpec().
+	Summary("Finds Beckhoff devices on the local networks with the UDP discovery broadcast and emits one message per device.").
+	Description("Every interval the input broadcasts the TwinCAT UDP info request on port 48899, the service also used for route " +
+		"registration, and waits responseTimeout for answers. Each responding device becomes one JSON message with its IP, " +
+		"AMS net ID, hostname, TwinCAT version and operating system; the devices of one round form one batch.").
+	Field(service.NewStringListField("broadcastAddresses").Description("Addresses to send the request to. Empty broadcasts on every IPv4 interface. Unicast addresses query a single device, e.g. across a router.").Default([]string{})).
+	Field(service.NewIntField("interval").Description("Time between discovery rounds in milliseconds.").Default(60000)).
+	Field(service.NewIntField("responseTimeout").Description("Time to wait for responses after each broadcast in milliseconds.").Default(2000))
+
+func init() {
+	err := service.RegisterBatchInput(
+		"ads_discovery", adsDiscoveryConf,
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
+			return newAdsDiscovery(conf, mgr)
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// adsDiscovery periodically broadcasts the UDP info request and reports the responders.
+type adsDiscovery struct {
+	addresses []string
+	interval  time.Duration
+	timeout   time.Duration
+	log       *service.Logger
+
+	next time.Time
+	done chan struct{}
+}
+
+// discoveredDevice is the JSON message emitted per device.
+type discoveredDevice struct {
+	IP             string `json:"ip"`
+	AMSNetID       string `json:"amsNetId"`
+	Hostname       string `json:"hostname"`
+	TwinCATVersion string `json:"twincatVersion,omitempty"`
+	OS             string `json:"os,omitempty"`
+	OSVersion      string `json:"osVersion,omitempty"`
+	Fingerprint    string `json:"fingerprint,omitempty"`
+	Time           string `json:"time"`
+}
+
+// discoveryTarget is one address the request is sent to, with the net ID it is sent from.
+type discoveryTarget struct {
+	addr   string
+	sender amsNetID
+}
+
+func newAdsDiscovery(conf *service.ParsedConfig, mgr *service.Resources) (*adsDiscovery, error) {
+	addresses, err := conf.FieldStringList("broadcastAddresses")
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addresses {
+		if net.ParseIP(a).To4() == nil {
+			return nil, fmt.Errorf("broadcastAddresses: %q is not an IPv4 address", a)
+		}
+	}
+	intervalMs, err := conf.FieldInt("interval")
+	if err != nil {
+		return nil, err
+	}
+	if intervalMs <= 0 {
+		return nil, fmt.Errorf("interval %d must be positive", intervalMs)
+	}
+	timeoutMs, err := conf.FieldInt("responseTimeout")
+	if err != nil {
+		return nil, err
+	}
+	if timeoutMs <= 0 || timeoutMs >= intervalMs {
+		return nil, fmt.Errorf("responseTimeout %d must be positive and shorter than interval", timeoutMs)
+	}
+	return &adsDiscovery{
+		addresses: addresses,
+		interval:  time.Duration(intervalMs) * time.Millisecond,
+		timeout:   time.Duration(timeoutMs) * time.Millisecond,
+		log:       mgr.Logger(),
+		done:      make(chan struct{}),
+	}, nil
+}
+
+func (d *adsDiscovery) Connect(ctx context.Context) error {
+	return nil
+}
+
+// targets returns where to send the request. Without configured addresses these are the
+// broadcast addresses of all IPv4 interfaces, each sent from the interface's own net ID.
+func (d *adsDiscovery) targets() ([]discoveryTarget, error) {
+	var targets []discoveryTarget
+	if len(d.addresses) > 0 {
+		for _, a := range d.addresses {
+			targets = append(targets, discoveryTarget{addr: a, sender: amsNetID{0, 0, 0, 0, 1, 1}})
+		}
+		return targets, nil
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagBroadcast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipNet.IP.To4()
+			if ip == nil || len(ipNet.Mask) != net.IPv4len {
+				continue
+			}
+			bcast := make(net.IP, net.IPv4len)
+			for i := range ip {
+				bcast[i] = ip[i] | ^ipNet.Mask[i]
+			}
+			targets = append(targets, discoveryTarget{
+				addr:   bcast.String(),
+				sender: amsNetID{ip[0], ip[1], ip[2], ip[3], 1, 1},
+			})
+		}
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no IPv4 interface with a broadcast address found; set broadcastAddresses")
+	}
+	return targets, nil
+}
+
+// discover runs one round and returns the devices that answered.
+func (d *adsDiscovery) discover(ctx context.Context) ([]discoveredDevice, error) {
+	targets, err := d.targets()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	sent := 0
+	for _, t := range targets {
+		req := marshalUDPRequest(adsUDPServiceInfo, 0, t.sender, nil)
+		addr := &net.UDPAddr{IP: net.ParseIP(t.addr), Port: adsUDPPort}
+		if _, err := conn.WriteToUDP(req, addr); err != nil {
+			d.log.Warnf("Discovery request to %s failed: %v", t.addr, err)
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		return nil, errors.New("discovery request could not be sent to any address")
+	}
+
+	deadline := time.Now().Add(d.timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	_ = conn.SetReadDeadline(deadline)
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	seen := map[amsNetID]bool{}
+	var devices []discoveredDevice
+	buf := make([]byte, adsDiscoveryReplyLimit)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return devices, nil
+			}
+			return devices, err
+		}
+		reply, err := parseUDPReply(buf[:n])
+		if err != nil || reply.service != adsUDPServiceInfo|adsUDPResponse || seen[reply.netID] {
+			continue
+		}
+		seen[reply.netID] = true
+		dev := describeDevice(reply, now)
+		dev.IP = from.IP.String()
+		devices = append(devices, dev)
+	}
+}
+
+// describeDevice decodes the tags of an info response.
+func describeDevice(r *adsUDPReply, now string) discoveredDevice {
+	dev := discoveredDevice{
+		AMSNetID: r.netID.String(),
+		Hostname: udpTagString(r.tags[adsUDPTagHost]),
+		Time:     now,
+	}
+	if v := r.tags[adsUDPTagTCVersion]; len(v) >= 4 {
+		dev.TwinCATVersion = fmt.Sprintf("%d.%d.%d", v[0], v[1], binary.LittleEndian.Uint16(v[2:]))
+	}
+	dev.OS, dev.OSVersion = decodeOSVersion(r.tags[adsUDPTagOSVersion])
+	dev.Fingerprint = udpTagString(r.tags[adsUDPTagFingerprint])
+	return dev
+}
+
+// udpTagString returns a NUL-terminated string tag.
+func udpTagString(b []byte) string {
+	s, _, _ := strings.Cut(string(b), "\x00")
+	return s
+}
+
+// decodeOSVersion decodes the OSVERSIONINFO-style tag: size, major, minor, build and
+// platform ID as uint32, followed by an optional UTF-16 service pack string.
+func decodeOSVersion(b []byte) (name, version string) {
+	if len(b) < 20 {
+		return "", ""
+	}
+	le := binary.LittleEndian
+	major, minor, build, platform := le.Uint32(b[4:]), le.Uint32(b[8:]), le.Uint32(b[12:]), le.Uint32(b[16:])
+	version = fmt.Sprintf("%d.%d.%d", major, minor, build)
+
+	switch platform {
+	case 1:
+		name = "Windows"
+	case 2:
+		name = "Windows NT"
+	case 3:
+		name = "Windows CE"
+	default:
+		name = "platform " + strconv.FormatUint(uint64(platform), 10)
+	}
+	if rest := b[20:]; len(rest) >= 2 {
+		u := make([]uint16, 0, len(rest)/2)
+		for i := 0; i+1 < len(rest); i += 2 {
+			c := le.Uint16(rest[i:])
+			if c == 0 {
+				break
+			}
+			u = append(u, c)
+		}
+		if csd := strings.TrimSpace(string(utf16.Decode(u))); csd != "" {
+			name += " " + csd
+		}
+	}
+	return name, version
+}
+
+func (d *adsDiscovery) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	for {
+		if wait := time.Until(d.next); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-d.done:
+				return nil, nil, service.ErrEndOfInput
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			}
+		}
+		d.next = time.Now().Add(d.interval)
+
+		devices, err := d.discover(ctx)
+		if err != nil {
+			d.log.Errorf("Discovery failed: %v", err)
+		}
+		if len(devices) == 0 {
+			d.log.Debugf("No Beckhoff devices answered the discovery request")
+			continue
+		}
+		d.log.Debugf("Discovered %d Beckhoff devices", len(devices))
+
+		batch := make(service.MessageBatch, 0, len(devices))
+		for _, dev := range devices {
+			data, err := json.Marshal(dev)
+			if err != nil {
+				return nil, nil, err
+			}
+			msg := service.NewMessage(data)
+			msg.MetaSet("target_ams", dev.AMSNetID)
+			msg.MetaSet("target_ip", dev.IP)
+			batch = append(batch, msg)
+		}
+		return batch, func(context.Context, error) error { return nil }, nil
+	}
+}
+
+func (d *adsDiscovery) Close(ctx context.Context) error {
+	select {
+	case <-d.done:
+	default:
+		close(d.done)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package benthosADS
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/RuneRoven/benthosADS/adstest"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+func TestDescribeDevice(t *testing.T) {
+	const now = "2026-01-02T03:04:05Z"
+	tests := []struct {
+		name  string
+		reply string // UDP info reply as sent by the device
+		want  discoveredDevice
+	}{
+		{
+			name: "TwinCAT 3 on Windows 10 LTSC",
+			reply: "03661471" + "00000000" + "01000080" + "0550c9e80101" + "1027" + "04000000" +
+				"05000a00" + "43582d353043394538" + "00" + // host CX-50C9E8
+				"03001e00" + "1e000000" + "0a000000" + "00000000" + "63450000" + "02000000" + "4c00540053004300" + "0000" +
+				"04000400" + "0301ba0f" + // 3.1.4026
+				"12000700" + "336631633561" + "00", // fingerprint
+			want: discoveredDevice{
+				AMSNetID: "5.80.201.232.1.1", Hostname: "CX-50C9E8", TwinCATVersion: "3.1.4026",
+				OS: "Windows NT LTSC", OSVersion: "10.0.17763", Fingerprint: "3f1c5a", Time: now,
+			},
+		},
+		{
+			name: "TwinCAT 2 on Windows CE",
+			reply: "03661471" + "00000000" + "01000080" + "ac1015040101" + "1027" + "03000000" +
+				"05000a00" + "43582d304131423243" + "00" + // host CX-0A1B2C
+				"04000400" + "020b0009" + // 2.11.2304
+				"03001600" + "16000000" + "07000000" + "00000000" + "03000000" + "03000000" + "0000",
+			want: discoveredDevice{
+				AMSNetID: "172.16.21.4.1.1", Hostname: "CX-0A1B2C", TwinCATVersion: "2.11.2304",
+				OS: "Windows CE", OSVersion: "7.0.3", Time: now,
+			},
+		},
+		{
+			name:  "hostname only",
+			reply: "03661471" + "00000000" + "01000080" + "c0a8010a0101" + "1027" + "01000000" + "05000400" + "706c6300",
+			want:  discoveredDevice{AMSNetID: "192.168.1.10.1.1", Hostname: "plc", Time: now},
+		},
+		{
+			name: "short version tags",
+			reply: "03661471" + "00000000" + "01000080" + "c0a8010a0101" + "1027" + "02000000" +
+				"04000200" + "0301" + "03000400" + "14000000",
+			want: discoveredDevice{AMSNetID: "192.168.1.10.1.1", Time: now},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := hex.DecodeString(tt.reply)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply, err := parseUDPReply(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply.service != adsUDPServiceInfo|adsUDPResponse {
+				t.Errorf("service = 0x%X, want an info response", reply.service)
+			}
+			if got := describeDevice(reply, now); got != tt.want {
+				t.Errorf("describeDevice = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeOSVersionPlatforms(t *testing.T) {
+	for platform, want := range map[byte]string{1: "Windows", 2: "Windows NT", 3: "Windows CE", 9: "platform 9"} {
+		b := make([]byte, 20)
+		b[4], b[16] = 6, platform
+		if name, version := decodeOSVersion(b); name != want || version != "6.0.0" {
+			t.Errorf("platform %d: %q %q, want %q 6.0.0", platform, name, version, want)
+		}
+	}
+}
+
+func TestDiscoverRound(t *testing.T) {
+	srv, err := adstest.NewServer(adstest.Config{UDPAddr: "127.0.0.1:48899", Hostname: "CX-TEST"})
+	if err != nil {
+		t.Skipf("UDP route service port unavailable: %v", err)
+	}
+	defer srv.Close()
+	d := &adsDiscovery{
+		addresses: []string{"127.0.0.1", "127.0.0.1"}, // the device answers twice but is reported once
+		interval:  time.Minute,
+		timeout:   500 * time.Millisecond,
+		log:       service.MockResources().Logger(),
+		done:      make(chan struct{}),
+	}
+	devices, err := d.discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("devices = %+v, want one", devices)
+	}
+	dev := devices[0]
+	if dev.IP != "127.0.0.1" || dev.AMSNetID != srv.NetID() || dev.Hostname != "CX-TEST" ||
+		dev.TwinCATVersion != "3.1.4024" || dev.OS != "Windows NT" || dev.OSVersion != "10.0.17763" {
+		t.Errorf("device = %+v", dev)
+	}
+}
@@ -0,0 +1,284 @@
+package benthosADS
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// adsNotificationGuidance is the number of notifications per connection Beckhoff recommends
+// not to exceed.
+const adsNotificationGuidance = 500
+
+// symbolFilter selects the symbols of a table for a generated config. Patterns use '*' and '?'
+// wildcards and match case-insensitively, like wildcard symbols of the ads input.
+type symbolFilter struct {
+	include    []string
+	exclude    []string
+	types      []string // matched on the declared type and its base type
+	attributes []string // name or name=value
+	maxSize    uint32   // 0 means no limit
+}
+
+// match reports whether sym passes the filter. Invalid patterns were rejected by validate.
+func (f *symbolFilter) match(t *symbolTable, sym *symbolInfo) bool {
+	name := strings.ToLower(sym.Name)
+	if len(f.include) > 0 && !matchAny(f.include, name) {
+		return false
+	}
+	if matchAny(f.exclude, name) {
+		return false
+	}
+	if len(f.types) > 0 && !matchAny(f.types, strings.ToLower(sym.DataType)) &&
+		!matchAny(f.types, strings.ToLower(t.baseTypeName(sym.DataType))) {
+		return false
+	}
+	if len(f.attributes) > 0 && !f.matchAttributes(sym) {
+		return false
+	}
+	return f.maxSize == 0 || sym.Size <= f.maxSize
+}
+
+// matchAttributes reports whether sym has one of the filter's attributes.
+func (f *symbolFilter) matchAttributes(sym *symbolInfo) bool {
+	for _, a := range f.attributes {
+		name, value, hasValue := strings.Cut(a, "=")
+		got, ok := sym.Attributes[strings.ToLower(strings.TrimSpace(name))]
+		if ok && (!hasValue || strings.EqualFold(got, strings.TrimSpace(value))) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the filter's patterns.
+func (f *symbolFilter) validate() error {
+	for _, patterns := range [][]string{f.include, f.exclude, f.types} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestTiming suggests maxDelay and cycleTime in ms for a symbol. Flags and states are
+// checked often since they are cheap and their changes matter, analog values at the input
+// default, and text and large structs rarely.
+func suggestTiming(baseType string, size uint32) (maxDelay, cycleTime int) {
+	upper := strings.ToUpper(baseType)
+	switch {
+	case upper == "BOOL":
+		return 100, 100
+	case strings.HasPrefix(upper, "STRING") || strings.HasPrefix(upper, "WSTRING"):
+		return 1000, 5000
+	case upper == "REAL" || upper == "LREAL":
+		return 100, 1000
+	case isPrimitive(upper):
+		return 100, 500
+	case size > 1024:
+		return 1000, 5000
+	default:
+		return 100, 1000
+	}
+}
+
+// generatedConnFields are the connection fields copied into a generated config.
+var generatedConnFields = []string{
+	"targetIP", "targetAMS", "targetPort", "runtimePort", "hostAMS", "hostPort", "requestTimeout",
+	"routeUsername", "routeUsernameFile", "routeUsernameEnv", "routePassword", "routePasswordFile", "routePasswordEnv", "routeHostAddress", "routeCredentials", "routeName", "routeLifetime",
+	"tls", "mqtt", "connectionLabel",
+}
+
+func cliGenerate(c *cli.Context) error {
+	readType := c.String("readType")
+	if readType != "notification" && readType != "interval" {
+		return fmt.Errorf("readType %q must be notification or interval", readType)
+	}
+	if c.Int("maxSize") < 0 {
+		return fmt.Errorf("maxSize %d must not be negative", c.Int("maxSize"))
+	}
+	filter := &symbolFilter{
+		include:    lowerAll(c.StringSlice("include")),
+		exclude:    lowerAll(c.StringSlice("exclude")),
+		types:      lowerAll(c.StringSlice("type")),
+		attributes: c.StringSlice("attribute"),
+		maxSize:    uint32(c.Int("maxSize")),
+	}
+	if err := filter.validate(); err != nil {
+		return err
+	}
+	fields, err := cliConnFields(c)
+	if err != nil {
+		return err
+	}
+
+	conn, err := cliConnect(c)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+	info, err := readUploadInfo(c.Context, conn.session)
+	if err != nil {
+		return err
+	}
+	table, err := uploadSymbolTable(c.Context, conn.session, info)
+	if err != nil {
+		return err
+	}
+
+	var selected []*symbolInfo
+	for _, sym := range table.Symbols {
+		if filter.match(table, sym) {
+			selected = append(selected, sym)
+		}
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("none of the %d symbols matches the filters", len(table.Symbols))
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Name < selected[j].Name })
+
+	if vars := redactSecrets(fields); len(vars) > 0 {
+		fmt.Fprintf(c.App.ErrWriter, "Passwords are written as references to %s, set the variables before running the config\n", strings.Join(vars, ", "))
+	}
+
+	var buf bytes.Buffer
+	if err = writeGeneratedConfig(&buf, fields, table, selected, readType, fmt.Sprintf("%s:%d", conn.targetAMS, conn.runtimePort)); err != nil {
+		return err
+	}
+	if readType == "notification" && len(selected) > adsNotificationGuidance {
+		fmt.Fprintf(c.App.ErrWriter, "Warning: %d notifications exceed the ~%d per connection Beckhoff recommends; "+
+			"narrow the filters, set maxNotificationsPerConnection or use --readType interval\n", len(selected), adsNotificationGuidance)
+	}
+
+	if out := c.String("output"); out != "" {
+		if err = writeFileAtomic(out, buf.Bytes()); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.App.ErrWriter, "Wrote %d of %d symbols to %s\n", len(selected), len(table.Symbols), out)
+		return nil
+	}
+	_, err = c.App.Writer.Write(buf.Bytes())
+	return err
+}
+
+// redactSecrets replaces the literal passwords among the connection fields with environment
+// variable references, so a generated config never holds one. It returns the variables to set.
+func redactSecrets(fields map[string]any) []string {
+	var vars []string
+	routePassword := func(m map[string]any, env string) {
+		if pw, ok := m["routePassword"].(string); ok && pw != "" {
+			delete(m, "routePassword")
+			m["routePasswordEnv"] = env
+			vars = append(vars, env)
+		}
+	}
+	routePassword(fields, "ADS_ROUTE_PASSWORD")
+	if creds, ok := fields["routeCredentials"].([]any); ok {
+		for i, e := range creds {
+			if m, ok := e.(map[string]any); ok {
+				routePassword(m, fmt.Sprintf("ADS_ROUTE_PASSWORD_%d", i+1))
+			}
+		}
+	}
+	// mqtt has no Env variant; Benthos interpolates environment variables in any string field.
+	if m, ok := fields["mqtt"].(map[string]any); ok {
+		if pw, ok := m["password"].(string); ok && pw != "" {
+			m["password"] = "${ADS_MQTT_PASSWORD}"
+			vars = append(vars, "ADS_MQTT_PASSWORD")
+		}
+	}
+	return vars
+}
+
+// writeGeneratedConfig writes a Benthos config with an ads input for the selected symbols and a
+// stdout output.
+func writeGeneratedConfig(w io.Writer, fields map[string]any, t *symbolTable, selected []*symbolInfo, readType, target string) error {
+	fmt.Fprintf(w, "# Generated by 'ads generate' from %s at %s.\n", target, time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "# %d of %d symbols; the suffixes are suggested maxDelay:cycleTime values for their type.\n", len(selected), len(t.Symbols))
+	fmt.Fprintln(w, "input:")
+	fmt.Fprintln(w, "  ads:")
+
+	conn := map[string]any{}
+	for _, name := range generatedConnFields {
+		if v, ok := fields[name]; ok {
+			conn[name] = v
+		}
+	}
+	if len(conn) > 0 {
+		data, err := yaml.Marshal(conn)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+	}
+
+	fmt.Fprintf(w, "    readType: %s\n", readType)
+	loadSymbols := false
+	for _, sym := range selected {
+		if len(sym.Arrays) > 0 || !isPrimitive(t.baseTypeName(sym.DataType)) {
+			loadSymbols = true
+			break
+		}
+	}
+	if loadSymbols {
+		fmt.Fprintln(w, "    loadSymbols: true    # struct and array symbols are decoded with the symbol table")
+	}
+	if readType == "notification" && len(selected) > adsNotificationGuidance {
+		fmt.Fprintf(w, "    # %d notifications exceed the ~%d Beckhoff recommends per connection.\n", len(selected), adsNotificationGuidance)
+		fmt.Fprintln(w, "    maxNotificationsPerConnection: 400")
+		fmt.Fprintln(w, "    notificationOverflow: shard")
+	}
+
+	fmt.Fprintln(w, "    symbols:")
+	entries := make([]string, len(selected))
+	width := 0
+	for i, sym := range selected {
+		entry := sym.Name
+		if readType == "notification" {
+			maxDelay, cycleTime := suggestTiming(t.baseTypeName(sym.DataType), sym.Size)
+			entry += ":" + strconv.Itoa(maxDelay) + ":" + strconv.Itoa(cycleTime)
+		}
+		entries[i] = yamlQuote(entry)
+		width = max(width, len(entries[i]))
+	}
+	for i, sym := range selected {
+		fmt.Fprintf(w, "      - %-*s  # %s\n", width, entries[i], sym.DataType)
+	}
+	fmt.Fprintln(w, "output:")
+	fmt.Fprintln(w, "  stdout: {}")
+	return nil
+}
+
+// yamlQuote single-quotes s for YAML.
+func yamlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func lowerAll(in []string) []string {
+	out := make([]string, len(in))
+	for i, s := range in {
+		out[i] = strings.ToLower(strings.TrimSpace(s))
+	}
+	return out
+}
@@ -0,0 +1,211 @@
+package benthosADS
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// generateTable is a symbol table with one symbol of each kind the generator tells apart.
+func generateTable() *symbolTable {
+	t := &symbolTable{
+		Symbols: map[string]*symbolInfo{},
+		DataTypes: map[string]*dataTypeInfo{
+			"t_speed":   {Name: "T_Speed", BaseType: "LREAL", Size: 8},
+			"st_motor":  {Name: "ST_Motor", Size: 16, SubItems: []subItemInfo{{Name: "speed", DataType: "LREAL", Size: 8}, {Name: "running", DataType: "BOOL", Size: 1, Offset: 8}}},
+			"st_recipe": {Name: "ST_Recipe", Size: 2048, SubItems: []subItemInfo{{Name: "steps", DataType: "ARRAY [1..256] OF LREAL", Size: 2048}}},
+		},
+	}
+	for _, sym := range []*symbolInfo{
+		{Name: "MAIN.bReady", DataType: "BOOL", Size: 1},
+		{Name: "MAIN.counter", DataType: "DINT", Size: 4, Attributes: map[string]string{"opc.uaaccess": "1"}},
+		{Name: "MAIN.speed", DataType: "T_Speed", Size: 8},
+		{Name: "MAIN.motor", DataType: "ST_Motor", Size: 16, Attributes: map[string]string{"tcrpcenable": ""}},
+		{Name: "MAIN.sText", DataType: "STRING(80)", Size: 81},
+		{Name: "GVL.recipe", DataType: "ST_Recipe", Size: 2048},
+		{Name: "GVL.levels", DataType: "ARRAY [0..9] OF INT", Size: 20, Arrays: []arrayDim{{LBound: 0, Elements: 10}}},
+	} {
+		t.Symbols[strings.ToLower(sym.Name)] = sym
+	}
+	return t
+}
+
+func TestSymbolFilterMatch(t *testing.T) {
+	table := generateTable()
+	names := []string{"GVL.levels", "GVL.recipe", "MAIN.bReady", "MAIN.counter", "MAIN.motor", "MAIN.sText", "MAIN.speed"}
+	tests := []struct {
+		name   string
+		filter symbolFilter
+		want   []string
+	}{
+		{"no filter", symbolFilter{}, names},
+		{"include", symbolFilter{include: []string{"main.*"}}, []string{"MAIN.bReady", "MAIN.counter", "MAIN.motor", "MAIN.sText", "MAIN.speed"}},
+		{"include and exclude", symbolFilter{include: []string{"main.*"}, exclude: []string{"main.s*", "main.b?eady"}}, []string{"MAIN.counter", "MAIN.motor"}},
+		{"declared type", symbolFilter{types: []string{"st_*"}}, []string{"GVL.recipe", "MAIN.motor"}},
+		{"base type of an alias", symbolFilter{types: []string{"lreal"}}, []string{"MAIN.speed"}},
+		{"string type", symbolFilter{types: []string{"string*"}}, []string{"MAIN.sText"}},
+		{"attribute", symbolFilter{attributes: []string{"TcRpcEnable"}}, []string{"MAIN.motor"}},
+		{"attribute missing", symbolFilter{attributes: []string{"OPC.UA.DA"}}, nil},
+		{"attribute value matches", symbolFilter{attributes: []string{"opc.uaaccess = 1"}}, []string{"MAIN.counter"}},
+		{"attribute value differs", symbolFilter{attributes: []string{"opc.uaaccess=3"}}, nil},
+		{"maxSize", symbolFilter{maxSize: 8}, []string{"MAIN.bReady", "MAIN.counter", "MAIN.speed"}},
+		{"all filters", symbolFilter{include: []string{"main.*"}, exclude: []string{"*ready"}, types: []string{"dint", "bool"}, maxSize: 4}, []string{"MAIN.counter"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.validate(); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, name := range names {
+				if tt.filter.match(table, table.Symbols[strings.ToLower(name)]) {
+					got = append(got, name)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matched %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if err := (&symbolFilter{exclude: []string{"main.[a"}}).validate(); err == nil {
+		t.Error("validate accepted an invalid pattern")
+	}
+}
+
+func TestWriteGeneratedConfig(t *testing.T) {
+	table := generateTable()
+	symbols := func(names ...string) []*symbolInfo {
+		var out []*symbolInfo
+		for _, name := range names {
+			out = append(out, table.Symbols[strings.ToLower(name)])
+		}
+		return out
+	}
+	fields := map[string]any{
+		"targetIP":         "192.168.3.10",
+		"targetAMS":        "192.168.3.10.1.1",
+		"runtimePort":      851,
+		"routePasswordEnv": "ADS_ROUTE_PASSWORD",
+		"symbols":          []any{"MAIN.other"}, // not a connection field
+	}
+
+	tests := []struct {
+		name     string
+		selected []*symbolInfo
+		readType string
+		want     string // the config after the header line with the time
+	}{
+		{
+			name:     "interval with primitives only",
+			selected: symbols("MAIN.bReady", "MAIN.counter", "MAIN.speed"),
+			readType: "interval",
+			want: `# 3 of 7 symbols; the suffixes are suggested maxDelay:cycleTime values for their type.
+input:
+  ads:
+    routePasswordEnv: ADS_ROUTE_PASSWORD
+    runtimePort: 851
+    targetAMS: 192.168.3.10.1.1
+    targetIP: 192.168.3.10
+    readType: interval
+    symbols:
+      - 'MAIN.bReady'   # BOOL
+      - 'MAIN.counter'  # DINT
+      - 'MAIN.speed'    # T_Speed
+output:
+  stdout: {}
+`,
+		},
+		{
+			name:     "notification with timing and structs",
+			selected: symbols("GVL.levels", "GVL.recipe", "MAIN.bReady", "MAIN.counter", "MAIN.motor", "MAIN.sText", "MAIN.speed"),
+			readType: "notification",
+			want: `# 7 of 7 symbols; the suffixes are suggested maxDelay:cycleTime values for their type.
+input:
+  ads:
+    routePasswordEnv: ADS_ROUTE_PASSWORD
+    runtimePort: 851
+    targetAMS: 192.168.3.10.1.1
+    targetIP: 192.168.3.10
+    readType: notification
+    loadSymbols: true    # struct and array symbols are decoded with the symbol table
+    symbols:
+      - 'GVL.levels:100:1000'   # ARRAY [0..9] OF INT
+      - 'GVL.recipe:1000:5000'  # ST_Recipe
+      - 'MAIN.bReady:100:100'   # BOOL
+      - 'MAIN.counter:100:500'  # DINT
+      - 'MAIN.motor:100:1000'   # ST_Motor
+      - 'MAIN.sText:1000:5000'  # STRING(80)
+      - 'MAIN.speed:100:1000'   # T_Speed
+output:
+  stdout: {}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeGeneratedConfig(&buf, fields, table, tt.selected, tt.readType, "192.168.3.10.1.1:851"); err != nil {
+				t.Fatal(err)
+			}
+			header, got, _ := strings.Cut(buf.String(), "\n")
+			if !strings.HasPrefix(header, "# Generated by 'ads generate' from 192.168.3.10.1.1:851 at ") {
+				t.Errorf("header = %q", header)
+			}
+			if got != tt.want {
+				t.Errorf("config =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+
+	// More notifications than Beckhoff recommends are spread over several connections.
+	many := &symbolTable{Symbols: map[string]*symbolInfo{}}
+	var selected []*symbolInfo
+	for i := 0; i <= adsNotificationGuidance; i++ {
+		sym := &symbolInfo{Name: fmt.Sprintf("GVL.value%03d", i), DataType: "INT", Size: 2}
+		many.Symbols[strings.ToLower(sym.Name)] = sym
+		selected = append(selected, sym)
+	}
+	var buf bytes.Buffer
+	if err := writeGeneratedConfig(&buf, nil, many, selected, "notification", "plc"); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.Contains(out, "    maxNotificationsPerConnection: 400\n    notificationOverflow: shard\n") ||
+		strings.Contains(out, "loadSymbols") {
+		t.Errorf("config for %d notifications =\n%s", len(selected), out)
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	fields := map[string]any{
+		"targetIP":      "192.168.1.10",
+		"routeUsername": "Administrator",
+		"routePassword": "1",
+		"routeCredentials": []any{
+			map[string]any{"match": "line1-*", "routePassword": "line1"},
+			map[string]any{"match": "line2-*", "routePasswordFile": "/run/secrets/line2"},
+		},
+		"mqtt": map[string]any{"broker": "tcp://broker:1883", "username": "ads", "password": "broker"},
+		"tls":  map[string]any{"certFile": "/certs/client.pem", "keyFile": "/certs/client.key"},
+	}
+	vars := redactSecrets(fields)
+
+	want := map[string]any{
+		"targetIP":         "192.168.1.10",
+		"routeUsername":    "Administrator",
+		"routePasswordEnv": "ADS_ROUTE_PASSWORD",
+		"routeCredentials": []any{
+			map[string]any{"match": "line1-*", "routePasswordEnv": "ADS_ROUTE_PASSWORD_1"},
+			map[string]any{"match": "line2-*", "routePasswordFile": "/run/secrets/line2"},
+		},
+		"mqtt": map[string]any{"broker": "tcp://broker:1883", "username": "ads", "password": "${ADS_MQTT_PASSWORD}"},
+		"tls":  map[string]any{"certFile": "/certs/client.pem", "keyFile": "/certs/client.key"},
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields = %v\nwant %v", fields, want)
+	}
+	if wantVars := []string{"ADS_ROUTE_PASSWORD", "ADS_ROUTE_PASSWORD_1", "ADS_MQTT_PASSWORD"}; !reflect.DeepEqual(vars, wantVars) {
+		t.Errorf("vars = %v, want %v", vars, wantVars)
+	}
+}
@@ -0,0 +1,573 @@
+package benthosADS
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// defaultMQTTTopic is the topic TwinCAT uses for its virtual AMS network unless configured
+// otherwise in the MQTT route.
+const defaultMQTTTopic = "VirtualAmsNetwork1"
+
+// adsMQTTField describes the ADS-over-MQTT settings shared by every ADS component.
+func adsMQTTField() *service.ConfigField {
+	return service.NewObjectField("mqtt",
+		service.NewBoolField("enabled").Description("Exchange AMS packets through an MQTT broker instead of a TCP connection to the PLC.").Default(false),
+		service.NewStringField("broker").Description("Broker URL, e.g. tcp://broker:1883 or ssl://broker:8883.").Default(""),
+		service.NewStringField("topic").Description("Topic of the virtual AMS network, as configured in the PLC's MQTT route.").Default(defaultMQTTTopic),
+		service.NewStringField("clientID").Description("MQTT client ID. Defaults to benthosADS-<hostAMS>.").Default(""),
+		service.NewStringField("username").Description("Broker username.").Default(""),
+		service.NewStringField("password").Description("Broker password.").Default("").Secret(),
+		service.NewStringField("caFile").Description("PEM file with the CA of the broker certificate (ssl:// only). Defaults to the system roots.").Default(""),
+		service.NewIntField("keepAlive").Description("MQTT keep alive in seconds.").Default(60),
+	).Description("ADS-over-MQTT settings. The PLC must have an MQTT route to the same broker and topic.").Optional()
+}
+
+// adsMQTTConfig is the parsed mqtt object.
+type adsMQTTConfig struct {
+	addr      string
+	tls       *tls.Config
+	topic     string
+	clientID  string
+	username  string
+	password  string
+	keepAlive time.Duration
+}
+
+// parseAdsMQTTConfig reads the mqtt object; it returns nil when ADS-over-MQTT is not enabled.
+func parseAdsMQTTConfig(conf *service.ParsedConfig) (*adsMQTTConfig, error) {
+	if !conf.Contains("mqtt") {
+		return nil, nil
+	}
+	enabled, err := conf.FieldBool("mqtt", "enabled")
+	if err != nil || !enabled {
+		return nil, err
+	}
+
+	m := &adsMQTTConfig{}
+	broker, err := conf.FieldString("mqtt", "broker")
+	if err != nil {
+		return nil, err
+	}
+	if m.topic, err = conf.FieldString("mqtt", "topic"); err != nil {
+		return nil, err
+	}
+	if m.clientID, err = conf.FieldString("mqtt", "clientID"); err != nil {
+		return nil, err
+	}
+	if m.username, err = conf.FieldString("mqtt", "username"); err != nil {
+		return nil, err
+	}
+	if m.password, err = conf.FieldString("mqtt", "password"); err != nil {
+		return nil, err
+	}
+	caFile, err := conf.FieldString("mqtt", "caFile")
+	if err != nil {
+		return nil, err
+	}
+	keepAlive, err := conf.FieldInt("mqtt", "keepAlive")
+	if err != nil {
+		return nil, err
+	}
+	if keepAlive < 0 || keepAlive > 65535 {
+		return nil, fmt.Errorf("mqtt.keepAlive %d out of range 0–65535", keepAlive)
+	}
+	m.keepAlive = time.Duration(keepAlive) * time.Second
+
+	m.topic = strings.Trim(m.topic, "/")
+	if m.topic == "" || strings.ContainsAny(m.topic, "+#") {
+		return nil, fmt.Errorf("mqtt.topic %q must be a plain topic without wildcards", m.topic)
+	}
+
+	u, err := url.Parse(broker)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("mqtt.broker %q must be a URL such as tcp://broker:1883", broker)
+	}
+	port := u.Port()
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		if port == "" {
+			port = "1883"
+		}
+	case "ssl", "tls", "mqtts":
+		if port == "" {
+			port = "8883"
+		}
+		m.tls = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+		if caFile != "" {
+			pem, err := os.ReadFile(caFile)
+			if err != nil {
+				return nil, fmt.Errorf("mqtt.caFile: %w", err)
+			}
+			m.tls.RootCAs = x509.NewCertPool()
+			if !m.tls.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("mqtt.caFile: no certificates found in %s", caFile)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("mqtt.broker: unsupported scheme %q (use tcp:// or ssl://)", u.Scheme)
+	}
+	m.addr = net.JoinHostPort(u.Hostname(), port)
+	return m, nil
+}
+
+// MQTT control packet types.
+const (
+	mqttConnect    byte = 1
+	mqttConnack    byte = 2
+	mqttPublish    byte = 3
+	mqttPuback     byte = 4
+	mqttSubscribe  byte = 8
+	mqttSuback     byte = 9
+	mqttPingreq    byte = 12
+	mqttPingresp   byte = 13
+	mqttDisconnect byte = 14
+)
+
+// mqttConnackErrors are the MQTT 3.1.1 CONNACK return codes.
+var mqttConnackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client ID rejected",
+	3: "server unavailable",
+	4: "bad username or password",
+	5: "not authorized",
+}
+
+// mqttClient is a minimal MQTT 3.1.1 client: QoS 0 publish and subscribe, which is all
+// ADS-over-MQTT needs since AMS has its own request/response handling.
+type mqttClient struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	writeMu sync.Mutex
+}
+
+// mqttWill is the last-will message the broker publishes when the client disappears.
+type mqttWill struct {
+	topic   string
+	payload []byte
+}
+
+func appendMQTTString(buf []byte, s string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+// dialMQTT connects to the broker and subscribes to topics before returning, so no message
+// addressed to this client can be missed.
+func dialMQTT(ctx context.Context, m *adsMQTTConfig, clientID string, will *mqttWill, topics []string) (*mqttClient, error) {
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if m.tls != nil {
+		conn, err = (&tls.Dialer{NetDialer: d, Config: m.tls}).DialContext(ctx, "tcp", m.addr)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", m.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connecting to MQTT broker %s: %w", m.addr, err)
+	}
+	c := &mqttClient{conn: conn, r: bufio.NewReader(conn)}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	}
+	if err = c.handshake(m, clientID, will, topics); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("MQTT broker %s: %w", m.addr, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (c *mqttClient) handshake(m *adsMQTTConfig, clientID string, will *mqttWill, topics []string) error {
+	flags := byte(0x02) // clean session
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4, 0, 0, 0) // protocol level, flags, keep alive (patched below)
+	if will != nil {
+		flags |= 0x04 | 0x20 // will, will retain
+	}
+	if m.username != "" {
+		flags |= 0x80
+		if m.password != "" {
+			flags |= 0x40
+		}
+	}
+	body[7] = flags
+	binary.BigEndian.PutUint16(body[8:], uint16(m.keepAlive/time.Second))
+	body = appendMQTTString(body, clientID)
+	if will != nil {
+		body = appendMQTTString(body, will.topic)
+		body = appendMQTTString(body, string(will.payload))
+	}
+	if m.username != "" {
+		body = appendMQTTString(body, m.username)
+		if m.password != "" {
+			body = appendMQTTString(body, m.password)
+		}
+	}
+	if err := c.write(mqttConnect<<4, body); err != nil {
+		return err
+	}
+	typ, data, err := c.read()
+	if err != nil {
+		return err
+	}
+	if typ != mqttConnack || len(data) < 2 {
+		return fmt.Errorf("expected CONNACK, got packet type %d", typ)
+	}
+	if data[1] != 0 {
+		if msg, ok := mqttConnackErrors[data[1]]; ok {
+			return fmt.Errorf("connection refused: %s", msg)
+		}
+		return fmt.Errorf("connection refused with code %d", data[1])
+	}
+
+	sub := binary.BigEndian.AppendUint16(nil, 1)
+	for _, t := range topics {
+		sub = appendMQTTString(sub, t)
+		sub = append(sub, 0)
+	}
+	if err = c.write(mqttSubscribe<<4|0x02, sub); err != nil {
+		return err
+	}
+	for {
+		if typ, data, err = c.read(); err != nil {
+			return err
+		}
+		if typ != mqttSuback {
+			continue
+		}
+		if len(data) < 2+len(topics) {
+			return errors.New("malformed SUBACK")
+		}
+		for i, code := range data[2:] {
+			if code == 0x80 && i < len(topics) {
+				return fmt.Errorf("subscription to %q refused", topics[i])
+			}
+		}
+		return nil
+	}
+}
+
+// write sends one control packet with the given first header byte.
+func (c *mqttClient) write(header byte, body []byte) error {
+	buf := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if n == 0 {
+			break
+		}
+	}
+	buf = append(buf, body...)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// read returns the type and body of the next control packet.
+func (c *mqttClient) read() (byte, []byte, error) {
+	typ, _, body, err := c.readPacket()
+	return typ, body, err
+}
+
+// readPacket returns the next control packet with the flags of its fixed header.
+func (c *mqttClient) readPacket() (typ, flags byte, body []byte, err error) {
+	header, err := c.r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	n, shift := 0, 0
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, 0, nil, errors.New("malformed MQTT packet length")
+		}
+	}
+	if n > amsMaxPacketLen {
+		return 0, 0, nil, fmt.Errorf("MQTT packet of %d bytes exceeds limit", n)
+	}
+	body = make([]byte, n)
+	if _, err = io.ReadFull(c.r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return header >> 4, header & 0x0f, body, nil
+}
+
+func (c *mqttClient) publish(topic string, payload []byte, retain bool) error {
+	header := mqttPublish << 4
+	if retain {
+		header |= 0x01
+	}
+	return c.write(header, append(appendMQTTString(nil, topic), payload...))
+}
+
+// run delivers incoming publishes to handle and keeps the connection alive until it fails
+// or is closed.
+func (c *mqttClient) run(keepAlive time.Duration, handle func(topic string, payload []byte)) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	if keepAlive > 0 {
+		go func() {
+			ticker := time.NewTicker(keepAlive / 2)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if c.write(mqttPingreq<<4, nil) != nil {
+						return
+					}
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+	for {
+		if keepAlive > 0 {
+			_ = c.conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		}
+		typ, flags, body, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+		if typ != mqttPublish {
+			continue
+		}
+		if len(body) < 2 {
+			return errors.New("malformed PUBLISH")
+		}
+		topicLen := int(binary.BigEndian.Uint16(body))
+		rest := body[2:]
+		if topicLen > len(rest) {
+			return errors.New("malformed PUBLISH")
+		}
+		topic, rest := string(rest[:topicLen]), rest[topicLen:]
+		if qos := flags >> 1 & 0x03; qos > 0 {
+			if len(rest) < 2 {
+				return errors.New("malformed PUBLISH")
+			}
+			// Subscriptions are QoS 0, but acknowledge anyway in case the broker upgrades.
+			if qos == 1 {
+				_ = c.write(mqttPuback<<4, rest[:2])
+			}
+			rest = rest[2:]
+		}
+		handle(topic, rest)
+	}
+}
+
+func (c *mqttClient) disconnect() {
+	_ = c.write(mqttDisconnect<<4, nil)
+	c.conn.Close()
+}
+
+// mqttInfo is the retained presence message TwinCAT devices publish on <topic>/<NetID>/info.
+func mqttInfo(online bool) []byte {
+	return fmt.Appendf(nil, `<info><online name="benthosADS" osVersion="%s" osPlatform="%s">%t</online></info>`, runtime.GOOS, runtime.GOARCH, online)
+}
+
+// mqttTunnel carries a go-ads session over ADS-over-MQTT. The session connects to a loopback
+// listener; AMS packets it sends are published to <topic>/<target NetID>/ams (or .../ams/res
+// for responses) and packets published to this client's NetID are written back to it.
+type mqttTunnel struct {
+	cfg      *adsMQTTConfig
+	clientID string
+	local    amsNetID
+	listener *sessionListener
+	log      *service.Logger
+
+	mu     sync.Mutex
+	client *mqttClient // nil while disconnected from the broker
+	conn   net.Conn    // current session connection
+	closed bool
+}
+
+// openMQTTTunnel connects to the broker as hostAMS and returns a tunnel whose local address
+// the session should connect to.
+func openMQTTTunnel(ctx context.Context, m *adsMQTTConfig, hostAMS string, log *service.Logger) (*mqttTunnel, error) {
+	local, err := parseAMSNetID(hostAMS)
+	if err != nil {
+		return nil, fmt.Errorf("hostAMS: %w", err)
+	}
+	clientID := m.clientID
+	if clientID == "" {
+		clientID = "benthosADS-" + hostAMS
+	}
+	tun := &mqttTunnel{cfg: m, clientID: clientID, local: local, log: log}
+	if err = tun.connect(ctx); err != nil {
+		return nil, err
+	}
+	if tun.listener, err = listenSession(); err != nil {
+		tun.close()
+		return nil, err
+	}
+	go tun.serve()
+	return tun, nil
+}
+
+func (tun *mqttTunnel) topic(netID amsNetID, suffix string) string {
+	return tun.cfg.topic + "/" + netID.String() + suffix
+}
+
+// connect dials the broker, announces this client on the virtual AMS network and starts
+// delivering incoming packets.
+func (tun *mqttTunnel) connect(ctx context.Context) error {
+	will := &mqttWill{topic: tun.topic(tun.local, "/info"), payload: mqttInfo(false)}
+	client, err := dialMQTT(ctx, tun.cfg, tun.clientID, will, []string{
+		tun.topic(tun.local, "/ams"),
+		tun.topic(tun.local, "/ams/res"),
+	})
+	if err != nil {
+		return err
+	}
+	if err = client.publish(will.topic, mqttInfo(true), true); err != nil {
+		client.disconnect()
+		return err
+	}
+
+	tun.mu.Lock()
+	tun.client = client
+	tun.mu.Unlock()
+	tun.log.Infof("Connected to MQTT broker %s as %s on %s", tun.cfg.addr, tun.local, tun.cfg.topic)
+
+	go func() {
+		err := client.run(tun.cfg.keepAlive, tun.deliver)
+		tun.mu.Lock()
+		defer tun.mu.Unlock()
+		if tun.client != client || tun.closed {
+			return
+		}
+		tun.log.Warnf("Lost connection to MQTT broker %s: %v", tun.cfg.addr, err)
+		tun.client = nil
+		client.conn.Close()
+		// Drop the session connection; the component reconnects through a new tunnel.
+		if tun.conn != nil {
+			tun.conn.Close()
+			tun.conn = nil
+		}
+	}()
+	return nil
+}
+
+// deliver writes a packet received from the broker to the session.
+func (tun *mqttTunnel) deliver(_ string, payload []byte) {
+	if len(payload) < amsHeaderLen {
+		return
+	}
+	frame := make([]byte, amsTCPHeaderLen+len(payload))
+	binary.LittleEndian.PutUint32(frame[2:], uint32(len(payload)))
+	copy(frame[amsTCPHeaderLen:], payload)
+
+	tun.mu.Lock()
+	conn := tun.conn
+	tun.mu.Unlock()
+	if conn != nil {
+		_, _ = conn.Write(frame)
+	}
+}
+
+// port returns the loopback port of the tunnel.
+func (tun *mqttTunnel) port() int {
+	return tun.listener.port()
+}
+
+// serve forwards the session's connection to the broker.
+func (tun *mqttTunnel) serve() {
+	conn, err := tun.listener.accept()
+	if err != nil {
+		return
+	}
+	tun.mu.Lock()
+	if tun.closed {
+		tun.mu.Unlock()
+		conn.Close()
+		return
+	}
+	tun.conn = conn
+	tun.mu.Unlock()
+	tun.forward(conn)
+}
+
+// forward publishes the packets the session writes to conn.
+func (tun *mqttTunnel) forward(conn net.Conn) {
+	defer func() {
+		tun.mu.Lock()
+		if tun.conn == conn {
+			tun.conn = nil
+		}
+		tun.mu.Unlock()
+		conn.Close()
+	}()
+	for {
+		p, err := readAMSPacket(conn)
+		if err != nil {
+			return
+		}
+		if p.tcpCommand != amsTCPCommand {
+			continue
+		}
+		suffix := "/ams"
+		if p.isResponse() {
+			suffix = "/ams/res"
+		}
+		tun.mu.Lock()
+		client := tun.client
+		tun.mu.Unlock()
+		if client == nil {
+			return
+		}
+		if err = client.publish(tun.topic(p.target.netID, suffix), p.marshal()[amsTCPHeaderLen:], false); err != nil {
+			tun.log.Warnf("Publishing AMS packet to MQTT broker failed: %v", err)
+			return
+		}
+	}
+}
+
+func (tun *mqttTunnel) close() {
+	tun.mu.Lock()
+	defer tun.mu.Unlock()
+	tun.closed = true
+	if tun.listener != nil {
+		tun.listener.close()
+	}
+	if tun.conn != nil {
+		tun.conn.Close()
+		tun.conn = nil
+	}
+	if tun.client != nil {
+		// A clean disconnect suppresses the will, so withdraw the presence message first.
+		_ = tun.client.publish(tun.topic(tun.local, "/info"), mqttInfo(false), true)
+		tun.client.disconnect()
+		tun.client = nil
+	}
+}
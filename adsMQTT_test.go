@@ -0,0 +1,409 @@
+package benthosADS
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// mqttPipe returns a client on one end of an in-memory connection and the raw other end.
+func mqttPipe(t *testing.T) (*mqttClient, net.Conn) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return &mqttClient{conn: a, r: bufio.NewReader(a)}, b
+}
+
+func TestMQTTRemainingLength(t *testing.T) {
+	// Encodings from the MQTT 3.1.1 specification, section 2.2.3.
+	tests := []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7F}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xFF, 0x7F}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+	}
+	for _, tt := range tests {
+		c, peer := mqttPipe(t)
+		body := bytes.Repeat([]byte{0xAB}, tt.n)
+		errc := make(chan error, 1)
+		go func() { errc <- c.write(mqttPublish<<4, body) }()
+		raw := make([]byte, 1+len(tt.want)+tt.n)
+		if _, err := io.ReadFull(peer, raw); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+		if raw[0] != 0x30 || !bytes.Equal(raw[1:1+len(tt.want)], tt.want) {
+			t.Errorf("length %d encoded as % x, want % x", tt.n, raw[1:1+len(tt.want)], tt.want)
+		}
+
+		r := &mqttClient{r: bufio.NewReader(bytes.NewReader(raw))}
+		typ, flags, got, err := r.readPacket()
+		if err != nil || typ != mqttPublish || flags != 0 || !bytes.Equal(got, body) {
+			t.Errorf("length %d: read type %d flags %d, %d bytes, %v", tt.n, typ, flags, len(got), err)
+		}
+	}
+}
+
+func TestMQTTReadPacketErrors(t *testing.T) {
+	tests := map[string]struct {
+		data []byte
+		want string
+	}{
+		"length over four bytes": {[]byte{0x30, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}, "malformed"},
+		"length over limit":      {[]byte{0x30, 0x81, 0x80, 0x80, 0x08}, "exceeds limit"},
+		"truncated body":         {[]byte{0x30, 0x05, 0, 1, 'a'}, "unexpected EOF"},
+		"truncated length":       {[]byte{0x30, 0x80}, "EOF"},
+	}
+	for name, tt := range tests {
+		c := &mqttClient{r: bufio.NewReader(bytes.NewReader(tt.data))}
+		if _, _, _, err := c.readPacket(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", name, err, tt.want)
+		}
+	}
+}
+
+func TestMQTTHandshake(t *testing.T) {
+	m := &adsMQTTConfig{keepAlive: 60 * time.Second, username: "u", password: "p"}
+	will := &mqttWill{topic: "t", payload: []byte("x")}
+	tests := []struct {
+		name    string
+		connack []byte
+		suback  []byte
+		want    string
+	}{
+		{"accepted", []byte{0x20, 2, 0, 0}, []byte{0x90, 3, 0, 1, 0}, ""},
+		{"bad credentials", []byte{0x20, 2, 0, 4}, nil, "bad username or password"},
+		{"unknown refusal", []byte{0x20, 2, 0, 0x42}, nil, "code 66"},
+		{"subscription refused", []byte{0x20, 2, 0, 0}, []byte{0x90, 3, 0, 1, 0x80}, `subscription to "s" refused`},
+	}
+	for _, tt := range tests {
+		c, peer := mqttPipe(t)
+		errc := make(chan error, 1)
+		go func() { errc <- c.handshake(m, "c", will, []string{"s"}) }()
+
+		connect := make([]byte, 27)
+		if _, err := io.ReadFull(peer, connect); err != nil {
+			t.Fatal(err)
+		}
+		want := []byte{0x10, 25, 0, 4, 'M', 'Q', 'T', 'T', 4,
+			0xE6,  // user name, password, will retain, will, clean session
+			0, 60, // keep alive
+			0, 1, 'c', 0, 1, 't', 0, 1, 'x', 0, 1, 'u', 0, 1, 'p'}
+		if !bytes.Equal(connect, want) {
+			t.Errorf("%s: CONNECT = % x\nwant        % x", tt.name, connect, want)
+		}
+		if _, err := peer.Write(tt.connack); err != nil {
+			t.Fatal(err)
+		}
+		if tt.suback != nil {
+			subscribe := make([]byte, 8)
+			if _, err := io.ReadFull(peer, subscribe); err != nil {
+				t.Fatal(err)
+			}
+			if want := []byte{0x82, 6, 0, 1, 0, 1, 's', 0}; !bytes.Equal(subscribe, want) {
+				t.Errorf("%s: SUBSCRIBE = % x, want % x", tt.name, subscribe, want)
+			}
+			// A broker may send other packets before the SUBACK.
+			if _, err := peer.Write(append([]byte{0xD0, 0}, tt.suback...)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := <-errc
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestMQTTRun(t *testing.T) {
+	c, peer := mqttPipe(t)
+	type message struct {
+		topic   string
+		payload string
+	}
+	received := make(chan message, 4)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.run(100*time.Millisecond, func(topic string, payload []byte) {
+			received <- message{topic, string(payload)}
+		})
+	}()
+	fromClient := make(chan []byte, 8)
+	go func() {
+		r := &mqttClient{r: bufio.NewReader(peer)}
+		for {
+			typ, _, body, err := r.readPacket()
+			if err != nil {
+				return
+			}
+			fromClient <- append([]byte{typ}, body...)
+		}
+	}()
+
+	qos0 := append(appendMQTTString(nil, "a/ams"), "hello"...)
+	qos1 := append(append(appendMQTTString(nil, "a/ams/res"), 0, 5), "world"...)
+	for _, p := range [][]byte{append([]byte{0x30, byte(len(qos0))}, qos0...), append([]byte{0x32, byte(len(qos1))}, qos1...)} {
+		if _, err := peer.Write(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []message{{"a/ams", "hello"}, {"a/ams/res", "world"}} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("received %+v, want %+v", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%+v not delivered", want)
+		}
+	}
+
+	var puback, ping bool
+	timeout := time.After(2 * time.Second)
+	for !puback || !ping {
+		select {
+		case p := <-fromClient:
+			switch {
+			case bytes.Equal(p, []byte{mqttPuback, 0, 5}):
+				puback = true
+			case bytes.Equal(p, []byte{mqttPingreq}):
+				ping = true
+			}
+		case <-timeout:
+			t.Fatalf("PUBACK sent: %v, PINGREQ sent: %v", puback, ping)
+		}
+	}
+
+	if _, err := peer.Write([]byte{0x30, 3, 0, 5, 'a'}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err == nil || !strings.Contains(err.Error(), "malformed PUBLISH") {
+		t.Errorf("run error = %v, want malformed PUBLISH", err)
+	}
+}
+
+// fakeBroker is an MQTT broker for the tunnel tests: it routes QoS 0 publishes to clients
+// subscribed to the exact topic and reports connects, publishes and disconnects.
+type fakeBroker struct {
+	ln     net.Listener
+	events chan brokerEvent
+
+	mu   sync.Mutex
+	subs map[*mqttClient][]string
+}
+
+type brokerEvent struct {
+	kind     byte
+	clientID string
+	topic    string
+	payload  []byte
+	retain   bool
+}
+
+func startFakeBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{ln: ln, events: make(chan brokerEvent, 64), subs: map[*mqttClient][]string{}}
+	t.Cleanup(func() {
+		ln.Close()
+		b.drop()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(&mqttClient{conn: conn, r: bufio.NewReader(conn)})
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) serve(c *mqttClient) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, c)
+		b.mu.Unlock()
+		c.conn.Close()
+	}()
+	for {
+		typ, flags, body, err := c.readPacket()
+		if err != nil {
+			return
+		}
+		switch typ {
+		case mqttConnect:
+			n := int(binary.BigEndian.Uint16(body[10:]))
+			b.events <- brokerEvent{kind: mqttConnect, clientID: string(body[12 : 12+n])}
+			b.mu.Lock()
+			b.subs[c] = nil
+			b.mu.Unlock()
+			_ = c.write(mqttConnack<<4, []byte{0, 0})
+		case mqttSubscribe:
+			ack := append([]byte(nil), body[:2]...)
+			b.mu.Lock()
+			for rest := body[2:]; len(rest) > 2; {
+				n := int(binary.BigEndian.Uint16(rest))
+				b.subs[c] = append(b.subs[c], string(rest[2:2+n]))
+				rest = rest[3+n:]
+				ack = append(ack, 0)
+			}
+			b.mu.Unlock()
+			_ = c.write(mqttSuback<<4, ack)
+		case mqttPublish:
+			n := int(binary.BigEndian.Uint16(body))
+			topic, payload := string(body[2:2+n]), body[2+n:]
+			b.events <- brokerEvent{kind: mqttPublish, topic: topic, payload: payload, retain: flags&0x01 != 0}
+			b.publish(topic, payload)
+		case mqttPingreq:
+			_ = c.write(mqttPingresp<<4, nil)
+		case mqttDisconnect:
+			b.events <- brokerEvent{kind: mqttDisconnect}
+			return
+		}
+	}
+}
+
+// publish delivers payload to the clients subscribed to topic.
+func (b *fakeBroker) publish(topic string, payload []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c, topics := range b.subs {
+		for _, t := range topics {
+			if t == topic {
+				_ = c.publish(topic, payload, false)
+			}
+		}
+	}
+}
+
+// drop closes every client connection, as a broker restart does.
+func (b *fakeBroker) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.subs {
+		c.conn.Close()
+	}
+}
+
+// expect returns the next event, which must be of kind.
+func (b *fakeBroker) expect(t *testing.T, kind byte) brokerEvent {
+	t.Helper()
+	select {
+	case e := <-b.events:
+		if e.kind != kind {
+			t.Fatalf("broker event %+v, want packet type %d", e, kind)
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no broker event of packet type %d", kind)
+	}
+	return brokerEvent{}
+}
+
+func TestMQTTTunnel(t *testing.T) {
+	b := startFakeBroker(t)
+	m := &adsMQTTConfig{addr: b.ln.Addr().String(), topic: defaultMQTTTopic}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	open := func() (*mqttTunnel, net.Conn) {
+		t.Helper()
+		tun, err := openMQTTTunnel(ctx, m, "10.0.0.9.1.1", service.MockResources().Logger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(tun.close)
+		if e := b.expect(t, mqttConnect); e.clientID != "benthosADS-10.0.0.9.1.1" {
+			t.Errorf("client ID = %q", e.clientID)
+		}
+		if e := b.expect(t, mqttPublish); e.topic != "VirtualAmsNetwork1/10.0.0.9.1.1/info" || !e.retain ||
+			!bytes.Contains(e.payload, []byte(">true<")) {
+			t.Errorf("presence = %+v", e)
+		}
+		session, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(tun.port()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { session.Close() })
+		_ = session.SetDeadline(time.Now().Add(5 * time.Second))
+		return tun, session
+	}
+	tun, session := open()
+
+	plc, _ := parseAMSNetID("5.80.201.232.1.1")
+	host, _ := parseAMSNetID("10.0.0.9.1.1")
+	req := &amsPacket{
+		target:     amsAddr{netID: plc, port: 851},
+		source:     amsAddr{netID: host, port: 32905},
+		command:    adsCmdReadState,
+		stateFlags: amsFlagADSCommand,
+		invokeID:   1,
+		data:       []byte{},
+	}
+	if _, err := session.Write(req.marshal()); err != nil {
+		t.Fatal(err)
+	}
+	e := b.expect(t, mqttPublish)
+	if e.topic != "VirtualAmsNetwork1/5.80.201.232.1.1/ams" || e.retain || !bytes.Equal(e.payload, req.marshal()[amsTCPHeaderLen:]) {
+		t.Fatalf("request publish = %+v", e)
+	}
+	resp := req.response(0, adsResult(0, []byte{5, 0, 0, 0}))
+	b.publish("VirtualAmsNetwork1/10.0.0.9.1.1/ams/res", resp.marshal()[amsTCPHeaderLen:])
+	got, err := readAMSPacket(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, resp) {
+		t.Errorf("response = %+v, want %+v", got, resp)
+	}
+
+	// The session holds the only connection; no other local process can take it over.
+	if other, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(tun.port())); err == nil {
+		other.Close()
+		t.Error("tunnel accepted a second connection")
+	}
+
+	tun.close()
+	if e := b.expect(t, mqttPublish); e.topic != "VirtualAmsNetwork1/10.0.0.9.1.1/info" || !e.retain ||
+		!bytes.Contains(e.payload, []byte(">false<")) {
+		t.Errorf("presence after close = %+v", e)
+	}
+	b.expect(t, mqttDisconnect)
+	if _, err = readAMSPacket(session); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("session after close: error = %v, want the connection closed", err)
+	}
+
+	// Losing the broker drops the session, which the component reconnects with a new tunnel.
+	_, session = open()
+	b.drop()
+	if _, err = readAMSPacket(session); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("session after broker loss: error = %v, want the connection closed", err)
+	}
+}
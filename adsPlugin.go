@@ -73,6 +73,38 @@ func validateIP(s string) error {
 	return nil
 }
 
+// validateHost checks that s is an IPv4 address, an IPv6 literal or a DNS hostname.
+// Hostnames are only checked for syntax here; they are resolved at connect time.
+func validateHost(s string) error {
+	if s == "" {
+		return errors.New("must not be empty")
+	}
+	if ip := net.ParseIP(strings.Trim(s, "[]")); ip != nil {
+		return nil
+	}
+	// All-numeric dotted input is a mistyped IPv4 address, not a hostname.
+	if strings.Trim(s, "0123456789.") == "" {
+		return validateIP(s)
+	}
+	if len(s) > 253 {
+		return fmt.Errorf("%q is too long for a hostname", s)
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(s, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return fmt.Errorf("%q contains an empty or too long label", s)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("%q: label %q must not start or end with '-'", s, label)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return fmt.Errorf("%q is not a valid IP address or hostname", s)
+			}
+		}
+	}
+	return nil
+}
+
 // validateAMSNetID checks that s is a valid AMS NetID (6 dot-separated octets, each 0–255).
 func validateAMSNetID(s string) error {
 	parts := strings.Split(s, ".")
@@ -109,6 +141,8 @@ type plcSymbol struct {
 	name      string
 	maxDelay  time.Duration
 	cycleTime time.Duration
+	address   *rawAddress // set for direct index group/offset addressing, which bypasses symbol lookup
+	required  bool        // connect fails when the symbol cannot be registered or read
 }
 
 func sanitize(s string) string {
@@ -143,8 +177,8 @@ func isLikelyContainerIP(ip string) bool {
 }
 
 // createSymbolList parses symbol strings into plcSymbol structs.
-// Format: "name" or "name:maxDelayMs:cycleTimeMs"
-func createSymbolList(s []string, defaultCycleTime int, defaultMaxDelay int) []plcSymbol {
+// Format: "name" or "name:maxDelayMs:cycleTimeMs", where name may also be a direct address (see parseRawAddress).
+func createSymbolList(s []string, defaultCycleTime int, defaultMaxDelay int) ([]plcSymbol, error) {
 	var result []plcSymbol
 	for _, symbol := range s {
 		colons := strings.Count(symbol, ":")
@@ -170,23 +204,26 @@ func createSymbolList(s []string, defaultCycleTime int, defaultMaxDelay int) []p
 			sym.maxDelay = time.Duration(maxDelay) * time.Millisecond
 			sym.cycleTime = time.Duration(cycleTime) * time.Millisecond
 		}
+		if isRawAddress(sym.name) {
+			addr, err := parseRawAddress(sym.name)
+			if err != nil {
+				return nil, fmt.Errorf("symbols: %w", err)
+			}
+			sym.address = addr
+		}
 		result = append(result, sym)
 	}
-	return result
+	return result, nil
 }
 
 type adsCommInput struct {
-	targetIP         string
-	targetAMS        string
-	targetPort       int
-	runtimePort      int
-	hostAMS          string
-	hostPort         int
+	adsConnConfig
+	targetName string // set when the input is one of several targets; tags messages with target metadata
+
 	readType         string
 	cycleTime        int
 	maxDelay         int
 	intervalTime     time.Duration
-	requestTimeout   time.Duration
 	handler          *adsLib.Session
 	log              *service.Logger
 	symbols          []plcSymbol
@@ -196,6 +233,35 @@ type adsCommInput struct {
 	// Shutdown signal — closed by Close() to unblock ReadBatchNotification.
 	done chan struct{}
 
+	// Handles of registered notifications, deleted on Close when the session is shared.
+	notificationHandles []uint32
+
+	// Notification symbols that failed on connect are registered again, first after
+	// symbolRetryInterval and then backing off by symbolRetryMultiplier up to
+	// symbolRetryMaxInterval, until retryStop is closed; retryExited is closed when the retry
+	// has stopped.
+	symbolRetryInterval    time.Duration
+	symbolRetryMaxInterval time.Duration
+	symbolRetryMultiplier  float64
+	retryStop              chan struct{}
+	retryExited            chan struct{}
+	symbolsPending         *service.MetricGauge
+
+	missingWarned map[string]bool // symbols missing from interval reads, warned about once per connection
+
+	rawSumReadUnsupported bool // the PLC rejected a sum read of direct addresses in ReadBatch; read them one by one
+
+	// Notification sharding: symbols beyond the per-connection limit go to extra sessions
+	// or, with notificationOverflow "poll", are read every intervalTime by a background poller.
+	maxNotificationsPerConnection int
+	notificationOverflow          string
+	shards                        []*adsLib.Session
+	pollStop                      chan struct{}
+
+	notificationConnections *service.MetricGauge
+	notificationsRegistered *service.MetricGauge
+	notificationsPolled     *service.MetricGauge
+
 	// Symbol metadata populated lazily after connect (from go-ads cache, no extra round-trips).
 	dataTypes   map[string]string
 	baseTypes   map[string]string
@@ -204,125 +270,100 @@ type adsCommInput struct {
 
 	loadSymbols bool
 
-	// Route registration settings
-	routeUsername    string
-	routePassword    string
-	routeHostAddress string
+	// Symbol and datatype table read from a TwinCAT project file or the symbol cache, nil otherwise.
+	symbolTable     *symbolTable
+	symbolCacheDir  string
+	symbolCacheInfo symbolUploadInfo // upload info of the PLC program symbolTable was downloaded from
+
+	capture *adsCapture // records emitted batches for ads_replay, nil when disabled; shared by targets
 
-	adsLogger *slog.Logger
+	symbolCheck *symbolCheckConfig // compares the symbols with the PLC on connect, nil when disabled
 }
 
 var adsConf = service.NewConfigSpec().
 	Summary("Creates an input that reads data from Beckhoff PLCs using ADS protocol. Created by Daniel H").
 	Description("This input plugin enables Benthos to read data directly from Beckhoff PLCs using the ADS protocol. " +
 		"Configure the plugin by specifying the PLC's IP address, runtime port, target AMS net ID, etc.").
-	Field(service.NewStringField("targetIP").Description("IP address of the Beckhoff PLC.")).
-	Field(service.NewStringField("targetAMS").Description("Target AMS net ID.")).
-	Field(service.NewIntField("targetPort").Description("TCP port of the PLC ADS gateway.").Default(48898)).
-	Field(service.NewIntField("runtimePort").Description("Target runtime port. 801 for TwinCAT 2, 851 for TwinCAT 3.").Default(801)).
-	Field(service.NewStringField("hostAMS").Description("Local AMS net ID. 'auto' derives it from the outbound TCP source IP.").Default("auto")).
-	Field(service.NewIntField("hostPort").Description("AMS source port used in protocol headers. Any arbitrary value works.").Default(10500)).
+	Fields(adsConnFields()...).
 	Field(service.NewStringField("readType").Description("Read type, interval or notification (default).").Default("notification")).
 	Field(service.NewIntField("maxDelay").Description("Max delay time after value change before PLC should send message, in milliseconds.").Default(100)).
 	Field(service.NewIntField("cycleTime").Description("Requested read interval for PLC to scan for changes (notification mode), in milliseconds.").Default(1000)).
 	Field(service.NewIntField("intervalTime").Description("Interval between reads in milliseconds for interval read type.").Default(1000)).
-	Field(service.NewStringField("logLevel").Description("Log level for ADS connection. Default disabled.").Default("disabled")).
-	Field(service.NewIntField("requestTimeout").Description("Timeout for individual ADS requests in milliseconds.").Default(5000)).
 	Field(service.NewStringField("transmissionMode").Description("Notification transmission mode: serverOnChange (default), serverCycle, serverOnChange2, serverCycle2.").Default("serverOnChange")).
-	Field(service.NewStringField("routeUsername").Description("Username for UDP route registration on the PLC. If set with routePassword, a route will be registered before connecting.").Default("")).
-	Field(service.NewStringField("routePassword").Description("Password for UDP route registration on the PLC.").Default("")).
-	Field(service.NewStringField("routeHostAddress").Description("The address the PLC should use to reach this client. Auto-detected from outbound connection if empty.").Default("")).
+	Field(service.NewIntField("maxNotificationsPerConnection").Description("Maximum number of notifications registered on one ADS connection. Beckhoff recommends staying below about 500. 0 registers all symbols on one connection.").Default(0)).
+	Field(service.NewStringField("notificationOverflow").Description("What to do with symbols beyond maxNotificationsPerConnection: 'shard' opens additional connections, 'poll' reads the last listed symbols every intervalTime instead.").Default("shard")).
 	Field(service.NewBoolField("loadSymbols").Description("Download the full symbol and datatype table from the PLC on connect. Required for struct and array symbols. May cause a brief real-time jitter on the PLC; use with care on large programs.").Default(false)).
-	Field(service.NewStringListField("symbols").Description("Symbols to read. Format: 'MAIN.var' or 'MAIN.var:maxDelayMs:cycleTimeMs'. " +
-		"Examples: 'MAIN.counter', '.globalCounter', 'MAIN.var:50:100'"))
+	Field(service.NewObjectListField("targets", adsTargetFields()...).Description("Read from several PLCs with one input. Each target gets its own connection and reconnects independently; messages carry target_name and target_ams metadata. When set, the top-level targetIP and targetAMS are not used.").Optional()).
+	Field(service.NewStringField("symbolCacheDir").Description("Directory for caching the symbol table downloaded by loadSymbols. The table is only downloaded again when the PLC's symbol version or upload info changes, so reconnects cause no extra PLC jitter. Structs and arrays are then decoded from the cached table, as with symbolFile. Empty disables caching.").Default("")).
+	Field(adsCaptureField()).
+	Field(symbolCheckField()).
+	Field(service.NewStringField("symbolFile").Description("Path to a TwinCAT .tmc (TwinCAT 3) or .tpy (TwinCAT 2) project file. Used for wildcard symbol selection, type metadata and struct decoding without downloading the symbol table from the PLC.").Default("")).
+	Field(service.NewBoolField("strictSymbols").Description("Fail the connect when any symbol fails to register (notification) or cannot be read (interval), instead of continuing with the others. Set required on single symbols to only make those mandatory.").Default(false)).
+	Field(service.NewIntField("symbolRetryInterval").Description("Time in milliseconds before registering notification symbols again that failed on connect, e.g. because the PLC program is being downloaded. Symbols that register join the running notifications. 0 disables the retry.").Default(30000)).
+	Field(service.NewIntField("symbolRetryMaxInterval").Description("Longest time in milliseconds between two retries of failed symbols.").Default(300000)).
+	Field(service.NewFloatField("symbolRetryMultiplier").Description("Factor the time between retries of failed symbols grows by after each retry in which none registered.").Default(2.0)).
+	Field(service.NewAnyListField("symbols").Description("Symbols to read. Format: 'MAIN.var' or 'MAIN.var:maxDelayMs:cycleTimeMs', or an object with name, maxDelay, cycleTime and required. " +
+		"Examples: 'MAIN.counter', '.globalCounter', 'MAIN.var:50:100', {name: MAIN.bEStop, cycleTime: 10, required: true}"))
 
 func newAdsCommInput(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
-	logLevel, err := conf.FieldString("logLevel")
+	connConf, err := parseAdsConnConfig(conf, mgr)
 	if err != nil {
 		return nil, err
 	}
-	adsLogger := slog.New(&benthosLogHandler{
-		logger: mgr.Logger(),
-		level:  slogLevelFromString(logLevel),
-	})
 
-	targetIP, err := conf.FieldString("targetIP")
-	if err != nil {
-		return nil, err
-	}
-
-	targetAMS, err := conf.FieldString("targetAMS")
+	readType, err := conf.FieldString("readType")
 	if err != nil {
 		return nil, err
 	}
-
-	if err = validateIP(targetIP); err != nil {
-		return nil, fmt.Errorf("targetIP: %w", err)
-	}
-	if err = validateAMSNetID(targetAMS); err != nil {
-		return nil, fmt.Errorf("targetAMS: %w", err)
+	if readType != "notification" && readType != "interval" {
+		return nil, errors.New("readType must be 'notification' or 'interval'")
 	}
 
-	targetPort, err := conf.FieldInt("targetPort")
+	maxDelay, err := conf.FieldInt("maxDelay")
 	if err != nil {
 		return nil, err
 	}
 
-	runtimePort, err := conf.FieldInt("runtimePort")
+	cycleTime, err := conf.FieldInt("cycleTime")
 	if err != nil {
 		return nil, err
 	}
-	if runtimePort < 0 || runtimePort > 65535 {
-		return nil, fmt.Errorf("runtimePort %d out of range 0–65535", runtimePort)
-	}
 
-	hostAMS, err := conf.FieldString("hostAMS")
+	symbolsList, err := conf.FieldAny("symbols")
 	if err != nil {
 		return nil, err
 	}
-	if hostAMS != "auto" && hostAMS != "" {
-		if err = validateAMSNetID(hostAMS); err != nil {
-			return nil, fmt.Errorf("hostAMS: %w", err)
-		}
+	symbols, required, err := symbolEntries(symbolsList, maxDelay, cycleTime)
+	if err != nil {
+		return nil, fmt.Errorf("symbols: %w", err)
 	}
-
-	hostPort, err := conf.FieldInt("hostPort")
+	strictSymbols, err := conf.FieldBool("strictSymbols")
 	if err != nil {
 		return nil, err
 	}
-	if hostPort < 0 || hostPort > 65535 {
-		return nil, fmt.Errorf("hostPort %d out of range 0–65535", hostPort)
-	}
-
-	readType, err := conf.FieldString("readType")
+	symbolRetryInt, err := conf.FieldInt("symbolRetryInterval")
 	if err != nil {
 		return nil, err
 	}
-	if readType != "notification" && readType != "interval" {
-		return nil, errors.New("readType must be 'notification' or 'interval'")
+	if symbolRetryInt < 0 {
+		return nil, fmt.Errorf("symbolRetryInterval %d must not be negative", symbolRetryInt)
 	}
-
-	maxDelay, err := conf.FieldInt("maxDelay")
+	symbolRetryMaxInt, err := conf.FieldInt("symbolRetryMaxInterval")
 	if err != nil {
 		return nil, err
 	}
-
-	cycleTime, err := conf.FieldInt("cycleTime")
-	if err != nil {
-		return nil, err
+	if symbolRetryMaxInt < symbolRetryInt {
+		return nil, fmt.Errorf("symbolRetryMaxInterval %d must not be less than symbolRetryInterval %d", symbolRetryMaxInt, symbolRetryInt)
 	}
-
-	symbols, err := conf.FieldStringList("symbols")
+	symbolRetryMultiplier, err := conf.FieldFloat("symbolRetryMultiplier")
 	if err != nil {
 		return nil, err
 	}
-
-	intervalTimeInt, err := conf.FieldInt("intervalTime")
-	if err != nil {
-		return nil, err
+	if symbolRetryMultiplier < 1 {
+		return nil, fmt.Errorf("symbolRetryMultiplier %v must be at least 1", symbolRetryMultiplier)
 	}
 
-	requestTimeoutInt, err := conf.FieldInt("requestTimeout")
+	intervalTimeInt, err := conf.FieldInt("intervalTime")
 	if err != nil {
 		return nil, err
 	}
@@ -345,58 +386,138 @@ func newAdsCommInput(conf *service.ParsedConfig, mgr *service.Resources) (servic
 		transmissionMode = adsLib.TransModeServerOnChange
 	}
 
-	routeUsername, err := conf.FieldString("routeUsername")
+	maxNotifications, err := conf.FieldInt("maxNotificationsPerConnection")
 	if err != nil {
 		return nil, err
 	}
+	if maxNotifications < 0 {
+		return nil, fmt.Errorf("maxNotificationsPerConnection %d must not be negative", maxNotifications)
+	}
 
-	routePassword, err := conf.FieldString("routePassword")
+	notificationOverflow, err := conf.FieldString("notificationOverflow")
 	if err != nil {
 		return nil, err
 	}
+	if notificationOverflow != "shard" && notificationOverflow != "poll" {
+		return nil, errors.New("notificationOverflow must be 'shard' or 'poll'")
+	}
 
-	routeHostAddress, err := conf.FieldString("routeHostAddress")
+	loadSymbols, err := conf.FieldBool("loadSymbols")
 	if err != nil {
 		return nil, err
 	}
 
-	loadSymbols, err := conf.FieldBool("loadSymbols")
+	symbolCacheDir, err := conf.FieldString("symbolCacheDir")
 	if err != nil {
 		return nil, err
 	}
 
-	// Derive hostAMS from routeHostAddress when set to "auto",
-	// matching the same convenience shortcut as the integrated plugin.
-	if hostAMS == "auto" && routeHostAddress != "" {
-		hostAMS = routeHostAddress + ".1.1"
+	symbolFile, err := conf.FieldString("symbolFile")
+	if err != nil {
+		return nil, err
+	}
+	var table *symbolTable
+	if symbolFile != "" {
+		if table, err = loadSymbolFile(symbolFile); err != nil {
+			return nil, fmt.Errorf("symbolFile: %w", err)
+		}
+	}
+	if symbols, err = expandSymbolPatterns(symbols, table); err != nil {
+		return nil, fmt.Errorf("symbols: %w", err)
 	}
 
-	symbolList := createSymbolList(symbols, cycleTime, maxDelay)
+	symbolList, err := createSymbolList(symbols, cycleTime, maxDelay)
+	if err != nil {
+		return nil, err
+	}
+	if table != nil {
+		applySymbolTable(symbolList, table)
+	}
+	markRequired(symbolList, strictSymbols, required)
+	capture, err := parseAdsCapture(conf)
+	if err != nil {
+		return nil, err
+	}
+	symbolCheck, err := parseSymbolCheck(conf)
+	if err != nil {
+		return nil, err
+	}
 	m := &adsCommInput{
-		targetIP:         targetIP,
-		targetAMS:        targetAMS,
-		targetPort:       targetPort,
-		runtimePort:      runtimePort,
-		hostAMS:          hostAMS,
-		hostPort:         hostPort,
+		adsConnConfig:    connConf,
 		readType:         readType,
 		maxDelay:         maxDelay,
 		cycleTime:        cycleTime,
 		symbols:          symbolList,
 		log:              mgr.Logger(),
 		intervalTime:     time.Duration(intervalTimeInt) * time.Millisecond,
-		requestTimeout:   time.Duration(requestTimeoutInt) * time.Millisecond,
 		notificationChan: make(chan *adsLib.Update, 256),
 		done:             make(chan struct{}),
 		transmissionMode: transmissionMode,
 		loadSymbols:      loadSymbols,
-		routeUsername:    routeUsername,
-		routePassword:    routePassword,
-		routeHostAddress: routeHostAddress,
-		adsLogger:        adsLogger,
+		symbolTable:      table,
+		symbolCacheDir:   symbolCacheDir,
+		capture:          capture,
+		symbolCheck:      symbolCheck,
+
+		symbolRetryInterval:    time.Duration(symbolRetryInt) * time.Millisecond,
+		symbolRetryMaxInterval: time.Duration(symbolRetryMaxInt) * time.Millisecond,
+		symbolRetryMultiplier:  symbolRetryMultiplier,
+		symbolsPending:         mgr.Metrics().NewGauge("ads_symbols_pending", "target"),
+
+		maxNotificationsPerConnection: maxNotifications,
+		notificationOverflow:          notificationOverflow,
+		notificationConnections:       mgr.Metrics().NewGauge("ads_notification_connections", "target"),
+		notificationsRegistered:       mgr.Metrics().NewGauge("ads_notifications_registered", "target"),
+		notificationsPolled:           mgr.Metrics().NewGauge("ads_notifications_polled", "target"),
+	}
+
+	if !conf.Contains("targets") {
+		return service.AutoRetryNacksBatched(m), nil
 	}
 
-	return service.AutoRetryNacksBatched(m), nil
+	targetConfs, err := conf.FieldObjectList("targets")
+	if err != nil {
+		return nil, err
+	}
+	if len(targetConfs) == 0 {
+		return nil, errors.New("targets must not be empty")
+	}
+	inputs := make([]*adsCommInput, 0, len(targetConfs))
+	seen := map[string]bool{}
+	for i, tc := range targetConfs {
+		name, targetConn, terr := parseAdsTarget(connConf, tc)
+		if terr != nil {
+			return nil, fmt.Errorf("targets[%d]: %w", i, terr)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("targets[%d]: duplicate name %q", i, name)
+		}
+		seen[name] = true
+
+		targetSymbols := symbolList
+		if tc.Contains("symbols") {
+			list, serr := tc.FieldAny("symbols")
+			if serr != nil {
+				return nil, serr
+			}
+			entries, targetRequired, serr := symbolEntries(list, maxDelay, cycleTime)
+			if serr != nil {
+				return nil, fmt.Errorf("targets[%d].symbols: %w", i, serr)
+			}
+			if entries, serr = expandSymbolPatterns(entries, table); serr != nil {
+				return nil, fmt.Errorf("targets[%d].symbols: %w", i, serr)
+			}
+			if targetSymbols, serr = createSymbolList(entries, cycleTime, maxDelay); serr != nil {
+				return nil, fmt.Errorf("targets[%d]: %w", i, serr)
+			}
+			if table != nil {
+				applySymbolTable(targetSymbols, table)
+			}
+			markRequired(targetSymbols, strictSymbols, targetRequired)
+		}
+		inputs = append(inputs, m.forTarget(name, targetConn, targetSymbols))
+	}
+	return service.AutoRetryNacksBatched(newAdsMultiInput(inputs, mgr.Logger())), nil
 }
 
 func init() {
@@ -421,81 +542,44 @@ func (g *adsCommInput) Connect(ctx context.Context) error {
 
 	g.log.Infof("Creating new connection")
 
-	var connOpts []adsLib.SessionOption
-	if g.adsLogger != nil {
-		connOpts = append(connOpts, adsLib.WithLogger(g.adsLogger))
-		adsLib.SetDefaultLogger(g.adsLogger)
-	}
-
-	if g.routeUsername != "" && g.routePassword != "" {
-		hostAddr := g.routeHostAddress
-		if hostAddr == "" {
-			// Use TCP connect to guarantee same source IP as the actual ADS connection.
-			tcpConn, dialErr := net.DialTimeout("tcp4", net.JoinHostPort(g.targetIP, "48898"), 3*time.Second)
-			if dialErr != nil {
-				// PLC unreachable — fall back to UDP routing lookup (no packet sent).
-				udpConn, udpErr := net.Dial("udp4", net.JoinHostPort(g.targetIP, "48899"))
-				if udpErr != nil {
-					g.log.Errorf("Failed to auto-detect local address: %v", dialErr)
-					return dialErr
-				}
-				hostAddr = udpConn.LocalAddr().(*net.UDPAddr).IP.String()
-				udpConn.Close()
-			} else {
-				hostAddr = tcpConn.LocalAddr().(*net.TCPAddr).IP.String()
-				tcpConn.Close()
-			}
-		}
-		if isLikelyContainerIP(hostAddr) {
-			g.log.Warnf("Auto-detected IP %s looks like a container IP. Set routeHostAddress to the Docker host's IP for route registration to work.", hostAddr)
-		}
-		routeName := fmt.Sprintf("benthosADS-%s", hostAddr)
-		g.log.Infof("Route will be registered on PLC %s: name=%s, clientIP=%s", g.targetIP, routeName, hostAddr)
-		connOpts = append(connOpts, adsLib.WithRoute(routeName, g.routeUsername, g.routePassword))
-		connOpts = append(connOpts, adsLib.WithHostIP(hostAddr))
-	}
-
-	targetAMS, err := adsLib.NewAMSAddress(g.targetAMS, uint16(g.runtimePort))
-	if err != nil {
-		g.log.Errorf("Invalid target AMS %q: %v", g.targetAMS, err)
-		return err
-	}
-
-	if g.hostAMS != "" && g.hostAMS != "auto" {
-		localAMS, lerr := adsLib.NewAMSAddress(g.hostAMS, uint16(g.hostPort))
-		if lerr != nil {
-			g.log.Errorf("Invalid local AMS %q: %v", g.hostAMS, lerr)
-			return lerr
-		}
-		connOpts = append(connOpts, adsLib.WithLocalAMS(localAMS))
-	}
-	if g.requestTimeout > 0 {
-		connOpts = append(connOpts, adsLib.WithRequestTimeout(g.requestTimeout))
-	}
-
-	// Use Background ctx for session lifetime — Benthos passes a per-call ctx to Connect
-	// that would tear the session down as soon as Connect returns. Teardown is driven by Close().
-	g.handler, err = adsLib.NewSession(context.Background(), adsLib.AMSEndpoint{
-		IP:   g.targetIP,
-		Port: g.targetPort,
-		AMS:  targetAMS,
-	}, connOpts...)
-	if err != nil {
-		g.log.Errorf("Failed to create session: %v", err)
+	var err error
+	if g.handler, err = g.acquireSession(ctx, g.log); err != nil {
 		return err
 	}
+	g.notificationHandles = nil
+	g.missingWarned = map[string]bool{}
+	g.rawSumReadUnsupported = false
 
 	success := false
 	defer func() {
 		if !success && g.handler != nil {
-			_ = g.handler.Close()
+			g.stopSharding()
+			g.deleteNotifications(ctx)
+			g.releaseSession(g.handler, g.log)
 			g.handler = nil
 		}
 	}()
 
-	g.log.Infof("Connecting to PLC")
-	if err = g.handler.Connect(ctx); err != nil {
-		g.log.Errorf("Failed to connect to PLC at %s: %v", g.targetIP, err)
+	if g.loadSymbols && g.symbolCacheDir != "" {
+		table, cacheErr := g.loadCachedSymbolTable(ctx)
+		if cacheErr != nil {
+			g.log.Errorf("Loading symbol table failed: %v", cacheErr)
+			return cacheErr
+		}
+		g.symbolTable = table
+		// Structs and arrays are read by address and decoded from the cached table, so go-ads
+		// never needs a table upload of its own.
+		applySymbolTable(g.symbols, table)
+	} else if g.loadSymbols {
+		g.log.Infof("Loading symbol and datatype table from PLC (loadSymbols=true)")
+		if err = g.handler.LoadSymbols(ctx); err != nil {
+			g.log.Errorf("LoadSymbols failed: %v", err)
+			return err
+		}
+		g.log.Infof("Symbol table loaded")
+	}
+	if err = g.checkSymbols(ctx); err != nil {
+		g.log.Errorf("%v", err)
 		return err
 	}
 
@@ -504,71 +588,59 @@ func (g *adsCommInput) Connect(ctx context.Context) error {
 	g.baseTypes = make(map[string]string, len(g.symbols))
 	g.dataSizes = make(map[string]uint32, len(g.symbols))
 	for _, sym := range g.symbols {
-		g.symbolNames[strings.ToLower(sym.name)] = sym.name
-	}
-
-	if g.loadSymbols {
-		g.log.Infof("Loading symbol and datatype table from PLC (loadSymbols=true)")
-		if err = g.handler.LoadSymbols(ctx); err != nil {
-			g.log.Errorf("LoadSymbols failed: %v", err)
-			return err
+		key := strings.ToLower(sym.name)
+		g.symbolNames[key] = sym.name
+		if sym.address != nil {
+			// Direct addresses carry their type in config, no symbol lookup needed.
+			g.dataTypes[key] = sym.address.dataType
+			g.baseTypes[key] = sym.address.dataType
+			g.dataSizes[key] = sym.address.length
+		}
+		if g.symbolTable != nil {
+			g.cacheSymbolMeta(ctx, sym.name)
 		}
-		g.log.Infof("Symbol table loaded")
 	}
 
 	if g.readType == "notification" {
-		configs := make([]adsLib.NotificationConfig, len(g.symbols))
-		for i, symbol := range g.symbols {
-			configs[i] = adsLib.NotificationConfig{
-				SymbolName:       symbol.name,
-				MaxDelay:         symbol.maxDelay,
-				CycleTime:        symbol.cycleTime,
-				TransmissionMode: g.transmissionMode,
-			}
-		}
-
-		results, err := g.handler.AddSymbolNotifications(ctx, configs, g.notificationChan)
+		notify, polled := g.allocateNotifications()
+		registered, pending, err := g.registerShardedNotifications(ctx, notify)
 		if err != nil {
-			g.log.Errorf("Batch add notifications failed: %v", err)
 			return err
 		}
-
-		registered := 0
-		for i, r := range results {
-			switch {
-			case r.Skipped == nil && r.Error == adsLib.ReturnCodeNoErrors:
-				registered++
-			case r.Skipped != nil:
-				g.log.Errorf("Notification symbol %q skipped (check symbol name): %v", configs[i].SymbolName, r.Skipped)
-			default:
-				g.log.Errorf("Notification symbol %q rejected by PLC: ADS error 0x%X", configs[i].SymbolName, uint32(r.Error))
+		var missing []string
+		for _, p := range pending {
+			for _, sym := range p.symbols {
+				if sym.required {
+					missing = append(missing, sym.name)
+				}
 			}
 		}
-		if registered == 0 && len(configs) > 0 {
-			return fmt.Errorf("no symbols registered for notifications (%d symbols all failed to resolve)", len(configs))
+		missing = append(missing, g.unresolvedRequired(ctx, polled)...)
+		if len(missing) > 0 {
+			return requiredSymbolsError(missing)
 		}
-		g.log.Infof("Registered %d/%d notification symbols", registered, len(configs))
+		if len(registered) == 0 && len(notify) > 0 {
+			return fmt.Errorf("no symbols registered for notifications (%d symbols all failed to resolve)", len(notify))
+		}
+		g.log.Infof("Registered %d/%d notification symbols", len(registered), len(notify))
 
 		// Populate metadata cache — symbols are in go-ads cache after AddSymbolNotifications.
 		for _, sym := range g.symbols {
-			key := strings.ToLower(sym.name)
-			if view, viewErr := g.handler.GetSymbol(ctx, sym.name); viewErr == nil {
-				g.dataTypes[key] = view.DataType
-				g.dataSizes[key] = view.Length
-				if bt := view.BaseTypeName(); bt != "" {
-					g.baseTypes[key] = bt
-				}
+			if sym.address == nil {
+				g.cacheSymbolMeta(ctx, sym.name)
 			}
 		}
+		if len(polled) > 0 {
+			g.startOverflowPoll(polled)
+		}
+		g.startSymbolRetry(pending, len(registered))
 
 		// Wait for initial sample from each registered symbol. TwinCAT sends an
 		// immediate sample on subscribe, so this completes quickly and ensures the
 		// first ReadBatch returns a full batch.
-		needed := make(map[string]bool, registered)
-		for i, r := range results {
-			if r.Skipped == nil && r.Error == adsLib.ReturnCodeNoErrors {
-				needed[strings.ToLower(configs[i].SymbolName)] = true
-			}
+		needed := make(map[string]bool, len(registered))
+		for _, name := range registered {
+			needed[strings.ToLower(name)] = true
 		}
 		initialCtx, initialCancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer initialCancel()
@@ -586,16 +658,91 @@ func (g *adsCommInput) Connect(ctx context.Context) error {
 			}
 		}
 	doneWaiting:
+	} else if missing := g.unresolvedRequired(ctx, g.symbols); len(missing) > 0 {
+		return requiredSymbolsError(missing)
 	}
 
 	success = true
 	return nil
 }
 
-func (g *adsCommInput) makeNotificationMessage(update *adsLib.Update) *service.Message {
-	msg := service.NewMessage([]byte(update.Value))
-	key := strings.ToLower(update.Variable)
-	name := update.Variable
+// registerNotifications subscribes symbols on session, delivering updates to notificationChan.
+// Symbols the PLC rejects are logged with logf and left out; the names of registered symbols
+// and their notification handles are returned.
+func (g *adsCommInput) registerNotifications(ctx context.Context, session *adsLib.Session, symbols []plcSymbol, logf func(string, ...any)) ([]string, []uint32, error) {
+	named, raw := splitRawSymbols(symbols)
+	configs := make([]adsLib.NotificationConfig, len(named))
+	for i, symbol := range named {
+		configs[i] = adsLib.NotificationConfig{
+			SymbolName:       symbol.name,
+			MaxDelay:         symbol.maxDelay,
+			CycleTime:        symbol.cycleTime,
+			TransmissionMode: g.transmissionMode,
+		}
+	}
+
+	var results []adsLib.NotificationResult
+	if len(configs) > 0 {
+		var err error
+		results, err = session.AddSymbolNotifications(ctx, configs, g.notificationChan)
+		if err != nil {
+			g.log.Errorf("Batch add notifications failed: %v", err)
+			return nil, nil, err
+		}
+	}
+	registered, handles := g.addRawNotifications(ctx, session, raw, logf)
+
+	for i, r := range results {
+		switch {
+		case r.Skipped == nil && r.Error == adsLib.ReturnCodeNoErrors:
+			registered = append(registered, configs[i].SymbolName)
+			handles = append(handles, r.Handle)
+		case r.Skipped != nil:
+			logf("Notification symbol %q skipped (check symbol name): %v", configs[i].SymbolName, r.Skipped)
+		default:
+			logf("Notification symbol %q rejected by PLC: ADS error 0x%X", configs[i].SymbolName, uint32(r.Error))
+		}
+	}
+	return registered, handles, nil
+}
+
+// cacheSymbolMeta stores type metadata for a symbol, preferring the symbol file over the go-ads cache.
+func (g *adsCommInput) cacheSymbolMeta(ctx context.Context, name string) {
+	key := strings.ToLower(name)
+	if g.symbolTable != nil {
+		if info, ok := g.symbolTable.symbol(name); ok {
+			g.dataTypes[key] = info.DataType
+			g.dataSizes[key] = info.Size
+			g.baseTypes[key] = g.symbolTable.baseTypeName(info.DataType)
+			return
+		}
+	}
+	if view, viewErr := g.handler.GetSymbol(ctx, name); viewErr == nil {
+		g.dataTypes[key] = view.DataType
+		g.dataSizes[key] = view.Length
+		if bt := view.BaseTypeName(); bt != "" {
+			g.baseTypes[key] = bt
+		}
+	}
+}
+
+func (g *adsCommInput) makeNotificationMessage(ctx context.Context, update *adsLib.Update) *service.Message {
+	// Symbols registered by the retry have no metadata yet; go-ads has them cached by now.
+	if _, ok := g.dataTypes[strings.ToLower(update.Variable)]; !ok && g.handler != nil {
+		g.cacheSymbolMeta(ctx, update.Variable)
+	}
+	msg := g.makeMessage(update.Variable, update.Value)
+	if src, ok := g.capture.take(update); ok {
+		msg = withCaptureSource(msg, src)
+	}
+	return msg
+}
+
+// makeMessage builds the output message for one symbol value, including type and target metadata.
+func (g *adsCommInput) makeMessage(symbolName, value string) *service.Message {
+	msg := service.NewMessage([]byte(value))
+	key := strings.ToLower(symbolName)
+	name := symbolName
 	if configured, ok := g.symbolNames[key]; ok {
 		name = configured
 	}
@@ -609,6 +756,10 @@ func (g *adsCommInput) makeNotificationMessage(update *adsLib.Update) *service.M
 	if sz, ok := g.dataSizes[key]; ok {
 		msg.MetaSet("data_size", strconv.FormatUint(uint64(sz), 10))
 	}
+	if g.targetName != "" {
+		msg.MetaSet("target_name", g.targetName)
+		msg.MetaSet("target_ams", g.targetAMS)
+	}
 	return msg
 }
 
@@ -619,18 +770,35 @@ func (g *adsCommInput) ReadBatchPull(ctx context.Context) (service.MessageBatch,
 		return nil, nil, service.ErrNotConnected
 	}
 
-	names := make([]string, len(g.symbols))
-	for i, symbol := range g.symbols {
+	named, raw := splitRawSymbols(g.symbols)
+	names := make([]string, len(named))
+	for i, symbol := range named {
 		names[i] = symbol.name
 	}
 
-	values, err := g.handler.ReadMultipleSymbols(ctx, names)
+	values := map[string]string{}
+	var rawData map[string][]byte // bytes of the direct address values, for the capture
+	var err error
+	if len(names) > 0 {
+		values, err = g.handler.ReadMultipleSymbols(ctx, names)
+	}
+	if err == nil && len(raw) > 0 {
+		var rawValues map[string]string
+		if rawValues, rawData, err = g.readRawValues(ctx, g.handler, raw, &g.rawSumReadUnsupported); err == nil {
+			for name, val := range rawValues {
+				values[name] = val
+			}
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, nil, ctx.Err() // shutting down
+	}
 	if err != nil {
 		g.log.Errorf("Batch read failed: %v", err)
 		if g.handler.IsClosed() {
 			old := g.handler
 			g.handler = nil
-			go func() { _ = old.Close() }()
+			go g.releaseSession(old, g.log)
 			return nil, nil, service.ErrNotConnected
 		}
 		g.log.Warnf("Batch read failed (will retry): %v", err)
@@ -643,16 +811,9 @@ func (g *adsCommInput) ReadBatchPull(ctx context.Context) (service.MessageBatch,
 	}
 
 	// Lazily populate type metadata from go-ads cache (no extra round-trips).
-	for _, sym := range g.symbols {
-		key := strings.ToLower(sym.name)
-		if _, ok := g.dataTypes[key]; !ok {
-			if view, viewErr := g.handler.GetSymbol(ctx, sym.name); viewErr == nil {
-				g.dataTypes[key] = view.DataType
-				g.dataSizes[key] = view.Length
-				if bt := view.BaseTypeName(); bt != "" {
-					g.baseTypes[key] = bt
-				}
-			}
+	for _, sym := range named {
+		if _, ok := g.dataTypes[strings.ToLower(sym.name)]; !ok {
+			g.cacheSymbolMeta(ctx, sym.name)
 		}
 	}
 
@@ -660,45 +821,36 @@ func (g *adsCommInput) ReadBatchPull(ctx context.Context) (service.MessageBatch,
 	for _, symbol := range g.symbols {
 		val, ok := values[symbol.name]
 		if !ok {
+			if len(values) > 0 && !g.missingWarned[symbol.name] {
+				g.log.Warnf("Symbol %q missing from interval read, skipping it until it can be read", symbol.name)
+				g.missingWarned[symbol.name] = true
+			}
 			continue
 		}
-		key := strings.ToLower(symbol.name)
-		valueMsg := service.NewMessage([]byte(val))
-		valueMsg.MetaSet("symbol_name", sanitize(symbol.name))
-		if dt, ok := g.dataTypes[key]; ok {
-			valueMsg.MetaSet("data_type", dt)
-		}
-		if bt, ok := g.baseTypes[key]; ok {
-			valueMsg.MetaSet("base_type", bt)
+		delete(g.missingWarned, symbol.name)
+		msg := g.makeMessage(symbol.name, val)
+		if data, ok := rawData[symbol.name]; ok && g.capture != nil {
+			msg = withCaptureSource(msg, captureSource{data: data})
 		}
-		if sz, ok := g.dataSizes[key]; ok {
-			valueMsg.MetaSet("data_size", strconv.FormatUint(uint64(sz), 10))
-		}
-		msgs = append(msgs, valueMsg)
+		msgs = append(msgs, msg)
 	}
 
 	// Some PLCs don't support ADS sum read — fall back to individual reads.
 	if len(msgs) == 0 && len(g.symbols) > 0 {
 		g.log.Warnf("Batch read returned no results for %d symbols, falling back to individual reads", len(g.symbols))
 		for _, symbol := range g.symbols {
-			val, readErr := g.handler.ReadFromSymbol(ctx, symbol.name)
+			var val string
+			var readErr error
+			if symbol.address != nil {
+				val, readErr = g.readRawSymbol(ctx, symbol)
+			} else {
+				val, readErr = g.handler.ReadFromSymbol(ctx, symbol.name)
+			}
 			if readErr != nil {
 				g.log.Errorf("Individual read failed for %s: %v", symbol.name, readErr)
 				continue
 			}
-			key := strings.ToLower(symbol.name)
-			valueMsg := service.NewMessage([]byte(val))
-			valueMsg.MetaSet("symbol_name", sanitize(symbol.name))
-			if dt, ok := g.dataTypes[key]; ok {
-				valueMsg.MetaSet("data_type", dt)
-			}
-			if bt, ok := g.baseTypes[key]; ok {
-				valueMsg.MetaSet("base_type", bt)
-			}
-			if sz, ok := g.dataSizes[key]; ok {
-				valueMsg.MetaSet("data_size", strconv.FormatUint(uint64(sz), 10))
-			}
-			msgs = append(msgs, valueMsg)
+			msgs = append(msgs, g.makeMessage(symbol.name, val))
 		}
 	}
 
@@ -729,22 +881,24 @@ func (g *adsCommInput) ReadBatchNotification(ctx context.Context) (service.Messa
 	case <-g.done:
 		return nil, nil, service.ErrEndOfInput
 	case <-waitCtx.Done():
-		if g.handler != nil && g.handler.IsClosed() {
-			_ = g.handler.Close()
+		if g.connectionLost() {
+			g.stopSharding()
+			g.deleteNotifications(ctx)
+			g.releaseSession(g.handler, g.log)
 			g.handler = nil
 			return nil, nil, service.ErrNotConnected
 		}
 		return nil, func(_ context.Context, _ error) error { return nil }, nil
 	}
 
-	msgs := service.MessageBatch{g.makeNotificationMessage(first)}
+	msgs := service.MessageBatch{g.makeNotificationMessage(ctx, first)}
 
 	// Drain all pending notifications without blocking to keep the channel buffer available.
 	for {
 		select {
 		case update := <-g.notificationChan:
 			if update != nil {
-				msgs = append(msgs, g.makeNotificationMessage(update))
+				msgs = append(msgs, g.makeNotificationMessage(ctx, update))
 			}
 		default:
 			return msgs, func(_ context.Context, _ error) error { return nil }, nil
@@ -754,10 +908,20 @@ func (g *adsCommInput) ReadBatchNotification(ctx context.Context) (service.Messa
 
 func (g *adsCommInput) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
 	g.log.Infof("ReadBatch called")
+	var batch service.MessageBatch
+	var ack service.AckFunc
+	var err error
 	if g.readType == "notification" {
-		return g.ReadBatchNotification(ctx)
+		batch, ack, err = g.ReadBatchNotification(ctx)
+	} else {
+		batch, ack, err = g.ReadBatchPull(ctx)
 	}
-	return g.ReadBatchPull(ctx)
+	if err == nil {
+		if cerr := g.capture.write(batch); cerr != nil {
+			g.log.Warnf("Capturing batch failed: %v", cerr)
+		}
+	}
+	return batch, ack, err
 }
 
 // Close shuts down the ADS connection.
@@ -765,16 +929,33 @@ func (g *adsCommInput) ReadBatch(ctx context.Context) (service.MessageBatch, ser
 //nolint:revive
 func (g *adsCommInput) Close(ctx context.Context) error {
 	g.log.Infof("Close called")
+	g.stopSymbolRetry()
 	if g.done != nil {
 		close(g.done)
 		g.done = nil
 	}
 	if g.handler != nil {
 		g.log.Infof("Closing down, cleaning up PLC handles")
-		if cerr := g.handler.Close(); cerr != nil {
-			g.log.Warnf("Handler close error: %v", cerr)
-		}
+		g.stopSharding()
+		g.deleteNotifications(ctx)
+		g.releaseSession(g.handler, g.log)
 		g.handler = nil
 	}
+	g.releaseRoute(ctx, g.log)
+	if err := g.capture.close(); err != nil {
+		g.log.Warnf("Closing capture failed: %v", err)
+	}
 	return nil
 }
+
+// deleteNotifications removes this input's notification handles from a shared session, which
+// stays open for other components. Unshared sessions drop all handles when they close.
+func (g *adsCommInput) deleteNotifications(ctx context.Context) {
+	if !g.shared() || len(g.notificationHandles) == 0 || g.handler.IsClosed() {
+		return
+	}
+	if err := g.handler.DeleteNotifications(ctx, g.notificationHandles); err != nil {
+		g.log.Warnf("Failed to delete %d notification handles: %v", len(g.notificationHandles), err)
+	}
+	g.notificationHandles = nil
+}
@@ -0,0 +1,453 @@
+package benthosADS
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RuneRoven/benthosADS/adstest"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+func TestValidateHost(t *testing.T) {
+	for _, s := range []string{
+		"192.168.1.100", "::1", "[fe80::1]", "plc1", "plc-1.line3.example.com", "plc_1.local.", "a.b",
+	} {
+		if err := validateHost(s); err != nil {
+			t.Errorf("validateHost(%q) = %v, want valid", s, err)
+		}
+	}
+	for _, s := range []string{
+		"", "192.168.1.256", "192.168.1", "1.2.3.4.5", "plc..local", "-plc", "plc-.local", "plc 1", "plc/1",
+		"plc:851", strings.Repeat("a", 64) + ".local",
+	} {
+		if err := validateHost(s); err == nil {
+			t.Errorf("validateHost(%q) = nil, want an error", s)
+		}
+	}
+	if err := validateHost(strings.Repeat("abcdefghi.", 26)); err == nil {
+		t.Error("hostname over 253 characters: want an error")
+	}
+}
+
+// newServerInput builds an ads input for srv from the given extra config lines, as a pipeline
+// would, and closes it at the end of the test.
+func newServerInput(t *testing.T, srv *adstest.Server, extra string) service.BatchInput {
+	t.Helper()
+	conf, err := adsConf.ParseYAML(fmt.Sprintf(`
+targetIP: 127.0.0.1
+targetPort: %d
+targetAMS: %s
+runtimePort: 851
+hostAMS: 10.0.0.9.1.1
+requestTimeout: 10000
+%s`, srv.Addr().Port, srv.NetID(), extra), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := newAdsCommInput(conf, service.MockResources())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = in.Close(context.Background()) })
+	return in
+}
+
+// readUntil reads batches until the last value of every symbol in want matches, and returns
+// the number of batches read.
+func readUntil(t *testing.T, in service.BatchInput, want map[string]string) int {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	got := map[string]string{}
+	for batches := 1; ; batches++ {
+		batch, _, err := in.ReadBatch(ctx)
+		if err != nil {
+			t.Fatalf("ReadBatch: %v (values so far %v)", err, got)
+		}
+		for _, msg := range batch {
+			name, _ := msg.MetaGet("symbol_name")
+			value, _ := msg.AsBytes()
+			got[name] = string(value)
+		}
+		done := true
+		for name, v := range want {
+			done = done && got[name] == v
+		}
+		if done {
+			return batches
+		}
+	}
+}
+
+func newTestServer(t *testing.T) *adstest.Server {
+	t.Helper()
+	srv, err := adstest.NewServer(adstest.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+	return srv
+}
+
+func addSymbols(t *testing.T, srv *adstest.Server, typ string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := srv.AddSymbol(name, typ, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInputNotificationsFromServer(t *testing.T) {
+	srv := newTestServer(t)
+	addSymbols(t, srv, "DINT", "MAIN.counter")
+	addSymbols(t, srv, "INT", "MAIN.level") // offset 4
+	in := newServerInput(t, srv, `
+symbols:
+  - MAIN.counter
+  - ig=0x4040,io=4,type=INT
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := in.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Notifications(); n != 2 {
+		t.Errorf("server has %d notifications, want 2", n)
+	}
+
+	if err := srv.SetValue("MAIN.counter", 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.SetValue("MAIN.level", -7); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, in, map[string]string{"MAIN_counter": "42", "ig_0x4040_io_4_type_INT": "-7"})
+
+	if err := in.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.WaitConnections(ctx, 0); err != nil {
+		t.Fatalf("connection still open after Close: %v", err)
+	}
+	if n := srv.Notifications(); n != 0 {
+		t.Errorf("server has %d notifications after Close", n)
+	}
+}
+
+func TestInputRetriesRejectedNotification(t *testing.T) {
+	srv := newTestServer(t)
+	addSymbols(t, srv, "DINT", "MAIN.a", "MAIN.b")
+	srv.InjectFault(adstest.Fault{
+		Command: adstest.CmdAddNotification, Symbol: "MAIN.b", ErrorCode: adstest.ErrNoMoreNotifyHandles, Count: 1,
+	})
+	in := newServerInput(t, srv, `
+symbolRetryInterval: 200
+symbols:
+  - MAIN.a
+  - MAIN.b
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := in.Connect(ctx); err != nil {
+		t.Fatalf("Connect failed on one rejected symbol: %v", err)
+	}
+	if n := srv.Notifications(); n != 1 {
+		t.Errorf("server has %d notifications after connect, want 1", n)
+	}
+	if err := srv.WaitNotifications(ctx, 2); err != nil {
+		t.Fatalf("rejected symbol was not registered again: %v", err)
+	}
+
+	if err := srv.SetValue("MAIN.a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.SetValue("MAIN.b", 2); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, in, map[string]string{"MAIN_a": "1", "MAIN_b": "2"})
+}
+
+func TestInputSumReadUnsupported(t *testing.T) {
+	srv := newTestServer(t)
+	addSymbols(t, srv, "DINT", "MAIN.a", "MAIN.b")
+	if err := srv.SetValue("MAIN.a", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.SetValue("MAIN.b", 4); err != nil {
+		t.Fatal(err)
+	}
+	srv.InjectFault(adstest.Fault{
+		Command: adstest.CmdReadWrite, IndexGroup: adstest.IndexGroupSumRead, ErrorCode: adstest.ErrServiceNotSupported,
+	})
+	in := newServerInput(t, srv, `
+readType: interval
+intervalTime: 10
+symbols:
+  - ig=0x4040,io=0,type=DINT
+  - ig=0x4040,io=4,type=DINT
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := in.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"ig_0x4040_io_0_type_DINT": "3", "ig_0x4040_io_4_type_DINT": "4"}
+	readUntil(t, in, want)
+
+	// Once rejected, the sum read is not tried again until the next connect.
+	sumReads, reads := srv.Requests(adstest.CmdReadWrite), srv.Requests(adstest.CmdRead)
+	readUntil(t, in, want)
+	if n := srv.Requests(adstest.CmdReadWrite); n != sumReads {
+		t.Errorf("%d more sum reads after the PLC rejected them", n-sumReads)
+	}
+	if n := srv.Requests(adstest.CmdRead); n < reads+2 {
+		t.Errorf("%d single reads for two addresses, want at least 2", n-reads)
+	}
+}
+
+func TestInputConnectionLostMidRead(t *testing.T) {
+	srv := newTestServer(t)
+	addSymbols(t, srv, "DINT", "MAIN.a")
+	in := newServerInput(t, srv, `
+readType: interval
+intervalTime: 10
+symbols:
+  - ig=0x4040,io=0,type=DINT
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := in.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	srv.InjectFault(adstest.Fault{
+		Command: adstest.CmdReadWrite, IndexGroup: adstest.IndexGroupSumRead, CloseConnection: true, Count: 1,
+	})
+	if _, _, err := in.ReadBatch(ctx); !errors.Is(err, service.ErrNotConnected) {
+		t.Fatalf("ReadBatch on a dropped connection = %v, want ErrNotConnected", err)
+	}
+
+	if err := in.Connect(ctx); err != nil {
+		t.Fatalf("reconnect: %v", err)
+	}
+	if err := srv.SetValue("MAIN.a", 9); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, in, map[string]string{"ig_0x4040_io_0_type_DINT": "9"})
+}
+
+func TestInputCloseDuringBlockedRead(t *testing.T) {
+	srv := newTestServer(t)
+	addSymbols(t, srv, "DINT", "MAIN.a")
+	in := newServerInput(t, srv, `
+readType: interval
+intervalTime: 10
+symbols:
+  - ig=0x4040,io=0,type=DINT
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := in.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	hold := make(chan struct{})
+	defer close(hold)
+	srv.InjectFault(adstest.Fault{Command: adstest.CmdReadWrite, IndexGroup: adstest.IndexGroupSumRead, Hold: hold})
+
+	// On shutdown Benthos cancels the context of the pending read and closes the input once
+	// ReadBatch returned, so neither may wait for the PLC's answer or the request timeout.
+	readCtx, readCancel := context.WithCancel(ctx)
+	defer readCancel()
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := in.ReadBatch(readCtx)
+		errs <- err
+	}()
+	if err := srv.WaitRequests(ctx, adstest.CmdReadWrite, 1); err != nil {
+		t.Fatal(err)
+	}
+	readCancel()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("cancelled ReadBatch = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ReadBatch kept blocking after its context was cancelled")
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- in.Close(ctx) }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close blocked on the held read")
+	}
+	if err := srv.WaitConnections(ctx, 0); err != nil {
+		t.Fatalf("connection still open after Close: %v", err)
+	}
+}
+
+func TestInputResubscribesAfterReconnect(t *testing.T) {
+	srv := newTestServer(t)
+	addSymbols(t, srv, "DINT", "MAIN.a")
+	in := newServerInput(t, srv, `
+symbols:
+  - MAIN.a
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	if err := in.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.WaitNotifications(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.DropConnections()
+	for {
+		_, _, err := in.ReadBatch(ctx)
+		if errors.Is(err, service.ErrNotConnected) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadBatch after the PLC dropped the connection = %v, want ErrNotConnected", err)
+		}
+	}
+	if err := in.Connect(ctx); err != nil {
+		t.Fatalf("reconnect: %v", err)
+	}
+	if err := srv.WaitNotifications(ctx, 1); err != nil {
+		t.Fatalf("notification not registered again: %v", err)
+	}
+	if err := srv.SetValue("MAIN.a", 42); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, in, map[string]string{"MAIN_a": "42"})
+}
+
+func TestInputCloseDuringOverflowPoll(t *testing.T) {
+	srv := newTestServer(t)
+	addSymbols(t, srv, "DINT", "MAIN.a", "MAIN.b")
+	in := newServerInput(t, srv, `
+maxNotificationsPerConnection: 1
+notificationOverflow: poll
+intervalTime: 10
+symbols:
+  - MAIN.a
+  - ig=0x4040,io=4,type=DINT
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	hold := make(chan struct{})
+	defer close(hold)
+	srv.InjectFault(adstest.Fault{Command: adstest.CmdReadWrite, IndexGroup: adstest.IndexGroupSumRead, Hold: hold})
+	if err := in.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // let the poller send its held sum read
+
+	closed := make(chan error, 1)
+	go func() { closed <- in.Close(ctx) }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close waited for the overflow poller's read")
+	}
+	if err := srv.WaitConnections(ctx, 0); err != nil {
+		t.Fatalf("connection still open after Close: %v", err)
+	}
+}
+
+func TestInputSymbolCacheHitSkipsUpload(t *testing.T) {
+	srv := newTestServer(t)
+	if err := srv.AddType(adstest.DataType{Name: "ST_Motor", Fields: []adstest.Field{
+		{Name: "speed", Type: "DINT"}, {Name: "running", Type: "BOOL"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.AddSymbol("MAIN.motor", "ST_Motor", nil); err != nil {
+		t.Fatal(err)
+	}
+	config := fmt.Sprintf(`
+readType: interval
+intervalTime: 10
+loadSymbols: true
+symbolCacheDir: %s
+symbols:
+  - MAIN.motor
+`, t.TempDir())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The first connect downloads the table into the cache.
+	first := newServerInput(t, srv, config)
+	if err := first.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// With the cache hit, any symbol or datatype upload fails the connect.
+	for _, group := range []uint32{adstest.IndexGroupSymUpload, adstest.IndexGroupDataTypes} {
+		srv.InjectFault(adstest.Fault{Command: adstest.CmdRead, IndexGroup: group, ErrorCode: adstest.ErrInvalidAccess})
+	}
+	second := newServerInput(t, srv, config)
+	if err := second.Connect(ctx); err != nil {
+		t.Fatalf("connect with a cached table: %v", err)
+	}
+	if err := srv.SetValue("MAIN.motor.speed", 1450); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.SetValue("MAIN.motor.running", true); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, second, map[string]string{"MAIN_motor": `{"running":true,"speed":1450}`})
+}
+
+func TestInputCapturesReadBytes(t *testing.T) {
+	srv := newTestServer(t)
+	addSymbols(t, srv, "INT", "MAIN.level")
+	if err := srv.SetValue("MAIN.level", -2); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "line.capture")
+	in := newServerInput(t, srv, fmt.Sprintf(`
+readType: interval
+intervalTime: 10
+capture:
+  path: %s
+symbols:
+  - ig=0x4040,io=0,type=INT
+`, path))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := in.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, in, map[string]string{"ig_0x4040_io_0_type_INT": "-2"})
+	if err := in.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	records, _ := readCapture(t, path)
+	if len(records) == 0 {
+		t.Fatal("nothing captured")
+	}
+	if got := records[0]; got.Value != "-2" || !bytes.Equal(got.Data, []byte{0xFE, 0xFF}) {
+		t.Errorf("record = %+v, want the value bytes of the read", *got)
+	}
+}
@@ -0,0 +1,202 @@
+package benthosADS
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	adsLib "github.com/RuneRoven/go-ads/v2"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// adsSessionPool shares ADS sessions between components that set the same connectionLabel,
+// so an input, processors and outputs talking to one PLC use a single TCP connection and route.
+type adsSessionPool struct {
+	mu      sync.Mutex
+	entries map[string]*pooledSession
+}
+
+type pooledSession struct {
+	session *adsLib.Session
+	refs    int
+	conf    *adsConnConfig // settings the session was opened with
+	opening chan struct{}  // closed when the first user finished opening the session
+	route   *heldRoute     // temporary route registered for the session, held by every user
+}
+
+var sessionPool = &adsSessionPool{entries: map[string]*pooledSession{}}
+
+// poolKey identifies a shared session: one per label and PLC runtime.
+func (c *adsConnConfig) poolKey() string {
+	return fmt.Sprintf("%s|%s|%s:%d", c.connectionLabel, c.targetIP, c.targetAMS, c.runtimePort)
+}
+
+// acquire returns the shared session for c, opening it on first use or when the previous
+// session lost its connection. The session is opened outside the pool lock, so a slow PLC
+// only delays users of the same key. Every successful acquire must be paired with a release.
+func (p *adsSessionPool) acquire(ctx context.Context, c *adsConnConfig, log *service.Logger) (*adsLib.Session, error) {
+	key := c.poolKey()
+	for {
+		p.mu.Lock()
+		e, ok := p.entries[key]
+		if ok {
+			if diff := connSettingsDiff(e.conf, c); diff != "" {
+				p.mu.Unlock()
+				return nil, fmt.Errorf("connectionLabel %q is already used for %s with a different %s", c.connectionLabel, c.targetIP, diff)
+			}
+			if e.opening != nil {
+				opening := e.opening
+				p.mu.Unlock()
+				select {
+				case <-opening:
+					continue
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+			if !e.session.IsClosed() {
+				e.refs++
+				session, route := e.session, e.route
+				p.mu.Unlock()
+				if route != nil {
+					c.holdRoute(route.targetIP, route.name, route.local)
+				}
+				log.Debugf("Sharing ADS connection %q (%d users)", c.connectionLabel, e.refs)
+				return session, nil
+			}
+			// The connection is gone. Holders of the old session release it into the void
+			// and re-acquire, so the reopened session starts counting from zero.
+			_ = e.session.Close()
+		}
+		e = &pooledSession{conf: c, opening: make(chan struct{})}
+		p.entries[key] = e
+		p.mu.Unlock()
+
+		session, err := c.openSession(ctx, log)
+
+		p.mu.Lock()
+		close(e.opening)
+		e.opening = nil
+		if err != nil {
+			delete(p.entries, key)
+			p.mu.Unlock()
+			return nil, err
+		}
+		e.session, e.refs, e.route = session, 1, c.heldRoute
+		p.mu.Unlock()
+		log.Infof("Opened shared ADS connection %q to %s", c.connectionLabel, c.targetIP)
+		return session, nil
+	}
+}
+
+// connSettingsDiff names the first setting in which two components with the same pool key
+// differ, or returns "" when they may share a session. Timeouts and logging may differ; the
+// session uses those of the component that opened it.
+func connSettingsDiff(a, b *adsConnConfig) string {
+	switch {
+	case a.hostAMS != b.hostAMS || a.hostPort != b.hostPort:
+		return "hostAMS or hostPort"
+	case a.targetPort != b.targetPort:
+		return "targetPort"
+	case a.routeUsername != b.routeUsername || a.routePassword != b.routePassword ||
+		!slices.Equal(a.routeCredentials, b.routeCredentials):
+		return "route credentials"
+	case a.routeHostAddress != b.routeHostAddress || a.routeName != b.routeName ||
+		a.routeLifetime != b.routeLifetime || a.listRoutes != b.listRoutes:
+		return "route setting"
+	case !sameTLSConfig(a.tls, b.tls):
+		return "tls setting"
+	case !sameMQTTConfig(a.mqtt, b.mqtt):
+		return "mqtt setting"
+	}
+	return ""
+}
+
+func sameTLSConfig(a, b *adsTLSConfig) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	sameCert := (a.cert == nil) == (b.cert == nil)
+	if sameCert && a.cert != nil {
+		sameCert = len(a.cert.Certificate) > 0 && len(b.cert.Certificate) > 0 &&
+			bytes.Equal(a.cert.Certificate[0], b.cert.Certificate[0])
+	}
+	return sameCert && a.port == b.port && a.serverName == b.serverName &&
+		bytes.Equal(a.fingerprint, b.fingerprint) && a.roots.Equal(b.roots)
+}
+
+func sameMQTTConfig(a, b *adsMQTTConfig) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if (a.tls == nil) != (b.tls == nil) || a.tls != nil && !a.tls.RootCAs.Equal(b.tls.RootCAs) {
+		return false
+	}
+	return a.addr == b.addr && a.topic == b.topic && a.clientID == b.clientID &&
+		a.username == b.username && a.password == b.password && a.keepAlive == b.keepAlive
+}
+
+// release drops one reference to session and closes it when the last user is gone. A user
+// giving up a live session also drops its hold on the temporary route, which is removed from
+// the PLC with the last user. After a connection loss the hold is kept for the reconnect, and
+// releasing a session that was already replaced is a no-op.
+func (p *adsSessionPool) release(c *adsConnConfig, session *adsLib.Session, log *service.Logger) {
+	lost := session.IsClosed()
+
+	p.mu.Lock()
+	key := c.poolKey()
+	e, ok := p.entries[key]
+	if !ok || e.session != session {
+		p.mu.Unlock()
+		return
+	}
+	e.refs--
+	last := e.refs <= 0
+	if last {
+		delete(p.entries, key)
+	}
+	p.mu.Unlock()
+
+	if last {
+		log.Infof("Closing shared ADS connection %q", c.connectionLabel)
+		if cerr := session.Close(); cerr != nil {
+			log.Warnf("Handler close error: %v", cerr)
+		}
+	}
+	if !lost {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		c.releaseRoute(ctx, log)
+	}
+}
+
+// acquireSession opens a session for this component, shared through the pool when
+// connectionLabel is set.
+func (c *adsConnConfig) acquireSession(ctx context.Context, log *service.Logger) (*adsLib.Session, error) {
+	if c.connectionLabel == "" {
+		return c.openSession(ctx, log)
+	}
+	return sessionPool.acquire(ctx, c, log)
+}
+
+// releaseSession gives up a session obtained from acquireSession.
+func (c *adsConnConfig) releaseSession(session *adsLib.Session, log *service.Logger) {
+	if session == nil {
+		return
+	}
+	if c.connectionLabel == "" {
+		if cerr := session.Close(); cerr != nil {
+			log.Warnf("Handler close error: %v", cerr)
+		}
+		return
+	}
+	sessionPool.release(c, session, log)
+}
+
+// shared reports whether sessions of this component may be used by other components too.
+func (c *adsConnConfig) shared() bool {
+	return c.connectionLabel != ""
+}
@@ -0,0 +1,76 @@
+package benthosADS
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+func TestConnSettingsDiff(t *testing.T) {
+	base := adsConnConfig{
+		targetIP:        "192.168.1.100",
+		targetAMS:       "192.168.1.100.1.1",
+		targetPort:      48898,
+		runtimePort:     851,
+		hostAMS:         "auto",
+		routeUsername:   "Administrator",
+		routePassword:   "1",
+		connectionLabel: "line3",
+		requestTimeout:  time.Second,
+	}
+	tests := map[string]struct {
+		change func(c *adsConnConfig)
+		want   string
+	}{
+		"same":             {func(c *adsConnConfig) {}, ""},
+		"timeout":          {func(c *adsConnConfig) { c.requestTimeout = 5 * time.Second }, ""},
+		"hostAMS":          {func(c *adsConnConfig) { c.hostAMS = "10.0.0.1.1.1" }, "hostAMS"},
+		"password":         {func(c *adsConnConfig) { c.routePassword = "2" }, "route credentials"},
+		"credentials list": {func(c *adsConnConfig) { c.routeCredentials = []routeCredential{{match: "*", username: "a"}} }, "route credentials"},
+		"route name":       {func(c *adsConnConfig) { c.routeName = "other" }, "route setting"},
+		"tls":              {func(c *adsConnConfig) { c.tls = &adsTLSConfig{port: 8016} }, "tls"},
+		"mqtt":             {func(c *adsConnConfig) { c.mqtt = &adsMQTTConfig{addr: "broker:1883", topic: "ads"} }, "mqtt"},
+	}
+	for name, tt := range tests {
+		other := base
+		tt.change(&other)
+		got := connSettingsDiff(&base, &other)
+		if tt.want == "" && got != "" || tt.want != "" && !strings.Contains(got, tt.want) {
+			t.Errorf("%s: diff = %q, want %q", name, got, tt.want)
+		}
+	}
+
+	a := &adsMQTTConfig{addr: "broker:8883", topic: "ads", tls: &tls.Config{ServerName: "broker"}}
+	b := &adsMQTTConfig{addr: "broker:8883", topic: "ads"}
+	if sameMQTTConfig(a, b) {
+		t.Error("mqtt with and without TLS compare equal")
+	}
+	if !sameTLSConfig(&adsTLSConfig{port: 8016, fingerprint: []byte{1}}, &adsTLSConfig{port: 8016, fingerprint: []byte{1}}) {
+		t.Error("equal tls settings compare different")
+	}
+}
+
+func TestSessionPoolRejectsOtherSettings(t *testing.T) {
+	pool := &adsSessionPool{entries: map[string]*pooledSession{}}
+	first := &adsConnConfig{targetIP: "10.0.0.1", targetAMS: "10.0.0.1.1.1", runtimePort: 851, connectionLabel: "x", hostAMS: "auto"}
+	pool.entries[first.poolKey()] = &pooledSession{conf: first, opening: make(chan struct{})}
+
+	second := *first
+	second.hostAMS = "10.0.0.2.1.1"
+	_, err := pool.acquire(context.Background(), &second, service.MockResources().Logger())
+	if err == nil || !strings.Contains(err.Error(), "hostAMS") {
+		t.Errorf("acquire with another hostAMS: error = %v", err)
+	}
+
+	// A user with the same settings waits for the session being opened.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	same := *first
+	if _, err = pool.acquire(ctx, &same, service.MockResources().Logger()); err != context.DeadlineExceeded {
+		t.Errorf("acquire while opening: error = %v, want the context deadline", err)
+	}
+}
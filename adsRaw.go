@@ -0,0 +1,443 @@
+package benthosADS
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	adsLib "github.com/RuneRoven/go-ads/v2"
+)
+
+// Index groups used for direct addressing of process images and the TwinCAT 2 flag area.
+const (
+	indexGroupMemoryByte = 0x4020 // %MB, %MW, %MD (TwinCAT 2 PLC flag area)
+	indexGroupInputByte  = 0xF020 // %IB, %IW, %ID (input process image)
+	indexGroupOutputByte = 0xF030 // %QB, %QW, %QD (output process image)
+)
+
+// rawAddress describes a variable read by index group and offset instead of by symbol name.
+type rawAddress struct {
+	indexGroup  uint32
+	indexOffset uint32
+	length      uint32
+	dataType    string
+	bit         int // bit within the read byte for %MX/%IX/%QX addresses, -1 otherwise
+
+	// Set for symbols taken from a symbol file; struct and array types are decoded using the table.
+	table  *symbolTable
+	arrays []arrayDim
+}
+
+// rawTypeSizes holds the byte size of every primitive type that can be decoded from raw data.
+var rawTypeSizes = map[string]uint32{
+	"BOOL": 1, "BYTE": 1, "USINT": 1, "SINT": 1,
+	"WORD": 2, "UINT": 2, "INT": 2,
+	"DWORD": 4, "UDINT": 4, "DINT": 4, "REAL": 4, "TIME": 4, "TOD": 4, "TIME_OF_DAY": 4, "DATE": 4, "DT": 4, "DATE_AND_TIME": 4,
+	"LWORD": 8, "ULINT": 8, "LINT": 8, "LREAL": 8,
+}
+
+// isRawAddress reports whether a symbol entry uses direct addressing.
+func isRawAddress(s string) bool {
+	return strings.HasPrefix(s, "%") || strings.HasPrefix(strings.ToLower(s), "ig=")
+}
+
+// parseRawAddress parses a direct address entry. Two forms are accepted:
+//
+//	ig=0x4020,io=100,len=4,type=DINT
+//	%MD100 | %IW4 | %QX0.1 (optionally followed by ",type=REAL")
+//
+// len defaults to the size of type, and type defaults to the unsigned type matching the IEC size prefix.
+func parseRawAddress(s string) (*rawAddress, error) {
+	addr := &rawAddress{bit: -1}
+	parts := strings.Split(s, ",")
+	first := 0
+	if strings.HasPrefix(parts[0], "%") {
+		if err := addr.parseIEC(parts[0]); err != nil {
+			return nil, err
+		}
+		first = 1
+	}
+
+	haveGroup := first == 1
+	haveOffset := first == 1
+	for _, p := range parts[first:] {
+		key, val, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: expected key=value, got %q", s, p)
+		}
+		switch strings.ToLower(key) {
+		case "ig":
+			v, err := strconv.ParseUint(val, 0, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%q: invalid index group %q", s, val)
+			}
+			addr.indexGroup = uint32(v)
+			haveGroup = true
+		case "io":
+			v, err := strconv.ParseUint(val, 0, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%q: invalid index offset %q", s, val)
+			}
+			addr.indexOffset = uint32(v)
+			haveOffset = true
+		case "len":
+			v, err := strconv.ParseUint(val, 0, 32)
+			if err != nil || v == 0 {
+				return nil, fmt.Errorf("%q: invalid length %q", s, val)
+			}
+			addr.length = uint32(v)
+		case "type":
+			addr.dataType = strings.ToUpper(val)
+		default:
+			return nil, fmt.Errorf("%q: unknown key %q (expected ig, io, len or type)", s, key)
+		}
+	}
+	if !haveGroup || !haveOffset {
+		return nil, fmt.Errorf("%q: both ig and io are required", s)
+	}
+	if addr.dataType == "" {
+		return nil, fmt.Errorf("%q: type is required", s)
+	}
+
+	size, known := rawTypeSizes[addr.dataType]
+	if strings.HasPrefix(addr.dataType, "STRING") {
+		size, known = stringTypeSize(addr.dataType), true
+	}
+	if !known {
+		return nil, fmt.Errorf("%q: unsupported type %q", s, addr.dataType)
+	}
+	if addr.length == 0 {
+		addr.length = size
+	}
+	if addr.length < size {
+		return nil, fmt.Errorf("%q: len %d is smaller than %s (%d bytes)", s, addr.length, addr.dataType, size)
+	}
+	return addr, nil
+}
+
+// parseIEC fills the address from an IEC 61131-3 direct address like %MD100 or %IX2.3.
+func (a *rawAddress) parseIEC(s string) error {
+	if len(s) < 3 {
+		return fmt.Errorf("%q is not a valid IEC address", s)
+	}
+	switch s[1] {
+	case 'M', 'm':
+		a.indexGroup = indexGroupMemoryByte
+	case 'I', 'i':
+		a.indexGroup = indexGroupInputByte
+	case 'Q', 'q':
+		a.indexGroup = indexGroupOutputByte
+	default:
+		return fmt.Errorf("%q: area must be M, I or Q", s)
+	}
+
+	rest := s[3:]
+	switch s[2] {
+	case 'X', 'x':
+		byteStr, bitStr, ok := strings.Cut(rest, ".")
+		if !ok {
+			return fmt.Errorf("%q: bit addresses need the form %%%cX<byte>.<bit>", s, s[1])
+		}
+		bit, err := strconv.Atoi(bitStr)
+		if err != nil || bit < 0 || bit > 7 {
+			return fmt.Errorf("%q: bit must be 0–7", s)
+		}
+		a.bit = bit
+		a.dataType = "BOOL"
+		rest = byteStr
+	case 'B', 'b':
+		a.dataType = "BYTE"
+	case 'W', 'w':
+		a.dataType = "WORD"
+	case 'D', 'd':
+		a.dataType = "DWORD"
+	case 'L', 'l':
+		a.dataType = "LWORD"
+	default:
+		return fmt.Errorf("%q: size must be X, B, W, D or L", s)
+	}
+
+	offset, err := strconv.ParseUint(rest, 10, 32)
+	if err != nil {
+		return fmt.Errorf("%q: invalid byte offset %q", s, rest)
+	}
+	a.indexOffset = uint32(offset)
+	return nil
+}
+
+// stringTypeSize returns the byte size of STRING or STRING(n), including the terminator.
+func stringTypeSize(t string) uint32 {
+	n := 80
+	if open := strings.IndexByte(t, '('); open >= 0 && strings.HasSuffix(t, ")") {
+		if v, err := strconv.Atoi(t[open+1 : len(t)-1]); err == nil && v > 0 {
+			n = v
+		}
+	}
+	return uint32(n + 1)
+}
+
+// decode converts raw little-endian PLC data into the string form used as message payload.
+func (a *rawAddress) decode(data []byte) (string, error) {
+	if a.bit >= 0 {
+		if len(data) < 1 {
+			return "", fmt.Errorf("short read: got 0 bytes")
+		}
+		return strconv.FormatBool(data[0]&(1<<a.bit) != 0), nil
+	}
+	if a.table != nil {
+		return a.table.decodeJSON(a.dataType, a.arrays, data)
+	}
+	return decodeRawValue(a.dataType, data)
+}
+
+// decodeRawValue decodes a primitive PLC value of the given type.
+func decodeRawValue(dataType string, data []byte) (string, error) {
+	if strings.HasPrefix(dataType, "STRING") {
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			data = data[:i]
+		}
+		return string(data), nil
+	}
+	size, ok := rawTypeSizes[dataType]
+	if !ok {
+		return "", fmt.Errorf("unsupported type %q", dataType)
+	}
+	if uint32(len(data)) < size {
+		return "", fmt.Errorf("short read for %s: got %d bytes, need %d", dataType, len(data), size)
+	}
+
+	le := binary.LittleEndian
+	switch dataType {
+	case "BOOL":
+		return strconv.FormatBool(data[0] != 0), nil
+	case "BYTE", "USINT":
+		return strconv.FormatUint(uint64(data[0]), 10), nil
+	case "SINT":
+		return strconv.FormatInt(int64(int8(data[0])), 10), nil
+	case "WORD", "UINT":
+		return strconv.FormatUint(uint64(le.Uint16(data)), 10), nil
+	case "INT":
+		return strconv.FormatInt(int64(int16(le.Uint16(data))), 10), nil
+	case "DWORD", "UDINT", "DATE", "DT", "DATE_AND_TIME":
+		return strconv.FormatUint(uint64(le.Uint32(data)), 10), nil
+	case "DINT":
+		return strconv.FormatInt(int64(int32(le.Uint32(data))), 10), nil
+	case "TIME", "TOD", "TIME_OF_DAY":
+		return (time.Duration(le.Uint32(data)) * time.Millisecond).String(), nil
+	case "REAL":
+		return strconv.FormatFloat(float64(math.Float32frombits(le.Uint32(data))), 'g', -1, 32), nil
+	case "LWORD", "ULINT":
+		return strconv.FormatUint(le.Uint64(data), 10), nil
+	case "LINT":
+		return strconv.FormatInt(int64(le.Uint64(data)), 10), nil
+	case "LREAL":
+		return strconv.FormatFloat(math.Float64frombits(le.Uint64(data)), 'g', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported type %q", dataType)
+}
+
+// encodeRawValue converts the string form of a primitive PLC value into its little-endian
+// representation, the inverse of decodeRawValue. TIME values accept durations ("1.5s") or milliseconds.
+func encodeRawValue(dataType, s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(dataType, "STRING") {
+		size := stringTypeSize(dataType)
+		if uint32(len(s)) >= size {
+			return nil, fmt.Errorf("%q exceeds %s", s, dataType)
+		}
+		buf := make([]byte, size)
+		copy(buf, s)
+		return buf, nil
+	}
+	size, ok := rawTypeSizes[dataType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported type %q", dataType)
+	}
+
+	le := binary.LittleEndian
+	buf := make([]byte, size)
+	var err error
+	switch dataType {
+	case "BOOL":
+		var b bool
+		if b, err = strconv.ParseBool(s); err == nil && b {
+			buf[0] = 1
+		}
+	case "BYTE", "USINT", "WORD", "UINT", "DWORD", "UDINT", "DATE", "DT", "DATE_AND_TIME", "LWORD", "ULINT":
+		var v uint64
+		if v, err = strconv.ParseUint(s, 10, int(size)*8); err == nil {
+			buf = le.AppendUint64(nil, v)[:size]
+		}
+	case "SINT", "INT", "DINT", "LINT":
+		var v int64
+		if v, err = strconv.ParseInt(s, 10, int(size)*8); err == nil {
+			buf = le.AppendUint64(nil, uint64(v))[:size]
+		}
+	case "TIME", "TOD", "TIME_OF_DAY":
+		d, perr := time.ParseDuration(s)
+		if perr != nil {
+			ms, merr := strconv.ParseUint(s, 10, 32)
+			if merr != nil {
+				return nil, fmt.Errorf("invalid %s value %q", dataType, s)
+			}
+			d = time.Duration(ms) * time.Millisecond
+		}
+		le.PutUint32(buf, uint32(d/time.Millisecond))
+	case "REAL":
+		var v float64
+		if v, err = strconv.ParseFloat(s, 32); err == nil {
+			le.PutUint32(buf, math.Float32bits(float32(v)))
+		}
+	case "LREAL":
+		var v float64
+		if v, err = strconv.ParseFloat(s, 64); err == nil {
+			le.PutUint64(buf, math.Float64bits(v))
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q", dataType, s)
+	}
+	return buf, nil
+}
+
+// readRawSymbols reads all directly addressed symbols with a single sum read and returns
+// the decoded values and the bytes they were decoded from, keyed by symbol name. Entries the
+// PLC rejects are logged and left out.
+func (g *adsCommInput) readRawSymbols(ctx context.Context, session *adsLib.Session, symbols []plcSymbol) (map[string]string, map[string][]byte, error) {
+	reqs := make([]adsLib.ReadRequest, len(symbols))
+	for i, sym := range symbols {
+		reqs[i] = adsLib.ReadRequest{
+			IndexGroup:  sym.address.indexGroup,
+			IndexOffset: sym.address.indexOffset,
+			Length:      sym.address.length,
+		}
+	}
+
+	results, err := session.ReadMultiple(ctx, reqs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make(map[string]string, len(symbols))
+	raw := make(map[string][]byte, len(symbols))
+	for i, r := range results {
+		if i >= len(symbols) {
+			break
+		}
+		if r.Error != adsLib.ReturnCodeNoErrors {
+			g.log.Errorf("Direct read of %s rejected by PLC: ADS error 0x%X", symbols[i].name, uint32(r.Error))
+			continue
+		}
+		val, decErr := symbols[i].address.decode(r.Data)
+		if decErr != nil {
+			g.log.Errorf("Decoding %s failed: %v", symbols[i].name, decErr)
+			continue
+		}
+		values[symbols[i].name] = val
+		raw[symbols[i].name] = r.Data
+	}
+	return values, raw, nil
+}
+
+// readRawValues reads directly addressed symbols with readRawSymbols. When the PLC rejects the
+// sum read, as TwinCAT 2 runtimes without sum command support do, it sets *sumUnsupported and
+// reads them one by one for as long as the flag stays set. Each reader keeps its own flag, so
+// the overflow poller and ReadBatch don't share state. An error is only returned when session
+// closed or ctx ended, which says nothing about sum read support.
+func (g *adsCommInput) readRawValues(ctx context.Context, session *adsLib.Session, symbols []plcSymbol, sumUnsupported *bool) (map[string]string, map[string][]byte, error) {
+	if !*sumUnsupported {
+		values, raw, err := g.readRawSymbols(ctx, session, symbols)
+		if err == nil || session.IsClosed() || ctx.Err() != nil {
+			return values, raw, err
+		}
+		g.log.Warnf("Sum read of %d direct addresses failed, reading them one by one: %v", len(symbols), err)
+		*sumUnsupported = true
+	}
+
+	values := make(map[string]string, len(symbols))
+	raw := make(map[string][]byte, len(symbols))
+	for _, sym := range symbols {
+		data, err := session.Read(ctx, sym.address.indexGroup, sym.address.indexOffset, sym.address.length)
+		if err != nil {
+			if session.IsClosed() || ctx.Err() != nil {
+				return nil, nil, err
+			}
+			g.log.Errorf("Direct read of %s failed: %v", sym.name, err)
+			continue
+		}
+		val, decErr := sym.address.decode(data)
+		if decErr != nil {
+			g.log.Errorf("Decoding %s failed: %v", sym.name, decErr)
+			continue
+		}
+		values[sym.name] = val
+		raw[sym.name] = data
+	}
+	return values, raw, nil
+}
+
+// readRawSymbol reads a single directly addressed symbol, used when the PLC does not support sum reads.
+func (g *adsCommInput) readRawSymbol(ctx context.Context, sym plcSymbol) (string, error) {
+	data, err := g.handler.Read(ctx, sym.address.indexGroup, sym.address.indexOffset, sym.address.length)
+	if err != nil {
+		return "", err
+	}
+	return sym.address.decode(data)
+}
+
+// splitRawSymbols separates symbols resolved by name from directly addressed ones.
+func splitRawSymbols(symbols []plcSymbol) (named, raw []plcSymbol) {
+	for _, sym := range symbols {
+		if sym.address != nil {
+			raw = append(raw, sym)
+		} else {
+			named = append(named, sym)
+		}
+	}
+	return named, raw
+}
+
+// addRawNotifications subscribes directly addressed symbols by index group and offset.
+// Decoded samples are delivered on notificationChan like regular symbol updates; rejected
+// addresses are logged with logf.
+func (g *adsCommInput) addRawNotifications(ctx context.Context, session *adsLib.Session, symbols []plcSymbol, logf func(string, ...any)) ([]string, []uint32) {
+	var registered []string
+	var handles []uint32
+	done := g.done
+	for _, sym := range symbols {
+		sym := sym
+		handle, err := session.AddDeviceNotification(ctx, adsLib.DeviceNotificationConfig{
+			IndexGroup:       sym.address.indexGroup,
+			IndexOffset:      sym.address.indexOffset,
+			Length:           sym.address.length,
+			MaxDelay:         sym.maxDelay,
+			CycleTime:        sym.cycleTime,
+			TransmissionMode: g.transmissionMode,
+		}, func(data []byte, timestamp time.Time) {
+			val, decErr := sym.address.decode(data)
+			if decErr != nil {
+				g.log.Errorf("Decoding %s failed: %v", sym.name, decErr)
+				return
+			}
+			update := &adsLib.Update{Variable: sym.name, Value: val}
+			g.capture.hold(update, data, timestamp)
+			select {
+			case g.notificationChan <- update:
+			case <-done:
+				g.capture.take(update)
+			}
+		})
+		if err != nil {
+			logf("Notification for direct address %q rejected: %v", sym.name, err)
+			continue
+		}
+		registered = append(registered, sym.name)
+		handles = append(handles, handle)
+	}
+	return registered, handles
+}
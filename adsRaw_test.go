@@ -0,0 +1,245 @@
+package benthosADS
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseRawAddress(t *testing.T) {
+	tests := []struct {
+		entry    string
+		group    uint32
+		offset   uint32
+		length   uint32
+		dataType string
+		bit      int
+	}{
+		{"%MD100", indexGroupMemoryByte, 100, 4, "DWORD", -1},
+		{"%MB7", indexGroupMemoryByte, 7, 1, "BYTE", -1},
+		{"%MW2", indexGroupMemoryByte, 2, 2, "WORD", -1},
+		{"%ML8", indexGroupMemoryByte, 8, 8, "LWORD", -1},
+		{"%IW4", indexGroupInputByte, 4, 2, "WORD", -1},
+		{"%QB2", indexGroupOutputByte, 2, 1, "BYTE", -1},
+		{"%MX10.3", indexGroupMemoryByte, 10, 1, "BOOL", 3},
+		{"%ix0.7", indexGroupInputByte, 0, 1, "BOOL", 7},
+		{"%MD100,type=REAL", indexGroupMemoryByte, 100, 4, "REAL", -1},
+		{"%MD100, type=dint", indexGroupMemoryByte, 100, 4, "DINT", -1},
+		{"ig=0x4020,io=100,len=4,type=DINT", 0x4020, 100, 4, "DINT", -1},
+		{"ig=0xF030,io=4,type=REAL", 0xF030, 4, 4, "REAL", -1},
+		{"IG=16416,IO=0x10,type=LREAL", 0x4020, 16, 8, "LREAL", -1},
+		{"ig=0x4020,io=200,type=STRING(20)", 0x4020, 200, 21, "STRING(20)", -1},
+		{"ig=0x4020,io=0,type=STRING", 0x4020, 0, 81, "STRING", -1},
+		{"ig=0x4020,io=0,len=8,type=DINT", 0x4020, 0, 8, "DINT", -1},
+	}
+	for _, tt := range tests {
+		addr, err := parseRawAddress(tt.entry)
+		if err != nil {
+			t.Errorf("parseRawAddress(%q): %v", tt.entry, err)
+			continue
+		}
+		if addr.indexGroup != tt.group || addr.indexOffset != tt.offset || addr.length != tt.length ||
+			addr.dataType != tt.dataType || addr.bit != tt.bit {
+			t.Errorf("parseRawAddress(%q) = group 0x%X offset %d len %d type %s bit %d, want 0x%X %d %d %s %d",
+				tt.entry, addr.indexGroup, addr.indexOffset, addr.length, addr.dataType, addr.bit,
+				tt.group, tt.offset, tt.length, tt.dataType, tt.bit)
+		}
+	}
+}
+
+func TestParseRawAddressErrors(t *testing.T) {
+	tests := []struct {
+		entry string
+		want  string
+	}{
+		{"%M", "not a valid IEC address"},
+		{"%ZD4", "area must be M, I or Q"},
+		{"%MY4", "size must be X, B, W, D or L"},
+		{"%MX10", "bit addresses need the form"},
+		{"%MX10.8", "bit must be 0–7"},
+		{"%MDabc", "invalid byte offset"},
+		{"ig=0x4020,type=DINT", "both ig and io are required"},
+		{"io=4,type=DINT", "both ig and io are required"},
+		{"ig=0x4020,io=4", "type is required"},
+		{"ig=0x4020,io=4,type=FOO", "unsupported type"},
+		{"ig=0x4020,io=4,len=2,type=DINT", "smaller than DINT"},
+		{"ig=0x4020,io=4,len=0,type=DINT", "invalid length"},
+		{"ig=zz,io=4,type=DINT", "invalid index group"},
+		{"ig=0x4020,io=-1,type=DINT", "invalid index offset"},
+		{"ig=0x4020,io=4,type=DINT,foo=1", "unknown key"},
+		{"ig=0x4020,io=4,DINT", "expected key=value"},
+	}
+	for _, tt := range tests {
+		_, err := parseRawAddress(tt.entry)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseRawAddress(%q) error = %v, want it to contain %q", tt.entry, err, tt.want)
+		}
+	}
+}
+
+func TestIsRawAddress(t *testing.T) {
+	for entry, want := range map[string]bool{
+		"%MD100":           true,
+		"ig=0x4020,io=0":   true,
+		"IG=0x4020,io=0":   true,
+		"MAIN.counter":     false,
+		".globalCounter":   false,
+		"MAIN.ig=notraw":   false,
+		"GVL.arr[2].value": false,
+	} {
+		if got := isRawAddress(entry); got != want {
+			t.Errorf("isRawAddress(%q) = %v, want %v", entry, got, want)
+		}
+	}
+}
+
+func TestDecodeRawValue(t *testing.T) {
+	tests := []struct {
+		dataType string
+		data     []byte
+		want     string
+	}{
+		{"BOOL", []byte{0}, "false"},
+		{"BOOL", []byte{1}, "true"},
+		{"BYTE", []byte{0xFF}, "255"},
+		{"USINT", []byte{7}, "7"},
+		{"SINT", []byte{0xFE}, "-2"},
+		{"WORD", []byte{0x34, 0x12}, "4660"},
+		{"UINT", []byte{0xFF, 0xFF}, "65535"},
+		{"INT", []byte{0xFF, 0xFF}, "-1"},
+		{"DWORD", []byte{0x78, 0x56, 0x34, 0x12}, "305419896"},
+		{"UDINT", []byte{0xFF, 0xFF, 0xFF, 0xFF}, "4294967295"},
+		{"DINT", []byte{0x9C, 0xFF, 0xFF, 0xFF}, "-100"},
+		{"REAL", []byte{0x00, 0x00, 0xC0, 0x3F}, "1.5"},
+		{"LREAL", []byte{0, 0, 0, 0, 0, 0, 0x04, 0xC0}, "-2.5"},
+		{"LWORD", []byte{1, 0, 0, 0, 0, 0, 0, 0x80}, "9223372036854775809"},
+		{"ULINT", []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, "18446744073709551615"},
+		{"LINT", []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, "-1"},
+		{"TIME", []byte{0xDC, 0x05, 0, 0}, "1.5s"},
+		{"TOD", []byte{0x10, 0x27, 0, 0}, "10s"},
+		{"DATE", []byte{0x80, 0x51, 0x01, 0x00}, "86400"},
+		{"STRING(10)", []byte("hello\x00xxxxx"), "hello"},
+		{"STRING", []byte("no terminator"), "no terminator"},
+		{"DWORD", []byte{1, 0, 0, 0, 0xFF}, "1"}, // longer reads decode the leading bytes
+	}
+	for _, tt := range tests {
+		got, err := decodeRawValue(tt.dataType, tt.data)
+		if err != nil {
+			t.Errorf("decodeRawValue(%s, %x): %v", tt.dataType, tt.data, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("decodeRawValue(%s, %x) = %q, want %q", tt.dataType, tt.data, got, tt.want)
+		}
+	}
+
+	if _, err := decodeRawValue("DINT", []byte{1, 2}); err == nil || !strings.Contains(err.Error(), "short read") {
+		t.Errorf("short DINT: error = %v, want short read", err)
+	}
+	if _, err := decodeRawValue("FOO", []byte{1}); err == nil {
+		t.Error("unknown type: expected an error")
+	}
+}
+
+func TestRawAddressDecodeBit(t *testing.T) {
+	addr, err := parseRawAddress("%MX10.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for data, want := range map[byte]string{0x08: "true", 0xF7: "false", 0xFF: "true", 0x00: "false"} {
+		got, err := addr.decode([]byte{data})
+		if err != nil || got != want {
+			t.Errorf("decode(%08b) = %q, %v; want %q", data, got, err, want)
+		}
+	}
+	if _, err := addr.decode(nil); err == nil {
+		t.Error("decode of an empty read: expected an error")
+	}
+
+	addr, err = parseRawAddress("%MD100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := addr.decode([]byte{0x2A, 0, 0, 0}); err != nil || got != "42" {
+		t.Errorf("%%MD100 decode = %q, %v; want 42", got, err)
+	}
+}
+
+func TestEncodeRawValue(t *testing.T) {
+	tests := []struct {
+		dataType string
+		value    string
+		want     []byte
+	}{
+		{"BOOL", "true", []byte{1}},
+		{"BOOL", "0", []byte{0}},
+		{"BYTE", "255", []byte{0xFF}},
+		{"SINT", "-2", []byte{0xFE}},
+		{"WORD", "4660", []byte{0x34, 0x12}},
+		{"INT", "-1", []byte{0xFF, 0xFF}},
+		{"DWORD", " 305419896 ", []byte{0x78, 0x56, 0x34, 0x12}},
+		{"DINT", "-100", []byte{0x9C, 0xFF, 0xFF, 0xFF}},
+		{"REAL", "1.5", []byte{0x00, 0x00, 0xC0, 0x3F}},
+		{"LREAL", "-2.5", []byte{0, 0, 0, 0, 0, 0, 0x04, 0xC0}},
+		{"LINT", "-1", []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{"TIME", "1.5s", []byte{0xDC, 0x05, 0, 0}},
+		{"TIME", "1500", []byte{0xDC, 0x05, 0, 0}},
+		{"STRING(5)", "abc", []byte{'a', 'b', 'c', 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		got, err := encodeRawValue(tt.dataType, tt.value)
+		if err != nil {
+			t.Errorf("encodeRawValue(%s, %q): %v", tt.dataType, tt.value, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("encodeRawValue(%s, %q) = %x, want %x", tt.dataType, tt.value, got, tt.want)
+		}
+	}
+
+	for _, bad := range []struct{ dataType, value string }{
+		{"BYTE", "256"},
+		{"SINT", "128"},
+		{"UINT", "-1"},
+		{"DINT", "1.5"},
+		{"BOOL", "maybe"},
+		{"TIME", "soon"},
+		{"REAL", "x"},
+		{"STRING(3)", "abcd"},
+		{"FOO", "1"},
+	} {
+		if _, err := encodeRawValue(bad.dataType, bad.value); err == nil {
+			t.Errorf("encodeRawValue(%s, %q): expected an error", bad.dataType, bad.value)
+		}
+	}
+}
+
+func TestRawValueRoundTrip(t *testing.T) {
+	for dataType, values := range map[string][]string{
+		"BOOL":       {"true", "false"},
+		"USINT":      {"0", "255"},
+		"SINT":       {"-128", "127"},
+		"UINT":       {"65535"},
+		"INT":        {"-32768", "32767"},
+		"UDINT":      {"4294967295"},
+		"DINT":       {"-2147483648", "2147483647"},
+		"ULINT":      {"18446744073709551615"},
+		"LINT":       {"-9223372036854775808"},
+		"REAL":       {"3.25", "-0.5"},
+		"LREAL":      {"3.141592653589793"},
+		"TIME":       {"1m30s", "250ms"},
+		"STRING(20)": {"", "Hello, PLC"},
+	} {
+		for _, v := range values {
+			data, err := encodeRawValue(dataType, v)
+			if err != nil {
+				t.Errorf("encodeRawValue(%s, %q): %v", dataType, v, err)
+				continue
+			}
+			got, err := decodeRawValue(dataType, data)
+			if err != nil || got != v {
+				t.Errorf("%s round trip of %q = %q, %v", dataType, v, got, err)
+			}
+		}
+	}
+}
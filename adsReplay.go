@@ -0,0 +1,178 @@
+package benthosADS
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+var adsReplayConf = service.NewConfigSpec().
+	Summary("Replays a capture recorded by the ads input's capture option.").
+	Description("Emits the recorded batches with the same content and metadata as the ads input produced them, paced by " +
+		"the recorded receive times. Use it to develop pipelines or reproduce incidents without the PLC.").
+	Field(service.NewStringField("path").Description("Capture file to replay.")).
+	Field(service.NewFloatField("speed").Description("Replay speed relative to the recording: 1 keeps the original timing, 10 replays ten times faster, 0 emits the batches without waiting.").Default(1.0)).
+	Field(service.NewBoolField("loop").Description("Start over at the end of the capture instead of ending the input.").Default(false))
+
+func init() {
+	err := service.RegisterBatchInput(
+		"ads_replay", adsReplayConf,
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
+			return newAdsReplay(conf, mgr)
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// adsReplay emits the batches of a capture file.
+type adsReplay struct {
+	path  string
+	speed float64
+	loop  bool
+	log   *service.Logger
+
+	reader   *captureReader
+	pending  *captureRecord // first record of the next batch
+	run      int            // capture run being replayed; each run is paced on its own
+	first    int64          // recorded time of the run's first batch
+	firstPLC int64          // PLC sample time of the run's first batch, 0 when it has none
+	started  time.Time      // wall clock time the run's first batch was emitted
+	done     chan struct{}
+}
+
+func newAdsReplay(conf *service.ParsedConfig, mgr *service.Resources) (*adsReplay, error) {
+	path, err := conf.FieldString("path")
+	if err != nil {
+		return nil, err
+	}
+	speed, err := conf.FieldFloat("speed")
+	if err != nil {
+		return nil, err
+	}
+	if speed < 0 {
+		return nil, fmt.Errorf("speed %v must not be negative", speed)
+	}
+	loop, err := conf.FieldBool("loop")
+	if err != nil {
+		return nil, err
+	}
+	return &adsReplay{path: path, speed: speed, loop: loop, log: mgr.Logger(), done: make(chan struct{})}, nil
+}
+
+func (r *adsReplay) Connect(ctx context.Context) error {
+	if r.reader != nil {
+		return nil
+	}
+	reader, err := openCaptureReader(r.path)
+	if err != nil {
+		return err
+	}
+	r.reader = reader
+	r.pending = nil
+	r.first, r.firstPLC = 0, 0
+	r.log.Infof("Replaying capture %s at speed %v", r.path, r.speed)
+	return nil
+}
+
+// readBatch returns the records of the next batch.
+func (r *adsReplay) readBatch() ([]*captureRecord, error) {
+	if r.pending == nil {
+		rec, err := r.reader.next()
+		if err != nil {
+			return nil, err
+		}
+		r.pending = rec
+	}
+	batch := []*captureRecord{r.pending}
+	r.pending = nil
+	for {
+		rec, err := r.reader.next()
+		if errors.Is(err, io.EOF) {
+			return batch, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rec.run != batch[0].run || rec.Batch != batch[0].Batch {
+			r.pending = rec
+			return batch, nil
+		}
+		batch = append(batch, rec)
+	}
+}
+
+func (r *adsReplay) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	if r.reader == nil {
+		return nil, nil, service.ErrNotConnected
+	}
+	records, err := r.readBatch()
+	if errors.Is(err, io.EOF) {
+		if r.reader.damaged > 0 {
+			r.log.Warnf("Capture %s has %d damaged sections, replayed the records around them", r.path, r.reader.damaged)
+		}
+		_ = r.reader.close()
+		r.reader = nil
+		if !r.loop {
+			r.log.Infof("Replay of %s finished", r.path)
+			return nil, nil, service.ErrEndOfInput
+		}
+		if err = r.Connect(ctx); err != nil {
+			return nil, nil, err
+		}
+		if records, err = r.readBatch(); errors.Is(err, io.EOF) {
+			return nil, nil, service.ErrEndOfInput // nothing to loop over
+		}
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading capture: %w", err)
+	}
+
+	// A new run starts after a restart of the recording pipeline; replaying the downtime
+	// between runs would only stall the replay.
+	if r.first == 0 || records[0].run != r.run {
+		r.run, r.first, r.firstPLC, r.started = records[0].run, records[0].Time, records[0].PLCTime, time.Now()
+	} else if r.speed > 0 {
+		// PLC sample times pace the batches as the PLC produced them, without network and
+		// scheduling jitter; batches without them fall back to the receive times.
+		offset := records[0].Time - r.first
+		if records[0].PLCTime != 0 && r.firstPLC != 0 {
+			offset = records[0].PLCTime - r.firstPLC
+		}
+		due := r.started.Add(time.Duration(float64(offset) / r.speed))
+		select {
+		case <-time.After(time.Until(due)):
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-r.done:
+			return nil, nil, service.ErrEndOfInput
+		}
+	}
+
+	batch := make(service.MessageBatch, len(records))
+	for i, rec := range records {
+		msg := service.NewMessage(rec.value())
+		for k, v := range rec.Meta {
+			msg.MetaSet(k, v)
+		}
+		batch[i] = msg
+	}
+	return batch, func(context.Context, error) error { return nil }, nil
+}
+
+func (r *adsReplay) Close(ctx context.Context) error {
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+	if r.reader != nil {
+		_ = r.reader.close()
+		r.reader = nil
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package benthosADS
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// The UDP service TwinCAT routers answer on port 48899, used for discovery and route management.
+const (
+	adsUDPPort  = 48899
+	adsUDPMagic = 0x71146603
+
+	adsUDPServiceInfo     uint32 = 1
+	adsUDPServiceAddRoute uint32 = 6
+	adsUDPResponse        uint32 = 0x80000000
+
+	adsUDPTagStatus    uint16 = 1
+	adsUDPTagPassword  uint16 = 2
+	adsUDPTagHost      uint16 = 5
+	adsUDPTagNetID     uint16 = 7
+	adsUDPTagRouteName uint16 = 12
+	adsUDPTagUsername  uint16 = 13
+)
+
+// adsUDPTag is one tag of a UDP service request or response.
+type adsUDPTag struct {
+	id   uint16
+	data []byte
+}
+
+func udpStringTag(id uint16, s string) adsUDPTag {
+	return adsUDPTag{id: id, data: append([]byte(s), 0)}
+}
+
+// marshalUDPRequest builds a UDP service request sent from the given AMS net ID.
+func marshalUDPRequest(service, invokeID uint32, sender amsNetID, tags []adsUDPTag) []byte {
+	le := binary.LittleEndian
+	buf := le.AppendUint32(nil, adsUDPMagic)
+	buf = le.AppendUint32(buf, invokeID)
+	buf = le.AppendUint32(buf, service)
+	buf = append(buf, sender[:]...)
+	buf = le.AppendUint16(buf, 10000)
+	buf = le.AppendUint32(buf, uint32(len(tags)))
+	for _, t := range tags {
+		buf = le.AppendUint16(buf, t.id)
+		buf = le.AppendUint16(buf, uint16(len(t.data)))
+		buf = append(buf, t.data...)
+	}
+	return buf
+}
+
+// adsUDPReply is a decoded UDP service response.
+type adsUDPReply struct {
+	service uint32
+	netID   amsNetID
+	tags    map[uint16][]byte
+}
+
+func parseUDPReply(data []byte) (*adsUDPReply, error) {
+	le := binary.LittleEndian
+	if len(data) < 24 || le.Uint32(data[0:]) != adsUDPMagic {
+		return nil, errors.New("not an ADS UDP response")
+	}
+	r := &adsUDPReply{service: le.Uint32(data[8:]), tags: map[uint16][]byte{}}
+	copy(r.netID[:], data[12:18])
+	count := le.Uint32(data[20:])
+	pos := 24
+	for i := uint32(0); i < count && pos+4 <= len(data); i++ {
+		id, n := le.Uint16(data[pos:]), int(le.Uint16(data[pos+2:]))
+		pos += 4
+		if pos+n > len(data) {
+			return nil, errors.New("truncated ADS UDP response")
+		}
+		r.tags[id] = data[pos : pos+n]
+		pos += n
+	}
+	return r, nil
+}
+
+// udpRequest sends one UDP service request to host and waits for the matching response.
+func udpRequest(ctx context.Context, host string, req []byte, service uint32, timeout time.Duration) (*adsUDPReply, error) {
+	conn, err := net.Dial("udp", net.JoinHostPort(host, strconv.Itoa(adsUDPPort)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+	if _, err = conn.Write(req); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 2048)
+	for {
+		n, rerr := conn.Read(buf)
+		if rerr != nil {
+			return nil, fmt.Errorf("no response from %s:%d: %w", host, adsUDPPort, rerr)
+		}
+		reply, perr := parseUDPReply(buf[:n])
+		if perr == nil && reply.service == service|adsUDPResponse {
+			return reply, nil
+		}
+	}
+}
+
+// status returns the status code of a UDP service response, 0 meaning success.
+func (r *adsUDPReply) status() uint32 {
+	if s, ok := r.tags[adsUDPTagStatus]; ok && len(s) >= 4 {
+		return binary.LittleEndian.Uint32(s)
+	}
+	return 0
+}
+
+// addRoute registers a static route for netID at hostAddr on the PLC at host, using the
+// PLC's administrator credentials.
+func addRoute(ctx context.Context, host, routeName string, netID amsNetID, hostAddr, username, password string) error {
+	req := marshalUDPRequest(adsUDPServiceAddRoute, 0, netID, []adsUDPTag{
+		udpStringTag(adsUDPTagRouteName, routeName),
+		{id: adsUDPTagNetID, data: netID[:]},
+		udpStringTag(adsUDPTagUsername, username),
+		udpStringTag(adsUDPTagPassword, password),
+		udpStringTag(adsUDPTagHost, hostAddr),
+	})
+	reply, err := udpRequest(ctx, host, req, adsUDPServiceAddRoute, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("adding route: %w", err)
+	}
+	if status := reply.status(); status != 0 {
+		return fmt.Errorf("adding route: PLC returned error 0x%X (check the route credentials)", status)
+	}
+	return nil
+}
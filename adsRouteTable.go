@@ -0,0 +1,293 @@
+package benthosADS
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// The TwinCAT system service manages the router's route table.
+const (
+	adsSystemServicePort uint16 = 10000
+
+	adsSysServiceDelRemote  uint32 = 0x322
+	adsSysServiceEnumRemote uint32 = 0x323
+
+	// adsErrDeviceNotFound ends the route enumeration.
+	adsErrDeviceNotFound uint32 = 0x70C
+
+	adsRouteEntryMaxLen = 0x800
+)
+
+// Route lifetimes. Temporary routes are removed when the last component using them closes.
+const (
+	routeLifetimePermanent = "permanent"
+	routeLifetimeTemporary = "temporary"
+)
+
+// routeEntry is one route in a PLC's route table.
+type routeEntry struct {
+	netID   amsNetID
+	name    string
+	address string
+}
+
+// parseRouteEntry decodes a route table entry. The entry starts with the route's net ID; its
+// name and address follow as NUL-terminated strings after a header whose layout differs
+// between TwinCAT versions, so the strings are located by scanning.
+func parseRouteEntry(data []byte) (routeEntry, error) {
+	var e routeEntry
+	if len(data) < 6 {
+		return e, errors.New("route entry too short")
+	}
+	copy(e.netID[:], data[:6])
+	var strs []string
+	start := -1
+	for i := 6; i < len(data); i++ {
+		b := data[i]
+		switch {
+		case b >= 0x20 && b < 0x7f:
+			if start < 0 {
+				start = i
+			}
+		case b == 0 && start >= 0 && i-start >= 2:
+			strs = append(strs, string(data[start:i]))
+			start = -1
+		default:
+			start = -1
+		}
+	}
+	if len(strs) > 0 {
+		e.address = strs[0]
+	}
+	if len(strs) > 1 {
+		e.name = strs[1]
+	}
+	return e, nil
+}
+
+// amsClient is a minimal ADS client for services the session can't address, such as the
+// system service on port 10000. Requests are sent one at a time.
+type amsClient struct {
+	amsConn
+	local    amsAddr
+	target   amsNetID
+	invokeID uint32
+	timeout  time.Duration
+}
+
+// request sends one ADS command to port and returns the response data after the result code.
+func (c *amsClient) request(command, port uint16, data []byte) ([]byte, error) {
+	c.invokeID++
+	req := &amsPacket{
+		target:     amsAddr{netID: c.target, port: port},
+		source:     c.local,
+		command:    command,
+		stateFlags: amsFlagADSCommand,
+		invokeID:   c.invokeID,
+		data:       data,
+	}
+	_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if err := c.send(req); err != nil {
+		return nil, err
+	}
+	for {
+		p, err := readAMSPacket(c.conn)
+		if err != nil {
+			return nil, err
+		}
+		if p.tcpCommand != amsTCPCommand || !p.isResponse() || p.invokeID != c.invokeID {
+			continue
+		}
+		if p.errorCode != 0 {
+			return nil, fmt.Errorf("AMS error 0x%X", p.errorCode)
+		}
+		if len(p.data) < 4 {
+			return nil, errors.New("ADS response too short")
+		}
+		if code := binary.LittleEndian.Uint32(p.data); code != 0 {
+			return nil, adsResultError(code)
+		}
+		return p.data[4:], nil
+	}
+}
+
+// adsResultError is a non-zero ADS result code.
+type adsResultError uint32
+
+func (e adsResultError) Error() string {
+	return fmt.Sprintf("ADS error 0x%X", uint32(e))
+}
+
+// readRoutes returns the PLC's route table.
+func (c *amsClient) readRoutes() ([]routeEntry, error) {
+	var routes []routeEntry
+	for i := uint32(0); ; i++ {
+		req := binary.LittleEndian.AppendUint32(nil, adsSysServiceEnumRemote)
+		req = binary.LittleEndian.AppendUint32(req, i)
+		req = binary.LittleEndian.AppendUint32(req, adsRouteEntryMaxLen)
+		resp, err := c.request(adsCmdRead, adsSystemServicePort, req)
+		var code adsResultError
+		if errors.As(err, &code) && uint32(code) == adsErrDeviceNotFound {
+			return routes, nil
+		}
+		if err != nil {
+			return routes, fmt.Errorf("reading route %d: %w", i, err)
+		}
+		if len(resp) < 4 {
+			return routes, errors.New("route response too short")
+		}
+		n := binary.LittleEndian.Uint32(resp)
+		if int(n) > len(resp)-4 {
+			return routes, errors.New("route response truncated")
+		}
+		e, err := parseRouteEntry(resp[4 : 4+n])
+		if err != nil {
+			return routes, err
+		}
+		routes = append(routes, e)
+	}
+}
+
+// deleteRoute removes the route with the given name.
+func (c *amsClient) deleteRoute(name string) error {
+	req := binary.LittleEndian.AppendUint32(nil, adsSysServiceDelRemote)
+	req = binary.LittleEndian.AppendUint32(req, 0)
+	req = binary.LittleEndian.AppendUint32(req, uint32(len(name)+1))
+	req = append(append(req, name...), 0)
+	_, err := c.request(adsCmdWrite, adsSystemServicePort, req)
+	return err
+}
+
+// dialSystemService opens a short-lived connection to the PLC's router as local, used for
+// route management next to the session.
+func (c *adsConnConfig) dialSystemService(ctx context.Context, targetIP string, local amsNetID) (*amsClient, error) {
+	target, err := parseAMSNetID(c.targetAMS)
+	if err != nil {
+		return nil, err
+	}
+	d := &net.Dialer{Timeout: 5 * time.Second}
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(targetIP, strconv.Itoa(c.targetPort)))
+	if err != nil {
+		return nil, err
+	}
+	timeout := c.requestTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &amsClient{
+		amsConn: amsConn{conn: conn},
+		local:   amsAddr{netID: local, port: uint16(c.hostPort)},
+		target:  target,
+		timeout: timeout,
+	}, nil
+}
+
+// manageRoutes runs after the route was registered: it removes older routes with the same name
+// but another net ID, so a changed client IP replaces its route instead of adding one, and logs
+// the route table when listRoutes is set. Routes are deleted by name, so register calls the
+// route registration again afterwards in case the PLC removed both.
+func (c *adsConnConfig) manageRoutes(ctx context.Context, targetIP, routeName string, local amsNetID, register func() error, log *service.Logger) error {
+	client, err := c.dialSystemService(ctx, targetIP, local)
+	if err != nil {
+		return err
+	}
+	defer client.conn.Close()
+
+	routes, err := client.readRoutes()
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for _, r := range routes {
+		switch {
+		case r.name == routeName && r.netID != local:
+			if err = client.deleteRoute(r.name); err != nil {
+				log.Warnf("Failed to remove outdated route %s (%s, %s) from %s: %v", r.name, r.netID, r.address, targetIP, err)
+				continue
+			}
+			replaced = true
+			log.Infof("Replaced outdated route %s (%s, %s) on %s", r.name, r.netID, r.address, targetIP)
+		case c.listRoutes && strings.HasPrefix(r.name, "benthosADS-") && r.name != routeName:
+			log.Warnf("Route %s (%s, %s) on %s looks like a leftover of an earlier benthosADS client", r.name, r.netID, r.address, targetIP)
+		}
+	}
+	if c.listRoutes {
+		for _, r := range routes {
+			log.Infof("Route on %s: name=%s, netID=%s, address=%s", targetIP, r.name, r.netID, r.address)
+		}
+	}
+	if replaced {
+		return register()
+	}
+	return nil
+}
+
+// temporaryRoutes counts the components using each temporary route. Every component of the
+// process uses the same route to a PLC, so it is only removed when the last one closes.
+var temporaryRoutes = struct {
+	mu   sync.Mutex
+	refs map[string]int
+}{refs: map[string]int{}}
+
+// heldRoute is a temporary route this component registered.
+type heldRoute struct {
+	key      string
+	targetIP string
+	name     string
+	local    amsNetID
+}
+
+// holdRoute records that this component uses a temporary route. Reconnects reuse the hold.
+func (c *adsConnConfig) holdRoute(targetIP, name string, local amsNetID) {
+	if c.routeLifetime != routeLifetimeTemporary || c.heldRoute != nil {
+		return
+	}
+	key := targetIP + "|" + name
+	temporaryRoutes.mu.Lock()
+	temporaryRoutes.refs[key]++
+	temporaryRoutes.mu.Unlock()
+	c.heldRoute = &heldRoute{key: key, targetIP: targetIP, name: name, local: local}
+}
+
+// releaseRoute drops this component's temporary route and removes it from the PLC when no
+// other component uses it.
+func (c *adsConnConfig) releaseRoute(ctx context.Context, log *service.Logger) {
+	h := c.heldRoute
+	if h == nil {
+		return
+	}
+	c.heldRoute = nil
+	temporaryRoutes.mu.Lock()
+	temporaryRoutes.refs[h.key]--
+	last := temporaryRoutes.refs[h.key] <= 0
+	if last {
+		delete(temporaryRoutes.refs, h.key)
+	}
+	temporaryRoutes.mu.Unlock()
+	if !last {
+		return
+	}
+
+	client, err := c.dialSystemService(ctx, h.targetIP, h.local)
+	if err != nil {
+		log.Warnf("Failed to remove temporary route %s from %s: %v", h.name, h.targetIP, err)
+		return
+	}
+	defer client.conn.Close()
+	// The PLC may drop the connection as soon as the route is gone, before answering.
+	if err = client.deleteRoute(h.name); err != nil && !errors.Is(err, io.EOF) {
+		log.Warnf("Failed to remove temporary route %s from %s: %v", h.name, h.targetIP, err)
+		return
+	}
+	log.Infof("Removed temporary route %s from %s", h.name, h.targetIP)
+}
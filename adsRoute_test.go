@@ -0,0 +1,84 @@
+package benthosADS
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/RuneRoven/benthosADS/adstest"
+)
+
+func TestUDPRequestLayout(t *testing.T) {
+	sender := amsNetID{10, 0, 0, 9, 1, 1}
+	got := marshalUDPRequest(adsUDPServiceAddRoute, 3, sender, []adsUDPTag{
+		udpStringTag(adsUDPTagRouteName, "r"),
+		{id: adsUDPTagNetID, data: sender[:]},
+	})
+	want := []byte{
+		0x03, 0x66, 0x14, 0x71, // magic
+		3, 0, 0, 0, // invoke ID
+		6, 0, 0, 0, // service
+		10, 0, 0, 9, 1, 1, 0x10, 0x27, // sender net ID, port 10000
+		2, 0, 0, 0, // tag count
+		12, 0, 2, 0, 'r', 0,
+		7, 0, 6, 0, 10, 0, 0, 9, 1, 1,
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("marshalUDPRequest = % x\nwant                % x", got, want)
+	}
+}
+
+func TestParseUDPReply(t *testing.T) {
+	plc := amsNetID{5, 80, 201, 232, 1, 1}
+	data := marshalUDPRequest(adsUDPServiceAddRoute|adsUDPResponse, 0, plc, []adsUDPTag{
+		{id: adsUDPTagStatus, data: binary.LittleEndian.AppendUint32(nil, 0x704)},
+		udpStringTag(adsUDPTagHost, "plc1"),
+	})
+	reply, err := parseUDPReply(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.service != adsUDPServiceAddRoute|adsUDPResponse || reply.netID != plc || reply.status() != 0x704 ||
+		string(reply.tags[adsUDPTagHost]) != "plc1\x00" {
+		t.Errorf("reply = %+v", reply)
+	}
+	if (&adsUDPReply{tags: map[uint16][]byte{}}).status() != 0 {
+		t.Error("reply without status tag: want status 0")
+	}
+
+	if _, err = parseUDPReply(data[:20]); err == nil {
+		t.Error("short reply: expected an error")
+	}
+	bad := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(bad, 0)
+	if _, err = parseUDPReply(bad); err == nil {
+		t.Error("wrong magic: expected an error")
+	}
+	if _, err = parseUDPReply(data[:len(data)-2]); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("truncated tag: error = %v", err)
+	}
+}
+
+func TestAddRoute(t *testing.T) {
+	srv, err := adstest.NewServer(adstest.Config{UDPAddr: "127.0.0.1:48899", RouteUsername: "Administrator", RoutePassword: "1"})
+	if err != nil {
+		t.Skipf("UDP route service port unavailable: %v", err)
+	}
+	defer srv.Close()
+	host := amsNetID{10, 0, 0, 9, 1, 1}
+	ctx := context.Background()
+
+	if err = addRoute(ctx, "127.0.0.1", "benthos", host, "10.0.0.9", "Administrator", "wrong"); err == nil ||
+		!strings.Contains(err.Error(), "0x704") {
+		t.Errorf("wrong password: error = %v, want PLC error 0x704", err)
+	}
+	if err = addRoute(ctx, "127.0.0.1", "benthos", host, "10.0.0.9", "Administrator", "1"); err != nil {
+		t.Fatal(err)
+	}
+	routes := srv.Routes()
+	if len(routes) != 1 || routes[0].Name != "benthos" || routes[0].NetID != "10.0.0.9.1.1" || routes[0].Address != "10.0.0.9" {
+		t.Errorf("routes = %+v", routes)
+	}
+}
@@ -0,0 +1,740 @@
+package benthosADS
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// Router ports handed to local clients start here, above the ports TwinCAT uses for its own services.
+const adsRouterFirstPort = 30000
+
+// adsRouterClientQueue is how many packets may wait for a local client. A client that falls
+// this far behind is disconnected, so it never holds up the PLC connection it shares.
+const adsRouterClientQueue = 256
+
+// adsRouterLateAnswer is how long a timed out AddNotification stays pending, so a success the
+// PLC sends after the client got its timeout still deletes the handle nobody owns.
+const adsRouterLateAnswer = time.Minute
+
+var adsRouterConf = service.NewConfigSpec().
+	Summary("Runs an embedded AMS router that holds the only route to each PLC and forwards ADS traffic of local clients.").
+	Description("Other ADS clients, including ads components of other pipelines and third-party tools, connect to listenAddress " +
+		"instead of the PLC and address the PLC by its AMS net ID as usual. The router registers one route per PLC for its own " +
+		"localAMS, forwards requests over a single connection and maps invoke IDs, ports and notification handles back to each " +
+		"client. The input emits a JSON event for every client and PLC connection change.").
+	Field(service.NewStringField("listenAddress").Description("TCP address local ADS clients connect to.").Default("127.0.0.1:48898")).
+	Field(service.NewStringField("localAMS").Description("AMS net ID of the router, registered as the route on every PLC.")).
+	Field(service.NewIntField("requestTimeout").Description("Time in milliseconds a forwarded request may wait for the PLC before the client receives a timeout error.").Default(5000)).
+	Field(service.NewObjectListField("routes", adsRouterRouteFields()...).Description("PLCs reachable through the router."))
+
+// adsRouterRouteFields describes one entry of the ads_router routes list.
+func adsRouterRouteFields() []*service.ConfigField {
+	fields := []*service.ConfigField{
+		service.NewStringField("name").Description("Name of the PLC, used in logs and events."),
+		service.NewStringField("targetIP").Description("IP address or hostname of the PLC."),
+		service.NewStringField("targetAMS").Description("AMS net ID of the PLC. Client requests for this net ID are forwarded to targetIP."),
+		service.NewIntField("targetPort").Description("TCP port of the PLC ADS gateway.").Default(48898),
+		service.NewStringField("routeUsername").Description("Username for UDP route registration on the PLC.").Default(""),
+		service.NewStringField("routePassword").Description("Password for UDP route registration on the PLC.").Default("").Secret(),
+		service.NewStringField("routeHostAddress").Description("The address the PLC should use to reach the router. Auto-detected if empty.").Default(""),
+	}
+	fields = append(fields, credentialSourceFields("routeUsername")...)
+	return append(fields, credentialSourceFields("routePassword")...)
+}
+
+func init() {
+	err := service.RegisterBatchInput(
+		"ads_router", adsRouterConf,
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
+			return newAdsRouter(conf, mgr)
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// adsRouter forwards AMS packets between local clients and PLCs. Each client address gets a
+// router port of its own and every request a router invoke ID, so the PLC sees one client
+// while responses and notifications still reach the client that asked for them.
+type adsRouter struct {
+	listenAddress string
+	localAMS      amsNetID
+	timeout       time.Duration
+	upstreams     map[amsNetID]*routerUpstream
+	log           *service.Logger
+
+	mu         sync.Mutex
+	listener   net.Listener
+	clients    map[*routerClient]struct{}
+	ports      map[uint16]*routerEndpoint
+	nextPort   uint16
+	pending    map[uint32]*routerPending
+	nextInvoke uint32
+
+	events chan []byte
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// routerClient is one TCP connection from a local ADS client. Packets to the client go through
+// out to its writer goroutine; quit is closed when the client is dropped.
+type routerClient struct {
+	amsConn
+	endpoints map[amsAddr]*routerEndpoint
+	out       chan *amsPacket
+	quit      chan struct{}
+}
+
+// routerEndpoint maps a client's AMS address to the router port the PLC sees.
+type routerEndpoint struct {
+	client  *routerClient
+	addr    amsAddr
+	port    uint16
+	handles map[uint32]routerHandle // notification handles owned by this endpoint
+}
+
+// routerHandle records where a notification handle was registered, so it can be deleted
+// when its client disconnects.
+type routerHandle struct {
+	upstream *routerUpstream
+	port     uint16
+}
+
+// routerPending is a forwarded request waiting for the PLC's response. endpoint is nil for
+// requests the router sends itself, whose responses are dropped.
+type routerPending struct {
+	endpoint *routerEndpoint
+	invokeID uint32
+	command  uint16
+	handle   uint32 // notification handle of a DeleteNotification request
+	upstream *routerUpstream
+	port     uint16 // target port of the request
+	deadline time.Time
+	timedOut bool // the client got its timeout; kept for a late AddNotification answer
+}
+
+// routerUpstream is the single connection to one PLC.
+type routerUpstream struct {
+	name string
+	conn adsConnConfig
+
+	mu         sync.Mutex
+	tcp        net.Conn
+	routeAdded bool
+}
+
+func newAdsRouter(conf *service.ParsedConfig, mgr *service.Resources) (*adsRouter, error) {
+	listenAddress, err := conf.FieldString("listenAddress")
+	if err != nil {
+		return nil, err
+	}
+	localAMSStr, err := conf.FieldString("localAMS")
+	if err != nil {
+		return nil, err
+	}
+	localAMS, err := parseAMSNetID(localAMSStr)
+	if err != nil {
+		return nil, fmt.Errorf("localAMS: %w", err)
+	}
+	timeoutMs, err := conf.FieldInt("requestTimeout")
+	if err != nil {
+		return nil, err
+	}
+	if timeoutMs <= 0 {
+		return nil, fmt.Errorf("requestTimeout %d must be positive", timeoutMs)
+	}
+
+	routeConfs, err := conf.FieldObjectList("routes")
+	if err != nil {
+		return nil, err
+	}
+	if len(routeConfs) == 0 {
+		return nil, errors.New("routes must not be empty")
+	}
+	upstreams := make(map[amsNetID]*routerUpstream, len(routeConfs))
+	for i, rc := range routeConfs {
+		up, uerr := parseRouterUpstream(rc)
+		if uerr != nil {
+			return nil, fmt.Errorf("routes[%d]: %w", i, uerr)
+		}
+		netID, _ := parseAMSNetID(up.conn.targetAMS)
+		if _, dup := upstreams[netID]; dup {
+			return nil, fmt.Errorf("routes[%d]: duplicate targetAMS %s", i, up.conn.targetAMS)
+		}
+		upstreams[netID] = up
+	}
+
+	return &adsRouter{
+		listenAddress: listenAddress,
+		localAMS:      localAMS,
+		timeout:       time.Duration(timeoutMs) * time.Millisecond,
+		upstreams:     upstreams,
+		log:           mgr.Logger(),
+		clients:       map[*routerClient]struct{}{},
+		ports:         map[uint16]*routerEndpoint{},
+		nextPort:      adsRouterFirstPort,
+		pending:       map[uint32]*routerPending{},
+		events:        make(chan []byte, 64),
+		done:          make(chan struct{}),
+	}, nil
+}
+
+func parseRouterUpstream(rc *service.ParsedConfig) (*routerUpstream, error) {
+	up := &routerUpstream{}
+	var err error
+	if up.name, err = rc.FieldString("name"); err != nil {
+		return nil, err
+	}
+	c := &up.conn
+	if c.targetIP, err = rc.FieldString("targetIP"); err != nil {
+		return nil, err
+	}
+	if c.targetAMS, err = rc.FieldString("targetAMS"); err != nil {
+		return nil, err
+	}
+	if err = c.validateTarget(); err != nil {
+		return nil, err
+	}
+	if c.targetPort, err = rc.FieldInt("targetPort"); err != nil {
+		return nil, err
+	}
+	if c.routeUsername, _, err = parseCredential(rc, "routeUsername"); err != nil {
+		return nil, err
+	}
+	if c.routePassword, _, err = parseCredential(rc, "routePassword"); err != nil {
+		return nil, err
+	}
+	if c.routeHostAddress, err = rc.FieldString("routeHostAddress"); err != nil {
+		return nil, err
+	}
+	return up, nil
+}
+
+// event queues a JSON event for the pipeline. Events are dropped when the pipeline falls
+// behind so forwarding never waits on downstream outputs.
+func (r *adsRouter) event(kind string, fields map[string]any) {
+	fields["event"] = kind
+	fields["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+	select {
+	case r.events <- data:
+	default:
+		r.log.Debugf("Dropping router event %s, pipeline is not keeping up", kind)
+	}
+}
+
+func (r *adsRouter) Connect(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.listener != nil {
+		return nil
+	}
+	ln, err := net.Listen("tcp", r.listenAddress)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", r.listenAddress, err)
+	}
+	r.listener = ln
+	r.log.Infof("AMS router %s listening on %s for %d PLCs", r.localAMS, ln.Addr(), len(r.upstreams))
+
+	r.wg.Add(2)
+	go r.acceptLoop(ln)
+	go r.expireLoop()
+	return nil
+}
+
+func (r *adsRouter) acceptLoop(ln net.Listener) {
+	defer r.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-r.done:
+			default:
+				r.log.Errorf("AMS router accept failed: %v", err)
+			}
+			return
+		}
+		c := &routerClient{
+			amsConn:   amsConn{conn: conn},
+			endpoints: map[amsAddr]*routerEndpoint{},
+			out:       make(chan *amsPacket, adsRouterClientQueue),
+			quit:      make(chan struct{}),
+		}
+		r.mu.Lock()
+		r.clients[c] = struct{}{}
+		r.mu.Unlock()
+		r.log.Infof("AMS router client connected from %s", conn.RemoteAddr())
+		r.event("client_connected", map[string]any{"client": conn.RemoteAddr().String()})
+
+		r.wg.Add(2)
+		go r.serveClient(c)
+		go r.writeClient(c)
+	}
+}
+
+// sendClient queues p for a local client without blocking. A client whose queue is full is
+// disconnected; its serveClient then drops it like any other disconnect.
+func (r *adsRouter) sendClient(c *routerClient, p *amsPacket) {
+	select {
+	case c.out <- p:
+	case <-c.quit:
+	default:
+		r.log.Warnf("AMS router: client %s is not reading, disconnecting it", c.conn.RemoteAddr())
+		_ = c.conn.Close()
+	}
+}
+
+// writeClient writes queued packets to a local client. A write that takes longer than the
+// request timeout disconnects the client.
+func (r *adsRouter) writeClient(c *routerClient) {
+	defer r.wg.Done()
+	for {
+		select {
+		case p := <-c.out:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(r.timeout))
+			if err := c.send(p); err != nil {
+				_ = c.conn.Close()
+				return
+			}
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// serveClient reads packets from one local client until it disconnects.
+func (r *adsRouter) serveClient(c *routerClient) {
+	defer r.wg.Done()
+	defer r.dropClient(c)
+	for {
+		p, err := readAMSPacket(c.conn)
+		if err != nil {
+			return
+		}
+		switch p.tcpCommand {
+		case amsTCPCommand:
+			r.forward(c, p)
+		case amsTCPPortConnect:
+			// Clients without an AMS net ID of their own ask the router for an address.
+			r.mu.Lock()
+			ep := r.newEndpoint(c, amsAddr{netID: r.localAMS})
+			ep.addr.port = ep.port
+			c.endpoints[ep.addr] = ep
+			r.mu.Unlock()
+			reply := append(append([]byte(nil), r.localAMS[:]...), 0, 0)
+			binary.LittleEndian.PutUint16(reply[6:], ep.port)
+			r.sendClient(c, &amsPacket{tcpCommand: amsTCPPortConnect, data: reply})
+		case amsTCPGetLocalNetID:
+			r.sendClient(c, &amsPacket{tcpCommand: amsTCPGetLocalNetID, data: r.localAMS[:]})
+		case amsTCPPortClose:
+			// Ports are released when the client disconnects.
+		default:
+			r.log.Debugf("AMS router ignoring AMS/TCP command 0x%X from %s", p.tcpCommand, c.conn.RemoteAddr())
+		}
+	}
+}
+
+// newEndpoint allocates a router port for addr; the caller adds it to c.endpoints.
+// Must be called with r.mu held.
+func (r *adsRouter) newEndpoint(c *routerClient, addr amsAddr) *routerEndpoint {
+	for {
+		port := r.nextPort
+		r.nextPort++
+		if r.nextPort < adsRouterFirstPort {
+			r.nextPort = adsRouterFirstPort
+		}
+		if _, used := r.ports[port]; used {
+			continue
+		}
+		ep := &routerEndpoint{client: c, addr: addr, port: port, handles: map[uint32]routerHandle{}}
+		r.ports[port] = ep
+		return ep
+	}
+}
+
+// forward sends a client request to the PLC owning its target net ID.
+func (r *adsRouter) forward(c *routerClient, p *amsPacket) {
+	if p.isResponse() {
+		// Clients only answer requests the PLC sends them, which the router does not relay.
+		return
+	}
+	up, ok := r.upstreams[p.target.netID]
+	if !ok {
+		r.log.Warnf("AMS router: no route to %s requested by %s", p.target.netID, c.conn.RemoteAddr())
+		r.sendClient(c, p.response(amsErrTargetMachineNotFound, nil))
+		return
+	}
+
+	r.mu.Lock()
+	ep, ok := c.endpoints[p.source]
+	if !ok {
+		ep = r.newEndpoint(c, p.source)
+		c.endpoints[p.source] = ep
+	}
+	invokeID := r.registerPending(&routerPending{
+		endpoint: ep,
+		invokeID: p.invokeID,
+		command:  p.command,
+		upstream: up,
+		port:     p.target.port,
+	})
+	if p.command == adsCmdDeleteNotification && len(p.data) >= 4 {
+		r.pending[invokeID].handle = binary.LittleEndian.Uint32(p.data)
+	}
+	r.mu.Unlock()
+
+	out := *p
+	out.source = amsAddr{netID: r.localAMS, port: ep.port}
+	out.invokeID = invokeID
+	if err := r.sendUpstream(up, &out); err != nil {
+		r.log.Errorf("AMS router: forwarding to %s (%s) failed: %v", up.name, up.conn.targetIP, err)
+		r.mu.Lock()
+		delete(r.pending, invokeID)
+		r.mu.Unlock()
+		r.sendClient(c, p.response(amsErrTargetMachineNotFound, nil))
+	}
+}
+
+// registerPending stores a request and returns the router invoke ID it is sent with.
+// Must be called with r.mu held.
+func (r *adsRouter) registerPending(pend *routerPending) uint32 {
+	pend.deadline = time.Now().Add(r.timeout)
+	for {
+		r.nextInvoke++
+		if _, used := r.pending[r.nextInvoke]; !used && r.nextInvoke != 0 {
+			r.pending[r.nextInvoke] = pend
+			return r.nextInvoke
+		}
+	}
+}
+
+// sendUpstream writes p to the PLC connection, connecting (and registering the route) first if needed.
+func (r *adsRouter) sendUpstream(up *routerUpstream, p *amsPacket) error {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	if up.tcp == nil {
+		if err := r.dialUpstream(up); err != nil {
+			return err
+		}
+	}
+	if _, err := up.tcp.Write(p.marshal()); err != nil {
+		_ = up.tcp.Close()
+		up.tcp = nil
+		return err
+	}
+	return nil
+}
+
+// dialUpstream connects to a PLC. Must be called with up.mu held.
+func (r *adsRouter) dialUpstream(up *routerUpstream) error {
+	select {
+	case <-r.done:
+		return errors.New("router closed")
+	default:
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	targetIP, err := up.conn.resolveTarget(ctx)
+	if err != nil {
+		return err
+	}
+	if !up.routeAdded && up.conn.routeUsername != "" && up.conn.routePassword != "" {
+		hostAddr := up.conn.routeHostAddress
+		if hostAddr == "" {
+			if hostAddr, err = detectLocalAddress(targetIP, up.conn.targetPort); err != nil {
+				return err
+			}
+		}
+		routeName := fmt.Sprintf("benthosADS-router-%s", hostAddr)
+		r.log.Infof("AMS router registering route %s on %s for %s", routeName, up.name, r.localAMS)
+		if err = addRoute(ctx, targetIP, routeName, r.localAMS, hostAddr, up.conn.routeUsername, up.conn.routePassword); err != nil {
+			return err
+		}
+		up.routeAdded = true
+	}
+
+	var d net.Dialer
+	tcp, err := d.DialContext(ctx, "tcp", net.JoinHostPort(targetIP, strconv.Itoa(up.conn.targetPort)))
+	if err != nil {
+		return err
+	}
+	// Close may have run during the dial. It closes done before it takes up.mu, which is held
+	// here, so checking again under the lock tells whether Close will still see this connection.
+	select {
+	case <-r.done:
+		_ = tcp.Close()
+		return errors.New("router closed")
+	default:
+	}
+	up.tcp = tcp
+	r.wg.Add(1)
+	r.log.Infof("AMS router connected to %s (%s)", up.name, targetIP)
+	r.event("plc_connected", map[string]any{"plc": up.name, "target_ams": up.conn.targetAMS})
+
+	go r.serveUpstream(up, tcp)
+	return nil
+}
+
+// serveUpstream relays responses and notifications from a PLC until its connection closes.
+func (r *adsRouter) serveUpstream(up *routerUpstream, tcp net.Conn) {
+	defer r.wg.Done()
+	for {
+		p, err := readAMSPacket(tcp)
+		if err != nil {
+			r.upstreamLost(up, tcp, err)
+			return
+		}
+		if p.tcpCommand != amsTCPCommand {
+			continue
+		}
+
+		if !p.isResponse() {
+			// Device notifications and other PLC requests are addressed to a router port.
+			r.mu.Lock()
+			ep := r.ports[p.target.port]
+			r.mu.Unlock()
+			if ep == nil {
+				continue
+			}
+			p.target = ep.addr
+			r.sendClient(ep.client, p)
+			continue
+		}
+
+		r.mu.Lock()
+		pend := r.pending[p.invokeID]
+		delete(r.pending, p.invokeID)
+		var ep *routerEndpoint
+		var orphan uint32 // handle registered for a client that disconnected meanwhile
+		hasOrphan := false
+		if pend != nil {
+			ep = pend.endpoint
+			if p.errorCode == 0 && len(p.data) >= 4 && binary.LittleEndian.Uint32(p.data) == 0 {
+				switch {
+				case pend.command == adsCmdAddNotification && len(p.data) >= 8:
+					h := binary.LittleEndian.Uint32(p.data[4:])
+					if ep != nil {
+						ep.handles[h] = routerHandle{upstream: up, port: pend.port}
+					} else {
+						orphan, hasOrphan = h, true
+					}
+				case pend.command == adsCmdDeleteNotification && ep != nil:
+					delete(ep.handles, pend.handle)
+				}
+			}
+		}
+		r.mu.Unlock()
+		if hasOrphan {
+			r.deleteNotification(up, pend.port, p.target.port, orphan)
+		}
+		if ep == nil {
+			continue
+		}
+		p.target = ep.addr
+		p.invokeID = pend.invokeID
+		r.sendClient(ep.client, p)
+	}
+}
+
+// deleteNotification deletes a notification handle on a PLC that no client owns any more.
+// source is the router port the handle was registered from.
+func (r *adsRouter) deleteNotification(up *routerUpstream, port, source uint16, handle uint32) {
+	netID, _ := parseAMSNetID(up.conn.targetAMS)
+	r.mu.Lock()
+	invokeID := r.registerPending(&routerPending{command: adsCmdDeleteNotification, upstream: up})
+	r.mu.Unlock()
+	req := &amsPacket{
+		target:     amsAddr{netID: netID, port: port},
+		source:     amsAddr{netID: r.localAMS, port: source},
+		command:    adsCmdDeleteNotification,
+		stateFlags: amsFlagADSCommand,
+		invokeID:   invokeID,
+		data:       binary.LittleEndian.AppendUint32(nil, handle),
+	}
+	if err := r.sendUpstream(up, req); err != nil {
+		r.log.Warnf("AMS router: deleting notification %d on %s failed: %v", handle, up.name, err)
+	}
+}
+
+// upstreamLost fails the requests still waiting on a closed PLC connection.
+func (r *adsRouter) upstreamLost(up *routerUpstream, tcp net.Conn, err error) {
+	up.mu.Lock()
+	if up.tcp == tcp {
+		up.tcp = nil
+	}
+	up.mu.Unlock()
+	_ = tcp.Close()
+
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+	r.log.Warnf("AMS router lost connection to %s: %v", up.name, err)
+	r.event("plc_disconnected", map[string]any{"plc": up.name, "target_ams": up.conn.targetAMS, "error": err.Error()})
+
+	r.mu.Lock()
+	var failed []*routerPending
+	for id, pend := range r.pending {
+		if pend.upstream == up {
+			delete(r.pending, id)
+			failed = append(failed, pend)
+		}
+	}
+	// Notification handles die with the connection.
+	for _, ep := range r.ports {
+		for h, owner := range ep.handles {
+			if owner.upstream == up {
+				delete(ep.handles, h)
+			}
+		}
+	}
+	r.mu.Unlock()
+	for _, pend := range failed {
+		r.failPending(pend, amsErrTargetMachineNotFound)
+	}
+}
+
+// failPending answers a forwarded request with an AMS error.
+func (r *adsRouter) failPending(pend *routerPending, code uint32) {
+	if pend.endpoint == nil {
+		return
+	}
+	netID, _ := parseAMSNetID(pend.upstream.conn.targetAMS)
+	r.sendClient(pend.endpoint.client, &amsPacket{
+		target:     pend.endpoint.addr,
+		source:     amsAddr{netID: netID, port: pend.port},
+		command:    pend.command,
+		stateFlags: amsFlagResponse | amsFlagADSCommand,
+		errorCode:  code,
+		invokeID:   pend.invokeID,
+	})
+}
+
+// expireLoop times out requests the PLC never answered.
+func (r *adsRouter) expireLoop() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			r.mu.Lock()
+			var expired []*routerPending
+			for id, pend := range r.pending {
+				if !now.After(pend.deadline) {
+					continue
+				}
+				if pend.command == adsCmdAddNotification && !pend.timedOut {
+					// The PLC may still register the handle; answer the client now and let a
+					// late success take the orphan path in serveUpstream.
+					answered := *pend
+					expired = append(expired, &answered)
+					pend.endpoint = nil
+					pend.timedOut = true
+					pend.deadline = now.Add(adsRouterLateAnswer)
+					continue
+				}
+				delete(r.pending, id)
+				expired = append(expired, pend) // failPending skips entries without endpoint
+			}
+			r.mu.Unlock()
+			for _, pend := range expired {
+				r.failPending(pend, amsErrClientTimeout)
+			}
+		}
+	}
+}
+
+// dropClient releases a disconnected client's ports and deletes the notifications it left
+// registered on the PLCs.
+func (r *adsRouter) dropClient(c *routerClient) {
+	_ = c.conn.Close()
+	close(c.quit)
+
+	r.mu.Lock()
+	delete(r.clients, c)
+	type orphan struct {
+		routerHandle
+		source uint16
+		handle uint32
+	}
+	var orphans []orphan
+	for _, ep := range c.endpoints {
+		delete(r.ports, ep.port)
+		for h, owner := range ep.handles {
+			orphans = append(orphans, orphan{routerHandle: owner, source: ep.port, handle: h})
+		}
+	}
+	for _, pend := range r.pending {
+		if pend.endpoint != nil && pend.endpoint.client == c {
+			pend.endpoint = nil
+		}
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+	r.log.Infof("AMS router client %s disconnected", c.conn.RemoteAddr())
+	r.event("client_disconnected", map[string]any{"client": c.conn.RemoteAddr().String(), "notifications_deleted": len(orphans)})
+
+	for _, o := range orphans {
+		r.deleteNotification(o.upstream, o.port, o.source, o.handle)
+	}
+}
+
+func (r *adsRouter) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	select {
+	case data := <-r.events:
+		return service.MessageBatch{service.NewMessage(data)}, func(context.Context, error) error { return nil }, nil
+	case <-r.done:
+		return nil, nil, service.ErrEndOfInput
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+func (r *adsRouter) Close(ctx context.Context) error {
+	select {
+	case <-r.done:
+		return nil
+	default:
+		close(r.done)
+	}
+	r.mu.Lock()
+	if r.listener != nil {
+		_ = r.listener.Close()
+	}
+	for c := range r.clients {
+		_ = c.conn.Close()
+	}
+	r.mu.Unlock()
+	for _, up := range r.upstreams {
+		up.mu.Lock()
+		if up.tcp != nil {
+			_ = up.tcp.Close()
+			up.tcp = nil
+		}
+		up.mu.Unlock()
+	}
+	r.wg.Wait()
+	return nil
+}
@@ -0,0 +1,188 @@
+package benthosADS
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/RuneRoven/benthosADS/adstest"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// startTestRouter runs a router on a free loopback port with one route to srv.
+func startTestRouter(t *testing.T, srv *adstest.Server) *adsRouter {
+	t.Helper()
+	localAMS, _ := parseAMSNetID("10.0.0.9.1.1")
+	plcAMS, _ := parseAMSNetID(srv.NetID())
+	r := &adsRouter{
+		listenAddress: "127.0.0.1:0",
+		localAMS:      localAMS,
+		timeout:       2 * time.Second,
+		upstreams: map[amsNetID]*routerUpstream{plcAMS: {name: "plc", conn: adsConnConfig{
+			targetIP: "127.0.0.1", targetAMS: srv.NetID(), targetPort: srv.Addr().Port,
+		}}},
+		log:      service.MockResources().Logger(),
+		clients:  map[*routerClient]struct{}{},
+		ports:    map[uint16]*routerEndpoint{},
+		nextPort: adsRouterFirstPort,
+		pending:  map[uint32]*routerPending{},
+		events:   make(chan []byte, 64),
+		done:     make(chan struct{}),
+	}
+	if err := r.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = r.Close(context.Background()) })
+	return r
+}
+
+// sendAddNotification sends an AddNotification for the first two bytes of the data area to
+// srv through a router client connection, with invoke ID 1.
+func sendAddNotification(t *testing.T, client net.Conn, srv *adstest.Server) {
+	t.Helper()
+	plcAMS, _ := parseAMSNetID(srv.NetID())
+	clientAMS, _ := parseAMSNetID("10.0.0.50.1.1")
+	req := make([]byte, 40)
+	binary.LittleEndian.PutUint32(req[0:], adstest.IndexGroupData)
+	binary.LittleEndian.PutUint32(req[8:], 2)
+	binary.LittleEndian.PutUint32(req[12:], 4) // on change
+	if _, err := client.Write((&amsPacket{
+		target:     amsAddr{netID: plcAMS, port: 851},
+		source:     amsAddr{netID: clientAMS, port: 32905},
+		command:    adsCmdAddNotification,
+		stateFlags: amsFlagADSCommand,
+		invokeID:   1,
+		data:       req,
+	}).marshal()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRouterDeletesLateNotificationOfDroppedClient(t *testing.T) {
+	srv, err := adstest.NewServer(adstest.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	if err = srv.AddSymbol("MAIN.n", "INT", 5); err != nil {
+		t.Fatal(err)
+	}
+	hold := make(chan struct{})
+	srv.InjectFault(adstest.Fault{Command: adstest.CmdAddNotification, Hold: hold, Count: 1})
+
+	r := startTestRouter(t, srv)
+	client, err := net.Dial("tcp", r.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendAddNotification(t, client, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = srv.WaitRequests(ctx, adstest.CmdAddNotification, 1); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.Close()
+	for {
+		r.mu.Lock()
+		n := len(r.clients)
+		r.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatal("router kept the closed client")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The PLC answers after the client is gone; the router must not leak the handle.
+	close(hold)
+	if err = srv.WaitRequests(ctx, adstest.CmdDeleteNotification, 1); err != nil {
+		t.Fatalf("router did not delete the orphaned notification: %v", err)
+	}
+	for srv.Notifications() > 0 {
+		if ctx.Err() != nil {
+			t.Fatalf("notification still registered: %d", srv.Notifications())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRouterDeletesNotificationAnsweredAfterTimeout(t *testing.T) {
+	srv, err := adstest.NewServer(adstest.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	if err = srv.AddSymbol("MAIN.n", "INT", 5); err != nil {
+		t.Fatal(err)
+	}
+	hold := make(chan struct{})
+	srv.InjectFault(adstest.Fault{Command: adstest.CmdAddNotification, Hold: hold, Count: 1})
+
+	r := startTestRouter(t, srv)
+	client, err := net.Dial("tcp", r.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	sendAddNotification(t, client, srv)
+
+	// The router answers the client with a timeout while the PLC still holds the request.
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := readAMSPacket(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.invokeID != 1 || resp.errorCode != amsErrClientTimeout {
+		t.Fatalf("response = invoke ID %d, error 0x%X, want the client timeout", resp.invokeID, resp.errorCode)
+	}
+
+	// The late success must not leave a handle registered that no client knows about.
+	close(hold)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = srv.WaitRequests(ctx, adstest.CmdDeleteNotification, 1); err != nil {
+		t.Fatalf("router did not delete the notification answered after the timeout: %v", err)
+	}
+	for srv.Notifications() > 0 {
+		if ctx.Err() != nil {
+			t.Fatalf("notification still registered: %d", srv.Notifications())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRouterDisconnectsClientThatDoesNotRead(t *testing.T) {
+	r := &adsRouter{timeout: 50 * time.Millisecond, log: service.MockResources().Logger()}
+	server, peer := net.Pipe()
+	defer peer.Close()
+	c := &routerClient{amsConn: amsConn{conn: server}, out: make(chan *amsPacket, 1), quit: make(chan struct{})}
+
+	r.sendClient(c, &amsPacket{tcpCommand: amsTCPGetLocalNetID, data: make([]byte, 6)})
+	r.sendClient(c, &amsPacket{tcpCommand: amsTCPGetLocalNetID, data: make([]byte, 6)}) // queue full
+	if _, err := server.Write([]byte{0}); err == nil {
+		t.Error("client connection still open after its queue overflowed")
+	}
+
+	// A client that stops reading mid-write is disconnected by the write deadline.
+	server, peer = net.Pipe()
+	defer peer.Close()
+	c = &routerClient{amsConn: amsConn{conn: server}, out: make(chan *amsPacket, 1), quit: make(chan struct{})}
+	r.wg.Add(1)
+	go r.writeClient(c)
+	r.sendClient(c, &amsPacket{tcpCommand: amsTCPGetLocalNetID, data: make([]byte, 6)})
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("writer blocked on a client that does not read")
+	}
+}
@@ -0,0 +1,433 @@
+package benthosADS
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// amsHandler serves the ADS requests sent to one AMS port of an amsServer.
+type amsHandler func(c *amsConn, p *amsPacket)
+
+// amsServer accepts AMS connections from PLCs and dispatches requests by target port, so an
+// ads_server input and output can share one listen address.
+type amsServer struct {
+	address  string
+	localAMS amsNetID
+	log      *service.Logger
+
+	mu       sync.Mutex
+	ports    map[uint16]*amsPort
+	listener net.Listener
+	conns    map[*amsConn]struct{}
+	wg       sync.WaitGroup
+}
+
+// amsPort is one AMS port served by an amsServer.
+type amsPort struct {
+	handler amsHandler
+	allowed map[amsNetID]bool // empty allows every source
+}
+
+var amsServers = struct {
+	sync.Mutex
+	byAddress map[string]*amsServer
+}{byAddress: map[string]*amsServer{}}
+
+// adsServerFields are the listener fields shared by the ads_server input and output.
+func adsServerFields() []*service.ConfigField {
+	return []*service.ConfigField{
+		service.NewStringField("listenAddress").Description("TCP address PLCs connect to. Components with the same listenAddress share one listener.").Default("0.0.0.0:48898"),
+		service.NewStringField("localAMS").Description("AMS net ID of this endpoint. The PLC needs a route to this net ID and the host's IP address."),
+		service.NewIntField("localPort").Description("AMS port PLCs address, the port input of ADSWRITE/ADSREAD."),
+		service.NewStringListField("allowedSources").Description("AMS net IDs allowed to send requests. Empty allows every PLC with a route.").Default([]any{}),
+	}
+}
+
+// adsServerConfig holds the parsed adsServerFields.
+type adsServerConfig struct {
+	listenAddress string
+	localAMS      amsNetID
+	localPort     uint16
+	allowed       []amsNetID
+}
+
+func parseAdsServerConfig(conf *service.ParsedConfig) (adsServerConfig, error) {
+	var c adsServerConfig
+	var err error
+	if c.listenAddress, err = conf.FieldString("listenAddress"); err != nil {
+		return c, err
+	}
+	localAMS, err := conf.FieldString("localAMS")
+	if err != nil {
+		return c, err
+	}
+	if c.localAMS, err = parseAMSNetID(localAMS); err != nil {
+		return c, fmt.Errorf("localAMS: %w", err)
+	}
+	port, err := conf.FieldInt("localPort")
+	if err != nil {
+		return c, err
+	}
+	if port <= 0 || port > 65535 {
+		return c, fmt.Errorf("localPort %d out of range 1–65535", port)
+	}
+	c.localPort = uint16(port)
+	sources, err := conf.FieldStringList("allowedSources")
+	if err != nil {
+		return c, err
+	}
+	for _, s := range sources {
+		id, perr := parseAMSNetID(s)
+		if perr != nil {
+			return c, fmt.Errorf("allowedSources: %w", perr)
+		}
+		c.allowed = append(c.allowed, id)
+	}
+	return c, nil
+}
+
+// registerAMSHandler starts serving h on conf.localPort, opening the listener for
+// conf.listenAddress on first use.
+func registerAMSHandler(conf adsServerConfig, h amsHandler, log *service.Logger) (*amsServer, error) {
+	amsServers.Lock()
+	defer amsServers.Unlock()
+
+	s, ok := amsServers.byAddress[conf.listenAddress]
+	if !ok {
+		ln, err := net.Listen("tcp", conf.listenAddress)
+		if err != nil {
+			return nil, fmt.Errorf("listening on %s: %w", conf.listenAddress, err)
+		}
+		s = &amsServer{
+			address:  conf.listenAddress,
+			localAMS: conf.localAMS,
+			log:      log,
+			ports:    map[uint16]*amsPort{},
+			listener: ln,
+			conns:    map[*amsConn]struct{}{},
+		}
+		amsServers.byAddress[conf.listenAddress] = s
+		log.Infof("ADS server %s listening on %s", conf.localAMS, ln.Addr())
+		s.wg.Add(1)
+		go s.acceptLoop()
+	} else if s.localAMS != conf.localAMS {
+		return nil, fmt.Errorf("localAMS %s differs from %s already served on %s", conf.localAMS, s.localAMS, conf.listenAddress)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, used := s.ports[conf.localPort]; used {
+		return nil, fmt.Errorf("AMS port %d is already served on %s", conf.localPort, conf.listenAddress)
+	}
+	port := &amsPort{handler: h, allowed: map[amsNetID]bool{}}
+	for _, id := range conf.allowed {
+		port.allowed[id] = true
+	}
+	s.ports[conf.localPort] = port
+	return s, nil
+}
+
+// unregister stops serving port and closes the listener when no port is left.
+func (s *amsServer) unregister(port uint16) {
+	amsServers.Lock()
+	defer amsServers.Unlock()
+
+	s.mu.Lock()
+	delete(s.ports, port)
+	last := len(s.ports) == 0
+	s.mu.Unlock()
+	if !last {
+		return
+	}
+	delete(amsServers.byAddress, s.address)
+	_ = s.listener.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		_ = c.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	s.log.Infof("ADS server on %s closed", s.address)
+}
+
+func (s *amsServer) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &amsConn{conn: conn}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		s.log.Infof("ADS server: connection from %s", conn.RemoteAddr())
+
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+// serve reads requests from one PLC connection and dispatches them by target port.
+func (s *amsServer) serve(c *amsConn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		_ = c.conn.Close()
+	}()
+	for {
+		p, err := readAMSPacket(c.conn)
+		if err != nil {
+			return
+		}
+		if p.tcpCommand != amsTCPCommand || p.isResponse() {
+			continue
+		}
+		if p.target.netID != s.localAMS {
+			_ = c.send(p.response(amsErrTargetMachineNotFound, nil))
+			continue
+		}
+		s.mu.Lock()
+		port := s.ports[p.target.port]
+		s.mu.Unlock()
+		switch {
+		case port == nil:
+			_ = c.send(p.response(amsErrTargetPortNotFound, nil))
+		case len(port.allowed) > 0 && !port.allowed[p.source.netID]:
+			s.log.Warnf("ADS server: rejected request from %s to port %d, not in allowedSources", p.source, p.target.port)
+			_ = c.send(p.response(0, adsResult(adsErrDeviceInvalidAccess)))
+		default:
+			port.handler(c, p)
+		}
+	}
+}
+
+// serveCommonRequest answers the requests every ADS device supports. It reports false for
+// requests the caller must handle itself.
+func serveCommonRequest(c *amsConn, p *amsPacket) bool {
+	switch p.command {
+	case adsCmdReadDeviceInfo:
+		info := []byte{1, 0, 0, 0} // major, minor, build (2 bytes)
+		name := make([]byte, 16)
+		copy(name, "benthosADS")
+		_ = c.send(p.response(0, adsResult(0, info, name)))
+	case adsCmdReadState:
+		state := binary.LittleEndian.AppendUint16(nil, adsStateRun)
+		state = binary.LittleEndian.AppendUint16(state, 0)
+		_ = c.send(p.response(0, adsResult(0, state)))
+	default:
+		return false
+	}
+	return true
+}
+
+var adsServerInputConf = service.NewConfigSpec().
+	Summary("Receives data pushed by PLCs with ADSWRITE and ADSRDWRT, acting as an ADS device.").
+	Description("Every write request becomes one message. The PLC receives its ADS return code only after the message is " +
+		"acknowledged downstream: 0 on success, nackCode when the message is rejected. Index group, offset and the sending " +
+		"PLC's AMS address are set as metadata.").
+	Fields(adsServerFields()...).
+	Field(service.NewStringField("dataType").Description("Decode the payload as this PLC type (e.g. DINT, LREAL, STRING(80)). Empty keeps the raw bytes.").Default("")).
+	Field(service.NewIntField("nackCode").Description("ADS return code sent to the PLC when a message is rejected downstream.").Default(int(adsErrDeviceNotReady)))
+
+func init() {
+	err := service.RegisterBatchInput(
+		"ads_server", adsServerInputConf,
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
+			return newAdsServerInput(conf, mgr)
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+type adsServerInput struct {
+	conf     adsServerConfig
+	dataType string
+	nackCode uint32
+	log      *service.Logger
+
+	server *amsServer
+	writes chan adsServerWrite
+	done   chan struct{}
+	// queueMu is held for reading while a write is queued, so Close can wait for queueing
+	// writes before it answers every write left in the queue.
+	queueMu sync.RWMutex
+}
+
+// adsServerWrite is a write request waiting to be read by the pipeline.
+type adsServerWrite struct {
+	conn *amsConn
+	req  *amsPacket
+}
+
+func newAdsServerInput(conf *service.ParsedConfig, mgr *service.Resources) (*adsServerInput, error) {
+	serverConf, err := parseAdsServerConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	dataType, err := conf.FieldString("dataType")
+	if err != nil {
+		return nil, err
+	}
+	if dataType != "" {
+		if _, ok := rawTypeSizes[dataType]; !ok && !strings.HasPrefix(dataType, "STRING") {
+			return nil, fmt.Errorf("dataType: unsupported type %q", dataType)
+		}
+	}
+	nackCode, err := conf.FieldInt("nackCode")
+	if err != nil {
+		return nil, err
+	}
+	if nackCode <= 0 {
+		return nil, fmt.Errorf("nackCode %d must be a non-zero ADS error code", nackCode)
+	}
+	return &adsServerInput{
+		conf:     serverConf,
+		dataType: dataType,
+		nackCode: uint32(nackCode),
+		log:      mgr.Logger(),
+		writes:   make(chan adsServerWrite, 64),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+func (a *adsServerInput) Connect(ctx context.Context) error {
+	if a.server != nil {
+		return nil
+	}
+	server, err := registerAMSHandler(a.conf, a.handle, a.log)
+	if err != nil {
+		return err
+	}
+	a.server = server
+	return nil
+}
+
+// handle queues write requests for the pipeline; the response is sent by the ack function.
+func (a *adsServerInput) handle(c *amsConn, p *amsPacket) {
+	if serveCommonRequest(c, p) {
+		return
+	}
+	if p.command != adsCmdWrite && p.command != adsCmdReadWrite {
+		_ = c.send(p.response(0, adsResult(adsErrDeviceSrvNotSupp)))
+		return
+	}
+	a.queueMu.RLock()
+	defer a.queueMu.RUnlock()
+	select {
+	case <-a.done:
+		_ = c.send(p.response(0, a.result(p, a.nackCode)))
+		return
+	default:
+	}
+	select {
+	case a.writes <- adsServerWrite{conn: c, req: p}:
+	case <-a.done:
+		_ = c.send(p.response(0, a.result(p, a.nackCode)))
+	}
+}
+
+func (a *adsServerInput) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	var w adsServerWrite
+	select {
+	case w = <-a.writes:
+	case <-a.done:
+		return nil, nil, service.ErrEndOfInput
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+
+	p := w.req
+	le := binary.LittleEndian
+	var group, offset uint32
+	var payload []byte
+	command := "write"
+	switch {
+	case p.command == adsCmdWrite && len(p.data) >= 12:
+		group, offset = le.Uint32(p.data[0:]), le.Uint32(p.data[4:])
+		payload = p.data[12:]
+		if n := le.Uint32(p.data[8:]); int(n) < len(payload) {
+			payload = payload[:n]
+		}
+	case p.command == adsCmdReadWrite && len(p.data) >= 16:
+		command = "readwrite"
+		group, offset = le.Uint32(p.data[0:]), le.Uint32(p.data[4:])
+		payload = p.data[16:]
+		if n := le.Uint32(p.data[12:]); int(n) < len(payload) {
+			payload = payload[:n]
+		}
+	default:
+		_ = w.conn.send(p.response(0, adsResult(adsErrDeviceInvalidSize)))
+		return service.MessageBatch{}, func(context.Context, error) error { return nil }, nil
+	}
+
+	msg := service.NewMessage(append([]byte(nil), payload...))
+	if a.dataType != "" {
+		val, err := decodeRawValue(a.dataType, payload)
+		if err != nil {
+			a.log.Errorf("Decoding write from %s as %s failed: %v", p.source, a.dataType, err)
+			_ = w.conn.send(p.response(0, a.result(p, adsErrDeviceInvalidData)))
+			return service.MessageBatch{}, func(context.Context, error) error { return nil }, nil
+		}
+		msg.SetBytes([]byte(val))
+	}
+	msg.MetaSet("ads_command", command)
+	msg.MetaSet("index_group", strconv.FormatUint(uint64(group), 10))
+	msg.MetaSet("index_offset", strconv.FormatUint(uint64(offset), 10))
+	msg.MetaSet("source_ams", p.source.netID.String())
+	msg.MetaSet("source_port", strconv.Itoa(int(p.source.port)))
+	msg.MetaSet("target_port", strconv.Itoa(int(p.target.port)))
+
+	return service.MessageBatch{msg}, func(_ context.Context, err error) error {
+		code := uint32(0)
+		if err != nil {
+			code = a.nackCode
+			a.log.Warnf("Write from %s rejected downstream, returning ADS error 0x%X: %v", p.source, code, err)
+		}
+		return w.conn.send(p.response(0, a.result(p, code)))
+	}, nil
+}
+
+// result encodes the response payload for a write or read-write request.
+func (a *adsServerInput) result(p *amsPacket, code uint32) []byte {
+	if p.command == adsCmdReadWrite {
+		// No data is read back; the length field tells the PLC so.
+		return adsResult(code, []byte{0, 0, 0, 0})
+	}
+	return adsResult(code)
+}
+
+func (a *adsServerInput) Close(ctx context.Context) error {
+	select {
+	case <-a.done:
+		return nil
+	default:
+		close(a.done)
+	}
+	if a.server != nil {
+		a.server.unregister(a.conf.localPort)
+		a.server = nil
+	}
+
+	// Writes the pipeline never read are rejected, so the PLC doesn't wait for its timeout.
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	for {
+		select {
+		case w := <-a.writes:
+			_ = w.conn.send(w.req.response(0, a.result(w.req, a.nackCode)))
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,528 @@
+package benthosADS
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// Index groups of the symbol services answered by the virtual symbol server.
+const (
+	indexGroupSymHandleByName  = 0xF003
+	indexGroupSymValueByName   = 0xF004
+	indexGroupSymValueByHandle = 0xF005
+	indexGroupSymReleaseHandle = 0xF006
+	indexGroupSymInfoByNameEx  = 0xF009
+	indexGroupVirtualData      = 0x4040
+)
+
+// adsTypeIDs maps PLC type names to the ADS data type IDs (ADST_*) used in symbol entries.
+var adsTypeIDs = map[string]uint32{
+	"BOOL": 33, "BYTE": 17, "USINT": 17, "SINT": 16,
+	"WORD": 18, "UINT": 18, "INT": 2,
+	"DWORD": 19, "UDINT": 19, "DATE": 19, "DT": 19, "DATE_AND_TIME": 19, "TIME": 19, "TOD": 19, "TIME_OF_DAY": 19,
+	"DINT": 3, "REAL": 4, "LREAL": 5,
+	"LWORD": 21, "ULINT": 21, "LINT": 20,
+}
+
+// Notification transmission modes of ADS AddDeviceNotification requests.
+const (
+	adsTransServerCycle  = 3
+	adsTransServerCycle2 = 5
+)
+
+var adsServerOutputConf = service.NewConfigSpec().
+	Summary("Hosts virtual symbols that PLCs read like any other ADS device, with values taken from pipeline messages.").
+	Description("Each message updates one symbol. PLCs look the symbols up by name (ADS symbol handles, as used by ADSREAD " +
+		"with a handle or by name), read them by index group 0x4040 and offset, or subscribe to them with ADS notifications. " +
+		"The symbols are read-only for PLCs.").
+	Fields(adsServerFields()...).
+	Field(service.NewObjectListField("symbols",
+		service.NewStringField("name").Description("Symbol name PLCs look up, e.g. 'Forecast.temperature'. Case-insensitive."),
+		service.NewStringField("type").Description("PLC type of the symbol: a primitive such as LREAL or DINT, or STRING(n)."),
+		service.NewStringField("initial").Description("Value served before the first message arrives. Empty serves zero.").Default(""),
+	).Description("Symbols served to PLCs.")).
+	Field(service.NewInterpolatedStringField("symbolName").Description("Symbol updated by a message; the message content is the new value.").Default(`${! meta("symbol_name") }`))
+
+func init() {
+	err := service.RegisterOutput(
+		"ads_server", adsServerOutputConf,
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.Output, int, error) {
+			out, err := newAdsServerOutput(conf, mgr)
+			return out, 1, err
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// virtualSymbol is one symbol hosted by the ads_server output. Symbols are laid out back to
+// back in index group 0x4040, so a PLC can also read them by offset.
+type virtualSymbol struct {
+	name     string
+	dataType string
+	typeID   uint32
+	offset   uint32
+	value    []byte
+}
+
+// virtualNotification is a notification a PLC registered on the virtual symbols.
+type virtualNotification struct {
+	handle    uint32
+	conn      *amsConn
+	target    amsAddr // the subscribing PLC
+	source    amsAddr // this server
+	group     uint32
+	offset    uint32
+	length    uint32
+	cyclic    bool
+	cycleTime time.Duration
+	last      []byte
+	stopped   chan struct{}
+}
+
+type adsServerOutput struct {
+	conf       adsServerConfig
+	symbolName *service.InterpolatedString
+	log        *service.Logger
+
+	mu            sync.Mutex
+	symbols       []*virtualSymbol
+	byName        map[string]*virtualSymbol
+	areaSize      uint32
+	handles       map[uint32]*virtualSymbol
+	nextHandle    uint32
+	notifications map[uint32]*virtualNotification
+	nextNotify    uint32
+	server        *amsServer
+}
+
+func newAdsServerOutput(conf *service.ParsedConfig, mgr *service.Resources) (*adsServerOutput, error) {
+	serverConf, err := parseAdsServerConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	symbolName, err := conf.FieldInterpolatedString("symbolName")
+	if err != nil {
+		return nil, err
+	}
+	symbolConfs, err := conf.FieldObjectList("symbols")
+	if err != nil {
+		return nil, err
+	}
+	if len(symbolConfs) == 0 {
+		return nil, errors.New("symbols must not be empty")
+	}
+
+	o := &adsServerOutput{
+		conf:          serverConf,
+		symbolName:    symbolName,
+		log:           mgr.Logger(),
+		byName:        map[string]*virtualSymbol{},
+		handles:       map[uint32]*virtualSymbol{},
+		notifications: map[uint32]*virtualNotification{},
+	}
+	for i, sc := range symbolConfs {
+		sym, serr := o.parseVirtualSymbol(sc)
+		if serr != nil {
+			return nil, fmt.Errorf("symbols[%d]: %w", i, serr)
+		}
+		key := strings.ToLower(sym.name)
+		if _, dup := o.byName[key]; dup {
+			return nil, fmt.Errorf("symbols[%d]: duplicate name %q", i, sym.name)
+		}
+		o.byName[key] = sym
+		o.symbols = append(o.symbols, sym)
+	}
+	return o, nil
+}
+
+// parseVirtualSymbol reads one symbol and places it at the end of the data area.
+func (o *adsServerOutput) parseVirtualSymbol(sc *service.ParsedConfig) (*virtualSymbol, error) {
+	name, err := sc.FieldString("name")
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.New("name must not be empty")
+	}
+	dataType, err := sc.FieldString("type")
+	if err != nil {
+		return nil, err
+	}
+	dataType = strings.ToUpper(strings.TrimSpace(dataType))
+	sym := &virtualSymbol{name: name, dataType: dataType, offset: o.areaSize}
+	switch {
+	case strings.HasPrefix(dataType, "STRING"):
+		sym.typeID = 30
+		sym.value = make([]byte, stringTypeSize(dataType))
+	case adsTypeIDs[dataType] != 0:
+		sym.typeID = adsTypeIDs[dataType]
+		sym.value = make([]byte, rawTypeSizes[dataType])
+	default:
+		return nil, fmt.Errorf("unsupported type %q", dataType)
+	}
+	initial, err := sc.FieldString("initial")
+	if err != nil {
+		return nil, err
+	}
+	if initial != "" {
+		if sym.value, err = encodeRawValue(dataType, initial); err != nil {
+			return nil, fmt.Errorf("initial: %w", err)
+		}
+	}
+	o.areaSize += uint32(len(sym.value))
+	return sym, nil
+}
+
+func (o *adsServerOutput) Connect(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.server != nil {
+		return nil
+	}
+	server, err := registerAMSHandler(o.conf, o.handle, o.log)
+	if err != nil {
+		return err
+	}
+	o.server = server
+	o.log.Infof("Serving %d virtual symbols on AMS port %d", len(o.symbols), o.conf.localPort)
+	return nil
+}
+
+func (o *adsServerOutput) Write(ctx context.Context, msg *service.Message) error {
+	name, err := o.symbolName.TryString(msg)
+	if err != nil {
+		return fmt.Errorf("symbolName interpolation: %w", err)
+	}
+	sym, ok := o.byName[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown virtual symbol %q", name)
+	}
+	content, err := msg.AsBytes()
+	if err != nil {
+		return err
+	}
+	value, err := encodeRawValue(sym.dataType, string(content))
+	if err != nil {
+		return fmt.Errorf("symbol %s: %w", sym.name, err)
+	}
+
+	o.mu.Lock()
+	sym.value = value
+	var changed []*virtualNotification
+	for _, n := range o.notifications {
+		if !n.cyclic && o.overlaps(n, sym) {
+			changed = append(changed, n)
+		}
+	}
+	o.mu.Unlock()
+	for _, n := range changed {
+		o.sendNotification(n, true)
+	}
+	return nil
+}
+
+// handle answers a PLC request addressed to this output's AMS port.
+func (o *adsServerOutput) handle(c *amsConn, p *amsPacket) {
+	if serveCommonRequest(c, p) {
+		return
+	}
+	le := binary.LittleEndian
+	var code uint32
+	var payload []byte
+	switch p.command {
+	case adsCmdRead:
+		if len(p.data) < 12 {
+			code = adsErrDeviceInvalidSize
+			break
+		}
+		var data []byte
+		data, code = o.read(le.Uint32(p.data[0:]), le.Uint32(p.data[4:]), le.Uint32(p.data[8:]))
+		payload = le.AppendUint32(nil, uint32(len(data)))
+		payload = append(payload, data...)
+	case adsCmdReadWrite:
+		if len(p.data) < 16 {
+			code = adsErrDeviceInvalidSize
+			break
+		}
+		readLen, writeLen := le.Uint32(p.data[8:]), le.Uint32(p.data[12:])
+		written := p.data[16:]
+		if int(writeLen) < len(written) {
+			written = written[:writeLen]
+		}
+		var data []byte
+		data, code = o.readWrite(le.Uint32(p.data[0:]), readLen, written)
+		if code == 0 && uint32(len(data)) > readLen {
+			data, code = nil, adsErrDeviceInvalidSize
+		}
+		payload = le.AppendUint32(nil, uint32(len(data)))
+		payload = append(payload, data...)
+	case adsCmdWrite:
+		code = adsErrDeviceInvalidAccess
+		if len(p.data) >= 16 && le.Uint32(p.data[0:]) == indexGroupSymReleaseHandle {
+			o.mu.Lock()
+			delete(o.handles, le.Uint32(p.data[12:]))
+			o.mu.Unlock()
+			code = 0
+		}
+	case adsCmdAddNotification:
+		if len(p.data) < 24 {
+			code = adsErrDeviceInvalidSize
+			break
+		}
+		var n *virtualNotification
+		if n, code = o.addNotification(c, p); code == 0 {
+			// The handle must reach the PLC before the first sample does.
+			_ = c.send(p.response(0, adsResult(0, le.AppendUint32(nil, n.handle))))
+			go o.runNotification(n)
+			return
+		}
+	case adsCmdDeleteNotification:
+		if len(p.data) < 4 {
+			code = adsErrDeviceInvalidSize
+			break
+		}
+		code = o.deleteNotification(le.Uint32(p.data))
+	default:
+		code = adsErrDeviceSrvNotSupp
+	}
+	_ = c.send(p.response(0, adsResult(code, payload)))
+}
+
+// read serves an ADS Read request.
+func (o *adsServerOutput) read(group, offset, length uint32) ([]byte, uint32) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch group {
+	case indexGroupSymValueByHandle:
+		sym, ok := o.handles[offset]
+		if !ok {
+			return nil, adsErrDeviceSymbolNotFound
+		}
+		if length > uint32(len(sym.value)) {
+			return nil, adsErrDeviceInvalidSize
+		}
+		return append([]byte(nil), sym.value[:length]...), 0
+	case indexGroupVirtualData:
+		if uint64(offset)+uint64(length) > uint64(o.areaSize) {
+			return nil, adsErrDeviceInvalidOffset
+		}
+		return o.area()[offset : offset+length], 0
+	case indexGroupSymVersion:
+		return []byte{1}, 0
+	case indexGroupSymUploadInfo2:
+		entries := o.symbolEntries()
+		info := binary.LittleEndian.AppendUint32(nil, uint32(len(o.symbols)))
+		info = binary.LittleEndian.AppendUint32(info, uint32(len(entries)))
+		info = append(info, make([]byte, 16)...)
+		if length < uint32(len(info)) {
+			info = info[:length]
+		}
+		return info, 0
+	case indexGroupSymUpload:
+		entries := o.symbolEntries()
+		if length < uint32(len(entries)) {
+			return nil, adsErrDeviceInvalidSize
+		}
+		return entries, 0
+	case indexGroupDataTypeUpload:
+		return nil, 0
+	}
+	return nil, adsErrDeviceInvalidGroup
+}
+
+// readWrite serves the symbol lookups PLCs send as ReadWrite requests.
+func (o *adsServerOutput) readWrite(group, readLen uint32, written []byte) ([]byte, uint32) {
+	name := string(bytes.TrimRight(written, "\x00"))
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	sym, ok := o.byName[strings.ToLower(name)]
+	switch group {
+	case indexGroupSymHandleByName, indexGroupSymValueByName, indexGroupSymInfoByNameEx:
+		if !ok {
+			return nil, adsErrDeviceSymbolNotFound
+		}
+	default:
+		return nil, adsErrDeviceInvalidGroup
+	}
+	switch group {
+	case indexGroupSymHandleByName:
+		o.nextHandle++
+		o.handles[o.nextHandle] = sym
+		return binary.LittleEndian.AppendUint32(nil, o.nextHandle), 0
+	case indexGroupSymValueByName:
+		return append([]byte(nil), sym.value...), 0
+	default:
+		entry := symbolEntry(sym)
+		if readLen < uint32(len(entry)) {
+			return nil, adsErrDeviceInvalidSize
+		}
+		return entry, 0
+	}
+}
+
+// area returns the current values of all symbols as one block. Must be called with o.mu held.
+func (o *adsServerOutput) area() []byte {
+	buf := make([]byte, 0, o.areaSize)
+	for _, sym := range o.symbols {
+		buf = append(buf, sym.value...)
+	}
+	return buf
+}
+
+// symbolEntries encodes the symbol upload table. Must be called with o.mu held.
+func (o *adsServerOutput) symbolEntries() []byte {
+	var buf []byte
+	for _, sym := range o.symbols {
+		buf = append(buf, symbolEntry(sym)...)
+	}
+	return buf
+}
+
+// symbolEntry encodes an AdsSymbolEntry, the layout parseSymbolEntries reads.
+func symbolEntry(sym *virtualSymbol) []byte {
+	le := binary.LittleEndian
+	entryLen := 30 + len(sym.name) + 1 + len(sym.dataType) + 1 + 1
+	buf := le.AppendUint32(nil, uint32(entryLen))
+	buf = le.AppendUint32(buf, indexGroupVirtualData)
+	buf = le.AppendUint32(buf, sym.offset)
+	buf = le.AppendUint32(buf, uint32(len(sym.value)))
+	buf = le.AppendUint32(buf, sym.typeID)
+	buf = le.AppendUint32(buf, 0)
+	buf = le.AppendUint16(buf, uint16(len(sym.name)))
+	buf = le.AppendUint16(buf, uint16(len(sym.dataType)))
+	buf = le.AppendUint16(buf, 0)
+	buf = append(buf, sym.name...)
+	buf = append(buf, 0)
+	buf = append(buf, sym.dataType...)
+	buf = append(buf, 0, 0)
+	return buf
+}
+
+// addNotification registers a notification on a symbol handle or a range of the data area.
+func (o *adsServerOutput) addNotification(c *amsConn, p *amsPacket) (*virtualNotification, uint32) {
+	le := binary.LittleEndian
+	n := &virtualNotification{
+		conn:    c,
+		target:  p.source,
+		source:  p.target,
+		group:   le.Uint32(p.data[0:]),
+		offset:  le.Uint32(p.data[4:]),
+		length:  le.Uint32(p.data[8:]),
+		stopped: make(chan struct{}),
+	}
+	mode := le.Uint32(p.data[12:])
+	n.cyclic = mode == adsTransServerCycle || mode == adsTransServerCycle2
+	n.cycleTime = time.Duration(le.Uint32(p.data[20:])) * 100 // 100 ns units
+	if n.cycleTime < 10*time.Millisecond {
+		n.cycleTime = 10 * time.Millisecond
+	}
+	if _, code := o.read(n.group, n.offset, n.length); code != 0 {
+		return nil, code
+	}
+
+	o.mu.Lock()
+	o.nextNotify++
+	n.handle = o.nextNotify
+	o.notifications[n.handle] = n
+	o.mu.Unlock()
+	return n, 0
+}
+
+// runNotification sends the initial sample a PLC expects after subscribing and, for cyclic
+// notifications, a sample every cycle until the notification is deleted.
+func (o *adsServerOutput) runNotification(n *virtualNotification) {
+	o.sendNotification(n, false)
+	if !n.cyclic {
+		return
+	}
+	ticker := time.NewTicker(n.cycleTime)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			o.sendNotification(n, false)
+		case <-n.stopped:
+			return
+		}
+	}
+}
+
+func (o *adsServerOutput) deleteNotification(handle uint32) uint32 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n, ok := o.notifications[handle]
+	if !ok {
+		return adsErrDeviceNotifyHandle
+	}
+	delete(o.notifications, handle)
+	close(n.stopped)
+	return 0
+}
+
+// overlaps reports whether notification n covers any byte of sym. Must be called with o.mu held.
+func (o *adsServerOutput) overlaps(n *virtualNotification, sym *virtualSymbol) bool {
+	if n.group == indexGroupSymValueByHandle {
+		return o.handles[n.offset] == sym
+	}
+	end := sym.offset + uint32(len(sym.value))
+	return n.offset < end && sym.offset < n.offset+n.length
+}
+
+// sendNotification sends the current value to the subscribing PLC. With onlyChanged, values
+// equal to the last one sent are skipped. A notification whose connection is gone is removed.
+func (o *adsServerOutput) sendNotification(n *virtualNotification, onlyChanged bool) {
+	data, code := o.read(n.group, n.offset, n.length)
+	if code != 0 {
+		return
+	}
+	o.mu.Lock()
+	if onlyChanged && bytes.Equal(data, n.last) {
+		o.mu.Unlock()
+		return
+	}
+	n.last = data
+	o.mu.Unlock()
+
+	le := binary.LittleEndian
+	sample := le.AppendUint32(nil, 1) // stamps
+	sample = le.AppendUint64(sample, uint64(time.Now().UnixNano()/100+116444736000000000))
+	sample = le.AppendUint32(sample, 1) // samples
+	sample = le.AppendUint32(sample, n.handle)
+	sample = le.AppendUint32(sample, uint32(len(data)))
+	sample = append(sample, data...)
+	err := n.conn.send(&amsPacket{
+		target:     n.target,
+		source:     n.source,
+		command:    adsCmdDeviceNotification,
+		stateFlags: amsFlagADSCommand,
+		data:       append(le.AppendUint32(nil, uint32(len(sample))), sample...),
+	})
+	if err != nil {
+		o.log.Debugf("Dropping notification %d for %s: %v", n.handle, n.target, err)
+		o.deleteNotification(n.handle)
+	}
+}
+
+func (o *adsServerOutput) Close(ctx context.Context) error {
+	o.mu.Lock()
+	for handle, n := range o.notifications {
+		delete(o.notifications, handle)
+		close(n.stopped)
+	}
+	server := o.server
+	o.server = nil
+	o.mu.Unlock()
+	if server != nil {
+		server.unregister(o.conf.localPort)
+	}
+	return nil
+}
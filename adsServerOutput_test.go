@@ -0,0 +1,287 @@
+package benthosADS
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// amsTestClient talks to an AMS server like a PLC would, using the plugin's own AMS codec.
+type amsTestClient struct {
+	t             *testing.T
+	conn          net.Conn
+	target        amsAddr
+	source        amsAddr
+	invokeID      uint32
+	responses     chan *amsPacket
+	notifications chan *amsPacket
+}
+
+func dialAMSTestClient(t *testing.T, addr net.Addr, target amsAddr) *amsTestClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	source, _ := parseAMSNetID("10.0.0.9.1.1")
+	c := &amsTestClient{
+		t:             t,
+		conn:          conn,
+		target:        target,
+		source:        amsAddr{netID: source, port: 32905},
+		responses:     make(chan *amsPacket, 16),
+		notifications: make(chan *amsPacket, 16),
+	}
+	go func() {
+		defer close(c.responses)
+		for {
+			p, err := readAMSPacket(conn)
+			if err != nil {
+				return
+			}
+			if p.isResponse() {
+				c.responses <- p
+			} else {
+				c.notifications <- p
+			}
+		}
+	}()
+	return c
+}
+
+// request sends an ADS request and returns the ADS result code and the payload after it.
+func (c *amsTestClient) request(command uint16, data []byte) (uint32, []byte) {
+	c.t.Helper()
+	c.invokeID++
+	p := &amsPacket{target: c.target, source: c.source, command: command, stateFlags: amsFlagADSCommand, invokeID: c.invokeID, data: data}
+	if _, err := c.conn.Write(p.marshal()); err != nil {
+		c.t.Fatal(err)
+	}
+	select {
+	case r := <-c.responses:
+		if r == nil {
+			c.t.Fatal("connection closed")
+		}
+		if r.invokeID != c.invokeID || r.command != command || r.errorCode != 0 || len(r.data) < 4 {
+			c.t.Fatalf("response %+v to command %d with invoke ID %d", r, command, c.invokeID)
+		}
+		return binary.LittleEndian.Uint32(r.data), r.data[4:]
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("no response to command %d", command)
+		return 0, nil
+	}
+}
+
+// read sends an ADS Read and returns the result code and the data read.
+func (c *amsTestClient) read(group, offset, length uint32) (uint32, []byte) {
+	c.t.Helper()
+	req := binary.LittleEndian.AppendUint32(nil, group)
+	req = binary.LittleEndian.AppendUint32(req, offset)
+	req = binary.LittleEndian.AppendUint32(req, length)
+	code, payload := c.request(adsCmdRead, req)
+	if code != 0 {
+		return code, nil
+	}
+	return code, payload[4:]
+}
+
+// readWrite sends an ADS ReadWrite with a symbol name, as the symbol lookups do.
+func (c *amsTestClient) readWrite(group, readLen uint32, name string) (uint32, []byte) {
+	c.t.Helper()
+	req := binary.LittleEndian.AppendUint32(nil, group)
+	req = binary.LittleEndian.AppendUint32(req, 0)
+	req = binary.LittleEndian.AppendUint32(req, readLen)
+	req = binary.LittleEndian.AppendUint32(req, uint32(len(name)+1))
+	code, payload := c.request(adsCmdReadWrite, append(append(req, name...), 0))
+	if code != 0 {
+		return code, nil
+	}
+	return code, payload[4:]
+}
+
+func (c *amsTestClient) write(group, offset uint32, data []byte) uint32 {
+	c.t.Helper()
+	req := binary.LittleEndian.AppendUint32(nil, group)
+	req = binary.LittleEndian.AppendUint32(req, offset)
+	req = binary.LittleEndian.AppendUint32(req, uint32(len(data)))
+	code, _ := c.request(adsCmdWrite, append(req, data...))
+	return code
+}
+
+// nextSample waits for a device notification and returns its handle and data.
+func (c *amsTestClient) nextSample() (uint32, []byte) {
+	c.t.Helper()
+	select {
+	case p := <-c.notifications:
+		le := binary.LittleEndian
+		// length, stamps, timestamp, samples, then handle, size and data of the first sample
+		if len(p.data) < 28 || p.command != adsCmdDeviceNotification {
+			c.t.Fatalf("notification %+v", p)
+		}
+		return le.Uint32(p.data[20:]), p.data[28 : 28+le.Uint32(p.data[24:])]
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("no notification")
+		return 0, nil
+	}
+}
+
+func newTestServerOutput(t *testing.T) (*adsServerOutput, *amsTestClient) {
+	t.Helper()
+	localAMS, _ := parseAMSNetID("10.0.0.1.1.1")
+	symbolName, err := service.NewInterpolatedString(`${! meta("symbol_name") }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &adsServerOutput{
+		conf:          adsServerConfig{listenAddress: "127.0.0.1:0", localAMS: localAMS, localPort: 852},
+		symbolName:    symbolName,
+		log:           service.MockResources().Logger(),
+		byName:        map[string]*virtualSymbol{},
+		handles:       map[uint32]*virtualSymbol{},
+		notifications: map[uint32]*virtualNotification{},
+	}
+	for _, sym := range []*virtualSymbol{
+		{name: "Forecast.temperature", dataType: "LREAL", typeID: adsTypeIDs["LREAL"], value: make([]byte, 8)},
+		{name: "Forecast.count", dataType: "DINT", typeID: adsTypeIDs["DINT"], value: make([]byte, 4)},
+	} {
+		sym.offset = o.areaSize
+		o.areaSize += uint32(len(sym.value))
+		o.byName[strings.ToLower(sym.name)] = sym
+		o.symbols = append(o.symbols, sym)
+	}
+	if err := o.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = o.Close(context.Background()) })
+	return o, dialAMSTestClient(t, o.server.listener.Addr(), amsAddr{netID: localAMS, port: 852})
+}
+
+func writeServerSymbol(t *testing.T, o *adsServerOutput, name, value string) {
+	t.Helper()
+	msg := service.NewMessage([]byte(value))
+	msg.MetaSet("symbol_name", name)
+	if err := o.Write(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerOutputReadsByNameAndHandle(t *testing.T) {
+	o, plc := newTestServerOutput(t)
+	le := binary.LittleEndian
+	writeServerSymbol(t, o, "forecast.TEMPERATURE", "21.5")
+	writeServerSymbol(t, o, "Forecast.count", "42")
+
+	code, data := plc.readWrite(indexGroupSymValueByName, 8, "FORECAST.temperature")
+	if code != 0 || math.Float64frombits(le.Uint64(data)) != 21.5 {
+		t.Errorf("value by name = %v (code 0x%X), want 21.5", data, code)
+	}
+	if code, _ = plc.readWrite(indexGroupSymValueByName, 8, "Forecast.missing"); code != adsErrDeviceSymbolNotFound {
+		t.Errorf("unknown name: code 0x%X, want 0x%X", code, adsErrDeviceSymbolNotFound)
+	}
+	code, data = plc.readWrite(indexGroupSymInfoByNameEx, 256, "Forecast.count")
+	if code != 0 || le.Uint32(data[4:]) != indexGroupVirtualData || le.Uint32(data[8:]) != 8 || le.Uint32(data[12:]) != 4 {
+		t.Errorf("symbol info = %v (code 0x%X), want group 0x4040 offset 8 size 4", data, code)
+	}
+
+	code, data = plc.readWrite(indexGroupSymHandleByName, 4, "Forecast.count")
+	if code != 0 || len(data) != 4 {
+		t.Fatalf("handle by name: %v (code 0x%X)", data, code)
+	}
+	handle := le.Uint32(data)
+	if code, data = plc.read(indexGroupSymValueByHandle, handle, 4); code != 0 || int32(le.Uint32(data)) != 42 {
+		t.Errorf("value by handle = %v (code 0x%X), want 42", data, code)
+	}
+	writeServerSymbol(t, o, "Forecast.count", "-7")
+	if code, data = plc.read(indexGroupSymValueByHandle, handle, 4); code != 0 || int32(le.Uint32(data)) != -7 {
+		t.Errorf("value by handle after write = %v (code 0x%X), want -7", data, code)
+	}
+
+	// Released handles are gone; other writes are rejected, the symbols are read-only.
+	if code = plc.write(indexGroupSymReleaseHandle, 0, le.AppendUint32(nil, handle)); code != 0 {
+		t.Errorf("release handle: code 0x%X", code)
+	}
+	if code, _ = plc.read(indexGroupSymValueByHandle, handle, 4); code != adsErrDeviceSymbolNotFound {
+		t.Errorf("released handle: code 0x%X, want 0x%X", code, adsErrDeviceSymbolNotFound)
+	}
+	if code = plc.write(indexGroupVirtualData, 8, []byte{1, 0, 0, 0}); code != adsErrDeviceInvalidAccess {
+		t.Errorf("write: code 0x%X, want 0x%X", code, adsErrDeviceInvalidAccess)
+	}
+}
+
+func TestServerOutputReadsByAddress(t *testing.T) {
+	o, plc := newTestServerOutput(t)
+	le := binary.LittleEndian
+	writeServerSymbol(t, o, "Forecast.temperature", "-3.25")
+	writeServerSymbol(t, o, "Forecast.count", "1000")
+
+	code, data := plc.read(indexGroupVirtualData, 0, 12)
+	if code != 0 || math.Float64frombits(le.Uint64(data)) != -3.25 || le.Uint32(data[8:]) != 1000 {
+		t.Errorf("data area = %v (code 0x%X)", data, code)
+	}
+	if code, _ = plc.read(indexGroupVirtualData, 8, 8); code != adsErrDeviceInvalidOffset {
+		t.Errorf("read past the area: code 0x%X, want 0x%X", code, adsErrDeviceInvalidOffset)
+	}
+	if code, _ = plc.read(0x4020, 0, 4); code != adsErrDeviceInvalidGroup {
+		t.Errorf("other index group: code 0x%X, want 0x%X", code, adsErrDeviceInvalidGroup)
+	}
+}
+
+func TestServerOutputNotifications(t *testing.T) {
+	o, plc := newTestServerOutput(t)
+	le := binary.LittleEndian
+	writeServerSymbol(t, o, "Forecast.count", "1")
+
+	// On-change notification on Forecast.count by address.
+	req := le.AppendUint32(nil, indexGroupVirtualData)
+	req = le.AppendUint32(req, 8)
+	req = le.AppendUint32(req, 4)
+	req = le.AppendUint32(req, 4) // ADSTRANS_SERVERONCHA
+	req = le.AppendUint32(req, 0)
+	req = le.AppendUint32(req, 0)
+	req = append(req, make([]byte, 16)...)
+	code, data := plc.request(adsCmdAddNotification, req)
+	if code != 0 || len(data) != 4 {
+		t.Fatalf("add notification: %v (code 0x%X)", data, code)
+	}
+	handle := le.Uint32(data)
+
+	if h, sample := plc.nextSample(); h != handle || le.Uint32(sample) != 1 {
+		t.Errorf("initial sample = handle %d value %v, want handle %d value 1", h, sample, handle)
+	}
+	// Neither an unchanged value nor another symbol sends a sample, so the next one is 2.
+	writeServerSymbol(t, o, "Forecast.count", "1")
+	writeServerSymbol(t, o, "Forecast.temperature", "20")
+	writeServerSymbol(t, o, "Forecast.count", "2")
+	if h, sample := plc.nextSample(); h != handle || le.Uint32(sample) != 2 {
+		t.Errorf("sample after write = handle %d value %v, want handle %d value 2", h, sample, handle)
+	}
+
+	if code, _ = plc.request(adsCmdDeleteNotification, le.AppendUint32(nil, handle)); code != 0 {
+		t.Errorf("delete notification: code 0x%X", code)
+	}
+	if code, _ = plc.request(adsCmdDeleteNotification, le.AppendUint32(nil, handle)); code != adsErrDeviceNotifyHandle {
+		t.Errorf("second delete: code 0x%X, want 0x%X", code, adsErrDeviceNotifyHandle)
+	}
+	writeServerSymbol(t, o, "Forecast.count", "3")
+	select {
+	case p := <-plc.notifications:
+		t.Errorf("sample %+v after the notification was deleted", p)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Registrations outside the data area fail without a handle.
+	req = le.AppendUint32(nil, indexGroupVirtualData)
+	req = le.AppendUint32(req, 12)
+	req = le.AppendUint32(req, 4)
+	req = append(req, make([]byte, 28)...)
+	if code, _ = plc.request(adsCmdAddNotification, req); code != adsErrDeviceInvalidOffset {
+		t.Errorf("notification past the area: code 0x%X, want 0x%X", code, adsErrDeviceInvalidOffset)
+	}
+}
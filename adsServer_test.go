@@ -0,0 +1,67 @@
+package benthosADS
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+func TestServerInputCloseRejectsQueuedWrites(t *testing.T) {
+	a := &adsServerInput{
+		nackCode: 0x707,
+		log:      service.MockResources().Logger(),
+		writes:   make(chan adsServerWrite, 64),
+		done:     make(chan struct{}),
+	}
+	server, peer := net.Pipe()
+	defer peer.Close()
+	c := &amsConn{conn: server}
+
+	write := func(invokeID uint32, command uint16) *amsPacket {
+		data := binary.LittleEndian.AppendUint32(nil, 0x4020)
+		data = binary.LittleEndian.AppendUint32(data, 0)
+		if command == adsCmdReadWrite {
+			data = binary.LittleEndian.AppendUint32(data, 0)
+		}
+		data = binary.LittleEndian.AppendUint32(data, 2)
+		return &amsPacket{command: command, stateFlags: amsFlagADSCommand, invokeID: invokeID, data: append(data, 1, 0)}
+	}
+	a.handle(c, write(1, adsCmdWrite))
+	a.handle(c, write(2, adsCmdReadWrite))
+
+	responses := make(chan *amsPacket, 3)
+	go func() {
+		for {
+			p, err := readAMSPacket(peer)
+			if err != nil {
+				close(responses)
+				return
+			}
+			responses <- p
+		}
+	}()
+	if err := a.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	a.handle(c, write(3, adsCmdWrite)) // after Close: rejected at once
+
+	for _, want := range []struct {
+		invokeID uint32
+		dataLen  int
+	}{{1, 4}, {2, 8}, {3, 4}} {
+		p := <-responses
+		if p == nil {
+			t.Fatalf("write %d was not answered", want.invokeID)
+		}
+		if p.invokeID != want.invokeID || !p.isResponse() || len(p.data) != want.dataLen ||
+			binary.LittleEndian.Uint32(p.data) != 0x707 {
+			t.Errorf("response %+v, want invoke ID %d with nackCode and %d bytes", p, want.invokeID, want.dataLen)
+		}
+	}
+	if _, _, err := a.ReadBatch(context.Background()); err != service.ErrEndOfInput {
+		t.Errorf("ReadBatch after Close: %v, want ErrEndOfInput", err)
+	}
+}
@@ -0,0 +1,189 @@
+package benthosADS
+
+import (
+	"context"
+	"time"
+
+	adsLib "github.com/RuneRoven/go-ads/v2"
+)
+
+// allocateNotifications splits the configured symbols into those registered as notifications
+// and those demoted to polling because they exceed maxNotificationsPerConnection. Symbols are
+// prioritised in configuration order, so the last listed symbols are the first to be polled.
+func (g *adsCommInput) allocateNotifications() (notify, polled []plcSymbol) {
+	limit := g.maxNotificationsPerConnection
+	if limit <= 0 || len(g.symbols) <= limit || g.notificationOverflow != "poll" {
+		return g.symbols, nil
+	}
+	return g.symbols[:limit], g.symbols[limit:]
+}
+
+// registerShardedNotifications registers symbols in chunks of maxNotificationsPerConnection.
+// The first chunk uses the input's session; every further chunk gets a session of its own.
+// Symbols that failed are returned with the session they failed on.
+func (g *adsCommInput) registerShardedNotifications(ctx context.Context, symbols []plcSymbol) ([]string, []pendingSymbols, error) {
+	limit := g.maxNotificationsPerConnection
+	if limit <= 0 {
+		limit = len(symbols)
+	}
+	var chunks [][]plcSymbol
+	for start := 0; start < len(symbols); start += limit {
+		end := start + limit
+		if end > len(symbols) {
+			end = len(symbols)
+		}
+		chunks = append(chunks, symbols[start:end])
+	}
+
+	var registered []string
+	var pending []pendingSymbols
+	for i, chunk := range chunks {
+		session := g.handler
+		if i > 0 {
+			var err error
+			if session, err = g.openSession(ctx, g.log); err != nil {
+				g.log.Errorf("Opening notification connection %d/%d failed: %v", i+1, len(chunks), err)
+				return nil, nil, err
+			}
+			g.shards = append(g.shards, session)
+		}
+		names, handles, err := g.registerNotifications(ctx, session, chunk, g.log.Errorf)
+		if err != nil {
+			return nil, nil, err
+		}
+		if failed := unregistered(chunk, names); len(failed) > 0 {
+			pending = append(pending, pendingSymbols{session: session, symbols: failed})
+		}
+		if i == 0 {
+			g.notificationHandles = handles
+		}
+		registered = append(registered, names...)
+		if len(chunks) > 1 {
+			g.log.Infof("Notification connection %d/%d: %d/%d symbols registered", i+1, len(chunks), len(names), len(chunk))
+		}
+	}
+
+	polled := len(g.symbols) - len(symbols)
+	if polled > 0 {
+		g.log.Warnf("%d symbols exceed maxNotificationsPerConnection (%d) and are polled every %v instead", polled, g.maxNotificationsPerConnection, g.intervalTime)
+	}
+	target := g.metricTarget()
+	g.notificationConnections.Set(int64(len(chunks)), target)
+	g.notificationsRegistered.Set(int64(len(registered)), target)
+	g.notificationsPolled.Set(int64(polled), target)
+	return registered, pending, nil
+}
+
+// metricTarget labels the sharding metrics of this input.
+func (g *adsCommInput) metricTarget() string {
+	if g.targetName != "" {
+		return g.targetName
+	}
+	return g.targetAMS
+}
+
+// startOverflowPoll reads the demoted symbols every intervalTime and delivers their values on
+// notificationChan, so they reach the pipeline alongside regular notifications.
+func (g *adsCommInput) startOverflowPoll(symbols []plcSymbol) {
+	session, done := g.handler, g.done
+	stop := make(chan struct{})
+	g.pollStop = stop
+
+	named, raw := splitRawSymbols(symbols)
+	names := make([]string, len(named))
+	for i, symbol := range named {
+		names[i] = symbol.name
+	}
+
+	// Stopping the poller or closing the input cancels a read in flight, so Close doesn't wait
+	// for the request timeout.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		defer cancel()
+		select {
+		case <-stop:
+		case <-done:
+		case <-ctx.Done():
+		}
+	}()
+
+	go func() {
+		defer cancel()
+		ticker := time.NewTicker(g.intervalTime)
+		defer ticker.Stop()
+		sumUnsupported := false
+		for {
+			values := map[string]string{}
+			var rawData map[string][]byte
+			var err error
+			if len(names) > 0 {
+				values, err = session.ReadMultipleSymbols(ctx, names)
+			}
+			if err == nil && len(raw) > 0 {
+				var rawValues map[string]string
+				if rawValues, rawData, err = g.readRawValues(ctx, session, raw, &sumUnsupported); err == nil {
+					for name, val := range rawValues {
+						values[name] = val
+					}
+				}
+			}
+			if err != nil {
+				if session.IsClosed() || ctx.Err() != nil {
+					return
+				}
+				g.log.Warnf("Polling %d overflow symbols failed (will retry): %v", len(symbols), err)
+			}
+			for _, symbol := range symbols {
+				val, ok := values[symbol.name]
+				if !ok {
+					continue
+				}
+				update := &adsLib.Update{Variable: symbol.name, Value: val}
+				if data, ok := rawData[symbol.name]; ok {
+					g.capture.hold(update, data, time.Time{})
+				}
+				select {
+				case g.notificationChan <- update:
+				case <-ctx.Done():
+					g.capture.take(update)
+					return
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stopSharding stops the symbol retry and overflow poller and closes the extra notification
+// connections.
+func (g *adsCommInput) stopSharding() {
+	g.stopSymbolRetry()
+	if g.pollStop != nil {
+		close(g.pollStop)
+		g.pollStop = nil
+	}
+	for _, session := range g.shards {
+		if cerr := session.Close(); cerr != nil {
+			g.log.Warnf("Handler close error: %v", cerr)
+		}
+	}
+	g.shards = nil
+}
+
+// connectionLost reports whether the input's session or any notification connection has closed.
+func (g *adsCommInput) connectionLost() bool {
+	if g.handler != nil && g.handler.IsClosed() {
+		return true
+	}
+	for _, session := range g.shards {
+		if session.IsClosed() {
+			return true
+		}
+	}
+	return false
+}
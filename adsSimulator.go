@@ -0,0 +1,452 @@
+package benthosADS
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// Value generators of the ads_simulator input.
+const (
+	simGeneratorConstant   = "constant"
+	simGeneratorSine       = "sine"
+	simGeneratorRamp       = "ramp"
+	simGeneratorRandom     = "random"
+	simGeneratorRandomWalk = "randomWalk"
+	simGeneratorToggle     = "toggle"
+	simGeneratorCounter    = "counter"
+)
+
+// simulatorGeneratorFields are the fields describing how one value is generated, shared by
+// symbols and struct members.
+func simulatorGeneratorFields() []*service.ConfigField {
+	return []*service.ConfigField{
+		service.NewStringField("name").Description("Symbol name, or member name inside fields."),
+		service.NewStringField("type").Description("PLC type: a primitive such as LREAL, DINT or BOOL, STRING(n), or a struct type name when fields are set."),
+		service.NewStringField("generator").Description("constant, sine, ramp, random, randomWalk, toggle or counter.").Default(simGeneratorConstant),
+		service.NewFloatField("min").Description("Lower bound of the generated value.").Default(0.0),
+		service.NewFloatField("max").Description("Upper bound of the generated value.").Default(100.0),
+		service.NewIntField("period").Description("Period of sine and ramp, and time between toggles, in milliseconds.").Default(60000),
+		service.NewFloatField("step").Description("Increment of counter and largest change per update of randomWalk.").Default(1.0),
+		service.NewStringField("value").Description("Value of the constant generator, e.g. '42', 'true' or a string.").Default(""),
+	}
+}
+
+var adsSimulatorConf = service.NewConfigSpec().
+	Summary("Generates PLC symbol data from a spec, in the same shape as the ads input, for pipeline development without hardware.").
+	Description("Messages carry the same content and metadata as messages of the ads input (symbol_name, data_type, base_type, " +
+		"data_size): primitives as their string form, structs as JSON. In notification mode every symbol is updated every " +
+		"cycleTime and emitted when its value changed, after an initial sample of all symbols; in interval mode all symbols " +
+		"are emitted every intervalTime.").
+	Field(service.NewStringField("readType").Description("notification (default) or interval, as in the ads input.").Default("notification")).
+	Field(service.NewIntField("cycleTime").Description("Update interval of each symbol in notification mode, in milliseconds.").Default(1000)).
+	Field(service.NewIntField("intervalTime").Description("Interval between batches in interval mode, in milliseconds.").Default(1000)).
+	Field(service.NewIntField("seed").Description("Seed of the random generators. 0 uses a random seed; any other value repeats the same data on every run.").Default(0)).
+	Field(service.NewObjectListField("symbols", append(simulatorGeneratorFields(),
+		service.NewIntField("cycleTime").Description("Update interval of this symbol in notification mode. 0 uses the input's cycleTime.").Default(0),
+		service.NewObjectListField("fields", simulatorGeneratorFields()...).Description("Members of a struct symbol, each with its own generator. The struct is emitted as a JSON object.").Optional(),
+	)...).Description("Simulated symbols."))
+
+func init() {
+	err := service.RegisterBatchInput(
+		"ads_simulator", adsSimulatorConf,
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
+			return newAdsSimulator(conf, mgr)
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// simValue generates one primitive value.
+type simValue struct {
+	name      string
+	dataType  string
+	generator string
+	min, max  float64
+	period    time.Duration
+	step      float64
+	constant  string
+
+	current float64 // state of counter and randomWalk
+	started bool
+}
+
+// simSymbol is one simulated symbol, a primitive or a struct of primitives.
+type simSymbol struct {
+	simValue
+	fields    []*simValue
+	size      uint32
+	cycleTime time.Duration
+
+	last string
+	due  time.Time
+}
+
+type adsSimulator struct {
+	readType     string
+	intervalTime time.Duration
+	symbols      []*simSymbol
+	rng          *rand.Rand
+	log          *service.Logger
+
+	start time.Time
+	next  time.Time // interval mode
+	done  chan struct{}
+}
+
+func parseSimValue(conf *service.ParsedConfig) (*simValue, error) {
+	v := &simValue{}
+	var err error
+	if v.name, err = conf.FieldString("name"); err != nil {
+		return nil, err
+	}
+	if v.dataType, err = conf.FieldString("type"); err != nil {
+		return nil, err
+	}
+	v.dataType = strings.TrimSpace(v.dataType)
+	if upper := strings.ToUpper(v.dataType); isPrimitive(upper) {
+		v.dataType = upper
+	}
+	if v.generator, err = conf.FieldString("generator"); err != nil {
+		return nil, err
+	}
+	switch v.generator {
+	case simGeneratorConstant, simGeneratorSine, simGeneratorRamp, simGeneratorRandom, simGeneratorRandomWalk, simGeneratorToggle, simGeneratorCounter:
+	default:
+		return nil, fmt.Errorf("%s: unknown generator %q", v.name, v.generator)
+	}
+	if v.min, err = conf.FieldFloat("min"); err != nil {
+		return nil, err
+	}
+	if v.max, err = conf.FieldFloat("max"); err != nil {
+		return nil, err
+	}
+	if v.max < v.min {
+		return nil, fmt.Errorf("%s: max %v is below min %v", v.name, v.max, v.min)
+	}
+	period, err := conf.FieldInt("period")
+	if err != nil {
+		return nil, err
+	}
+	if period <= 0 {
+		return nil, fmt.Errorf("%s: period must be positive", v.name)
+	}
+	v.period = time.Duration(period) * time.Millisecond
+	if v.step, err = conf.FieldFloat("step"); err != nil {
+		return nil, err
+	}
+	if v.constant, err = conf.FieldString("value"); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func newAdsSimulator(conf *service.ParsedConfig, mgr *service.Resources) (*adsSimulator, error) {
+	readType, err := conf.FieldString("readType")
+	if err != nil {
+		return nil, err
+	}
+	if readType != "notification" && readType != "interval" {
+		return nil, errors.New("readType must be 'notification' or 'interval'")
+	}
+	cycleTime, err := conf.FieldInt("cycleTime")
+	if err != nil {
+		return nil, err
+	}
+	intervalTime, err := conf.FieldInt("intervalTime")
+	if err != nil {
+		return nil, err
+	}
+	if cycleTime <= 0 || intervalTime <= 0 {
+		return nil, errors.New("cycleTime and intervalTime must be positive")
+	}
+	seed, err := conf.FieldInt("seed")
+	if err != nil {
+		return nil, err
+	}
+	if seed == 0 {
+		seed = int(time.Now().UnixNano())
+	}
+
+	entries, err := conf.FieldObjectList("symbols")
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("symbols must not be empty")
+	}
+	s := &adsSimulator{
+		readType:     readType,
+		intervalTime: time.Duration(intervalTime) * time.Millisecond,
+		rng:          rand.New(rand.NewSource(int64(seed))),
+		log:          mgr.Logger(),
+		done:         make(chan struct{}),
+	}
+	for i, e := range entries {
+		v, verr := parseSimValue(e)
+		if verr != nil {
+			return nil, fmt.Errorf("symbols[%d]: %w", i, verr)
+		}
+		sym := &simSymbol{simValue: *v}
+		ct, cerr := e.FieldInt("cycleTime")
+		if cerr != nil {
+			return nil, cerr
+		}
+		if ct <= 0 {
+			ct = cycleTime
+		}
+		sym.cycleTime = time.Duration(ct) * time.Millisecond
+
+		if e.Contains("fields") {
+			fields, ferr := e.FieldObjectList("fields")
+			if ferr != nil {
+				return nil, ferr
+			}
+			for j, f := range fields {
+				fv, fverr := parseSimValue(f)
+				if fverr != nil {
+					return nil, fmt.Errorf("symbols[%d].fields[%d]: %w", i, j, fverr)
+				}
+				sym.fields = append(sym.fields, fv)
+			}
+		}
+		if sym.size, err = sym.layoutSize(); err != nil {
+			return nil, fmt.Errorf("symbols[%d]: %w", i, err)
+		}
+		s.symbols = append(s.symbols, sym)
+	}
+	return s, nil
+}
+
+// primitiveSize returns the size of a primitive or STRING type.
+func primitiveSize(dataType string) (uint32, bool) {
+	if strings.HasPrefix(dataType, "STRING") {
+		return stringTypeSize(dataType), true
+	}
+	size, ok := rawTypeSizes[dataType]
+	return size, ok
+}
+
+// layoutSize returns the byte size of the symbol, laying out struct members with natural
+// alignment up to 8 bytes as TwinCAT 3 does on x64.
+func (s *simSymbol) layoutSize() (uint32, error) {
+	if len(s.fields) == 0 {
+		size, ok := primitiveSize(s.dataType)
+		if !ok {
+			return 0, fmt.Errorf("%s: unsupported type %q (structs need fields)", s.name, s.dataType)
+		}
+		return size, nil
+	}
+	var size, maxAlign uint32 = 0, 1
+	for _, f := range s.fields {
+		fs, ok := primitiveSize(f.dataType)
+		if !ok {
+			return 0, fmt.Errorf("%s.%s: unsupported type %q", s.name, f.name, f.dataType)
+		}
+		align := min(fs, 8)
+		if strings.HasPrefix(f.dataType, "STRING") {
+			align = 1
+		}
+		size = (size + align - 1) / align * align
+		size += fs
+		maxAlign = max(maxAlign, align)
+	}
+	return (size + maxAlign - 1) / maxAlign * maxAlign, nil
+}
+
+// generate returns the next value at elapsed time t, in the string form the ads input emits.
+func (v *simValue) generate(t time.Duration, rng *rand.Rand) (string, error) {
+	if v.generator == simGeneratorConstant {
+		if v.constant == "" && !strings.HasPrefix(v.dataType, "STRING") {
+			return v.format(v.min)
+		}
+		if strings.HasPrefix(v.dataType, "STRING") {
+			return v.constant, nil
+		}
+		raw, err := encodeRawValue(v.dataType, v.constant)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", v.name, err)
+		}
+		return decodeRawValue(v.dataType, raw)
+	}
+
+	span := v.max - v.min
+	phase := float64(t%v.period) / float64(v.period)
+	var x float64
+	switch v.generator {
+	case simGeneratorSine:
+		x = v.min + span*(1+math.Sin(2*math.Pi*phase))/2
+	case simGeneratorRamp:
+		x = v.min + span*phase
+	case simGeneratorRandom:
+		x = v.min + span*rng.Float64()
+	case simGeneratorRandomWalk:
+		if !v.started {
+			v.current = v.min + span/2
+		} else {
+			v.current += v.step * (2*rng.Float64() - 1)
+		}
+		x = math.Max(v.min, math.Min(v.max, v.current))
+		v.current = x
+	case simGeneratorCounter:
+		if !v.started {
+			v.current = v.min
+		} else if v.current += v.step; span > 0 && v.current > v.max {
+			v.current = v.min
+		}
+		x = v.current
+	case simGeneratorToggle:
+		if (t/v.period)%2 == 1 {
+			x = 1
+		}
+		if v.dataType != "BOOL" {
+			x = v.min + span*x
+		}
+	}
+	v.started = true
+	return v.format(x)
+}
+
+// format converts x to the string form of the value's type, going through the PLC's raw
+// representation so values are rounded and clamped like real PLC data.
+func (v *simValue) format(x float64) (string, error) {
+	if strings.HasPrefix(v.dataType, "STRING") {
+		return strconv.FormatFloat(x, 'g', -1, 64), nil
+	}
+	size, ok := rawTypeSizes[v.dataType]
+	if !ok {
+		return "", fmt.Errorf("%s: unsupported type %q", v.name, v.dataType)
+	}
+	le := binary.LittleEndian
+	buf := make([]byte, 8)
+	switch v.dataType {
+	case "BOOL":
+		if x >= 0.5 {
+			buf[0] = 1
+		}
+	case "REAL":
+		le.PutUint32(buf, math.Float32bits(float32(x)))
+	case "LREAL":
+		le.PutUint64(buf, math.Float64bits(x))
+	case "SINT", "INT", "DINT", "LINT":
+		limit := math.Ldexp(1, int(size)*8-1)
+		le.PutUint64(buf, uint64(int64(math.Max(-limit, math.Min(limit-1, math.Round(x))))))
+	default:
+		limit := math.Ldexp(1, int(size)*8) - 1
+		le.PutUint64(buf, uint64(math.Max(0, math.Min(limit, math.Round(x)))))
+	}
+	return decodeRawValue(v.dataType, buf[:size])
+}
+
+// value generates the symbol's value; structs become a JSON object like the ads input
+// decodes them.
+func (s *simSymbol) value(t time.Duration, rng *rand.Rand) (string, error) {
+	if len(s.fields) == 0 {
+		return s.generate(t, rng)
+	}
+	obj := make(map[string]any, len(s.fields))
+	for _, f := range s.fields {
+		v, err := f.generate(t, rng)
+		if err != nil {
+			return "", err
+		}
+		obj[f.name] = jsonPrimitive(f.dataType, v)
+	}
+	b, err := json.Marshal(obj)
+	return string(b), err
+}
+
+func (s *adsSimulator) Connect(ctx context.Context) error {
+	if s.start.IsZero() {
+		s.start = time.Now()
+		s.next = s.start
+		for _, sym := range s.symbols {
+			sym.due = s.start
+		}
+		s.log.Infof("Simulating %d symbols in %s mode", len(s.symbols), s.readType)
+	}
+	return nil
+}
+
+func (s *adsSimulator) makeMessage(sym *simSymbol, value string) *service.Message {
+	msg := service.NewMessage([]byte(value))
+	msg.MetaSet("symbol_name", sanitize(sym.name))
+	msg.MetaSet("data_type", sym.dataType)
+	msg.MetaSet("base_type", sym.dataType)
+	msg.MetaSet("data_size", strconv.FormatUint(uint64(sym.size), 10))
+	return msg
+}
+
+func (s *adsSimulator) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	for {
+		due := s.next
+		if s.readType == "notification" {
+			due = s.symbols[0].due
+			for _, sym := range s.symbols[1:] {
+				if sym.due.Before(due) {
+					due = sym.due
+				}
+			}
+		}
+		if wait := time.Until(due); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			case <-s.done:
+				return nil, nil, service.ErrEndOfInput
+			}
+		}
+
+		now := time.Now()
+		t := now.Sub(s.start)
+		var batch service.MessageBatch
+		for _, sym := range s.symbols {
+			if s.readType == "notification" && sym.due.After(now) {
+				continue
+			}
+			value, err := sym.value(t, s.rng)
+			if err != nil {
+				return nil, nil, err
+			}
+			if s.readType == "notification" {
+				for !sym.due.After(now) {
+					sym.due = sym.due.Add(sym.cycleTime)
+				}
+				// Like serverOnChange notifications, unchanged values are not sent again.
+				if value == sym.last {
+					continue
+				}
+			}
+			sym.last = value
+			batch = append(batch, s.makeMessage(sym, value))
+		}
+		if s.readType == "interval" {
+			for !s.next.After(now) {
+				s.next = s.next.Add(s.intervalTime)
+			}
+		}
+		if len(batch) > 0 {
+			return batch, func(context.Context, error) error { return nil }, nil
+		}
+	}
+}
+
+func (s *adsSimulator) Close(ctx context.Context) error {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package benthosADS
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+func TestSimulatorGenerators(t *testing.T) {
+	s := time.Second
+	tests := []struct {
+		name  string
+		value simValue
+		times []time.Duration // generate is called at each time in turn
+		want  []string
+	}{
+		{"constant", simValue{dataType: "DINT", generator: simGeneratorConstant, constant: "42"}, []time.Duration{0, s}, []string{"42", "42"}},
+		{"constant defaults to min", simValue{dataType: "INT", generator: simGeneratorConstant, min: 7}, []time.Duration{0}, []string{"7"}},
+		{"constant string", simValue{dataType: "STRING(10)", generator: simGeneratorConstant, constant: "ready"}, []time.Duration{0}, []string{"ready"}},
+		{"constant bool", simValue{dataType: "BOOL", generator: simGeneratorConstant, constant: "true"}, []time.Duration{0}, []string{"true"}},
+		{
+			"sine", simValue{dataType: "LREAL", generator: simGeneratorSine, max: 100, period: 4 * s},
+			[]time.Duration{0, s, 3 * s, 4 * s}, []string{"50", "100", "0", "50"},
+		},
+		{
+			"ramp", simValue{dataType: "INT", generator: simGeneratorRamp, min: -10, max: 10, period: 10 * s},
+			[]time.Duration{0, 2500 * time.Millisecond, 9999 * time.Millisecond, 10 * s}, []string{"-10", "-5", "10", "-10"},
+		},
+		{
+			"toggle bool", simValue{dataType: "BOOL", generator: simGeneratorToggle, max: 1, period: s},
+			[]time.Duration{0, 1500 * time.Millisecond, 2 * s}, []string{"false", "true", "false"},
+		},
+		{
+			"toggle between min and max", simValue{dataType: "INT", generator: simGeneratorToggle, min: 10, max: 20, period: s},
+			[]time.Duration{0, 1500 * time.Millisecond}, []string{"10", "20"},
+		},
+		{
+			"counter wraps after max", simValue{dataType: "UDINT", generator: simGeneratorCounter, min: 1, max: 3, step: 1, period: s},
+			[]time.Duration{0, 0, 0, 0, 0}, []string{"1", "2", "3", "1", "2"},
+		},
+		{
+			"counter is clamped to the type", simValue{dataType: "SINT", generator: simGeneratorCounter, min: 120, max: 200, step: 5, period: s},
+			[]time.Duration{0, 0, 0}, []string{"120", "125", "127"},
+		},
+		{
+			"unsigned types stop at zero", simValue{dataType: "USINT", generator: simGeneratorRamp, min: -50, max: 50, period: s},
+			[]time.Duration{0, 750 * time.Millisecond}, []string{"0", "25"},
+		},
+		{
+			"REAL is rounded to float32", simValue{dataType: "REAL", generator: simGeneratorConstant, min: 0.1},
+			[]time.Duration{0}, []string{"0.1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			v := tt.value
+			var got []string
+			for _, at := range tt.times {
+				s, err := v.generate(at, rng)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, s)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimulatorRandomGeneratorsStayInBounds(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := simValue{dataType: "LREAL", generator: simGeneratorRandom, min: -5, max: 5, period: time.Second}
+	walk := simValue{dataType: "LREAL", generator: simGeneratorRandomWalk, min: 0, max: 10, step: 3, period: time.Second}
+	if first, _ := walk.generate(0, rng); first != "5" {
+		t.Errorf("randomWalk starts at %s, want the middle of the range", first)
+	}
+	prev := 5.0
+	for i := 0; i < 1000; i++ {
+		r, _ := random.generate(0, rng)
+		if x, _ := strconv.ParseFloat(r, 64); x < -5 || x > 5 {
+			t.Fatalf("random value %v outside [-5, 5]", x)
+		}
+		w, _ := walk.generate(0, rng)
+		x, _ := strconv.ParseFloat(w, 64)
+		if x < 0 || x > 10 || x-prev > 3 || prev-x > 3 {
+			t.Fatalf("randomWalk moved from %v to %v, want steps of at most 3 within [0, 10]", prev, x)
+		}
+		prev = x
+	}
+}
+
+// simulatorValues reads n interval batches of a simulator with the given seed.
+func simulatorValues(t *testing.T, seed, n int) []string {
+	t.Helper()
+	conf, err := adsSimulatorConf.ParseYAML(`
+readType: interval
+intervalTime: 1
+seed: `+strconv.Itoa(seed)+`
+symbols:
+  - name: MAIN.noise
+    type: LREAL
+    generator: random
+  - name: MAIN.level
+    type: DINT
+    generator: randomWalk
+    step: 10
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim, err := newAdsSimulator(conf, service.MockResources())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close(context.Background())
+	ctx := context.Background()
+	if err = sim.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for i := 0; i < n; i++ {
+		batch, _, err := sim.ReadBatch(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, msg := range batch {
+			b, _ := msg.AsBytes()
+			values = append(values, string(b))
+		}
+	}
+	return values
+}
+
+func TestSimulatorSeed(t *testing.T) {
+	first, second := simulatorValues(t, 7, 20), simulatorValues(t, 7, 20)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("seed 7 produced different data:\n%v\n%v", first, second)
+	}
+	if other := simulatorValues(t, 8, 20); reflect.DeepEqual(first, other) {
+		t.Error("seeds 7 and 8 produced the same data")
+	}
+}
+
+// metadata returns all metadata of msg.
+func metadata(msg *service.Message) map[string]string {
+	meta := map[string]string{}
+	_ = msg.MetaWalk(func(k, v string) error {
+		meta[k] = v
+		return nil
+	})
+	return meta
+}
+
+func TestSimulatorMessagesMatchInput(t *testing.T) {
+	sim := &adsSimulator{}
+	motor := &simSymbol{
+		simValue: simValue{name: "MAIN.motor", dataType: "ST_Motor"},
+		fields: []*simValue{
+			{name: "speed", dataType: "LREAL", generator: simGeneratorConstant, constant: "1450.5"},
+			{name: "running", dataType: "BOOL", generator: simGeneratorConstant, constant: "true"},
+			{name: "count", dataType: "INT", generator: simGeneratorConstant, constant: "-3"},
+		},
+	}
+	counter := &simSymbol{simValue: simValue{name: "MAIN.counter", dataType: "DINT", generator: simGeneratorConstant, constant: "5"}}
+
+	// The ads input as it is set up for the same symbols by a symbol table.
+	in := &adsCommInput{
+		symbolNames: map[string]string{"main.motor": "MAIN.motor", "main.counter": "MAIN.counter"},
+		dataTypes:   map[string]string{"main.motor": "ST_Motor", "main.counter": "DINT"},
+		baseTypes:   map[string]string{"main.motor": "ST_Motor", "main.counter": "DINT"},
+		dataSizes:   map[string]uint32{"main.motor": 16, "main.counter": 4},
+	}
+	for _, tt := range []struct {
+		sym  *simSymbol
+		want string
+	}{
+		{motor, `{"count":-3,"running":true,"speed":1450.5}`},
+		{counter, "5"},
+	} {
+		var err error
+		if tt.sym.size, err = tt.sym.layoutSize(); err != nil {
+			t.Fatal(err)
+		}
+		value, err := tt.sym.value(0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != tt.want {
+			t.Errorf("%s = %s, want %s", tt.sym.name, value, tt.want)
+		}
+		got, want := sim.makeMessage(tt.sym, value), in.makeMessage(tt.sym.name, tt.want)
+		if gm, wm := metadata(got), metadata(want); !reflect.DeepEqual(gm, wm) {
+			t.Errorf("%s metadata = %v, want %v as from the ads input", tt.sym.name, gm, wm)
+		}
+	}
+}
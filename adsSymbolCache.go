@@ -0,0 +1,313 @@
+package benthosADS
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	adsLib "github.com/RuneRoven/go-ads/v2"
+)
+
+// Index groups of the ADS symbol upload services.
+const (
+	indexGroupSymVersion     = 0xF008
+	indexGroupSymUpload      = 0xF00B
+	indexGroupDataTypeUpload = 0xF00E
+	indexGroupSymUploadInfo2 = 0xF00F
+)
+
+// Flags of AdsSymbolEntry marking optional data after the comment.
+const (
+	symbolFlagTypeGUID   = 0x0008
+	symbolFlagAttributes = 0x1000
+)
+
+// symbolUploadInfo is the ADSIGRP_SYM_UPLOADINFO2 header. Together with the symbol version it
+// identifies a PLC program: any online change or download changes at least one of the fields.
+type symbolUploadInfo struct {
+	Symbols      uint32 `json:"symbols"`
+	SymbolSize   uint32 `json:"symbolSize"`
+	DataTypes    uint32 `json:"dataTypes"`
+	DataTypeSize uint32 `json:"dataTypeSize"`
+	Version      uint8  `json:"version"`
+}
+
+// symbolCacheFile is the on-disk format of a cached symbol table.
+type symbolCacheFile struct {
+	TargetAMS   string           `json:"targetAMS"`
+	RuntimePort int              `json:"runtimePort"`
+	Info        symbolUploadInfo `json:"info"`
+	Table       *symbolTable     `json:"table"`
+}
+
+// readUploadInfo reads the symbol version and upload sizes. Both are small reads that do
+// not disturb the PLC, unlike the table upload itself.
+func readUploadInfo(ctx context.Context, session *adsLib.Session) (symbolUploadInfo, error) {
+	var info symbolUploadInfo
+	data, err := session.Read(ctx, indexGroupSymUploadInfo2, 0, 24)
+	if err != nil {
+		return info, fmt.Errorf("reading upload info: %w", err)
+	}
+	if len(data) < 16 {
+		return info, fmt.Errorf("upload info too short: %d bytes", len(data))
+	}
+	le := binary.LittleEndian
+	info.Symbols = le.Uint32(data[0:])
+	info.SymbolSize = le.Uint32(data[4:])
+	info.DataTypes = le.Uint32(data[8:])
+	info.DataTypeSize = le.Uint32(data[12:])
+
+	version, err := session.Read(ctx, indexGroupSymVersion, 0, 1)
+	if err != nil {
+		return info, fmt.Errorf("reading symbol version: %w", err)
+	}
+	if len(version) > 0 {
+		info.Version = version[0]
+	}
+	return info, nil
+}
+
+// uploadSymbolTable downloads the full symbol and datatype tables and parses them into a symbolTable.
+func uploadSymbolTable(ctx context.Context, session *adsLib.Session, info symbolUploadInfo) (*symbolTable, error) {
+	symData, err := session.Read(ctx, indexGroupSymUpload, 0, info.SymbolSize)
+	if err != nil {
+		return nil, fmt.Errorf("uploading symbols: %w", err)
+	}
+	typeData, err := session.Read(ctx, indexGroupDataTypeUpload, 0, info.DataTypeSize)
+	if err != nil {
+		return nil, fmt.Errorf("uploading datatypes: %w", err)
+	}
+
+	t := &symbolTable{
+		Symbols:   make(map[string]*symbolInfo, info.Symbols),
+		DataTypes: make(map[string]*dataTypeInfo, info.DataTypes),
+	}
+	if err = parseSymbolEntries(symData, t); err != nil {
+		return nil, err
+	}
+	if err = parseDataTypeEntries(typeData, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// parseSymbolEntries decodes the ADSIGRP_SYM_UPLOAD blob (a sequence of AdsSymbolEntry).
+func parseSymbolEntries(data []byte, t *symbolTable) error {
+	le := binary.LittleEndian
+	for len(data) >= 30 {
+		entryLen := le.Uint32(data[0:])
+		if entryLen < 30 || int(entryLen) > len(data) {
+			return fmt.Errorf("corrupt symbol entry (length %d, %d bytes left)", entryLen, len(data))
+		}
+		entry := data[:entryLen]
+		nameLen := int(le.Uint16(entry[24:]))
+		typeLen := int(le.Uint16(entry[26:]))
+		if 30+nameLen+1+typeLen+1 > len(entry) {
+			return errors.New("corrupt symbol entry: name exceeds entry")
+		}
+		name := string(entry[30 : 30+nameLen])
+		typeName := string(entry[30+nameLen+1 : 30+nameLen+1+typeLen])
+		sym := &symbolInfo{
+			Name:        name,
+			DataType:    typeName,
+			Size:        le.Uint32(entry[12:]),
+			IndexGroup:  le.Uint32(entry[4:]),
+			IndexOffset: le.Uint32(entry[8:]),
+		}
+		flags := le.Uint32(entry[20:])
+		if flags&symbolFlagAttributes != 0 {
+			pos := 30 + nameLen + 1 + typeLen + 1 + int(le.Uint16(entry[28:])) + 1
+			if flags&symbolFlagTypeGUID != 0 {
+				pos += 16
+			}
+			attrs, err := parseSymbolAttributes(entry, pos)
+			if err != nil {
+				return fmt.Errorf("symbol %s: %w", name, err)
+			}
+			sym.Attributes = attrs
+		}
+		t.Symbols[strings.ToLower(name)] = sym
+		data = data[entryLen:]
+	}
+	return nil
+}
+
+// parseSymbolAttributes decodes the attribute pairs starting at pos of a symbol entry: a count,
+// then for each attribute the name and value lengths followed by both NUL-terminated strings.
+func parseSymbolAttributes(entry []byte, pos int) (map[string]string, error) {
+	if pos+2 > len(entry) {
+		return nil, errors.New("corrupt symbol entry: attributes exceed entry")
+	}
+	count := int(binary.LittleEndian.Uint16(entry[pos:]))
+	pos += 2
+	attrs := make(map[string]string, count)
+	for i := 0; i < count; i++ {
+		if pos+2 > len(entry) {
+			return nil, errors.New("corrupt symbol entry: attributes exceed entry")
+		}
+		nameLen, valueLen := int(entry[pos]), int(entry[pos+1])
+		pos += 2
+		if pos+nameLen+1+valueLen+1 > len(entry) {
+			return nil, errors.New("corrupt symbol entry: attributes exceed entry")
+		}
+		name := string(entry[pos : pos+nameLen])
+		attrs[strings.ToLower(name)] = string(entry[pos+nameLen+1 : pos+nameLen+1+valueLen])
+		pos += nameLen + 1 + valueLen + 1
+	}
+	return attrs, nil
+}
+
+// parseDataTypeEntries decodes the ADSIGRP_SYM_DT_UPLOAD blob (a sequence of AdsDatatypeEntry).
+func parseDataTypeEntries(data []byte, t *symbolTable) error {
+	for len(data) >= 42 {
+		info, entryLen, err := parseDataTypeEntry(data)
+		if err != nil {
+			return err
+		}
+		t.DataTypes[strings.ToLower(info.Name)] = info
+		data = data[entryLen:]
+	}
+	return nil
+}
+
+// parseDataTypeEntry decodes one AdsDatatypeEntry including its nested sub item entries.
+func parseDataTypeEntry(data []byte) (*dataTypeInfo, uint32, error) {
+	le := binary.LittleEndian
+	entryLen := le.Uint32(data[0:])
+	if entryLen < 42 || int(entryLen) > len(data) {
+		return nil, 0, fmt.Errorf("corrupt datatype entry (length %d, %d bytes left)", entryLen, len(data))
+	}
+	entry := data[:entryLen]
+	size := le.Uint32(entry[16:])
+	nameLen := int(le.Uint16(entry[32:]))
+	typeLen := int(le.Uint16(entry[34:]))
+	commentLen := int(le.Uint16(entry[36:]))
+	arrayDims := int(le.Uint16(entry[38:]))
+	subItems := int(le.Uint16(entry[40:]))
+
+	pos := 42
+	if pos+nameLen+1+typeLen+1+commentLen+1 > len(entry) {
+		return nil, 0, errors.New("corrupt datatype entry: name exceeds entry")
+	}
+	info := &dataTypeInfo{
+		Name:     string(entry[pos : pos+nameLen]),
+		BaseType: string(entry[pos+nameLen+1 : pos+nameLen+1+typeLen]),
+		Size:     size,
+	}
+	pos += nameLen + 1 + typeLen + 1 + commentLen + 1
+
+	for i := 0; i < arrayDims; i++ {
+		if pos+8 > len(entry) {
+			return nil, 0, errors.New("corrupt datatype entry: array info exceeds entry")
+		}
+		info.Arrays = append(info.Arrays, arrayDim{
+			LBound:   int(int32(le.Uint32(entry[pos:]))),
+			Elements: int(le.Uint32(entry[pos+4:])),
+		})
+		pos += 8
+	}
+
+	for i := 0; i < subItems; i++ {
+		if pos+42 > len(entry) {
+			return nil, 0, errors.New("corrupt datatype entry: sub item exceeds entry")
+		}
+		sub, subLen, err := parseDataTypeEntry(entry[pos:])
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", info.Name, err)
+		}
+		offset := le.Uint32(entry[pos+20:])
+		info.SubItems = append(info.SubItems, subItemInfo{
+			Name:     sub.Name,
+			DataType: sub.BaseType,
+			Size:     sub.Size,
+			Offset:   offset,
+			Arrays:   sub.Arrays,
+		})
+		pos += int(subLen)
+	}
+	return info, entryLen, nil
+}
+
+// symbolCachePath returns the cache file for the configured target and runtime port.
+func (g *adsCommInput) symbolCachePath() string {
+	return filepath.Join(g.symbolCacheDir, fmt.Sprintf("symbols-%s-%d.json", g.targetAMS, g.runtimePort))
+}
+
+// loadCachedSymbolTable returns the symbol table for the connected PLC, downloading it only
+// when the PLC's symbol version or upload info differs from the cached copy.
+func (g *adsCommInput) loadCachedSymbolTable(ctx context.Context) (*symbolTable, error) {
+	info, err := readUploadInfo(ctx, g.handler)
+	if err != nil {
+		return nil, err
+	}
+
+	// A reconnect to an unchanged PLC reuses the table already in memory.
+	if g.symbolTable != nil && g.symbolCacheInfo == info {
+		g.log.Infof("Symbol table unchanged (version %d), skipping download", info.Version)
+		return g.symbolTable, nil
+	}
+
+	cachePath := g.symbolCachePath()
+	if data, readErr := os.ReadFile(cachePath); readErr == nil {
+		var cached symbolCacheFile
+		switch {
+		case json.Unmarshal(data, &cached) != nil:
+			g.log.Warnf("Ignoring unreadable symbol cache %s", cachePath)
+		case cached.Info == info && cached.Table != nil:
+			g.log.Infof("Using cached symbol table %s (version %d, %d symbols)", cachePath, info.Version, info.Symbols)
+			g.symbolCacheInfo = info
+			return cached.Table, nil
+		default:
+			g.log.Infof("Symbol cache %s is stale (PLC version %d, cached %d), downloading", cachePath, info.Version, cached.Info.Version)
+		}
+	}
+
+	g.log.Infof("Downloading symbol and datatype table from PLC (%d symbols, %d datatypes)", info.Symbols, info.DataTypes)
+	table, err := uploadSymbolTable(ctx, g.handler, info)
+	if err != nil {
+		return nil, err
+	}
+	g.symbolCacheInfo = info
+
+	data, err := json.Marshal(symbolCacheFile{
+		TargetAMS:   g.targetAMS,
+		RuntimePort: g.runtimePort,
+		Info:        info,
+		Table:       table,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = writeFileAtomic(cachePath, data); err != nil {
+		// The table is usable even if it can't be cached.
+		g.log.Warnf("Failed to write symbol cache %s: %v", cachePath, err)
+	}
+	return table, nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place so readers
+// never see a partially written cache.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,183 @@
+package benthosADS
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// symbolEntryBytes builds an AdsSymbolEntry as returned by the symbol upload.
+func symbolEntryBytes(name, typeName, comment string, group, offset, size, flags uint32, tail []byte) []byte {
+	var b []byte
+	b = binary.LittleEndian.AppendUint32(b, 0) // length, set below
+	b = binary.LittleEndian.AppendUint32(b, group)
+	b = binary.LittleEndian.AppendUint32(b, offset)
+	b = binary.LittleEndian.AppendUint32(b, size)
+	b = binary.LittleEndian.AppendUint32(b, 0x41) // ADST_BIGTYPE
+	b = binary.LittleEndian.AppendUint32(b, flags)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(name)))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(typeName)))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(comment)))
+	b = append(b, name+"\x00"+typeName+"\x00"+comment+"\x00"...)
+	b = append(b, tail...)
+	binary.LittleEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+// dataTypeEntryBytes builds an AdsDatatypeEntry; offset is the member offset when nested.
+func dataTypeEntryBytes(name, typeName string, size, offset uint32, arrays []arrayDim, subItems ...[]byte) []byte {
+	var b []byte
+	b = binary.LittleEndian.AppendUint32(b, 0) // length, set below
+	b = binary.LittleEndian.AppendUint32(b, 1) // version
+	b = binary.LittleEndian.AppendUint32(b, 0) // hash
+	b = binary.LittleEndian.AppendUint32(b, 0) // type hash
+	b = binary.LittleEndian.AppendUint32(b, size)
+	b = binary.LittleEndian.AppendUint32(b, offset)
+	b = binary.LittleEndian.AppendUint32(b, 0x41)
+	b = binary.LittleEndian.AppendUint32(b, 0) // flags
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(name)))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(typeName)))
+	b = binary.LittleEndian.AppendUint16(b, 0) // comment
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(arrays)))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(subItems)))
+	b = append(b, name+"\x00"+typeName+"\x00\x00"...)
+	for _, a := range arrays {
+		b = binary.LittleEndian.AppendUint32(b, uint32(int32(a.LBound)))
+		b = binary.LittleEndian.AppendUint32(b, uint32(a.Elements))
+	}
+	for _, sub := range subItems {
+		b = append(b, sub...)
+	}
+	binary.LittleEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+func TestParseSymbolEntries(t *testing.T) {
+	// MAIN.n : INT at 0x4040/0x10, written out byte by byte.
+	literal := []byte{
+		0x2A, 0, 0, 0, 0x40, 0x40, 0, 0, 0x10, 0, 0, 0, 0x02, 0, 0, 0, 0x02, 0, 0, 0, 0, 0, 0, 0,
+		6, 0, 3, 0, 0, 0,
+		'M', 'A', 'I', 'N', '.', 'n', 0, 'I', 'N', 'T', 0, 0,
+	}
+	guid := make([]byte, 16)
+	attrs := []byte{2, 0, 8, 1, 'O', 'P', 'C', '.', 'U', 'A', '.', 'D', 0, '1', 0, 4, 0, 'u', 'n', 'i', 't', 0, 0}
+	data := append(literal, symbolEntryBytes("MAIN.stMotor", "ST_Motor", " drive", 0x4040, 0x20, 16, 0, nil)...)
+	data = append(data, symbolEntryBytes("GVL.fTemp", "REAL", "", 0x4040, 0x40, 4,
+		symbolFlagTypeGUID|symbolFlagAttributes, append(guid, attrs...))...)
+
+	table := &symbolTable{Symbols: map[string]*symbolInfo{}}
+	if err := parseSymbolEntries(data, table); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]*symbolInfo{
+		"main.n":       {Name: "MAIN.n", DataType: "INT", Size: 2, IndexGroup: 0x4040, IndexOffset: 0x10},
+		"main.stmotor": {Name: "MAIN.stMotor", DataType: "ST_Motor", Size: 16, IndexGroup: 0x4040, IndexOffset: 0x20},
+		"gvl.ftemp": {Name: "GVL.fTemp", DataType: "REAL", Size: 4, IndexGroup: 0x4040, IndexOffset: 0x40,
+			Attributes: map[string]string{"opc.ua.d": "1", "unit": ""}},
+	}
+	if !reflect.DeepEqual(table.Symbols, want) {
+		for k, v := range table.Symbols {
+			t.Logf("%s: %+v", k, *v)
+		}
+		t.Error("parsed symbols differ from the fixture")
+	}
+}
+
+func TestParseSymbolEntriesCorrupt(t *testing.T) {
+	entry := symbolEntryBytes("MAIN.n", "INT", "", 0x4040, 0, 2, 0, nil)
+	tests := map[string][]byte{
+		"length past end": func() []byte {
+			b := append([]byte(nil), entry...)
+			binary.LittleEndian.PutUint32(b, uint32(len(b)+1))
+			return b
+		}(),
+		"name past end": func() []byte {
+			b := append([]byte(nil), entry...)
+			binary.LittleEndian.PutUint16(b[24:], 200)
+			return b
+		}(),
+		"attributes past end": symbolEntryBytes("MAIN.n", "INT", "", 0x4040, 0, 2, symbolFlagAttributes, []byte{5, 0}),
+	}
+	for name, data := range tests {
+		table := &symbolTable{Symbols: map[string]*symbolInfo{}}
+		if err := parseSymbolEntries(data, table); err == nil || !strings.Contains(err.Error(), "corrupt") {
+			t.Errorf("%s: error = %v, want a corrupt entry error", name, err)
+		}
+	}
+}
+
+func TestParseDataTypeEntry(t *testing.T) {
+	data := dataTypeEntryBytes("ST_Motor", "", 16, 0, nil,
+		dataTypeEntryBytes("fSpeed", "LREAL", 8, 0, nil),
+		dataTypeEntryBytes("eState", "E_State", 2, 8, nil),
+		dataTypeEntryBytes("aTemps", "ARRAY [1..2] OF INT", 4, 12, []arrayDim{{LBound: 1, Elements: 2}}),
+	)
+	info, n, err := parseDataTypeEntry(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(n) != len(data) {
+		t.Errorf("entry length = %d, want %d", n, len(data))
+	}
+	want := &dataTypeInfo{Name: "ST_Motor", Size: 16, SubItems: []subItemInfo{
+		{Name: "fSpeed", DataType: "LREAL", Size: 8, Offset: 0},
+		{Name: "eState", DataType: "E_State", Size: 2, Offset: 8},
+		{Name: "aTemps", DataType: "ARRAY [1..2] OF INT", Size: 4, Offset: 12, Arrays: []arrayDim{{LBound: 1, Elements: 2}}},
+	}}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("ST_Motor = %+v, want %+v", *info, *want)
+	}
+
+	arr, _, err := parseDataTypeEntry(dataTypeEntryBytes("ARRAY [-1..1] OF DINT", "DINT", 12, 0, []arrayDim{{LBound: -1, Elements: 3}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arr.BaseType != "DINT" || !reflect.DeepEqual(arr.Arrays, []arrayDim{{LBound: -1, Elements: 3}}) {
+		t.Errorf("array type = %+v", *arr)
+	}
+
+	table := &symbolTable{DataTypes: map[string]*dataTypeInfo{}}
+	types := append(data, dataTypeEntryBytes("E_State", "INT", 2, 0, nil)...)
+	if err = parseDataTypeEntries(types, table); err != nil {
+		t.Fatal(err)
+	}
+	if len(table.DataTypes) != 2 || table.baseTypeName("E_State") != "INT" {
+		t.Errorf("datatypes = %v", table.DataTypes)
+	}
+	got, err := table.decodeJSON("ST_Motor", nil, []byte{0, 0, 0, 0, 0, 0, 0xF0, 0x3F, 1, 0, 0, 0, 5, 0, 6, 0})
+	if err != nil || got != `{"aTemps":[5,6],"eState":1,"fSpeed":1}` {
+		t.Errorf("decoded ST_Motor = %s, %v", got, err)
+	}
+}
+
+func TestParseDataTypeEntryCorrupt(t *testing.T) {
+	valid := dataTypeEntryBytes("ST_X", "", 2, 0, nil, dataTypeEntryBytes("n", "INT", 2, 0, nil))
+	tests := map[string][]byte{
+		"length past end": func() []byte {
+			b := append([]byte(nil), valid...)
+			binary.LittleEndian.PutUint32(b, uint32(len(b)+4))
+			return b
+		}(),
+		"name past end": func() []byte {
+			b := append([]byte(nil), valid...)
+			binary.LittleEndian.PutUint16(b[32:], 500)
+			return b
+		}(),
+		"array info past end": func() []byte {
+			b := dataTypeEntryBytes("A", "INT", 2, 0, nil)
+			binary.LittleEndian.PutUint16(b[38:], 1)
+			return b
+		}(),
+		"sub item past end": func() []byte {
+			b := append([]byte(nil), valid...)
+			binary.LittleEndian.PutUint16(b[40:], 2)
+			return b
+		}(),
+	}
+	for name, data := range tests {
+		if _, _, err := parseDataTypeEntry(data); err == nil || !strings.Contains(err.Error(), "corrupt") {
+			t.Errorf("%s: error = %v, want a corrupt entry error", name, err)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package benthosADS
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/urfave/cli/v2"
+)
+
+// symbolCheckField configures the connect-time comparison of the configured symbols with the PLC.
+func symbolCheckField() *service.ConfigField {
+	return service.NewObjectField("symbolCheck",
+		service.NewStringField("snapshotDir").Description("Directory for the snapshot of the symbols' types and sizes. Each connect compares against the snapshot of the previous one and updates it. Empty only checks that the symbols exist.").Default(""),
+		service.NewBoolField("failOnMissing").Description("Fail the connect when a configured symbol is missing on the PLC, instead of only logging it.").Default(false),
+	).Description("Compare the configured symbols with the PLC on connect and log removed, renamed-by-case, retyped and resized symbols, e.g. after a PLC deployment.").Optional()
+}
+
+// symbolCheckConfig holds the symbolCheck settings of the ads input.
+type symbolCheckConfig struct {
+	snapshotDir   string
+	failOnMissing bool
+}
+
+func parseSymbolCheck(conf *service.ParsedConfig) (*symbolCheckConfig, error) {
+	if !conf.Contains("symbolCheck") {
+		return nil, nil
+	}
+	var c symbolCheckConfig
+	var err error
+	if c.snapshotDir, err = conf.FieldString("symbolCheck", "snapshotDir"); err != nil {
+		return nil, err
+	}
+	if c.failOnMissing, err = conf.FieldBool("symbolCheck", "failOnMissing"); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// symbolSnapshot records the type and size of symbols at one point in time, so a later program
+// version can be compared with it.
+type symbolSnapshot struct {
+	TargetAMS   string                    `json:"targetAMS"`
+	RuntimePort int                       `json:"runtimePort"`
+	Time        string                    `json:"time"`
+	Symbols     map[string]snapshotSymbol `json:"symbols"` // strings.ToLower(name) → symbol
+}
+
+type snapshotSymbol struct {
+	Name     string `json:"name"`
+	DataType string `json:"dataType"`
+	Size     uint32 `json:"size"`
+}
+
+// readSymbolSnapshot reads a snapshot file. A missing file returns nil without error.
+func readSymbolSnapshot(path string) (*symbolSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s symbolSnapshot
+	if err = json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &s, nil
+}
+
+func writeSymbolSnapshot(path string, s *symbolSnapshot) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// Kinds of symbol changes reported by diffSymbols.
+const (
+	symbolRemoved = "removed"
+	symbolRenamed = "renamed"
+	symbolRetyped = "retyped"
+	symbolResized = "resized"
+)
+
+// symbolChange is one difference between a configured symbol and the PLC.
+type symbolChange struct {
+	Kind string
+	Name string // as configured
+	From string
+	To   string
+}
+
+func (c symbolChange) String() string {
+	switch c.Kind {
+	case symbolRemoved:
+		if c.From != "" {
+			return fmt.Sprintf("%s removed (was %s)", c.Name, c.From)
+		}
+		return fmt.Sprintf("%s not found on the PLC", c.Name)
+	case symbolRenamed:
+		return fmt.Sprintf("%s renamed by case from %s to %s", c.Name, c.From, c.To)
+	case symbolRetyped:
+		return fmt.Sprintf("%s changed type from %s to %s", c.Name, c.From, c.To)
+	default:
+		return fmt.Sprintf("%s changed size from %s to %s bytes", c.Name, c.From, c.To)
+	}
+}
+
+// diffSymbols compares the configured names with the PLC's current symbols and, if not nil, a
+// snapshot of an earlier program version. current is keyed by lower case name. Renames by case
+// are reported against the snapshot, and only when caseKnown, i.e. current holds the names as
+// the PLC declares them. A configured name that differs from the PLC only by case is no rename:
+// ADS resolves symbol names case-insensitively.
+func diffSymbols(names []string, current map[string]snapshotSymbol, snapshot *symbolSnapshot, caseKnown bool) []symbolChange {
+	var changes []symbolChange
+	for _, name := range names {
+		key := strings.ToLower(name)
+		var old snapshotSymbol
+		hasOld := false
+		if snapshot != nil {
+			old, hasOld = snapshot.Symbols[key]
+		}
+		cur, ok := current[key]
+		if !ok {
+			c := symbolChange{Kind: symbolRemoved, Name: name}
+			if hasOld {
+				c.From = fmt.Sprintf("%s, %d bytes", old.DataType, old.Size)
+			}
+			changes = append(changes, c)
+			continue
+		}
+		if caseKnown && hasOld && old.Name != cur.Name {
+			changes = append(changes, symbolChange{Kind: symbolRenamed, Name: name, From: old.Name, To: cur.Name})
+		}
+		if !hasOld {
+			continue
+		}
+		if !strings.EqualFold(old.DataType, cur.DataType) {
+			changes = append(changes, symbolChange{Kind: symbolRetyped, Name: name, From: old.DataType, To: cur.DataType})
+		}
+		if old.Size != cur.Size {
+			changes = append(changes, symbolChange{Kind: symbolResized, Name: name, From: fmt.Sprint(old.Size), To: fmt.Sprint(cur.Size)})
+		}
+	}
+	return changes
+}
+
+// keepMissing adds the snapshot entries of symbols missing from current, so a symbol that is
+// gone or could not be looked up is still compared with its last known type when it returns.
+func keepMissing(current map[string]snapshotSymbol, snapshot *symbolSnapshot) map[string]snapshotSymbol {
+	if snapshot == nil {
+		return current
+	}
+	merged := make(map[string]snapshotSymbol, len(current))
+	for key, sym := range snapshot.Symbols {
+		merged[key] = sym
+	}
+	for key, sym := range current {
+		merged[key] = sym
+	}
+	return merged
+}
+
+// symbolNotFound reports whether a failed symbol lookup means the PLC doesn't know the symbol,
+// as opposed to the lookup itself failing, e.g. on a timeout. ADS reports a missing symbol as
+// device error 0x710, "symbol not found".
+func symbolNotFound(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "0x710") || strings.Contains(msg, "not found")
+}
+
+// newSymbolSnapshot builds a snapshot of the current symbols.
+func newSymbolSnapshot(targetAMS string, runtimePort int, current map[string]snapshotSymbol) *symbolSnapshot {
+	return &symbolSnapshot{
+		TargetAMS:   targetAMS,
+		RuntimePort: runtimePort,
+		Time:        time.Now().UTC().Format(time.RFC3339),
+		Symbols:     current,
+	}
+}
+
+// symbolSnapshotPath returns the snapshot file for the configured target and runtime port.
+func (g *adsCommInput) symbolSnapshotPath() string {
+	return filepath.Join(g.symbolCheck.snapshotDir, fmt.Sprintf("snapshot-%s-%d.json", g.targetAMS, g.runtimePort))
+}
+
+// checkSymbols compares the configured symbols with the connected PLC and the snapshot of the
+// previous connect. Names are compared by case only when the input has the PLC's symbol table
+// (loadSymbols with symbolCacheDir); otherwise types and sizes come from a lookup per symbol.
+func (g *adsCommInput) checkSymbols(ctx context.Context) error {
+	if g.symbolCheck == nil {
+		return nil
+	}
+	live := g.loadSymbols && g.symbolCacheDir != "" && g.symbolTable != nil
+	var names, unknown []string
+	current := make(map[string]snapshotSymbol, len(g.symbols))
+	for _, sym := range g.symbols {
+		if isRawAddress(sym.name) {
+			continue
+		}
+		key := strings.ToLower(sym.name)
+		if live {
+			names = append(names, sym.name)
+			if info, ok := g.symbolTable.symbol(sym.name); ok {
+				current[key] = snapshotSymbol{Name: info.Name, DataType: info.DataType, Size: info.Size}
+			}
+			continue
+		}
+		view, err := g.handler.GetSymbol(ctx, sym.name)
+		if err != nil && !symbolNotFound(err) {
+			// Says nothing about the symbol; it is neither reported nor dropped from the snapshot.
+			g.log.Warnf("Symbol check: looking up %s failed: %v", sym.name, err)
+			unknown = append(unknown, sym.name)
+			continue
+		}
+		names = append(names, sym.name)
+		if err == nil {
+			current[key] = snapshotSymbol{Name: sym.name, DataType: view.DataType, Size: view.Length}
+		}
+	}
+
+	var snapshot *symbolSnapshot
+	if g.symbolCheck.snapshotDir != "" {
+		var err error
+		if snapshot, err = readSymbolSnapshot(g.symbolSnapshotPath()); err != nil {
+			g.log.Warnf("Ignoring unreadable symbol snapshot: %v", err)
+			snapshot = nil
+		}
+	}
+
+	var missing []string
+	changes := diffSymbols(names, current, snapshot, live)
+	for _, c := range changes {
+		g.log.Warnf("Symbol check: %s", c)
+		if c.Kind == symbolRemoved {
+			missing = append(missing, c.Name)
+		}
+	}
+	if len(changes) == 0 && len(unknown) == 0 {
+		g.log.Infof("Symbol check: %d symbols unchanged", len(names))
+	}
+	if len(missing) > 0 && g.symbolCheck.failOnMissing {
+		return fmt.Errorf("symbol check: %d configured symbols missing on the PLC: %s", len(missing), strings.Join(missing, ", "))
+	}
+
+	if g.symbolCheck.snapshotDir != "" {
+		path := g.symbolSnapshotPath()
+		if err := writeSymbolSnapshot(path, newSymbolSnapshot(g.targetAMS, g.runtimePort, keepMissing(current, snapshot))); err != nil {
+			g.log.Warnf("Failed to write symbol snapshot %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// cliDiff compares symbols with the PLC's symbol table and a snapshot and can save a new
+// snapshot. It exits with an error when it found changes.
+func cliDiff(c *cli.Context) error {
+	fields, err := cliConnFields(c)
+	if err != nil {
+		return err
+	}
+	configured, _, err := symbolEntries(fields["symbols"], 0, 0)
+	if err != nil {
+		return fmt.Errorf("symbols: %w", err)
+	}
+	entries := append(c.Args().Slice(), configured...)
+	var snapshot *symbolSnapshot
+	if path := c.String("snapshot"); path != "" {
+		if snapshot, err = readSymbolSnapshot(path); err != nil {
+			return err
+		}
+		if snapshot == nil {
+			return fmt.Errorf("snapshot %s does not exist", path)
+		}
+	}
+
+	conn, err := cliConnect(c)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+	info, err := readUploadInfo(c.Context, conn.session)
+	if err != nil {
+		return err
+	}
+	table, err := uploadSymbolTable(c.Context, conn.session, info)
+	if err != nil {
+		return err
+	}
+
+	if entries, err = expandSymbolPatterns(entries, table); err != nil {
+		return err
+	}
+	symbols, err := createSymbolList(entries, 0, 0)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, sym := range symbols {
+		if !isRawAddress(sym.name) {
+			names = append(names, sym.name)
+		}
+	}
+	// Without configured symbols, compare what the snapshot has, or save all symbols.
+	if len(names) == 0 && snapshot != nil {
+		for _, sym := range snapshot.Symbols {
+			names = append(names, sym.Name)
+		}
+	}
+	if len(names) == 0 && c.String("save") != "" {
+		for _, sym := range table.Symbols {
+			names = append(names, sym.Name)
+		}
+	}
+	if len(names) == 0 {
+		return errors.New("no symbols to compare; pass symbols, a config with symbols or --snapshot")
+	}
+	sort.Strings(names)
+
+	current := make(map[string]snapshotSymbol, len(names))
+	for _, name := range names {
+		if sym, ok := table.symbol(name); ok {
+			current[strings.ToLower(name)] = snapshotSymbol{Name: sym.Name, DataType: sym.DataType, Size: sym.Size}
+		}
+	}
+	changes := diffSymbols(names, current, snapshot, true)
+	for _, ch := range changes {
+		fmt.Fprintln(c.App.Writer, ch)
+	}
+
+	if path := c.String("save"); path != "" {
+		if err = writeSymbolSnapshot(path, newSymbolSnapshot(conn.targetAMS, conn.runtimePort, current)); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.App.ErrWriter, "Saved %d symbols to %s\n", len(current), path)
+	}
+	if len(changes) > 0 {
+		return fmt.Errorf("%d changes in %d symbols", len(changes), len(names))
+	}
+	fmt.Fprintf(c.App.ErrWriter, "%d symbols unchanged\n", len(names))
+	return nil
+}
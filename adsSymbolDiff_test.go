@@ -0,0 +1,76 @@
+package benthosADS
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDiffSymbols(t *testing.T) {
+	snapshot := &symbolSnapshot{Symbols: map[string]snapshotSymbol{
+		"main.speed": {Name: "MAIN.speed", DataType: "INT", Size: 2},
+		"main.state": {Name: "MAIN.State", DataType: "E_State", Size: 2},
+		"main.gone":  {Name: "MAIN.gone", DataType: "BOOL", Size: 1},
+	}}
+	current := map[string]snapshotSymbol{
+		"main.speed": {Name: "MAIN.Speed", DataType: "REAL", Size: 4},
+		"main.state": {Name: "MAIN.State", DataType: "E_State", Size: 2},
+		"main.new":   {Name: "MAIN.New", DataType: "DINT", Size: 4},
+	}
+	names := []string{"MAIN.speed", "main.state", "MAIN.gone", "MAIN.new", "MAIN.never"}
+
+	got := diffSymbols(names, current, snapshot, true)
+	want := []symbolChange{
+		{Kind: symbolRenamed, Name: "MAIN.speed", From: "MAIN.speed", To: "MAIN.Speed"},
+		{Kind: symbolRetyped, Name: "MAIN.speed", From: "INT", To: "REAL"},
+		{Kind: symbolResized, Name: "MAIN.speed", From: "2", To: "4"},
+		{Kind: symbolRemoved, Name: "MAIN.gone", From: "BOOL, 1 bytes"},
+		{Kind: symbolRemoved, Name: "MAIN.never"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffSymbols =\n%v\nwant\n%v", got, want)
+	}
+
+	// Without the PLC's declared names, casing is not compared.
+	got = diffSymbols([]string{"MAIN.speed"}, current, snapshot, false)
+	if len(got) != 2 || got[0].Kind != symbolRetyped {
+		t.Errorf("diffSymbols without case = %v", got)
+	}
+	// Without a snapshot only missing symbols are reported, whatever the configured casing.
+	if got = diffSymbols(names, current, nil, true); len(got) != 2 {
+		t.Errorf("diffSymbols without snapshot = %v", got)
+	}
+}
+
+func TestKeepMissing(t *testing.T) {
+	snapshot := &symbolSnapshot{Symbols: map[string]snapshotSymbol{
+		"a": {Name: "A", DataType: "INT", Size: 2},
+		"b": {Name: "B", DataType: "BOOL", Size: 1},
+	}}
+	current := map[string]snapshotSymbol{"a": {Name: "A", DataType: "DINT", Size: 4}}
+	got := keepMissing(current, snapshot)
+	want := map[string]snapshotSymbol{
+		"a": {Name: "A", DataType: "DINT", Size: 4},
+		"b": {Name: "B", DataType: "BOOL", Size: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("keepMissing = %v, want %v", got, want)
+	}
+	if got = keepMissing(current, nil); !reflect.DeepEqual(got, current) {
+		t.Errorf("keepMissing without snapshot = %v", got)
+	}
+}
+
+func TestSymbolNotFound(t *testing.T) {
+	for err, want := range map[error]bool{
+		errors.New("ADS error 0x710"):                                true,
+		fmt.Errorf("get symbol: %w", errors.New("symbol not found")): true,
+		errors.New("request timed out"):                              false,
+		errors.New("connection closed"):                              false,
+	} {
+		if got := symbolNotFound(err); got != want {
+			t.Errorf("symbolNotFound(%q) = %v, want %v", err, got, want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package benthosADS
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	adsLib "github.com/RuneRoven/go-ads/v2"
+)
+
+// symbolEntries converts the symbols list of a config into entries for createSymbolList. An
+// element is either a string ('MAIN.var' or 'MAIN.var:maxDelayMs:cycleTimeMs') or an object
+// with name, maxDelay, cycleTime and required. The lower case names of required objects are
+// returned as patterns for markRequired.
+func symbolEntries(list any, defaultMaxDelay, defaultCycleTime int) (entries, required []string, err error) {
+	items, ok := list.([]any)
+	if !ok && list != nil {
+		return nil, nil, fmt.Errorf("expected a list, got %T", list)
+	}
+	for i, item := range items {
+		switch v := item.(type) {
+		case string:
+			entries = append(entries, v)
+		case map[string]any:
+			entry, req, err := symbolObjectEntry(v, defaultMaxDelay, defaultCycleTime)
+			if err != nil {
+				return nil, nil, fmt.Errorf("entry %d: %w", i, err)
+			}
+			entries = append(entries, entry)
+			if req {
+				required = append(required, strings.ToLower(strings.TrimSpace(v["name"].(string))))
+			}
+		default:
+			return nil, nil, fmt.Errorf("entry %d: expected a string or an object, got %T", i, item)
+		}
+	}
+	return entries, required, nil
+}
+
+// symbolObjectEntry converts an object element of the symbols list into a string entry.
+func symbolObjectEntry(obj map[string]any, defaultMaxDelay, defaultCycleTime int) (string, bool, error) {
+	name, _ := obj["name"].(string)
+	if strings.TrimSpace(name) == "" {
+		return "", false, fmt.Errorf("name must be a non-empty string")
+	}
+	maxDelay, cycleTime := defaultMaxDelay, defaultCycleTime
+	timing, required := false, false
+	for key, value := range obj {
+		var err error
+		switch key {
+		case "name":
+		case "maxDelay":
+			maxDelay, err = symbolObjectInt(key, value)
+			timing = true
+		case "cycleTime":
+			cycleTime, err = symbolObjectInt(key, value)
+			timing = true
+		case "required":
+			var ok bool
+			if required, ok = value.(bool); !ok {
+				err = fmt.Errorf("required must be true or false, got %v", value)
+			}
+		default:
+			err = fmt.Errorf("unknown field %q in symbol %s", key, name)
+		}
+		if err != nil {
+			return "", false, err
+		}
+	}
+	if !timing {
+		return name, required, nil
+	}
+	return name + ":" + strconv.Itoa(maxDelay) + ":" + strconv.Itoa(cycleTime), required, nil
+}
+
+func symbolObjectInt(key string, value any) (int, error) {
+	switch n := value.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case float64:
+		if n == float64(int(n)) {
+			return int(n), nil
+		}
+	}
+	return 0, fmt.Errorf("%s must be a whole number of milliseconds, got %v", key, value)
+}
+
+// markRequired marks every symbol as required when strict, otherwise those matching one of the
+// patterns. Patterns with '*' or '?' match like wildcard symbols, others by name.
+func markRequired(symbols []plcSymbol, strict bool, patterns []string) {
+	for i := range symbols {
+		if strict {
+			symbols[i].required = true
+			continue
+		}
+		name := strings.ToLower(symbols[i].name)
+		for _, p := range patterns {
+			if p == name {
+				symbols[i].required = true
+				break
+			}
+			if ok, _ := path.Match(p, name); ok && strings.ContainsAny(p, "*?") {
+				symbols[i].required = true
+				break
+			}
+		}
+	}
+}
+
+// unresolvedRequired returns the names of the required symbols the PLC cannot resolve. Named
+// symbols are looked up, direct addresses read once.
+func (g *adsCommInput) unresolvedRequired(ctx context.Context, symbols []plcSymbol) []string {
+	var missing []string
+	for _, sym := range symbols {
+		if !sym.required {
+			continue
+		}
+		var err error
+		if sym.address != nil {
+			_, err = g.readRawSymbol(ctx, sym)
+		} else {
+			_, err = g.handler.GetSymbol(ctx, sym.name)
+		}
+		if err != nil {
+			g.log.Errorf("Required symbol %q cannot be read: %v", sym.name, err)
+			missing = append(missing, sym.name)
+		}
+	}
+	return missing
+}
+
+// requiredSymbolsError reports required symbols that failed to register or resolve.
+func requiredSymbolsError(missing []string) error {
+	sort.Strings(missing)
+	return fmt.Errorf("%d required symbols failed: %s", len(missing), strings.Join(missing, ", "))
+}
+
+// pendingSymbols are symbols that failed to register on one notification connection.
+type pendingSymbols struct {
+	session *adsLib.Session
+	symbols []plcSymbol
+}
+
+// unregistered returns the symbols whose names are not in registered.
+func unregistered(symbols []plcSymbol, registered []string) []plcSymbol {
+	ok := make(map[string]bool, len(registered))
+	for _, name := range registered {
+		ok[strings.ToLower(name)] = true
+	}
+	var out []plcSymbol
+	for _, sym := range symbols {
+		if !ok[strings.ToLower(sym.name)] {
+			out = append(out, sym)
+		}
+	}
+	return out
+}
+
+// startSymbolRetry registers the symbols that failed on connect again, each on the connection
+// it failed on. The first retry runs after symbolRetryInterval; while no symbol registers, the
+// time between retries grows by symbolRetryMultiplier up to symbolRetryMaxInterval. Symbols
+// that register join the running notifications; the other handles are left untouched.
+// registered is the number of symbols registered so far.
+func (g *adsCommInput) startSymbolRetry(pending []pendingSymbols, registered int) {
+	target := g.metricTarget()
+	g.symbolsPending.Set(int64(countPending(pending)), target)
+	if g.symbolRetryInterval <= 0 || len(pending) == 0 {
+		return
+	}
+	g.log.Infof("Retrying %d symbols that failed to register after %v, backing off up to %v",
+		countPending(pending), g.symbolRetryInterval, g.symbolRetryMaxInterval)
+
+	stop, exited := make(chan struct{}), make(chan struct{})
+	g.retryStop, g.retryExited = stop, exited
+	main, done := g.handler, g.done
+	go func() {
+		defer close(exited)
+		delay := g.symbolRetryInterval
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+			case <-stop:
+				return
+			case <-done:
+				return
+			}
+			before := countPending(pending)
+			pending = g.retryPending(main, pending)
+			left := countPending(pending)
+			g.symbolsPending.Set(int64(left), target)
+			if left < before {
+				registered += before - left
+				g.notificationsRegistered.Set(int64(registered), target)
+				delay = g.symbolRetryInterval // more symbols are likely to follow, e.g. during a download
+			} else {
+				delay = g.nextRetryDelay(delay)
+			}
+			if left == 0 {
+				g.log.Infof("All symbols that failed to register are registered now")
+				return
+			}
+			g.log.Debugf("%d symbols still fail to register, retrying in %v", left, delay)
+			timer.Reset(delay)
+		}
+	}()
+}
+
+// nextRetryDelay returns the time until the retry after one that registered no symbol.
+func (g *adsCommInput) nextRetryDelay(delay time.Duration) time.Duration {
+	next := time.Duration(float64(delay) * g.symbolRetryMultiplier)
+	if next > g.symbolRetryMaxInterval || next < delay {
+		return g.symbolRetryMaxInterval
+	}
+	return next
+}
+
+func countPending(pending []pendingSymbols) int {
+	n := 0
+	for _, p := range pending {
+		n += len(p.symbols)
+	}
+	return n
+}
+
+// retryPending registers pending symbols and returns those still failing. Failures were logged
+// on connect, so they are only logged at debug level here. Handles registered on main are kept
+// for deleteNotifications; extra connections drop theirs when they close.
+func (g *adsCommInput) retryPending(main *adsLib.Session, pending []pendingSymbols) []pendingSymbols {
+	var still []pendingSymbols
+	for _, p := range pending {
+		if p.session.IsClosed() {
+			still = append(still, p) // the reconnect stops the retry and registers all symbols again
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), g.retryTimeout())
+		names, handles, err := g.registerNotifications(ctx, p.session, p.symbols, g.log.Debugf)
+		cancel()
+		if err != nil {
+			g.log.Warnf("Retrying %d symbols failed: %v", len(p.symbols), err)
+			still = append(still, p)
+			continue
+		}
+		if len(names) > 0 {
+			if p.session == main {
+				g.notificationHandles = append(g.notificationHandles, handles...)
+			}
+			g.log.Infof("Registered %d symbols on retry: %s", len(names), strings.Join(names, ", "))
+		}
+		if rest := unregistered(p.symbols, names); len(rest) > 0 {
+			still = append(still, pendingSymbols{session: p.session, symbols: rest})
+		}
+	}
+	return still
+}
+
+// retryTimeout bounds one registration attempt of the retry.
+func (g *adsCommInput) retryTimeout() time.Duration {
+	if g.requestTimeout > 0 {
+		return 2 * g.requestTimeout
+	}
+	return 10 * time.Second
+}
+
+// stopSymbolRetry stops the retry and waits for a running attempt, so its handles are known
+// before the notifications are deleted.
+func (g *adsCommInput) stopSymbolRetry() {
+	if g.retryStop == nil {
+		return
+	}
+	close(g.retryStop)
+	<-g.retryExited
+	g.retryStop, g.retryExited = nil, nil
+}
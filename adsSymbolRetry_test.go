@@ -0,0 +1,154 @@
+package benthosADS
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSymbolEntries(t *testing.T) {
+	tests := []struct {
+		name         string
+		list         any
+		wantEntries  []string
+		wantRequired []string
+		wantErr      string
+	}{
+		{name: "no symbols", list: nil},
+		{
+			name:        "strings are kept as they are",
+			list:        []any{"MAIN.counter", "MAIN.level:50:200", "GVL.*"},
+			wantEntries: []string{"MAIN.counter", "MAIN.level:50:200", "GVL.*"},
+		},
+		{
+			name:        "object without timing",
+			list:        []any{map[string]any{"name": "MAIN.counter"}},
+			wantEntries: []string{"MAIN.counter"},
+		},
+		{
+			name: "object timing falls back to the defaults",
+			list: []any{
+				map[string]any{"name": "MAIN.fast", "maxDelay": 10, "cycleTime": 20},
+				map[string]any{"name": "MAIN.slow", "cycleTime": int64(5000)},
+				map[string]any{"name": "MAIN.json", "maxDelay": float64(50)},
+			},
+			wantEntries: []string{"MAIN.fast:10:20", "MAIN.slow:100:5000", "MAIN.json:50:1000"},
+		},
+		{
+			name: "required names are lower case",
+			list: []any{
+				"MAIN.optional",
+				map[string]any{"name": "MAIN.Motor", "required": true},
+				map[string]any{"name": "GVL.*", "required": true, "cycleTime": 500},
+				map[string]any{"name": "MAIN.other", "required": false},
+			},
+			wantEntries:  []string{"MAIN.optional", "MAIN.Motor", "GVL.*:100:500", "MAIN.other"},
+			wantRequired: []string{"main.motor", "gvl.*"},
+		},
+		{name: "not a list", list: "MAIN.counter", wantErr: "expected a list, got string"},
+		{name: "number entry", list: []any{"MAIN.counter", 5}, wantErr: "entry 1: expected a string or an object"},
+		{name: "missing name", list: []any{map[string]any{"required": true}}, wantErr: "entry 0: name must be a non-empty string"},
+		{name: "unknown field", list: []any{map[string]any{"name": "MAIN.x", "cycletime": 5}}, wantErr: `unknown field "cycletime"`},
+		{name: "required not a bool", list: []any{map[string]any{"name": "MAIN.x", "required": "yes"}}, wantErr: "required must be true or false"},
+		{name: "fractional cycleTime", list: []any{map[string]any{"name": "MAIN.x", "cycleTime": 2.5}}, wantErr: "cycleTime must be a whole number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, required, err := symbolEntries(tt.list, 100, 1000)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(entries, tt.wantEntries) {
+				t.Errorf("entries = %q, want %q", entries, tt.wantEntries)
+			}
+			if !reflect.DeepEqual(required, tt.wantRequired) {
+				t.Errorf("required = %q, want %q", required, tt.wantRequired)
+			}
+		})
+	}
+}
+
+func TestMarkRequired(t *testing.T) {
+	names := []string{"MAIN.motor", "MAIN.motorSpeed", "GVL.level", "GVL.levels"}
+	tests := []struct {
+		name     string
+		strict   bool
+		patterns []string
+		want     []string
+	}{
+		{name: "none", want: nil},
+		{name: "strict marks every symbol", strict: true, want: names},
+		{name: "exact name", patterns: []string{"main.motor"}, want: []string{"MAIN.motor"}},
+		{name: "wildcards", patterns: []string{"main.motor*", "gvl.level?"}, want: []string{"MAIN.motor", "MAIN.motorSpeed", "GVL.levels"}},
+		{name: "no partial match", patterns: []string{"main", "level"}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			symbols := make([]plcSymbol, len(names))
+			for i, name := range names {
+				symbols[i] = plcSymbol{name: name}
+			}
+			markRequired(symbols, tt.strict, tt.patterns)
+			var got []string
+			for _, sym := range symbols {
+				if sym.required {
+					got = append(got, sym.name)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("required = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInputRequiredSymbols(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name:   "required symbol exists",
+			config: "symbols:\n  - name: MAIN.counter\n    required: true\n  - MAIN.missing",
+		},
+		{
+			name:    "required symbol is missing",
+			config:  "symbols:\n  - MAIN.counter\n  - name: MAIN.missing\n    required: true\n  - MAIN.optional",
+			wantErr: "1 required symbols failed: MAIN.missing",
+		},
+		{
+			name:    "strictSymbols requires all",
+			config:  "strictSymbols: true\nsymbols: [ MAIN.optional, MAIN.counter, MAIN.missing ]",
+			wantErr: "2 required symbols failed: MAIN.missing, MAIN.optional",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			addSymbols(t, srv, "DINT", "MAIN.counter")
+			in := newServerInput(t, srv, "readType: interval\nintervalTime: 10\n"+tt.config)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			defer cancel()
+			err := in.Connect(ctx)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Connect error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,477 @@
+package benthosADS
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// symbolTable is a local copy of the PLC symbol and datatype table. It is built from a
+// TwinCAT project file and lets the input resolve types, expand wildcards and decode structs
+// without downloading the table from the PLC.
+type symbolTable struct {
+	Symbols   map[string]*symbolInfo   `json:"symbols"`   // strings.ToLower(name) → symbol
+	DataTypes map[string]*dataTypeInfo `json:"dataTypes"` // strings.ToLower(name) → datatype
+}
+
+type symbolInfo struct {
+	Name        string     `json:"name"`
+	DataType    string     `json:"dataType"`
+	Size        uint32     `json:"size"`
+	IndexGroup  uint32     `json:"indexGroup"`
+	IndexOffset uint32     `json:"indexOffset"`
+	Arrays      []arrayDim `json:"arrays,omitempty"`
+	// Attributes are the PLC attributes of the declaration ({attribute 'name' := 'value'}),
+	// keyed by lower case name. Only the symbol upload of TwinCAT 3 carries them.
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type dataTypeInfo struct {
+	Name     string        `json:"name"`
+	BaseType string        `json:"baseType,omitempty"` // element type for arrays, underlying type for aliases and enums
+	Size     uint32        `json:"size"`
+	Arrays   []arrayDim    `json:"arrays,omitempty"`
+	SubItems []subItemInfo `json:"subItems,omitempty"`
+}
+
+type subItemInfo struct {
+	Name     string     `json:"name"`
+	DataType string     `json:"dataType"`
+	Size     uint32     `json:"size"`
+	Offset   uint32     `json:"offset"`
+	Arrays   []arrayDim `json:"arrays,omitempty"`
+	// BitSize and BitOffset are set for BIT members, which are packed into the bits of the
+	// bytes at Offset; BitOffset counts from the lowest bit of the first byte.
+	BitSize   uint32 `json:"bitSize,omitempty"`
+	BitOffset uint32 `json:"bitOffset,omitempty"`
+}
+
+type arrayDim struct {
+	LBound   int `json:"lBound"`
+	Elements int `json:"elements"`
+}
+
+// Index groups TwinCAT 3 uses for symbols in a module data area.
+const (
+	indexGroupPlcData = 0x4040
+)
+
+// tmcAreaGroups maps the data area types of a .tmc file to the index group their symbols are
+// read with; the offset is the symbol's bit offset in the area. Symbols of other areas, e.g.
+// retain data, keep their type information but get no address, so they are read by name
+// instead of aliasing offsets of another area.
+var tmcAreaGroups = map[string]uint32{
+	"Internal":  indexGroupPlcData,
+	"MArea":     indexGroupMemoryByte,
+	"InputDst":  indexGroupInputByte,
+	"OutputSrc": indexGroupOutputByte,
+}
+
+// maxTypeDepth bounds how deep type references are followed, which is deeper than any real
+// program nests types and stops at cyclic aliases in a corrupt or hand-edited project file.
+const maxTypeDepth = 16
+
+// XML shapes shared by .tpy (TwinCAT 2) and .tmc (TwinCAT 3) files. Both use the same element
+// names for datatypes; .tpy lists symbols at top level with explicit IGroup/IOffset while .tmc
+// nests them inside module data areas addressed by bit offset.
+type xmlProject struct {
+	DataTypes []xmlDataType `xml:"DataTypes>DataType"`
+	Symbols   []xmlSymbol   `xml:"Symbols>Symbol"`
+	DataAreas []xmlDataArea `xml:"Modules>Module>DataAreas>DataArea"`
+}
+
+type xmlDataArea struct {
+	AreaNo  xmlAreaNo   `xml:"AreaNo"`
+	Symbols []xmlSymbol `xml:"Symbol"`
+}
+
+type xmlAreaNo struct {
+	AreaType string `xml:"AreaType,attr"`
+}
+
+type xmlDataType struct {
+	Name     string         `xml:"Name"`
+	Type     string         `xml:"Type"`
+	BaseType string         `xml:"BaseType"`
+	BitSize  uint32         `xml:"BitSize"`
+	Arrays   []xmlArrayInfo `xml:"ArrayInfo"`
+	SubItems []xmlSymbol    `xml:"SubItem"`
+}
+
+type xmlSymbol struct {
+	Name     string         `xml:"Name"`
+	Type     string         `xml:"Type"`
+	BaseType string         `xml:"BaseType"`
+	BitSize  uint32         `xml:"BitSize"`
+	BitOffs  uint32         `xml:"BitOffs"`
+	IGroup   *uint32        `xml:"IGroup"`
+	IOffset  *uint32        `xml:"IOffset"`
+	Arrays   []xmlArrayInfo `xml:"ArrayInfo"`
+}
+
+type xmlArrayInfo struct {
+	LBound   int `xml:"LBound"`
+	Elements int `xml:"Elements"`
+}
+
+func (s xmlSymbol) typeName() string {
+	if s.Type != "" {
+		return strings.TrimSpace(s.Type)
+	}
+	return strings.TrimSpace(s.BaseType)
+}
+
+func convertArrays(in []xmlArrayInfo) []arrayDim {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make([]arrayDim, len(in))
+	for i, a := range in {
+		out[i] = arrayDim{LBound: a.LBound, Elements: a.Elements}
+	}
+	return out
+}
+
+// loadSymbolFile parses a TwinCAT .tmc or .tpy project file into a symbolTable.
+func loadSymbolFile(filePath string) (*symbolTable, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var proj xmlProject
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.CharsetReader = latin1Reader
+	if err = dec.Decode(&proj); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filePath, err)
+	}
+
+	t := &symbolTable{
+		Symbols:   map[string]*symbolInfo{},
+		DataTypes: map[string]*dataTypeInfo{},
+	}
+	for _, dt := range proj.DataTypes {
+		info := &dataTypeInfo{
+			Name:     strings.TrimSpace(dt.Name),
+			BaseType: strings.TrimSpace(dt.Type),
+			Size:     dt.BitSize / 8,
+			Arrays:   convertArrays(dt.Arrays),
+		}
+		if info.BaseType == "" {
+			info.BaseType = strings.TrimSpace(dt.BaseType)
+		}
+		for _, sub := range dt.SubItems {
+			item := subItemInfo{
+				Name:     strings.TrimSpace(sub.Name),
+				DataType: sub.typeName(),
+				Size:     sub.BitSize / 8,
+				Offset:   sub.BitOffs / 8,
+				Arrays:   convertArrays(sub.Arrays),
+			}
+			if sub.BitSize%8 != 0 || sub.BitOffs%8 != 0 {
+				item.BitSize, item.BitOffset = sub.BitSize, sub.BitOffs%8
+				item.Size = (item.BitOffset + item.BitSize + 7) / 8
+			}
+			info.SubItems = append(info.SubItems, item)
+		}
+		t.DataTypes[strings.ToLower(info.Name)] = info
+	}
+
+	// .tpy: explicit index group and offset per symbol.
+	for _, sym := range proj.Symbols {
+		info := &symbolInfo{
+			Name:     strings.TrimSpace(sym.Name),
+			DataType: sym.typeName(),
+			Size:     sym.BitSize / 8,
+			Arrays:   convertArrays(sym.Arrays),
+		}
+		if sym.IGroup != nil && sym.IOffset != nil {
+			info.IndexGroup, info.IndexOffset = *sym.IGroup, *sym.IOffset
+		}
+		t.Symbols[strings.ToLower(info.Name)] = info
+	}
+
+	// .tmc: symbols addressed by bit offset within a data area.
+	for _, area := range proj.DataAreas {
+		group, known := tmcAreaGroups[area.AreaNo.AreaType]
+		for _, sym := range area.Symbols {
+			info := &symbolInfo{
+				Name:     strings.TrimSpace(sym.Name),
+				DataType: sym.typeName(),
+				Size:     sym.BitSize / 8,
+				Arrays:   convertArrays(sym.Arrays),
+			}
+			if known {
+				info.IndexGroup, info.IndexOffset = group, sym.BitOffs/8
+			}
+			t.Symbols[strings.ToLower(info.Name)] = info
+		}
+	}
+
+	if len(t.Symbols) == 0 {
+		return nil, fmt.Errorf("%s contains no symbols", filePath)
+	}
+	return t, nil
+}
+
+// latin1Reader decodes the ISO-8859-1 encoding TwinCAT 2 declares in .tpy files. Windows-1252
+// differs only in typographic characters that are kept as their Latin-1 code points.
+func latin1Reader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252":
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", charset)
+	}
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return strings.NewReader(string(runes)), nil
+}
+
+func (t *symbolTable) symbol(name string) (*symbolInfo, bool) {
+	s, ok := t.Symbols[strings.ToLower(name)]
+	return s, ok
+}
+
+func (t *symbolTable) dataType(name string) (*dataTypeInfo, bool) {
+	d, ok := t.DataTypes[strings.ToLower(name)]
+	return d, ok
+}
+
+// baseTypeName resolves aliases and enums down to the underlying type name.
+func (t *symbolTable) baseTypeName(typeName string) string {
+	for i := 0; i < maxTypeDepth; i++ {
+		dt, ok := t.dataType(typeName)
+		if !ok || dt.BaseType == "" || len(dt.SubItems) > 0 || len(dt.Arrays) > 0 {
+			return typeName
+		}
+		typeName = dt.BaseType
+	}
+	return typeName
+}
+
+// isPrimitive reports whether typeName decodes without consulting the table.
+func isPrimitive(typeName string) bool {
+	upper := strings.ToUpper(typeName)
+	if _, ok := rawTypeSizes[upper]; ok {
+		return true
+	}
+	return strings.HasPrefix(upper, "STRING")
+}
+
+// expand resolves a symbol pattern with '*' and '?' wildcards against the table, case-insensitively.
+// Names without wildcards are returned unchanged, even if they are not in the table.
+func (t *symbolTable) expand(pattern string) ([]string, error) {
+	if !strings.ContainsAny(pattern, "*?") {
+		return []string{pattern}, nil
+	}
+	lowerPattern := strings.ToLower(pattern)
+	var names []string
+	for key, sym := range t.Symbols {
+		ok, err := path.Match(lowerPattern, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if ok {
+			names = append(names, sym.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("pattern %q matches no symbols", pattern)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// expandSymbolPatterns replaces wildcard entries in the configured symbol list with every
+// matching table symbol, keeping any ":maxDelay:cycleTime" suffix.
+func expandSymbolPatterns(entries []string, t *symbolTable) ([]string, error) {
+	var out []string
+	for _, entry := range entries {
+		name, suffix, _ := strings.Cut(entry, ":")
+		if !strings.ContainsAny(name, "*?") || isRawAddress(name) {
+			out = append(out, entry)
+			continue
+		}
+		if t == nil {
+			return nil, fmt.Errorf("wildcard %q requires symbolFile", name)
+		}
+		names, err := t.expand(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			if suffix != "" {
+				n += ":" + suffix
+			}
+			out = append(out, n)
+		}
+	}
+	return out, nil
+}
+
+// decodeJSON decodes raw PLC data of a struct, array or primitive type into a JSON string.
+func (t *symbolTable) decodeJSON(typeName string, arrays []arrayDim, data []byte) (string, error) {
+	v, err := t.decodeValue(typeName, arrays, data, 0)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// decodeValue decodes data of typeName; depth counts the type references followed so far.
+func (t *symbolTable) decodeValue(typeName string, arrays []arrayDim, data []byte, depth int) (any, error) {
+	if depth > maxTypeDepth {
+		return nil, fmt.Errorf("datatype %q nests deeper than %d levels, possibly a cyclic alias", typeName, maxTypeDepth)
+	}
+	if len(arrays) > 0 {
+		return t.decodeArray(arrayElemType(typeName), arrays, data, depth)
+	}
+	if isPrimitive(typeName) {
+		upper := strings.ToUpper(typeName)
+		s, err := decodeRawValue(upper, data)
+		if err != nil {
+			return nil, err
+		}
+		return jsonPrimitive(upper, s), nil
+	}
+
+	dt, ok := t.dataType(typeName)
+	if !ok {
+		return nil, fmt.Errorf("unknown datatype %q", typeName)
+	}
+	if len(dt.Arrays) > 0 {
+		return t.decodeArray(dt.BaseType, dt.Arrays, data, depth+1)
+	}
+	if len(dt.SubItems) == 0 {
+		if dt.BaseType == "" {
+			return nil, fmt.Errorf("datatype %q has no members or base type", typeName)
+		}
+		return t.decodeValue(dt.BaseType, nil, data, depth+1)
+	}
+
+	obj := make(map[string]any, len(dt.SubItems))
+	for _, sub := range dt.SubItems {
+		end := sub.Offset + sub.Size
+		if end > uint32(len(data)) {
+			return nil, fmt.Errorf("member %s.%s exceeds data (%d > %d bytes)", typeName, sub.Name, end, len(data))
+		}
+		if sub.BitSize > 0 {
+			obj[sub.Name] = decodeBits(data[sub.Offset:end], sub.BitOffset, sub.BitSize)
+			continue
+		}
+		v, err := t.decodeValue(sub.DataType, sub.Arrays, data[sub.Offset:end], depth+1)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", typeName, sub.Name, err)
+		}
+		obj[sub.Name] = v
+	}
+	return obj, nil
+}
+
+// decodeBits extracts a packed BIT member. A single bit decodes as a bool, like a %MX address.
+func decodeBits(data []byte, offset, size uint32) any {
+	var v uint64
+	for i, b := range data {
+		v |= uint64(b) << (8 * i)
+	}
+	v = v >> offset & (1<<size - 1)
+	if size == 1 {
+		return v != 0
+	}
+	return v
+}
+
+func (t *symbolTable) decodeArray(elemType string, arrays []arrayDim, data []byte, depth int) (any, error) {
+	n := arrays[0].Elements
+	if n <= 0 {
+		return []any{}, nil
+	}
+	stride := len(data) / n
+	out := make([]any, n)
+	for i := 0; i < n; i++ {
+		v, err := t.decodeValue(elemType, arrays[1:], data[i*stride:(i+1)*stride], depth)
+		if err != nil {
+			return nil, fmt.Errorf("[%d]: %w", arrays[0].LBound+i, err)
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// arrayElemType returns the element type of an array whose dimensions are given separately.
+// .tpy files and the symbol upload name array symbols 'ARRAY [1..3] OF INT', .tmc files by
+// their element type alone.
+func arrayElemType(typeName string) string {
+	if !strings.HasPrefix(strings.ToUpper(typeName), "ARRAY") {
+		return typeName
+	}
+	if i := strings.Index(strings.ToUpper(typeName), " OF "); i >= 0 {
+		return strings.TrimSpace(typeName[i+len(" OF "):])
+	}
+	return typeName
+}
+
+// jsonPrimitive converts a decoded primitive string into the matching JSON value.
+func jsonPrimitive(dataType, s string) any {
+	switch {
+	case dataType == "BOOL":
+		return s == "true"
+	case strings.HasPrefix(dataType, "STRING"), strings.HasPrefix(dataType, "TIME"), dataType == "TOD":
+		return s
+	}
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		return s
+	}
+	return json.Number(s)
+}
+
+// applySymbolTable points every symbol found in the table at its table address, so structs and
+// arrays are read by group/offset and decoded locally. Addresses from an earlier table are
+// replaced; addresses given directly in config are kept.
+func applySymbolTable(symbols []plcSymbol, t *symbolTable) {
+	for i, sym := range symbols {
+		if sym.address != nil && sym.address.table == nil {
+			continue
+		}
+		symbols[i].address = nil
+		if info, ok := t.symbol(sym.name); ok {
+			symbols[i].address = t.tableAddress(info)
+		}
+	}
+}
+
+// tableAddress builds a direct address for a table symbol whose type the go-ads library
+// cannot decode without a full symbol upload (structs, arrays, aliases).
+func (t *symbolTable) tableAddress(sym *symbolInfo) *rawAddress {
+	if sym.IndexGroup == 0 || sym.Size == 0 {
+		return nil
+	}
+	if len(sym.Arrays) == 0 && isPrimitive(t.baseTypeName(sym.DataType)) {
+		return nil
+	}
+	return &rawAddress{
+		indexGroup:  sym.IndexGroup,
+		indexOffset: sym.IndexOffset,
+		length:      sym.Size,
+		dataType:    sym.DataType,
+		bit:         -1,
+		table:       t,
+		arrays:      sym.Arrays,
+	}
+}
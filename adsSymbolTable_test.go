@@ -0,0 +1,249 @@
+package benthosADS
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadSymbolFileTMC(t *testing.T) {
+	table, err := loadSymbolFile("testdata/plc.tmc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		dataType string
+		size     uint32
+		group    uint32
+		offset   uint32
+	}{
+		{"MAIN.bSensor", "BOOL", 1, indexGroupInputByte, 2},
+		{"MAIN.nValve", "INT", 2, indexGroupOutputByte, 0},
+		{"MAIN.stMotor", "ST_Motor", 16, indexGroupPlcData, 256},
+		{"MAIN.nCounter", "DINT", 4, indexGroupPlcData, 272},
+		{"MAIN.aValues", "INT", 8, indexGroupPlcData, 280},
+		{"MAIN.nShiftCount", "DINT", 4, 0, 0}, // retain area: no known index group
+	}
+	for _, tt := range tests {
+		sym, ok := table.symbol(strings.ToLower(tt.name))
+		if !ok {
+			t.Errorf("%s missing from table", tt.name)
+			continue
+		}
+		if sym.Name != tt.name || sym.DataType != tt.dataType || sym.Size != tt.size ||
+			sym.IndexGroup != tt.group || sym.IndexOffset != tt.offset {
+			t.Errorf("%s = %+v, want type %s size %d group 0x%X offset %d",
+				tt.name, *sym, tt.dataType, tt.size, tt.group, tt.offset)
+		}
+	}
+	if sym, _ := table.symbol("MAIN.aValues"); !reflect.DeepEqual(sym.Arrays, []arrayDim{{LBound: 0, Elements: 4}}) {
+		t.Errorf("MAIN.aValues arrays = %v", sym.Arrays)
+	}
+
+	motor, ok := table.dataType("st_motor")
+	if !ok {
+		t.Fatal("ST_Motor missing from datatypes")
+	}
+	want := []subItemInfo{
+		{Name: "fSpeed", DataType: "LREAL", Size: 8, Offset: 0},
+		{Name: "eState", DataType: "E_State", Size: 2, Offset: 8},
+		{Name: "bRunning", DataType: "BOOL", Size: 1, Offset: 10},
+		{Name: "aTemps", DataType: "INT", Size: 4, Offset: 12, Arrays: []arrayDim{{LBound: 1, Elements: 2}}},
+	}
+	if motor.Size != 16 || !reflect.DeepEqual(motor.SubItems, want) {
+		t.Errorf("ST_Motor = %+v", *motor)
+	}
+	if got := table.baseTypeName("E_State"); got != "INT" {
+		t.Errorf("baseTypeName(E_State) = %s, want INT", got)
+	}
+}
+
+func TestLoadSymbolFileTPY(t *testing.T) {
+	table, err := loadSymbolFile("testdata/plc.tpy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		dataType string
+		size     uint32
+		group    uint32
+		offset   uint32
+	}{
+		{".bStart", "BOOL", 1, 0x4040, 0},
+		{"MAIN.stValve", "ST_Valve", 8, 0x4040, 4},
+		{"MAIN.fPressure", "T_Pressure", 4, 0x4040, 12},
+		{"MAIN.aLevels", "ARRAY [1..3] OF INT", 6, 0x4020, 100},
+	}
+	for _, tt := range tests {
+		sym, ok := table.symbol(tt.name)
+		if !ok {
+			t.Errorf("%s missing from table", tt.name)
+			continue
+		}
+		if sym.DataType != tt.dataType || sym.Size != tt.size || sym.IndexGroup != tt.group || sym.IndexOffset != tt.offset {
+			t.Errorf("%s = %+v, want type %s size %d group 0x%X offset %d",
+				tt.name, *sym, tt.dataType, tt.size, tt.group, tt.offset)
+		}
+	}
+	if got := table.baseTypeName("T_Pressure"); got != "REAL" {
+		t.Errorf("baseTypeName(T_Pressure) = %s, want REAL", got)
+	}
+}
+
+func TestLoadSymbolFileErrors(t *testing.T) {
+	if _, err := loadSymbolFile("testdata/missing.tmc"); err == nil {
+		t.Error("missing file: expected an error")
+	}
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"broken.tmc": "<TcModuleClass><DataTypes>",
+		"empty.tmc":  "<TcModuleClass><DataTypes/></TcModuleClass>",
+	} {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadSymbolFile(file); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestTableAddress(t *testing.T) {
+	table, err := loadSymbolFile("testdata/plc.tmc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbols := []plcSymbol{
+		{name: "MAIN.stMotor"},
+		{name: "MAIN.aValues"},
+		{name: "MAIN.nCounter"},    // primitive, read by name
+		{name: "MAIN.nShiftCount"}, // unknown area, read by name
+		{name: "MAIN.notInFile"},
+	}
+	applySymbolTable(symbols, table)
+	if a := symbols[0].address; a == nil || a.indexGroup != indexGroupPlcData || a.indexOffset != 256 || a.length != 16 {
+		t.Errorf("MAIN.stMotor address = %+v", a)
+	}
+	if a := symbols[1].address; a == nil || a.indexOffset != 280 || a.length != 8 {
+		t.Errorf("MAIN.aValues address = %+v", a)
+	}
+	for _, sym := range symbols[2:] {
+		if sym.address != nil {
+			t.Errorf("%s: got address %+v, want a read by name", sym.name, sym.address)
+		}
+	}
+}
+
+func TestDecodeJSONFromFile(t *testing.T) {
+	tmc, err := loadSymbolFile("testdata/plc.tmc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	motor := make([]byte, 16)
+	binary.LittleEndian.PutUint64(motor[0:], math.Float64bits(1450.5))
+	binary.LittleEndian.PutUint16(motor[8:], 1)
+	motor[10] = 1
+	binary.LittleEndian.PutUint16(motor[12:], 21)
+	binary.LittleEndian.PutUint16(motor[14:], 0xFFFE)
+	got, err := tmc.decodeJSON("ST_Motor", nil, motor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"aTemps":[21,-2],"bRunning":true,"eState":1,"fSpeed":1450.5}`; got != want {
+		t.Errorf("ST_Motor = %s, want %s", got, want)
+	}
+
+	values := []byte{1, 0, 2, 0, 3, 0, 4, 0}
+	if got, err := tmc.decodeJSON("INT", []arrayDim{{Elements: 4}}, values); err != nil || got != "[1,2,3,4]" {
+		t.Errorf("MAIN.aValues = %s, %v", got, err)
+	}
+
+	tpy, err := loadSymbolFile("testdata/plc.tpy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels, _ := tpy.symbol("MAIN.aLevels")
+	if got, err := tpy.decodeJSON(levels.DataType, levels.Arrays, []byte{10, 0, 20, 0, 30, 0}); err != nil || got != "[10,20,30]" {
+		t.Errorf("MAIN.aLevels = %s, %v", got, err)
+	}
+	valve := []byte{1, 0, 0x2C, 0x01, 0x00, 0x00, 0x20, 0x41}
+	if got, err := tpy.decodeJSON("ST_Valve", nil, valve); err != nil || got != `{"bOpen":true,"fFlow":10,"nPosition":300}` {
+		t.Errorf("ST_Valve = %s, %v", got, err)
+	}
+	if _, err := tpy.decodeJSON("ST_Valve", nil, valve[:4]); err == nil || !strings.Contains(err.Error(), "exceeds data") {
+		t.Errorf("short ST_Valve: error = %v, want exceeds data", err)
+	}
+}
+
+func TestLoadSymbolFileBitMembers(t *testing.T) {
+	table, err := loadSymbolFile("testdata/bits.tmc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags, ok := table.dataType("ST_Flags")
+	if !ok {
+		t.Fatal("ST_Flags missing from datatypes")
+	}
+	want := []subItemInfo{
+		{Name: "bReady", DataType: "BIT", Size: 1, Offset: 0, BitSize: 1, BitOffset: 0},
+		{Name: "bFault", DataType: "BIT", Size: 1, Offset: 0, BitSize: 1, BitOffset: 1},
+		{Name: "bManual", DataType: "BIT", Size: 1, Offset: 1, BitSize: 1, BitOffset: 1},
+		{Name: "nCode", DataType: "INT", Size: 2, Offset: 2},
+	}
+	if !reflect.DeepEqual(flags.SubItems, want) {
+		t.Errorf("ST_Flags members = %+v, want %+v", flags.SubItems, want)
+	}
+
+	got, err := table.decodeJSON("ST_Flags", nil, []byte{0b10, 0b10, 7, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"bFault":true,"bManual":true,"bReady":false,"nCode":7}`; got != want {
+		t.Errorf("ST_Flags = %s, want %s", got, want)
+	}
+}
+
+func TestDecodeJSONCyclicAlias(t *testing.T) {
+	table := &symbolTable{DataTypes: map[string]*dataTypeInfo{
+		"t_a":  {Name: "T_A", BaseType: "T_B", Size: 4},
+		"t_b":  {Name: "T_B", BaseType: "T_A", Size: 4},
+		"st_x": {Name: "ST_X", Size: 4, SubItems: []subItemInfo{{Name: "self", DataType: "ST_X", Size: 4}}},
+	}}
+	for _, typeName := range []string{"T_A", "ST_X"} {
+		_, err := table.decodeJSON(typeName, nil, make([]byte, 4))
+		if err == nil || !strings.Contains(err.Error(), "cyclic") {
+			t.Errorf("%s: error = %v, want a cyclic type error", typeName, err)
+		}
+	}
+	if got := table.baseTypeName("T_A"); got != "T_A" && got != "T_B" {
+		t.Errorf("baseTypeName(T_A) = %s", got)
+	}
+}
+
+func TestExpandSymbolPatterns(t *testing.T) {
+	table, err := loadSymbolFile("testdata/plc.tmc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := expandSymbolPatterns([]string{"main.n*:0:10", "MAIN.bSensor", "%MD100"}, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"MAIN.nCounter:0:10", "MAIN.nShiftCount:0:10", "MAIN.nValve:0:10", "MAIN.bSensor", "%MD100"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandSymbolPatterns = %v, want %v", got, want)
+	}
+	if _, err := expandSymbolPatterns([]string{"GVL.*"}, table); err == nil {
+		t.Error("pattern without matches: expected an error")
+	}
+	if _, err := expandSymbolPatterns([]string{"MAIN.*"}, nil); err == nil {
+		t.Error("wildcard without table: expected an error")
+	}
+}
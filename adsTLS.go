@@ -0,0 +1,320 @@
+package benthosADS
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// secureADSPort is the TCP port of Secure ADS on TwinCAT 3.1 routers.
+const secureADSPort = 8016
+
+// adsTLSField describes the Secure ADS settings shared by every ADS component.
+func adsTLSField() *service.ConfigField {
+	return service.NewObjectField("tls",
+		service.NewBoolField("enabled").Description("Connect with Secure ADS (TLS) instead of plain ADS.").Default(false),
+		service.NewIntField("port").Description("TCP port of Secure ADS on the PLC.").Default(secureADSPort),
+		service.NewStringField("serverName").Description("Name the PLC certificate must be issued for. Defaults to targetIP.").Default(""),
+		service.NewStringField("caFile").Description("PEM file with the CA that signed the PLC certificate (Secure ADS with a shared CA).").Default(""),
+		service.NewStringField("serverFingerprint").Description("SHA-256 fingerprint of the PLC certificate, as shown by TwinCAT. Pins a self-signed PLC certificate instead of verifying it against a CA.").Default(""),
+		service.NewStringField("certFile").Description("PEM file with the client certificate.").Default(""),
+		service.NewStringField("keyFile").Description("PEM file with the client certificate's private key.").Default(""),
+		service.NewStringField("selfSignedDir").Description("Directory in which a self-signed client certificate is created and kept when certFile is empty. Its fingerprint is logged for the PLC's route.").Default(""),
+	).Description("Secure ADS (TLS) settings. TwinCAT 3.1 routers accept Secure ADS on port 8016.").Optional()
+}
+
+// adsTLSConfig is the parsed tls object.
+type adsTLSConfig struct {
+	port        int
+	serverName  string
+	roots       *x509.CertPool
+	fingerprint []byte
+	cert        *tls.Certificate
+}
+
+// parseAdsTLSConfig reads the tls object; it returns nil when Secure ADS is not enabled.
+func parseAdsTLSConfig(conf *service.ParsedConfig, log *service.Logger) (*adsTLSConfig, error) {
+	if !conf.Contains("tls") {
+		return nil, nil
+	}
+	enabled, err := conf.FieldBool("tls", "enabled")
+	if err != nil || !enabled {
+		return nil, err
+	}
+
+	strField := func(name string) string {
+		if err == nil {
+			var v string
+			v, err = conf.FieldString("tls", name)
+			return v
+		}
+		return ""
+	}
+	serverName := strField("serverName")
+	caFile := strField("caFile")
+	fingerprint := strField("serverFingerprint")
+	certFile := strField("certFile")
+	keyFile := strField("keyFile")
+	selfSignedDir := strField("selfSignedDir")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &adsTLSConfig{serverName: serverName}
+	if t.port, err = conf.FieldInt("tls", "port"); err != nil {
+		return nil, err
+	}
+	if t.port <= 0 || t.port > 65535 {
+		return nil, fmt.Errorf("tls.port %d out of range 1–65535", t.port)
+	}
+
+	if caFile == "" && fingerprint == "" {
+		return nil, errors.New("tls: set caFile or serverFingerprint so the PLC certificate can be verified")
+	}
+	if caFile != "" {
+		pemData, rerr := os.ReadFile(caFile)
+		if rerr != nil {
+			return nil, fmt.Errorf("tls.caFile: %w", rerr)
+		}
+		t.roots = x509.NewCertPool()
+		if !t.roots.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("tls.caFile: no certificates found in %s", caFile)
+		}
+	}
+	if fingerprint != "" {
+		if t.fingerprint, err = parseFingerprint(fingerprint); err != nil {
+			return nil, fmt.Errorf("tls.serverFingerprint: %w", err)
+		}
+	}
+
+	switch {
+	case certFile != "" || keyFile != "":
+		cert, cerr := tls.LoadX509KeyPair(certFile, keyFile)
+		if cerr != nil {
+			return nil, fmt.Errorf("tls: loading client certificate: %w", cerr)
+		}
+		t.cert = &cert
+	case selfSignedDir != "":
+		cert, cerr := loadOrCreateSelfSigned(selfSignedDir)
+		if cerr != nil {
+			return nil, fmt.Errorf("tls.selfSignedDir: %w", cerr)
+		}
+		t.cert = cert
+		log.Infof("Secure ADS client certificate fingerprint (add to the PLC route): %s", formatFingerprint(cert.Certificate[0]))
+	}
+	return t, nil
+}
+
+// parseFingerprint accepts SHA-256 fingerprints as plain hex or colon/space separated.
+func parseFingerprint(s string) ([]byte, error) {
+	s = strings.NewReplacer(":", "", " ", "", "-", "").Replace(strings.TrimPrefix(strings.ToLower(s), "sha256:"))
+	fp, err := hex.DecodeString(s)
+	if err != nil || len(fp) != sha256.Size {
+		return nil, fmt.Errorf("%q is not a SHA-256 fingerprint", s)
+	}
+	return fp, nil
+}
+
+func formatFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// loadOrCreateSelfSigned returns the client certificate kept in dir, creating it on first use
+// so its fingerprint stays the same across restarts.
+func loadOrCreateSelfSigned(dir string) (*tls.Certificate, error) {
+	certPath, keyPath := filepath.Join(dir, "benthosADS-client.crt"), filepath.Join(dir, "benthosADS-client.key")
+	if cert, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil {
+		return &cert, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "benthosADS " + host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(20, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return nil, err
+	}
+	if err = writeFileAtomic(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})); err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// clientConfig builds the tls.Config for a connection to host. The PLC certificate is
+// verified against the CA, or against the pinned fingerprint when only that is set.
+func (t *adsTLSConfig) clientConfig(host string) *tls.Config {
+	serverName := t.serverName
+	if serverName == "" {
+		serverName = host
+	}
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		// Verification is done in VerifyConnection so errors can name the certificate received.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return t.verifyPLC(cs, serverName)
+		},
+	}
+	if t.cert != nil {
+		cfg.Certificates = []tls.Certificate{*t.cert}
+	}
+	return cfg
+}
+
+// verifyPLC checks the certificate the PLC presented.
+func (t *adsTLSConfig) verifyPLC(cs tls.ConnectionState, serverName string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("PLC presented no certificate")
+	}
+	leaf := cs.PeerCertificates[0]
+	got := formatFingerprint(leaf.Raw)
+
+	if t.fingerprint != nil {
+		sum := sha256.Sum256(leaf.Raw)
+		if !bytes.Equal(sum[:], t.fingerprint) {
+			return fmt.Errorf("PLC certificate %q has fingerprint %s, which does not match serverFingerprint; if the PLC certificate was renewed, update serverFingerprint", leaf.Subject.CommonName, got)
+		}
+		return nil
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         t.roots,
+		Intermediates: intermediates,
+		DNSName:       serverName,
+	})
+	if err != nil {
+		var hostErr x509.HostnameError
+		if errors.As(err, &hostErr) {
+			return fmt.Errorf("PLC certificate %q is not valid for %q (set tls.serverName): %w", leaf.Subject.CommonName, serverName, err)
+		}
+		if leaf.Issuer.String() == leaf.Subject.String() {
+			return fmt.Errorf("PLC presented self-signed certificate %q (fingerprint %s) not signed by caFile; set serverFingerprint to trust it", leaf.Subject.CommonName, got)
+		}
+		return fmt.Errorf("PLC certificate %q (fingerprint %s) failed verification: %w", leaf.Subject.CommonName, got, err)
+	}
+	return nil
+}
+
+// tlsTunnel carries a go-ads session over Secure ADS. The session connects to a loopback
+// listener and its connection is relayed over the TLS connection to the PLC.
+type tlsTunnel struct {
+	listener *sessionListener
+	remote   net.Conn // established up front so handshake errors surface in Connect
+
+	mu     sync.Mutex
+	local  net.Conn
+	closed bool
+}
+
+// openTLSTunnel performs the TLS handshake with the PLC at targetIP and returns a tunnel whose
+// local address the session should connect to. host is the configured targetIP, used to verify
+// the certificate when serverName is not set.
+func openTLSTunnel(ctx context.Context, t *adsTLSConfig, targetIP, host string) (*tlsTunnel, error) {
+	addr := net.JoinHostPort(targetIP, strconv.Itoa(t.port))
+	d := tls.Dialer{NetDialer: &net.Dialer{Timeout: 10 * time.Second}, Config: t.clientConfig(host)}
+	remote, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("secure ADS handshake with %s: %w", addr, err)
+	}
+	ln, err := listenSession()
+	if err != nil {
+		remote.Close()
+		return nil, err
+	}
+	tun := &tlsTunnel{listener: ln, remote: remote}
+	go tun.serve()
+	return tun, nil
+}
+
+// port returns the loopback port of the tunnel.
+func (tun *tlsTunnel) port() int {
+	return tun.listener.port()
+}
+
+// serve relays the session's connection until either side closes.
+func (tun *tlsTunnel) serve() {
+	defer tun.close()
+	local, err := tun.listener.accept()
+	if err != nil {
+		return
+	}
+	tun.mu.Lock()
+	if tun.closed {
+		tun.mu.Unlock()
+		local.Close()
+		return
+	}
+	tun.local = local
+	tun.mu.Unlock()
+
+	done := make(chan struct{}, 2)
+	go func() { _, _ = io.Copy(tun.remote, local); done <- struct{}{} }()
+	go func() { _, _ = io.Copy(local, tun.remote); done <- struct{}{} }()
+	<-done
+}
+
+func (tun *tlsTunnel) close() {
+	tun.listener.close()
+	tun.mu.Lock()
+	defer tun.mu.Unlock()
+	tun.closed = true
+	tun.remote.Close()
+	if tun.local != nil {
+		tun.local.Close()
+	}
+}
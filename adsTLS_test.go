@@ -0,0 +1,90 @@
+package benthosADS
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTLSEcho runs a TLS echo server with a self-signed certificate and returns its port and
+// the SHA-256 fingerprint of the certificate.
+func startTLSEcho(t *testing.T) (int, []byte) {
+	t.Helper()
+	cert, err := loadOrCreateSelfSigned(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	sum := sha256.Sum256(cert.Certificate[0])
+	return ln.Addr().(*net.TCPAddr).Port, sum[:]
+}
+
+func TestTLSTunnelAcceptsOneConnection(t *testing.T) {
+	port, fingerprint := startTLSEcho(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tun, err := openTLSTunnel(ctx, &adsTLSConfig{port: port, fingerprint: fingerprint}, "127.0.0.1", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.close()
+
+	addr := "127.0.0.1:" + strconv.Itoa(tun.port())
+	session, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	_ = session.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = session.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(session, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("relayed %q, %v", buf, err)
+	}
+
+	// The session holds the only connection; nobody else on the host gets through the tunnel.
+	if other, err := net.Dial("tcp", addr); err == nil {
+		other.Close()
+		t.Error("tunnel accepted a second connection")
+	}
+
+	tun.close()
+	if _, err = session.Read(buf); err == nil {
+		t.Error("session connection still open after the tunnel closed")
+	}
+}
+
+func TestTLSTunnelFingerprintMismatch(t *testing.T) {
+	port, fingerprint := startTLSEcho(t)
+	fingerprint[0] ^= 0xFF
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := openTLSTunnel(ctx, &adsTLSConfig{port: port, fingerprint: fingerprint}, "127.0.0.1", "127.0.0.1")
+	if err == nil || !strings.Contains(err.Error(), "does not match serverFingerprint") {
+		t.Errorf("error = %v, want a fingerprint mismatch", err)
+	}
+}
@@ -0,0 +1,256 @@
+package benthosADS
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	adsLib "github.com/RuneRoven/go-ads/v2"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// adsTargetFields describes one entry of the ads input's targets list. Unset fields fall back
+// to the top-level value of the same name.
+func adsTargetFields() []*service.ConfigField {
+	fields := []*service.ConfigField{
+		service.NewStringField("name").Description("Name of the target, set as target_name metadata on every message."),
+		service.NewStringField("targetIP").Description("IP address or hostname of the PLC."),
+		service.NewStringField("targetAMS").Description("Target AMS net ID."),
+		service.NewIntField("targetPort").Description("TCP port of the PLC ADS gateway.").Optional(),
+		service.NewIntField("runtimePort").Description("Target runtime port.").Optional(),
+		service.NewStringField("hostAMS").Description("Local AMS net ID for this target.").Optional(),
+		service.NewStringField("routeUsername").Description("Username for UDP route registration on this PLC.").Optional(),
+		service.NewStringField("routePassword").Description("Password for UDP route registration on this PLC.").Secret().Optional(),
+	}
+	fields = append(fields, credentialSourceFields("routeUsername")...)
+	fields = append(fields, credentialSourceFields("routePassword")...)
+	return append(fields,
+		service.NewStringField("routeHostAddress").Description("The address this PLC should use to reach this client.").Optional(),
+		service.NewStringField("routeName").Description("Name of the route registered on this PLC.").Optional(),
+		service.NewAnyListField("symbols").Description("Symbols to read from this target, replacing the top-level symbols list. Entries take the same forms as there.").Optional(),
+	)
+}
+
+// parseAdsTarget builds the connection settings of one target on top of the top-level settings.
+func parseAdsTarget(base adsConnConfig, t *service.ParsedConfig) (string, adsConnConfig, error) {
+	c := base
+	name, err := t.FieldString("name")
+	if err != nil {
+		return "", c, err
+	}
+	if c.targetIP, err = t.FieldString("targetIP"); err != nil {
+		return name, c, err
+	}
+	if c.targetAMS, err = t.FieldString("targetAMS"); err != nil {
+		return name, c, err
+	}
+	if err = c.validateTarget(); err != nil {
+		return name, c, err
+	}
+
+	if t.Contains("targetPort") {
+		if c.targetPort, err = t.FieldInt("targetPort"); err != nil {
+			return name, c, err
+		}
+	}
+	if t.Contains("runtimePort") {
+		if c.runtimePort, err = t.FieldInt("runtimePort"); err != nil {
+			return name, c, err
+		}
+		if c.runtimePort < 0 || c.runtimePort > 65535 {
+			return name, c, fmt.Errorf("runtimePort %d out of range 0–65535", c.runtimePort)
+		}
+	}
+	if t.Contains("hostAMS") {
+		if c.hostAMS, err = t.FieldString("hostAMS"); err != nil {
+			return name, c, err
+		}
+		if c.hostAMS != "auto" && c.hostAMS != "" {
+			if err = validateAMSNetID(c.hostAMS); err != nil {
+				return name, c, fmt.Errorf("hostAMS: %w", err)
+			}
+		}
+	}
+	for field, dst := range map[string]*string{
+		"routeHostAddress": &c.routeHostAddress,
+		"routeName":        &c.routeName,
+	} {
+		if t.Contains(field) {
+			if *dst, err = t.FieldString(field); err != nil {
+				return name, c, err
+			}
+		}
+	}
+	// Credentials: the target's own, then the first matching routeCredentials entry, then the
+	// top-level ones. A credential the target doesn't set keeps the already resolved inherited value.
+	username, usernameSet, err := parseCredential(t, "routeUsername")
+	if err != nil {
+		return name, c, err
+	}
+	password, passwordSet, err := parseCredential(t, "routePassword")
+	if err != nil {
+		return name, c, err
+	}
+	if usernameSet || passwordSet {
+		if usernameSet {
+			c.routeUsername = username
+		}
+		if passwordSet {
+			c.routePassword = password
+		}
+	} else {
+		c.matchRouteCredentials(name)
+	}
+	// Re-derive an automatic hostAMS from the target's own routeHostAddress.
+	autoHostAMS := base.hostAMS == "auto" || (base.routeHostAddress != "" && base.hostAMS == base.routeHostAddress+".1.1")
+	if !t.Contains("hostAMS") && t.Contains("routeHostAddress") && autoHostAMS && c.routeHostAddress != "" {
+		if validateIP(c.routeHostAddress) != nil {
+			return name, c, fmt.Errorf("hostAMS: 'auto' needs an IPv4 routeHostAddress, got %q; set hostAMS explicitly", c.routeHostAddress)
+		}
+		c.hostAMS = c.routeHostAddress + ".1.1"
+	}
+	return name, c, nil
+}
+
+// forTarget returns a copy of the input that reads from another PLC with its own session,
+// channels and metadata maps.
+func (g *adsCommInput) forTarget(name string, conn adsConnConfig, symbols []plcSymbol) *adsCommInput {
+	c := *g
+	c.adsConnConfig = conn
+	c.targetName = name
+	c.symbols = append([]plcSymbol(nil), symbols...)
+	c.handler = nil
+	c.notificationHandles = nil
+	c.shards = nil
+	c.pollStop = nil
+	c.retryStop, c.retryExited = nil, nil
+	c.notificationChan = make(chan *adsLib.Update, cap(g.notificationChan))
+	c.done = make(chan struct{})
+	c.symbolCacheInfo = symbolUploadInfo{}
+	return &c
+}
+
+// adsTargetReconnectInterval is how long a target waits before reconnecting or reading again after a failure.
+const adsTargetReconnectInterval = 5 * time.Second
+
+type adsTargetBatch struct {
+	batch service.MessageBatch
+	ack   service.AckFunc
+}
+
+// adsMultiInput fans in batches from several PLCs. Each target runs its own connect/read loop
+// so one unreachable PLC never blocks data from the others.
+type adsMultiInput struct {
+	targets []*adsCommInput
+	log     *service.Logger
+
+	batches chan adsTargetBatch
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	started bool
+}
+
+func newAdsMultiInput(targets []*adsCommInput, log *service.Logger) *adsMultiInput {
+	return &adsMultiInput{
+		targets: targets,
+		log:     log,
+		batches: make(chan adsTargetBatch, len(targets)),
+		stop:    make(chan struct{}),
+	}
+}
+
+func (m *adsMultiInput) Connect(ctx context.Context) error {
+	if m.started {
+		return nil
+	}
+	m.started = true
+	for _, t := range m.targets {
+		m.wg.Add(1)
+		go m.runTarget(t)
+	}
+	return nil
+}
+
+// runTarget connects one target and forwards its batches until Close, reconnecting on connection loss.
+func (m *adsMultiInput) runTarget(t *adsCommInput) {
+	defer m.wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-m.stop
+		cancel()
+	}()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := t.Connect(ctx); err != nil {
+			m.log.Errorf("Target %s (%s): connect failed, retrying in %v: %v", t.targetName, t.targetIP, adsTargetReconnectInterval, err)
+			select {
+			case <-time.After(adsTargetReconnectInterval):
+				continue
+			case <-m.stop:
+				return
+			}
+		}
+		m.log.Infof("Target %s (%s) connected", t.targetName, t.targetIP)
+
+		for ctx.Err() == nil {
+			batch, ack, err := t.ReadBatch(ctx)
+			if errors.Is(err, service.ErrNotConnected) {
+				m.log.Warnf("Target %s (%s) lost connection, reconnecting", t.targetName, t.targetIP)
+				break
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				// A failing read usually fails again at once; wait instead of spinning on it.
+				m.log.Errorf("Target %s (%s): read failed, retrying in %v: %v", t.targetName, t.targetIP, adsTargetReconnectInterval, err)
+				select {
+				case <-time.After(adsTargetReconnectInterval):
+					continue
+				case <-m.stop:
+					return
+				}
+			}
+			if len(batch) == 0 {
+				continue
+			}
+			select {
+			case m.batches <- adsTargetBatch{batch: batch, ack: ack}:
+			case <-m.stop:
+				return
+			}
+		}
+	}
+}
+
+func (m *adsMultiInput) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	select {
+	case b := <-m.batches:
+		return b.batch, b.ack, nil
+	case <-m.stop:
+		return nil, nil, service.ErrEndOfInput
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+func (m *adsMultiInput) Close(ctx context.Context) error {
+	select {
+	case <-m.stop:
+		return nil
+	default:
+		close(m.stop)
+	}
+	// Stop the read loops before closing sessions so no target is read while it shuts down.
+	m.wg.Wait()
+	for _, t := range m.targets {
+		_ = t.Close(ctx)
+	}
+	return nil
+}
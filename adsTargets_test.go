@@ -0,0 +1,182 @@
+package benthosADS
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+func TestParseAdsTarget(t *testing.T) {
+	t.Setenv("ADS_TEST_OVEN_PASSWORD", "oven")
+	base := adsConnConfig{
+		targetPort:       48898,
+		runtimePort:      851,
+		hostAMS:          "auto",
+		hostPort:         10500,
+		routeUsername:    "Administrator",
+		routePassword:    "file:looks-like-a-reference", // resolved already, used as is
+		routeHostAddress: "",
+		routeCredentials: []routeCredential{
+			{match: "line1-*", username: "line1", password: "line1-secret"},
+			{match: "192.168.4.*", username: "line2", password: "line2-secret"},
+		},
+	}
+	spec := service.NewConfigSpec().Fields(adsTargetFields()...)
+	target := func(extra string) string {
+		return "name: press\ntargetIP: 192.168.3.10\ntargetAMS: 192.168.3.10.1.1\n" + extra
+	}
+
+	tests := []struct {
+		name    string
+		yaml    string
+		change  func(c *adsConnConfig) // applied to the expected config, starting from base
+		wantErr string
+	}{
+		{
+			name: "inherits the top-level settings",
+			yaml: target(""),
+		},
+		{
+			name: "overrides connection settings",
+			yaml: target("targetPort: 48899\nruntimePort: 852\nhostAMS: 10.0.0.5.1.1\nrouteName: press"),
+			change: func(c *adsConnConfig) {
+				c.targetPort, c.runtimePort, c.hostAMS, c.routeName = 48899, 852, "10.0.0.5.1.1", "press"
+			},
+		},
+		{
+			name: "own username keeps the resolved top-level password",
+			yaml: target("routeUsername: operator"),
+			change: func(c *adsConnConfig) {
+				c.routeUsername = "operator"
+			},
+		},
+		{
+			name: "own password from the environment",
+			yaml: target("routePasswordEnv: ADS_TEST_OVEN_PASSWORD"),
+			change: func(c *adsConnConfig) {
+				c.routePassword = "oven"
+			},
+		},
+		{
+			name: "routeCredentials matched by name",
+			yaml: "name: line1-press\ntargetIP: 192.168.3.10\ntargetAMS: 192.168.3.10.1.1\n",
+			change: func(c *adsConnConfig) {
+				c.routeUsername, c.routePassword = "line1", "line1-secret"
+			},
+		},
+		{
+			name: "routeCredentials matched by targetIP",
+			yaml: "name: oven\ntargetIP: 192.168.4.20\ntargetAMS: 192.168.4.20.1.1\n",
+			change: func(c *adsConnConfig) {
+				c.targetIP, c.targetAMS = "192.168.4.20", "192.168.4.20.1.1"
+				c.routeUsername, c.routePassword = "line2", "line2-secret"
+			},
+		},
+		{
+			name: "own credentials win over routeCredentials",
+			yaml: "name: line1-press\ntargetIP: 192.168.3.10\ntargetAMS: 192.168.3.10.1.1\nroutePassword: own\n",
+			change: func(c *adsConnConfig) {
+				c.routePassword = "own"
+			},
+		},
+		{
+			name: "automatic hostAMS follows routeHostAddress",
+			yaml: target("routeHostAddress: 192.168.3.1"),
+			change: func(c *adsConnConfig) {
+				c.routeHostAddress, c.hostAMS = "192.168.3.1", "192.168.3.1.1.1"
+			},
+		},
+		{name: "invalid targetAMS", yaml: "name: press\ntargetIP: 192.168.3.10\ntargetAMS: 192.168.3\n", wantErr: "targetAMS"},
+		{name: "runtimePort out of range", yaml: target("runtimePort: 70000"), wantErr: "runtimePort 70000 out of range"},
+		{name: "invalid hostAMS", yaml: target("hostAMS: 10.0.0.5"), wantErr: "hostAMS"},
+		{name: "hostname with automatic hostAMS", yaml: target("routeHostAddress: client.local"), wantErr: "needs an IPv4 routeHostAddress"},
+		{name: "two forms of the password", yaml: target("routePassword: own\nroutePasswordEnv: ADS_TEST_OVEN_PASSWORD"), wantErr: "set only one of"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := spec.ParseYAML(tt.yaml, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			name, got, err := parseAdsTarget(base, conf)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := base
+			want.targetIP, want.targetAMS = "192.168.3.10", "192.168.3.10.1.1"
+			if tt.change != nil {
+				tt.change(&want)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s = %+v\nwant %+v", name, got, want)
+			}
+		})
+	}
+}
+
+func TestInputReadsTwoTargets(t *testing.T) {
+	line1, line2 := newTestServer(t), newTestServer(t)
+	addSymbols(t, line1, "DINT", "MAIN.counter")
+	addSymbols(t, line2, "DINT", "MAIN.counter")
+	addSymbols(t, line2, "INT", "MAIN.level")
+	if err := line1.SetValue("MAIN.counter", 11); err != nil {
+		t.Fatal(err)
+	}
+	if err := line2.SetValue("MAIN.level", 22); err != nil {
+		t.Fatal(err)
+	}
+	in := newServerInput(t, line1, fmt.Sprintf(`
+readType: interval
+intervalTime: 10
+symbols: [ MAIN.counter ]
+targets:
+  - name: line1
+    targetIP: 127.0.0.1
+    targetPort: %d
+    targetAMS: %s
+  - name: line2
+    targetIP: 127.0.0.1
+    targetPort: %d
+    targetAMS: %s
+    symbols: [ MAIN.level ]
+`, line1.Addr().Port, line1.NetID(), line2.Addr().Port, line2.NetID()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := in.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"line1 " + line1.NetID() + " MAIN_counter": "11",
+		"line2 " + line2.NetID() + " MAIN_level":   "22",
+	}
+	got := map[string]string{}
+	for !reflect.DeepEqual(got, want) {
+		batch, _, err := in.ReadBatch(ctx)
+		if err != nil {
+			t.Fatalf("ReadBatch: %v (values so far %v)", err, got)
+		}
+		for _, msg := range batch {
+			target, _ := msg.MetaGet("target_name")
+			ams, _ := msg.MetaGet("target_ams")
+			symbol, _ := msg.MetaGet("symbol_name")
+			value, _ := msg.AsBytes()
+			key := target + " " + ams + " " + symbol
+			if _, ok := want[key]; !ok {
+				t.Fatalf("unexpected message %s = %s", key, value)
+			}
+			got[key] = string(value)
+		}
+	}
+}
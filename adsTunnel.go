@@ -0,0 +1,61 @@
+package benthosADS
+
+import (
+	"net"
+	"time"
+
+	adsLib "github.com/RuneRoven/go-ads/v2"
+)
+
+// adsTunnel is a loopback endpoint that carries a session over another transport
+// (Secure ADS or ADS-over-MQTT), since go-ads always dials a plain TCP address.
+type adsTunnel interface {
+	port() int
+	close()
+}
+
+// closeTunnelWith shuts tun down once session is closed.
+func closeTunnelWith(tun adsTunnel, session *adsLib.Session) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if session.IsClosed() {
+			tun.close()
+			return
+		}
+	}
+}
+
+// sessionListener is the loopback listener of a tunnel. Any local process can connect to it,
+// and the tunnel speaks for this client to the PLC, so it hands out exactly one connection and
+// stops listening right after. The session connects as soon as the tunnel is open; should
+// another process get there first, the session's own connection is refused, openSession fails
+// and closes the tunnel together with the stray connection. A session that loses its
+// connection is not reconnected through the tunnel: the component reconnects with a new one.
+type sessionListener struct {
+	ln net.Listener
+}
+
+func listenSession() (*sessionListener, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return &sessionListener{ln: ln}, nil
+}
+
+func (l *sessionListener) port() int {
+	return l.ln.Addr().(*net.TCPAddr).Port
+}
+
+// accept waits for the session's connection and closes the listener, so every later
+// connection is refused.
+func (l *sessionListener) accept() (net.Conn, error) {
+	conn, err := l.ln.Accept()
+	l.close()
+	return conn, err
+}
+
+func (l *sessionListener) close() {
+	l.ln.Close()
+}
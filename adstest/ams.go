@@ -0,0 +1,187 @@
+package adstest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Command is an ADS command ID.
+type Command uint16
+
+// ADS commands.
+const (
+	CmdReadDeviceInfo     Command = 1
+	CmdRead               Command = 2
+	CmdWrite              Command = 3
+	CmdReadState          Command = 4
+	CmdWriteControl       Command = 5
+	CmdAddNotification    Command = 6
+	CmdDeleteNotification Command = 7
+	CmdDeviceNotification Command = 8
+	CmdReadWrite          Command = 9
+)
+
+// ADS error codes commonly injected with Fault.
+const (
+	ErrDeviceError          uint32 = 0x700
+	ErrServiceNotSupported  uint32 = 0x701
+	ErrInvalidIndexGroup    uint32 = 0x702
+	ErrInvalidIndexOffset   uint32 = 0x703
+	ErrInvalidAccess        uint32 = 0x704
+	ErrInvalidSize          uint32 = 0x705
+	ErrInvalidData          uint32 = 0x706
+	ErrNotReady             uint32 = 0x707
+	ErrBusy                 uint32 = 0x708
+	ErrNotFound             uint32 = 0x70C
+	ErrSymbolNotFound       uint32 = 0x710
+	ErrInvalidNotifyHandle  uint32 = 0x714
+	ErrNoMoreNotifyHandles  uint32 = 0x716
+	ErrTimeout              uint32 = 0x719
+	errTargetPortNotFound   uint32 = 0x06
+	errTargetMachineMissing uint32 = 0x07
+)
+
+const (
+	flagResponse   uint16 = 0x0001
+	flagADSCommand uint16 = 0x0004
+
+	tcpHeaderLen = 6
+	amsHeaderLen = 32
+	maxPacketLen = 16 << 20
+)
+
+// netID is a 6 byte AMS net ID.
+type netID [6]byte
+
+func parseNetID(s string) (netID, error) {
+	var id netID
+	parts := strings.Split(s, ".")
+	if len(parts) != 6 {
+		return id, fmt.Errorf("AMS net ID %q must have 6 octets", s)
+	}
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 0 || v > 255 {
+			return id, fmt.Errorf("AMS net ID %q has invalid octet %q", s, p)
+		}
+		id[i] = byte(v)
+	}
+	return id, nil
+}
+
+func (id netID) String() string {
+	return fmt.Sprintf("%d.%d.%d.%d.%d.%d", id[0], id[1], id[2], id[3], id[4], id[5])
+}
+
+type amsAddr struct {
+	netID netID
+	port  uint16
+}
+
+// packet is one AMS/TCP frame. Router commands (tcpCommand != 0) only carry data.
+type packet struct {
+	tcpCommand uint16
+	target     amsAddr
+	source     amsAddr
+	command    Command
+	stateFlags uint16
+	errorCode  uint32
+	invokeID   uint32
+	data       []byte
+}
+
+func readPacket(r io.Reader) (*packet, error) {
+	var hdr [tcpHeaderLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	p := &packet{tcpCommand: le.Uint16(hdr[0:])}
+	length := le.Uint32(hdr[2:])
+	if length > maxPacketLen {
+		return nil, fmt.Errorf("AMS packet of %d bytes exceeds limit", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	if p.tcpCommand != 0 {
+		p.data = body
+		return p, nil
+	}
+	if len(body) < amsHeaderLen {
+		return nil, fmt.Errorf("AMS packet too short: %d bytes", len(body))
+	}
+	copy(p.target.netID[:], body[0:6])
+	p.target.port = le.Uint16(body[6:])
+	copy(p.source.netID[:], body[8:14])
+	p.source.port = le.Uint16(body[14:])
+	p.command = Command(le.Uint16(body[16:]))
+	p.stateFlags = le.Uint16(body[18:])
+	dataLen := le.Uint32(body[20:])
+	p.errorCode = le.Uint32(body[24:])
+	p.invokeID = le.Uint32(body[28:])
+	if int(dataLen) > len(body)-amsHeaderLen {
+		return nil, errors.New("AMS data length exceeds packet")
+	}
+	p.data = body[amsHeaderLen : amsHeaderLen+int(dataLen)]
+	return p, nil
+}
+
+func (p *packet) marshal() []byte {
+	le := binary.LittleEndian
+	buf := make([]byte, tcpHeaderLen+amsHeaderLen+len(p.data))
+	le.PutUint32(buf[2:], uint32(amsHeaderLen+len(p.data)))
+	h := buf[tcpHeaderLen:]
+	copy(h[0:6], p.target.netID[:])
+	le.PutUint16(h[6:], p.target.port)
+	copy(h[8:14], p.source.netID[:])
+	le.PutUint16(h[14:], p.source.port)
+	le.PutUint16(h[16:], uint16(p.command))
+	le.PutUint16(h[18:], p.stateFlags)
+	le.PutUint32(h[20:], uint32(len(p.data)))
+	le.PutUint32(h[24:], p.errorCode)
+	le.PutUint32(h[28:], p.invokeID)
+	copy(h[amsHeaderLen:], p.data)
+	return buf
+}
+
+func (p *packet) response(errorCode uint32, data []byte) *packet {
+	return &packet{
+		target:     p.source,
+		source:     p.target,
+		command:    p.command,
+		stateFlags: flagResponse | flagADSCommand,
+		errorCode:  errorCode,
+		invokeID:   p.invokeID,
+		data:       data,
+	}
+}
+
+// result encodes an ADS result code followed by payload.
+func result(code uint32, payload ...[]byte) []byte {
+	buf := binary.LittleEndian.AppendUint32(nil, code)
+	for _, p := range payload {
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+// conn is one client connection. Writes are serialised between responses and notifications.
+type conn struct {
+	net.Conn
+	writeMu sync.Mutex
+}
+
+func (c *conn) send(p *packet) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.Write(p.marshal())
+	return err
+}
@@ -0,0 +1,195 @@
+package adstest
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+// Fault changes how the server answers matching requests. The zero fields of Command,
+// IndexGroup and Symbol match everything, so Fault{Drop: true} drops every request.
+//
+// Faults also apply to the entries of sum commands, which see the command of the single
+// request they bundle (CmdRead for a sum read, CmdAddNotification for a sum notification
+// registration). There only ErrorCode, Delay and Hold take effect, failing the entry while the
+// other entries succeed. Faults on CmdDeviceNotification delay or suppress samples.
+type Fault struct {
+	// Command matches requests with this command.
+	Command Command
+	// IndexGroup matches requests addressing this index group, e.g. IndexGroupSumRead.
+	IndexGroup uint32
+	// Symbol matches requests addressing this symbol by name, handle, offset or notification.
+	Symbol string
+
+	// ErrorCode answers with this ADS error instead of serving the request.
+	ErrorCode uint32
+	// Drop leaves the request unanswered.
+	Drop bool
+	// Delay answers the request this much later.
+	Delay time.Duration
+	// Hold blocks the request until the channel is closed, after Delay. Tests use it to act
+	// while a request is in flight, e.g. close the client during a blocked read.
+	Hold <-chan struct{}
+	// CloseConnection closes the client connection instead of answering.
+	CloseConnection bool
+
+	// Count limits the fault to this many requests; 0 keeps it until removed.
+	Count int
+}
+
+type faultEntry struct {
+	Fault
+	used int
+}
+
+// InjectFault adds a fault. Faults are checked in the order they were added and the first
+// match applies. The returned function removes the fault.
+func (s *Server) InjectFault(f Fault) (remove func()) {
+	e := &faultEntry{Fault: f}
+	s.mu.Lock()
+	s.faults = append(s.faults, e)
+	s.mu.Unlock()
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, x := range s.faults {
+			if x == e {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// ClearFaults removes all faults.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	s.faults = nil
+	s.mu.Unlock()
+}
+
+// op is the part of a request faults match on.
+type op struct {
+	cmd    Command
+	group  uint32
+	offset uint32
+	name   string // symbol name of by-name requests
+	handle uint32 // notification handle of delete requests
+}
+
+// requestOp describes a request for fault matching.
+func (s *Server) requestOp(p *packet) op {
+	le := binary.LittleEndian
+	o := op{cmd: p.command}
+	d := p.data
+	switch p.command {
+	case CmdRead, CmdWrite, CmdAddNotification:
+		if len(d) >= 8 {
+			o.group, o.offset = le.Uint32(d), le.Uint32(d[4:])
+		}
+	case CmdReadWrite:
+		if len(d) >= 16 {
+			o.group, o.offset = le.Uint32(d), le.Uint32(d[4:])
+			switch o.group {
+			case IndexGroupHandleByName, IndexGroupValueByName, IndexGroupInfoByNameEx:
+				o.name = strings.TrimRight(string(d[16:]), "\x00")
+			}
+		}
+	case CmdDeleteNotification:
+		if len(d) >= 4 {
+			o.handle = le.Uint32(d)
+		}
+	}
+	return o
+}
+
+// fault returns the first fault matching o and counts its use.
+func (s *Server) fault(o op) (Fault, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.faults {
+		if !s.matches(f.Fault, o) {
+			continue
+		}
+		f.used++
+		if f.Count > 0 && f.used >= f.Count {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+		}
+		return f.Fault, true
+	}
+	return Fault{}, false
+}
+
+// entryFault applies a fault to one entry of a sum command and returns its error code.
+func (s *Server) entryFault(o op) uint32 {
+	f, ok := s.fault(o)
+	if !ok || !s.await(f) {
+		return 0
+	}
+	return f.ErrorCode
+}
+
+// matches reports whether f applies to o. Must be called with s.mu held.
+func (s *Server) matches(f Fault, o op) bool {
+	if f.Command != 0 && f.Command != o.cmd {
+		return false
+	}
+	if f.IndexGroup != 0 && f.IndexGroup != o.group {
+		return false
+	}
+	if f.Symbol == "" {
+		return true
+	}
+	target, ok := s.resolve(f.Symbol)
+	if !ok {
+		return false
+	}
+	var offset uint32
+	switch {
+	case o.name != "":
+		r, found := s.resolve(o.name)
+		if !found {
+			return false
+		}
+		offset = r.offset
+	case o.cmd == CmdDeleteNotification:
+		n, found := s.notifications[o.handle]
+		if !found {
+			return false
+		}
+		offset = n.offset
+	case o.group == IndexGroupValueByHandle:
+		r, found := s.handles[o.offset]
+		if !found {
+			return false
+		}
+		offset = r.offset
+	case o.group == IndexGroupData:
+		offset = o.offset
+	default:
+		return false
+	}
+	return offset >= target.offset && offset < target.offset+target.typ.size
+}
+
+// await delays a request as f asks, by Delay and then until Hold is closed. It reports false
+// if the server closed meanwhile.
+func (s *Server) await(f Fault) bool {
+	if f.Delay > 0 {
+		t := time.NewTimer(f.Delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-s.done:
+			return false
+		}
+	}
+	if f.Hold != nil {
+		select {
+		case <-f.Hold:
+		case <-s.done:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,1105 @@
+// Package adstest provides an in-process ADS device for testing benthosADS pipelines without
+// a PLC. A Server hosts a programmable symbol table on a loopback TCP port and answers the
+// requests the ads input and processors send: symbol upload, reads by name, handle and offset,
+// sum commands and device notifications. It can also answer the UDP route service and inject
+// faults such as dropped requests, ADS error codes and slow responses.
+package adstest
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ADS states for SetState.
+const (
+	StateStop uint16 = 6
+	StateRun  uint16 = 5
+)
+
+// Notification transmission modes (ADSTRANS_*) sent cyclically; the others are sent on change.
+const (
+	transServerCycle  = 3
+	transServerCycle2 = 5
+)
+
+// systemServicePort is the TwinCAT system service, which serves the route table.
+const (
+	systemServicePort uint16 = 10000
+
+	sysServiceDelRemote  uint32 = 0x322
+	sysServiceEnumRemote uint32 = 0x323
+)
+
+// Config configures a Server. The zero value serves runtime port 851 as 127.0.0.1.1.1 on a
+// free loopback port, without the UDP route service.
+type Config struct {
+	// NetID is the AMS net ID of the device. Default 127.0.0.1.1.1.
+	NetID string
+	// Port is the AMS port of the PLC runtime. Default 851.
+	Port uint16
+	// Addr is the TCP listen address. Default 127.0.0.1:0.
+	Addr string
+	// UDPAddr enables the UDP route service on this address. benthosADS always sends route
+	// requests to port 48899, so use 127.0.0.1:48899 to register routes against the server.
+	UDPAddr string
+	// Hostname is reported by the UDP info service. Default adstest.
+	Hostname string
+	// RouteUsername and RoutePassword are the credentials route requests must carry. Empty
+	// accepts any credentials.
+	RouteUsername string
+	RoutePassword string
+	// RequireRoute closes connections that send requests from a net ID without a route, like
+	// a TwinCAT router does.
+	RequireRoute bool
+}
+
+// Route is an entry of the server's route table.
+type Route struct {
+	Name    string
+	NetID   string
+	Address string
+}
+
+// Server is an in-process ADS device. It is safe for concurrent use.
+type Server struct {
+	cfg   Config
+	netID netID
+	ln    net.Listener
+	udp   net.PacketConn
+	done  chan struct{}
+	wg    sync.WaitGroup
+
+	mu            sync.Mutex
+	types         map[string]*typeInfo
+	typeList      []*typeInfo
+	symbols       []*symbol
+	data          []byte
+	handles       map[uint32]ref
+	nextHandle    uint32
+	notifications map[uint32]*notification
+	nextNotify    uint32
+	conns         map[*conn]struct{}
+	routes        []Route
+	state         uint16
+	symVersion    uint8
+	faults        []*faultEntry
+	requests      map[Command]int
+	changed       chan struct{} // closed and replaced when the counters above change
+	closed        bool
+}
+
+// NewServer starts a server. Close it when done.
+func NewServer(cfg Config) (*Server, error) {
+	if cfg.NetID == "" {
+		cfg.NetID = "127.0.0.1.1.1"
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 851
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = "127.0.0.1:0"
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname = "adstest"
+	}
+	id, err := parseNetID(cfg.NetID)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		cfg:           cfg,
+		netID:         id,
+		done:          make(chan struct{}),
+		types:         map[string]*typeInfo{},
+		handles:       map[uint32]ref{},
+		notifications: map[uint32]*notification{},
+		conns:         map[*conn]struct{}{},
+		state:         StateRun,
+		symVersion:    1,
+		requests:      map[Command]int{},
+		changed:       make(chan struct{}),
+	}
+	if s.ln, err = net.Listen("tcp", cfg.Addr); err != nil {
+		return nil, err
+	}
+	if cfg.UDPAddr != "" {
+		if s.udp, err = net.ListenPacket("udp", cfg.UDPAddr); err != nil {
+			_ = s.ln.Close()
+			return nil, err
+		}
+		s.wg.Add(1)
+		go s.serveUDP()
+	}
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return s, nil
+}
+
+// Addr returns the TCP address clients connect to, e.g. as targetIP and targetPort.
+func (s *Server) Addr() *net.TCPAddr {
+	return s.ln.Addr().(*net.TCPAddr)
+}
+
+// NetID returns the AMS net ID of the device, the targetAMS of clients.
+func (s *Server) NetID() string {
+	return s.netID.String()
+}
+
+// UDPAddr returns the address of the UDP route service, or nil when it is disabled.
+func (s *Server) UDPAddr() net.Addr {
+	if s.udp == nil {
+		return nil
+	}
+	return s.udp.LocalAddr()
+}
+
+// Close stops the server and closes all client connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	for _, n := range s.notifications {
+		close(n.stop)
+	}
+	s.notifications = map[uint32]*notification{}
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	s.mu.Unlock()
+
+	err := s.ln.Close()
+	if s.udp != nil {
+		_ = s.udp.Close()
+	}
+	s.wg.Wait()
+	return err
+}
+
+// AddType registers a struct type, which symbols and later types can then use.
+func (s *Server) AddType(dt DataType) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if dt.Name == "" || len(dt.Fields) == 0 {
+		return errors.New("a type needs a name and fields")
+	}
+	if _, ok := s.types[strings.ToLower(dt.Name)]; ok {
+		return fmt.Errorf("type %s already exists", dt.Name)
+	}
+	t, err := s.structType(dt)
+	if err != nil {
+		return err
+	}
+	s.types[strings.ToLower(dt.Name)] = t
+	s.typeList = append(s.typeList, t)
+	s.symVersion++
+	return nil
+}
+
+// AddSymbol adds a symbol such as MAIN.counter of type typ, a primitive like DINT, STRING(n),
+// ARRAY [l..u] OF T or a type added with AddType. value is the initial value as accepted by
+// SetValue; nil starts from zero. Every change to the symbol table bumps the symbol version,
+// as an online change does.
+func (s *Server) AddSymbol(name, typ string, value any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sym := range s.symbols {
+		if strings.EqualFold(sym.name, name) {
+			return fmt.Errorf("symbol %s already exists", name)
+		}
+	}
+	t, err := s.typeOf(typ)
+	if err != nil {
+		return fmt.Errorf("symbol %s: %w", name, err)
+	}
+	var init []byte
+	if value != nil {
+		if init, err = encode(t, value); err != nil {
+			return fmt.Errorf("symbol %s: %w", name, err)
+		}
+	}
+	align := uint32(min(t.align, 8))
+	offset := (uint32(len(s.data)) + align - 1) / align * align
+	s.data = append(s.data, make([]byte, offset+t.size-uint32(len(s.data)))...)
+	copy(s.data[offset:], init)
+	s.symbols = append(s.symbols, &symbol{name: name, typ: t, offset: offset})
+	s.symVersion++
+	return nil
+}
+
+// SetAttribute sets a PLC attribute of a symbol, as {attribute 'name' := 'value'} does in its
+// declaration. The attributes are part of the symbol upload.
+func (s *Server) SetAttribute(symbolName, name, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sym := range s.symbols {
+		if !strings.EqualFold(sym.name, symbolName) {
+			continue
+		}
+		for i, a := range sym.attrs {
+			if strings.EqualFold(a[0], name) {
+				sym.attrs[i][1] = value
+				s.symVersion++
+				return nil
+			}
+		}
+		sym.attrs = append(sym.attrs, [2]string{name, value})
+		s.symVersion++
+		return nil
+	}
+	return fmt.Errorf("symbol %s not found", symbolName)
+}
+
+// attributes returns the attributes of a top-level symbol. Must be called with s.mu held.
+func (s *Server) attributes(name string) [][2]string {
+	for _, sym := range s.symbols {
+		if strings.EqualFold(sym.name, name) {
+			return sym.attrs
+		}
+	}
+	return nil
+}
+
+// SetValue sets a symbol or a member of one, e.g. MAIN.station.values[2]. Values are Go
+// numbers, bools, strings, time.Duration for TIME, or []byte in PLC layout for any type.
+// Notifications on the changed range are sent before SetValue returns.
+func (s *Server) SetValue(path string, value any) error {
+	s.mu.Lock()
+	r, ok := s.resolve(path)
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("symbol %s not found", path)
+	}
+	b, err := encode(r.typ, value)
+	if err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	changed := s.store(r.offset, b)
+	s.mu.Unlock()
+	s.notify(changed)
+	return nil
+}
+
+// Value returns the raw value of a symbol or a member of one.
+func (s *Server) Value(path string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.resolve(path)
+	if !ok {
+		return nil, fmt.Errorf("symbol %s not found", path)
+	}
+	return append([]byte(nil), s.data[r.offset:r.offset+r.typ.size]...), nil
+}
+
+// SetState sets the ADS state reported by ReadState, e.g. StateStop to simulate a stopped
+// runtime.
+func (s *Server) SetState(state uint16) {
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+}
+
+// AddRoute adds a route as if it had been registered over UDP.
+func (s *Server) AddRoute(r Route) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addRoute(r)
+}
+
+// Routes returns the route table.
+func (s *Server) Routes() []Route {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Route(nil), s.routes...)
+}
+
+// Notifications returns the number of registered device notifications.
+func (s *Server) Notifications() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.notifications)
+}
+
+// Connections returns the number of open client connections.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Requests returns the number of requests received with the given command.
+func (s *Server) Requests(cmd Command) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[cmd]
+}
+
+// WaitNotifications waits until at least n device notifications are registered.
+func (s *Server) WaitNotifications(ctx context.Context, n int) error {
+	return s.waitUntil(ctx, func() bool { return len(s.notifications) >= n })
+}
+
+// WaitConnections waits until exactly n client connections are open, e.g. 0 after a client
+// closed or n after it reconnected.
+func (s *Server) WaitConnections(ctx context.Context, n int) error {
+	return s.waitUntil(ctx, func() bool { return len(s.conns) == n })
+}
+
+// WaitRequests waits until at least n requests with the given command were received.
+func (s *Server) WaitRequests(ctx context.Context, cmd Command, n int) error {
+	return s.waitUntil(ctx, func() bool { return s.requests[cmd] >= n })
+}
+
+// waitUntil waits until cond, called with s.mu held, reports true.
+func (s *Server) waitUntil(ctx context.Context, cond func() bool) error {
+	for {
+		s.mu.Lock()
+		ok, changed := cond(), s.changed
+		s.mu.Unlock()
+		if ok {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.done:
+			return errors.New("server closed")
+		}
+	}
+}
+
+// signal wakes the waiters of waitUntil. Must be called with s.mu held.
+func (s *Server) signal() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// DropConnections closes all client connections, as a PLC restart or a cable pull would.
+// The server keeps accepting new connections.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{Conn: nc}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = nc.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.signal()
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+// serve reads requests from one connection. Each request is answered in its own goroutine,
+// so a delayed or dropped request doesn't hold up the others.
+func (s *Server) serve(c *conn) {
+	defer s.wg.Done()
+	defer s.closeConn(c)
+	for {
+		p, err := readPacket(c)
+		if err != nil {
+			return
+		}
+		if p.tcpCommand != 0 || p.stateFlags&flagResponse != 0 {
+			continue
+		}
+		s.mu.Lock()
+		s.requests[p.command]++
+		s.signal()
+		routed := !s.cfg.RequireRoute || s.hasRoute(p.source.netID)
+		s.mu.Unlock()
+		if !routed {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(c, p)
+		}()
+	}
+}
+
+// closeConn closes c and deletes its notifications.
+func (s *Server) closeConn(c *conn) {
+	_ = c.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+	for h, n := range s.notifications {
+		if n.conn == c {
+			delete(s.notifications, h)
+			close(n.stop)
+		}
+	}
+	s.signal()
+}
+
+// handle answers one request.
+func (s *Server) handle(c *conn, p *packet) {
+	if p.target.netID != s.netID {
+		_ = c.send(p.response(errTargetMachineMissing, nil))
+		return
+	}
+	if p.target.port != s.cfg.Port && p.target.port != systemServicePort {
+		_ = c.send(p.response(errTargetPortNotFound, nil))
+		return
+	}
+	if f, ok := s.fault(s.requestOp(p)); ok {
+		if !s.await(f) {
+			return
+		}
+		switch {
+		case f.Drop:
+			return
+		case f.CloseConnection:
+			_ = c.Close()
+			return
+		case f.ErrorCode != 0:
+			_ = c.send(p.response(0, errorResult(p.command, f.ErrorCode)))
+			return
+		}
+	}
+	if p.target.port == systemServicePort {
+		s.systemService(c, p)
+		return
+	}
+
+	le := binary.LittleEndian
+	d := p.data
+	var code uint32
+	var payload []byte
+	switch p.command {
+	case CmdReadDeviceInfo:
+		name := make([]byte, 16)
+		copy(name, "adstest")
+		payload = append(le.AppendUint16([]byte{3, 1}, 4024), name...)
+	case CmdReadState:
+		s.mu.Lock()
+		payload = le.AppendUint16(nil, s.state)
+		s.mu.Unlock()
+		payload = le.AppendUint16(payload, 0)
+	case CmdWriteControl:
+		if len(d) < 8 {
+			code = ErrInvalidSize
+			break
+		}
+		s.SetState(le.Uint16(d))
+	case CmdRead:
+		if len(d) < 12 {
+			code = ErrInvalidSize
+			break
+		}
+		var data []byte
+		data, code = s.read(le.Uint32(d), le.Uint32(d[4:]), le.Uint32(d[8:]))
+		payload = append(le.AppendUint32(nil, uint32(len(data))), data...)
+	case CmdWrite:
+		if len(d) < 12 || int(le.Uint32(d[8:])) > len(d)-12 {
+			code = ErrInvalidSize
+			break
+		}
+		var changed []*notification
+		changed, code = s.write(le.Uint32(d), le.Uint32(d[4:]), d[12:12+le.Uint32(d[8:])])
+		defer s.notify(changed)
+	case CmdReadWrite:
+		if len(d) < 16 || int(le.Uint32(d[12:])) > len(d)-16 {
+			code = ErrInvalidSize
+			break
+		}
+		group, offset, readLen := le.Uint32(d), le.Uint32(d[4:]), le.Uint32(d[8:])
+		written := d[16 : 16+le.Uint32(d[12:])]
+		if group == IndexGroupSumAddNotify {
+			s.sumAddNotifications(c, p, offset, written)
+			return
+		}
+		var data []byte
+		var changed []*notification
+		data, changed, code = s.readWrite(group, offset, written)
+		defer s.notify(changed)
+		if code == 0 && uint32(len(data)) > readLen {
+			data, code = nil, ErrInvalidSize
+		}
+		payload = append(le.AppendUint32(nil, uint32(len(data))), data...)
+	case CmdAddNotification:
+		if len(d) < 24 {
+			code = ErrInvalidSize
+			break
+		}
+		n, ncode := s.addNotification(c, p, d)
+		if ncode != 0 {
+			code = ncode
+			break
+		}
+		// The handle must reach the client before the first sample does.
+		_ = c.send(p.response(0, result(0, le.AppendUint32(nil, n.handle))))
+		s.start(n)
+		return
+	case CmdDeleteNotification:
+		if len(d) < 4 {
+			code = ErrInvalidSize
+			break
+		}
+		code = s.deleteNotification(le.Uint32(d))
+	default:
+		code = ErrServiceNotSupported
+	}
+	if code != 0 {
+		_ = c.send(p.response(0, errorResult(p.command, code)))
+		return
+	}
+	_ = c.send(p.response(0, result(0, payload)))
+}
+
+// errorResult is the response data of a failed command: the result code plus the fields a
+// successful response of the command starts with, zeroed.
+func errorResult(cmd Command, code uint32) []byte {
+	switch cmd {
+	case CmdRead, CmdReadWrite, CmdAddNotification:
+		return result(code, make([]byte, 4))
+	}
+	return result(code)
+}
+
+// store writes b to the data area at offset and returns the on-change notifications whose
+// range changed. Must be called with s.mu held.
+func (s *Server) store(offset uint32, b []byte) []*notification {
+	copy(s.data[offset:], b)
+	end := offset + uint32(len(b))
+	var changed []*notification
+	for _, n := range s.notifications {
+		if !n.cyclic && n.offset < end && offset < n.offset+n.length {
+			changed = append(changed, n)
+		}
+	}
+	return changed
+}
+
+// read serves an ADS Read request.
+func (s *Server) read(group, offset, length uint32) ([]byte, uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch group {
+	case IndexGroupData:
+		if uint64(offset)+uint64(length) > uint64(len(s.data)) {
+			return nil, ErrInvalidIndexOffset
+		}
+		return append([]byte(nil), s.data[offset:offset+length]...), 0
+	case IndexGroupValueByHandle:
+		r, ok := s.handles[offset]
+		if !ok {
+			return nil, ErrSymbolNotFound
+		}
+		if length > r.typ.size {
+			return nil, ErrInvalidSize
+		}
+		return append([]byte(nil), s.data[r.offset:r.offset+length]...), 0
+	case IndexGroupSymVersion:
+		return []byte{s.symVersion}, 0
+	case IndexGroupUploadInfo2:
+		le := binary.LittleEndian
+		info := le.AppendUint32(nil, uint32(len(s.symbols)))
+		info = le.AppendUint32(info, uint32(len(s.symbolEntries())))
+		types := s.dataTypes()
+		info = le.AppendUint32(info, uint32(len(types)))
+		info = le.AppendUint32(info, uint32(len(s.dataTypeEntries(types))))
+		info = append(info, make([]byte, 8)...)
+		return info[:min(int(length), len(info))], 0
+	case IndexGroupSymUpload:
+		entries := s.symbolEntries()
+		if length < uint32(len(entries)) {
+			return nil, ErrInvalidSize
+		}
+		return entries, 0
+	case IndexGroupDataTypes:
+		entries := s.dataTypeEntries(s.dataTypes())
+		if length < uint32(len(entries)) {
+			return nil, ErrInvalidSize
+		}
+		return entries, 0
+	}
+	return nil, ErrInvalidIndexGroup
+}
+
+// write serves an ADS Write request and returns the notifications to send.
+func (s *Server) write(group, offset uint32, data []byte) ([]*notification, uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch group {
+	case IndexGroupData:
+		if uint64(offset)+uint64(len(data)) > uint64(len(s.data)) {
+			return nil, ErrInvalidIndexOffset
+		}
+		return s.store(offset, data), 0
+	case IndexGroupValueByHandle:
+		r, ok := s.handles[offset]
+		if !ok {
+			return nil, ErrSymbolNotFound
+		}
+		if uint32(len(data)) > r.typ.size {
+			return nil, ErrInvalidSize
+		}
+		return s.store(r.offset, data), 0
+	case IndexGroupReleaseHandle:
+		if len(data) < 4 {
+			return nil, ErrInvalidSize
+		}
+		h := binary.LittleEndian.Uint32(data)
+		if _, ok := s.handles[h]; !ok {
+			return nil, ErrSymbolNotFound
+		}
+		delete(s.handles, h)
+		return nil, 0
+	}
+	return nil, ErrInvalidIndexGroup
+}
+
+// readWrite serves the symbol lookups and sum commands sent as ReadWrite requests.
+func (s *Server) readWrite(group, offset uint32, written []byte) ([]byte, []*notification, uint32) {
+	switch group {
+	case IndexGroupSumRead:
+		data, code := s.sumRead(offset, written)
+		return data, nil, code
+	case IndexGroupSumWrite:
+		return s.sumWrite(offset, written)
+	case IndexGroupSumReadWrite:
+		return s.sumReadWrite(offset, written)
+	case IndexGroupSumDelNotify:
+		data, code := s.sumDeleteNotifications(offset, written)
+		return data, nil, code
+	}
+
+	name := strings.TrimRight(string(written), "\x00")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch group {
+	case IndexGroupHandleByName, IndexGroupValueByName, IndexGroupInfoByNameEx:
+	default:
+		return nil, nil, ErrInvalidIndexGroup
+	}
+	r, ok := s.resolve(name)
+	if !ok {
+		return nil, nil, ErrSymbolNotFound
+	}
+	switch group {
+	case IndexGroupHandleByName:
+		s.nextHandle++
+		s.handles[s.nextHandle] = r
+		return binary.LittleEndian.AppendUint32(nil, s.nextHandle), nil, 0
+	case IndexGroupValueByName:
+		return append([]byte(nil), s.data[r.offset:r.offset+r.typ.size]...), nil, 0
+	default:
+		return symbolEntry(r.name, r.typ, IndexGroupData, r.offset, s.attributes(r.name)), nil, 0
+	}
+}
+
+// sumRead serves ADSIGRP_SUMUP_READ: count (group, offset, length) entries, answered with
+// all result codes followed by all values. Failed entries keep their length, zeroed.
+func (s *Server) sumRead(count uint32, req []byte) ([]byte, uint32) {
+	le := binary.LittleEndian
+	if uint64(len(req)) < uint64(count)*12 {
+		return nil, ErrInvalidSize
+	}
+	var codes, values []byte
+	for i := uint32(0); i < count; i++ {
+		e := req[i*12:]
+		group, offset, length := le.Uint32(e), le.Uint32(e[4:]), le.Uint32(e[8:])
+		code := s.entryFault(op{cmd: CmdRead, group: group, offset: offset})
+		var data []byte
+		if code == 0 {
+			data, code = s.read(group, offset, length)
+		}
+		if code != 0 {
+			data = make([]byte, length)
+		}
+		codes = le.AppendUint32(codes, code)
+		values = append(values, data...)
+	}
+	return append(codes, values...), 0
+}
+
+// sumWrite serves ADSIGRP_SUMUP_WRITE: count (group, offset, length) entries followed by the
+// values, answered with one result code per entry.
+func (s *Server) sumWrite(count uint32, req []byte) ([]byte, []*notification, uint32) {
+	le := binary.LittleEndian
+	if uint64(len(req)) < uint64(count)*12 {
+		return nil, nil, ErrInvalidSize
+	}
+	values := req[count*12:]
+	var codes []byte
+	var changed []*notification
+	for i := uint32(0); i < count; i++ {
+		e := req[i*12:]
+		group, offset, length := le.Uint32(e), le.Uint32(e[4:]), le.Uint32(e[8:])
+		if uint32(len(values)) < length {
+			return nil, changed, ErrInvalidSize
+		}
+		code := s.entryFault(op{cmd: CmdWrite, group: group, offset: offset})
+		if code == 0 {
+			var n []*notification
+			n, code = s.write(group, offset, values[:length])
+			changed = append(changed, n...)
+		}
+		codes = le.AppendUint32(codes, code)
+		values = values[length:]
+	}
+	return codes, changed, 0
+}
+
+// sumReadWrite serves ADSIGRP_SUMUP_READWRITE: count (group, offset, readLength, writeLength)
+// entries followed by the written data, answered with a (result, length) pair per entry
+// followed by the data read.
+func (s *Server) sumReadWrite(count uint32, req []byte) ([]byte, []*notification, uint32) {
+	le := binary.LittleEndian
+	if uint64(len(req)) < uint64(count)*16 {
+		return nil, nil, ErrInvalidSize
+	}
+	written := req[count*16:]
+	var header, values []byte
+	var changed []*notification
+	for i := uint32(0); i < count; i++ {
+		e := req[i*16:]
+		group, offset, readLen, writeLen := le.Uint32(e), le.Uint32(e[4:]), le.Uint32(e[8:]), le.Uint32(e[12:])
+		if uint32(len(written)) < writeLen {
+			return nil, changed, ErrInvalidSize
+		}
+		w := written[:writeLen]
+		written = written[writeLen:]
+		code := s.entryFault(op{cmd: CmdReadWrite, group: group, offset: offset, name: strings.TrimRight(string(w), "\x00")})
+		var data []byte
+		if code == 0 {
+			var n []*notification
+			data, n, code = s.readWrite(group, offset, w)
+			changed = append(changed, n...)
+			if code == 0 && uint32(len(data)) > readLen {
+				data, code = nil, ErrInvalidSize
+			}
+		}
+		if code != 0 {
+			data = nil
+		}
+		header = le.AppendUint32(header, code)
+		header = le.AppendUint32(header, uint32(len(data)))
+		values = append(values, data...)
+	}
+	return append(header, values...), changed, 0
+}
+
+// notification is a device notification registered by a client.
+type notification struct {
+	handle         uint32
+	conn           *conn
+	target, source amsAddr
+	offset, length uint32 // range of the data area
+	cyclic         bool
+	cycle          time.Duration
+	last           []byte
+	stop           chan struct{}
+}
+
+// addNotification registers a notification from AddDeviceNotification request data: group,
+// offset, length, transmission mode, max delay and cycle time.
+func (s *Server) addNotification(c *conn, p *packet, d []byte) (*notification, uint32) {
+	le := binary.LittleEndian
+	group, offset, length := le.Uint32(d), le.Uint32(d[4:]), le.Uint32(d[8:])
+	mode := le.Uint32(d[12:])
+	n := &notification{
+		conn:   c,
+		target: p.source,
+		source: p.target,
+		length: length,
+		cyclic: mode == transServerCycle || mode == transServerCycle2,
+		cycle:  max(time.Duration(le.Uint32(d[20:]))*100, time.Millisecond), // 100 ns units
+		stop:   make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch group {
+	case IndexGroupData:
+		n.offset = offset
+	case IndexGroupValueByHandle:
+		r, ok := s.handles[offset]
+		if !ok {
+			return nil, ErrSymbolNotFound
+		}
+		if length > r.typ.size {
+			return nil, ErrInvalidSize
+		}
+		n.offset = r.offset
+	default:
+		return nil, ErrInvalidIndexGroup
+	}
+	if uint64(n.offset)+uint64(length) > uint64(len(s.data)) {
+		return nil, ErrInvalidIndexOffset
+	}
+	s.nextNotify++
+	n.handle = s.nextNotify
+	s.notifications[n.handle] = n
+	s.signal()
+	return n, 0
+}
+
+// sumAddNotifications serves ADSIGRP_SUMUP_ADDDEVNOTE: count 40 byte AddDeviceNotification
+// requests, answered with a (result, handle) pair per entry. Entries fail independently.
+func (s *Server) sumAddNotifications(c *conn, p *packet, count uint32, req []byte) {
+	le := binary.LittleEndian
+	if uint64(len(req)) < uint64(count)*40 {
+		_ = c.send(p.response(0, errorResult(CmdReadWrite, ErrInvalidSize)))
+		return
+	}
+	var resp []byte
+	var added []*notification
+	for i := uint32(0); i < count; i++ {
+		e := req[i*40:]
+		code := s.entryFault(op{cmd: CmdAddNotification, group: le.Uint32(e), offset: le.Uint32(e[4:])})
+		var n *notification
+		if code == 0 {
+			if n, code = s.addNotification(c, p, e); code == 0 {
+				added = append(added, n)
+			}
+		}
+		resp = le.AppendUint32(resp, code)
+		if n != nil {
+			resp = le.AppendUint32(resp, n.handle)
+		} else {
+			resp = le.AppendUint32(resp, 0)
+		}
+	}
+	_ = c.send(p.response(0, result(0, le.AppendUint32(nil, uint32(len(resp))), resp)))
+	for _, n := range added {
+		s.start(n)
+	}
+}
+
+// sumDeleteNotifications serves ADSIGRP_SUMUP_DELDEVNOTE: count handles, answered with one
+// result code per handle.
+func (s *Server) sumDeleteNotifications(count uint32, req []byte) ([]byte, uint32) {
+	le := binary.LittleEndian
+	if uint64(len(req)) < uint64(count)*4 {
+		return nil, ErrInvalidSize
+	}
+	var codes []byte
+	for i := uint32(0); i < count; i++ {
+		codes = le.AppendUint32(codes, s.deleteNotification(le.Uint32(req[i*4:])))
+	}
+	return codes, 0
+}
+
+func (s *Server) deleteNotification(handle uint32) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.notifications[handle]
+	if !ok {
+		return ErrInvalidNotifyHandle
+	}
+	delete(s.notifications, handle)
+	close(n.stop)
+	s.signal()
+	return 0
+}
+
+// start sends the initial sample of n and, for cyclic notifications, a sample every cycle
+// until the notification is deleted.
+func (s *Server) start(n *notification) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.sample(n, false)
+		if !n.cyclic {
+			return
+		}
+		ticker := time.NewTicker(n.cycle)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sample(n, false)
+			case <-n.stop:
+				return
+			}
+		}
+	}()
+}
+
+// notify sends a sample for each on-change notification whose value changed.
+func (s *Server) notify(changed []*notification) {
+	for _, n := range changed {
+		s.sample(n, true)
+	}
+}
+
+// sample sends the current value of n to its client. With onlyChanged, values equal to the
+// last sample are skipped.
+func (s *Server) sample(n *notification, onlyChanged bool) {
+	s.mu.Lock()
+	if _, ok := s.notifications[n.handle]; !ok {
+		s.mu.Unlock()
+		return
+	}
+	data := append([]byte(nil), s.data[n.offset:n.offset+n.length]...)
+	if onlyChanged && string(data) == string(n.last) {
+		s.mu.Unlock()
+		return
+	}
+	n.last = data
+	s.mu.Unlock()
+
+	if f, ok := s.fault(op{cmd: CmdDeviceNotification, group: IndexGroupData, offset: n.offset}); ok {
+		if !s.await(f) {
+			return
+		}
+		switch {
+		case f.Drop, f.ErrorCode != 0:
+			return
+		case f.CloseConnection:
+			_ = n.conn.Close()
+			return
+		}
+	}
+
+	le := binary.LittleEndian
+	stamp := le.AppendUint32(nil, 1) // stamps
+	stamp = le.AppendUint64(stamp, uint64(time.Now().UnixNano()/100+116444736000000000))
+	stamp = le.AppendUint32(stamp, 1) // samples
+	stamp = le.AppendUint32(stamp, n.handle)
+	stamp = le.AppendUint32(stamp, uint32(len(data)))
+	stamp = append(stamp, data...)
+	_ = n.conn.send(&packet{
+		target:     n.target,
+		source:     n.source,
+		command:    CmdDeviceNotification,
+		stateFlags: flagADSCommand,
+		data:       append(le.AppendUint32(nil, uint32(len(stamp))), stamp...),
+	})
+}
+
+// symbolEntries encodes the symbol upload table. Must be called with s.mu held.
+func (s *Server) symbolEntries() []byte {
+	var buf []byte
+	for _, sym := range s.symbols {
+		buf = append(buf, symbolEntry(sym.name, sym.typ, IndexGroupData, sym.offset, sym.attrs)...)
+	}
+	return buf
+}
+
+// dataTypes returns the types of the datatype upload: the struct types and the array types
+// of symbols. Must be called with s.mu held.
+func (s *Server) dataTypes() []*typeInfo {
+	types := append([]*typeInfo(nil), s.typeList...)
+	seen := map[string]bool{}
+	for _, sym := range s.symbols {
+		if sym.typ.elem != nil && !seen[sym.typ.name] {
+			seen[sym.typ.name] = true
+			types = append(types, sym.typ)
+		}
+	}
+	return types
+}
+
+func (s *Server) dataTypeEntries(types []*typeInfo) []byte {
+	var buf []byte
+	for _, t := range types {
+		buf = append(buf, dataTypeEntry(t.name, t, 0, datatypeFlagDataType)...)
+	}
+	return buf
+}
+
+// systemService serves the route table on the system service port.
+func (s *Server) systemService(c *conn, p *packet) {
+	le := binary.LittleEndian
+	d := p.data
+	code := ErrServiceNotSupported
+	var payload []byte
+	switch {
+	case p.command == CmdRead && len(d) >= 12 && le.Uint32(d) == sysServiceEnumRemote:
+		s.mu.Lock()
+		i := le.Uint32(d[4:])
+		if int(i) < len(s.routes) {
+			entry := routeEntry(s.routes[i])
+			payload = append(le.AppendUint32(nil, uint32(len(entry))), entry...)
+			code = 0
+		} else {
+			code = ErrNotFound
+		}
+		s.mu.Unlock()
+	case p.command == CmdWrite && len(d) >= 12 && le.Uint32(d) == sysServiceDelRemote:
+		name := strings.TrimRight(string(d[12:]), "\x00")
+		code = ErrNotFound
+		s.mu.Lock()
+		for i, r := range s.routes {
+			if strings.EqualFold(r.Name, name) {
+				s.routes = append(s.routes[:i], s.routes[i+1:]...)
+				code = 0
+				break
+			}
+		}
+		s.mu.Unlock()
+	}
+	if code != 0 {
+		_ = c.send(p.response(0, errorResult(p.command, code)))
+		return
+	}
+	_ = c.send(p.response(0, result(0, payload)))
+}
+
+// routeEntry encodes a route table entry: net ID, a header of flags, timeout and string
+// lengths, then the address and name.
+func routeEntry(r Route) []byte {
+	le := binary.LittleEndian
+	id, _ := parseNetID(r.NetID)
+	buf := append(id[:], 0, 0)
+	buf = le.AppendUint32(buf, 0) // flags
+	buf = le.AppendUint32(buf, 0) // timeout
+	buf = le.AppendUint32(buf, 0) // max fragment
+	buf = le.AppendUint32(buf, uint32(len(r.Address)+1))
+	buf = le.AppendUint32(buf, uint32(len(r.Name)+1))
+	buf = append(append(buf, r.Address...), 0)
+	return append(append(buf, r.Name...), 0)
+}
+
+// addRoute adds r, replacing a route with the same name. Must be called with s.mu held.
+func (s *Server) addRoute(r Route) {
+	for i, old := range s.routes {
+		if strings.EqualFold(old.Name, r.Name) {
+			s.routes[i] = r
+			return
+		}
+	}
+	s.routes = append(s.routes, r)
+}
+
+// hasRoute reports whether a route to id exists. Must be called with s.mu held.
+func (s *Server) hasRoute(id netID) bool {
+	for _, r := range s.routes {
+		if r.NetID == id.String() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,515 @@
+package adstest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var le = binary.LittleEndian
+
+// client is a minimal ADS client speaking to a Server over TCP.
+type client struct {
+	t      *testing.T
+	conn   net.Conn
+	target amsAddr
+	source amsAddr
+
+	mu      sync.Mutex
+	invoke  uint32
+	pending map[uint32]chan *packet
+	notes   chan *packet
+	closed  chan struct{}
+}
+
+func newServer(t *testing.T, cfg Config) *Server {
+	t.Helper()
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func dial(t *testing.T, s *Server) *client {
+	t.Helper()
+	nc, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &client{
+		t:       t,
+		conn:    nc,
+		target:  amsAddr{netID: s.netID, port: s.cfg.Port},
+		source:  amsAddr{netID: netID{10, 0, 0, 9, 1, 1}, port: 32905},
+		pending: map[uint32]chan *packet{},
+		notes:   make(chan *packet, 16),
+		closed:  make(chan struct{}),
+	}
+	t.Cleanup(func() { _ = nc.Close() })
+	go func() {
+		defer close(c.closed)
+		for {
+			p, err := readPacket(nc)
+			if err != nil {
+				return
+			}
+			if p.command == CmdDeviceNotification {
+				c.notes <- p
+				continue
+			}
+			c.mu.Lock()
+			ch := c.pending[p.invokeID]
+			delete(c.pending, p.invokeID)
+			c.mu.Unlock()
+			if ch != nil {
+				ch <- p
+			}
+		}
+	}()
+	return c
+}
+
+// send sends a request and returns the channel its response arrives on.
+func (c *client) send(cmd Command, data []byte) <-chan *packet {
+	c.t.Helper()
+	ch := make(chan *packet, 1)
+	c.mu.Lock()
+	c.invoke++
+	id := c.invoke
+	c.pending[id] = ch
+	c.mu.Unlock()
+	p := &packet{target: c.target, source: c.source, command: cmd, stateFlags: flagADSCommand, invokeID: id, data: data}
+	if _, err := c.conn.Write(p.marshal()); err != nil {
+		c.t.Fatal(err)
+	}
+	return ch
+}
+
+// request sends a request and returns the response data, or an error if none arrives within
+// timeout.
+func (c *client) request(cmd Command, data []byte, timeout time.Duration) ([]byte, error) {
+	select {
+	case p := <-c.send(cmd, data):
+		if p.errorCode != 0 {
+			return nil, errors.New("AMS error")
+		}
+		return p.data, nil
+	case <-c.closed:
+		return nil, errors.New("connection closed")
+	case <-time.After(timeout):
+		return nil, errors.New("no response")
+	}
+}
+
+// call sends a request that must be answered and returns the ADS result code and payload.
+func (c *client) call(cmd Command, data []byte) (uint32, []byte) {
+	c.t.Helper()
+	resp, err := c.request(cmd, data, 5*time.Second)
+	if err != nil {
+		c.t.Fatalf("command %d: %v", cmd, err)
+	}
+	return le.Uint32(resp), resp[4:]
+}
+
+func (c *client) read(group, offset, length uint32) (uint32, []byte) {
+	c.t.Helper()
+	code, payload := c.call(CmdRead, le.AppendUint32(le.AppendUint32(le.AppendUint32(nil, group), offset), length))
+	return code, payload[4 : 4+le.Uint32(payload)]
+}
+
+func (c *client) readWrite(group, offset, readLen uint32, written []byte) (uint32, []byte) {
+	c.t.Helper()
+	req := le.AppendUint32(nil, group)
+	req = le.AppendUint32(req, offset)
+	req = le.AppendUint32(req, readLen)
+	req = le.AppendUint32(req, uint32(len(written)))
+	code, payload := c.call(CmdReadWrite, append(req, written...))
+	return code, payload[4 : 4+le.Uint32(payload)]
+}
+
+func (c *client) handle(name string) uint32 {
+	c.t.Helper()
+	code, data := c.readWrite(IndexGroupHandleByName, 0, 4, append([]byte(name), 0))
+	if code != 0 {
+		c.t.Fatalf("handle of %s: ADS error 0x%X", name, code)
+	}
+	return le.Uint32(data)
+}
+
+// addNotification registers an on-change notification for length bytes at group and offset.
+func (c *client) addNotification(group, offset, length uint32) (uint32, uint32) {
+	c.t.Helper()
+	req := le.AppendUint32(nil, group)
+	req = le.AppendUint32(req, offset)
+	req = le.AppendUint32(req, length)
+	req = le.AppendUint32(req, 4) // ADSTRANS_SERVERONCHA
+	req = append(req, make([]byte, 24)...)
+	code, payload := c.call(CmdAddNotification, req)
+	return code, le.Uint32(payload)
+}
+
+// sample waits for the next notification sample and returns its handle, timestamp and data.
+func (c *client) sample() (uint32, time.Time, []byte) {
+	c.t.Helper()
+	select {
+	case p := <-c.notes:
+		d := p.data[4:]
+		ft := le.Uint64(d[4:])
+		ts := time.Unix(0, int64(ft-116444736000000000)*100)
+		s := d[16:]
+		return le.Uint32(s), ts, s[8 : 8+le.Uint32(s[4:])]
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("no notification sample")
+	}
+	return 0, time.Time{}, nil
+}
+
+func TestServerRead(t *testing.T) {
+	s := newServer(t, Config{})
+	if err := s.AddType(DataType{Name: "ST_Pair", Fields: []Field{{"a", "BYTE"}, {"b", "DINT"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddSymbol("MAIN.n", "INT", -2); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddSymbol("MAIN.pair", "ST_Pair", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetValue("MAIN.pair.b", 70000); err != nil {
+		t.Fatal(err)
+	}
+	c := dial(t, s)
+
+	if code, data := c.read(IndexGroupData, 0, 2); code != 0 || !bytes.Equal(data, []byte{0xFE, 0xFF}) {
+		t.Errorf("read by offset = % x, 0x%X", data, code)
+	}
+	h := c.handle("main.PAIR") // names resolve case-insensitively
+	if code, data := c.read(IndexGroupValueByHandle, h, 8); code != 0 || !bytes.Equal(data, []byte{0, 0, 0, 0, 0x70, 0x11, 1, 0}) {
+		t.Errorf("read by handle = % x, 0x%X", data, code)
+	}
+	if code, data := c.readWrite(IndexGroupValueByName, 0, 4, []byte("MAIN.pair.b\x00")); code != 0 || le.Uint32(data) != 70000 {
+		t.Errorf("read by name = % x, 0x%X", data, code)
+	}
+
+	if code, _ := c.readWrite(IndexGroupHandleByName, 0, 4, []byte("MAIN.missing\x00")); code != ErrSymbolNotFound {
+		t.Errorf("handle of missing symbol: 0x%X, want 0x%X", code, ErrSymbolNotFound)
+	}
+	if code, _ := c.read(IndexGroupValueByHandle, h+100, 8); code != ErrSymbolNotFound {
+		t.Errorf("read by unknown handle: 0x%X", code)
+	}
+	if code, _ := c.read(IndexGroupData, 0, 1000); code != ErrInvalidIndexOffset {
+		t.Errorf("read past the data area: 0x%X", code)
+	}
+	if code, _ := c.read(0x1234, 0, 1); code != ErrInvalidIndexGroup {
+		t.Errorf("read of unknown index group: 0x%X", code)
+	}
+
+	req := le.AppendUint32(le.AppendUint32(le.AppendUint32(nil, IndexGroupValueByHandle), h), 1)
+	if code, _ := c.call(CmdWrite, append(req, 9)); code != 0 {
+		t.Fatalf("write by handle: 0x%X", code)
+	}
+	if v, _ := s.Value("MAIN.pair.a"); !bytes.Equal(v, []byte{9}) {
+		t.Errorf("MAIN.pair.a = % x after write", v)
+	}
+	if code, _ := c.call(CmdWrite, le.AppendUint32(le.AppendUint32(le.AppendUint32(nil, IndexGroupReleaseHandle), 0), 4)); code == 0 {
+		t.Error("release without handle data: want an error")
+	}
+	if code, _ := c.call(CmdWrite, append(le.AppendUint32(le.AppendUint32(le.AppendUint32(nil, IndexGroupReleaseHandle), 0), 4), le.AppendUint32(nil, h)...)); code != 0 {
+		t.Errorf("release handle: 0x%X", code)
+	}
+	if code, _ := c.read(IndexGroupValueByHandle, h, 8); code != ErrSymbolNotFound {
+		t.Errorf("read by released handle: 0x%X", code)
+	}
+
+	s.SetState(StateStop)
+	if code, data := c.call(CmdReadState, nil); code != 0 || le.Uint16(data) != StateStop {
+		t.Errorf("read state = % x, 0x%X", data, code)
+	}
+	if got := s.Requests(CmdRead); got != 6 {
+		t.Errorf("Requests(CmdRead) = %d, want 6", got)
+	}
+}
+
+func TestServerSumRead(t *testing.T) {
+	s := newServer(t, Config{})
+	for name, v := range map[string]int{"MAIN.a": 1, "MAIN.b": 2} {
+		if err := s.AddSymbol(name, "DINT", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := dial(t, s)
+	hb := c.handle("MAIN.b")
+	var req []byte
+	for _, e := range [][3]uint32{{IndexGroupData, 0, 4}, {IndexGroupValueByHandle, hb, 4}, {IndexGroupData, 400, 2}} {
+		req = le.AppendUint32(le.AppendUint32(le.AppendUint32(req, e[0]), e[1]), e[2])
+	}
+	code, data := c.readWrite(IndexGroupSumRead, 3, 22, req)
+	if code != 0 {
+		t.Fatalf("sum read: 0x%X", code)
+	}
+	want := le.AppendUint32(le.AppendUint32(le.AppendUint32(nil, 0), 0), ErrInvalidIndexOffset)
+	want = append(le.AppendUint32(le.AppendUint32(want, 1), 2), 0, 0) // failed entry keeps its length
+	if !bytes.Equal(data, want) {
+		t.Errorf("sum read = % x\nwant       % x", data, want)
+	}
+}
+
+func TestServerNotifications(t *testing.T) {
+	s := newServer(t, Config{})
+	if err := s.AddSymbol("MAIN.n", "INT", 5); err != nil {
+		t.Fatal(err)
+	}
+	c := dial(t, s)
+	code, handle := c.addNotification(IndexGroupValueByHandle, c.handle("MAIN.n"), 2)
+	if code != 0 {
+		t.Fatalf("add notification: 0x%X", code)
+	}
+	if s.Notifications() != 1 {
+		t.Errorf("Notifications() = %d, want 1", s.Notifications())
+	}
+	h, ts, data := c.sample()
+	if h != handle || !bytes.Equal(data, []byte{5, 0}) || time.Since(ts).Abs() > time.Minute {
+		t.Errorf("initial sample: handle %d, time %v, data % x", h, ts, data)
+	}
+	if err := s.SetValue("MAIN.n", 6); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, data = c.sample(); !bytes.Equal(data, []byte{6, 0}) {
+		t.Errorf("sample after change = % x", data)
+	}
+	if err := s.SetValue("MAIN.n", 6); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-c.notes:
+		t.Errorf("sample without change: % x", p.data)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if code, _ = c.addNotification(IndexGroupData, 100, 2); code != ErrInvalidIndexOffset {
+		t.Errorf("notification past the data area: 0x%X", code)
+	}
+	if code, _ := c.call(CmdDeleteNotification, le.AppendUint32(nil, handle)); code != 0 {
+		t.Errorf("delete notification: 0x%X", code)
+	}
+	if s.Notifications() != 0 {
+		t.Errorf("Notifications() = %d after delete", s.Notifications())
+	}
+	if code, _ := c.call(CmdDeleteNotification, le.AppendUint32(nil, handle)); code != ErrInvalidNotifyHandle {
+		t.Errorf("second delete: 0x%X, want 0x%X", code, ErrInvalidNotifyHandle)
+	}
+
+	// Notifications of a connection go away with it.
+	if code, _ = c.addNotification(IndexGroupData, 0, 2); code != 0 {
+		t.Fatal("add notification failed")
+	}
+	c.sample()
+	_ = c.conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.WaitConnections(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if s.Notifications() != 0 {
+		t.Errorf("Notifications() = %d after the client disconnected", s.Notifications())
+	}
+}
+
+func TestServerUDPAddRoute(t *testing.T) {
+	s := newServer(t, Config{UDPAddr: "127.0.0.1:0", RouteUsername: "Administrator", RoutePassword: "1"})
+	conn, err := net.Dial("udp", s.UDPAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	host := netID{10, 0, 0, 9, 1, 1}
+	addRoute := func(password string) uint32 {
+		t.Helper()
+		tags := [][]byte{
+			stringTag(udpTagRouteName, "benthos"),
+			tag(udpTagNetID, host[:]),
+			stringTag(udpTagUsername, "Administrator"),
+			stringTag(udpTagPassword, password),
+			stringTag(udpTagHost, "10.0.0.9"),
+		}
+		req := le.AppendUint32(nil, udpMagic)
+		req = le.AppendUint32(req, 1)
+		req = le.AppendUint32(req, udpServiceAddRoute)
+		req = le.AppendUint16(append(req, host[:]...), 10000)
+		req = le.AppendUint32(req, uint32(len(tags)))
+		for _, tg := range tags {
+			req = append(req, tg...)
+		}
+		if _, err := conn.Write(req); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 2048)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply := buf[:n]
+		if le.Uint32(reply) != udpMagic || le.Uint32(reply[8:]) != udpServiceAddRoute|udpResponse ||
+			!bytes.Equal(reply[12:18], s.netID[:]) || le.Uint16(reply[24:]) != udpTagStatus {
+			t.Fatalf("reply = % x", reply)
+		}
+		return le.Uint32(reply[28:])
+	}
+
+	if status := addRoute("wrong"); status != udpStatusAccessDeny {
+		t.Errorf("wrong password: status 0x%X", status)
+	}
+	if len(s.Routes()) != 0 {
+		t.Errorf("routes after rejected request = %+v", s.Routes())
+	}
+	if status := addRoute("1"); status != 0 {
+		t.Errorf("add route: status 0x%X", status)
+	}
+	if routes := s.Routes(); len(routes) != 1 || routes[0] != (Route{Name: "benthos", NetID: "10.0.0.9.1.1", Address: "10.0.0.9"}) {
+		t.Errorf("routes = %+v", routes)
+	}
+}
+
+func TestServerFaults(t *testing.T) {
+	s := newServer(t, Config{})
+	for _, name := range []string{"MAIN.a", "MAIN.b"} {
+		if err := s.AddSymbol(name, "DINT", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := dial(t, s)
+
+	t.Run("ErrorCode and Count", func(t *testing.T) {
+		s.InjectFault(Fault{Command: CmdRead, ErrorCode: ErrTimeout, Count: 2})
+		for i := 0; i < 2; i++ {
+			if code, data := c.read(IndexGroupData, 0, 4); code != ErrTimeout || len(data) != 0 {
+				t.Errorf("read %d: 0x%X, % x", i, code, data)
+			}
+		}
+		if code, _ := c.read(IndexGroupData, 0, 4); code != 0 {
+			t.Errorf("read after Count: 0x%X", code)
+		}
+	})
+
+	t.Run("Symbol", func(t *testing.T) {
+		remove := s.InjectFault(Fault{Symbol: "MAIN.b", ErrorCode: ErrInvalidAccess})
+		if code, _ := c.read(IndexGroupData, 0, 4); code != 0 {
+			t.Errorf("read of MAIN.a: 0x%X", code)
+		}
+		if code, _ := c.read(IndexGroupData, 4, 4); code != ErrInvalidAccess {
+			t.Errorf("read of MAIN.b: 0x%X", code)
+		}
+		if code, _ := c.readWrite(IndexGroupValueByName, 0, 4, []byte("main.b\x00")); code != ErrInvalidAccess {
+			t.Errorf("read of main.b by name: 0x%X", code)
+		}
+		var req []byte
+		for _, offset := range []uint32{0, 4} {
+			req = le.AppendUint32(le.AppendUint32(le.AppendUint32(req, IndexGroupData), offset), 4)
+		}
+		// In a sum read only the entry of the symbol fails.
+		if code, data := c.readWrite(IndexGroupSumRead, 2, 16, req); code != 0 || le.Uint32(data) != 0 || le.Uint32(data[4:]) != ErrInvalidAccess {
+			t.Errorf("sum read = % x, 0x%X", data, code)
+		}
+		remove()
+		if code, _ := c.read(IndexGroupData, 4, 4); code != 0 {
+			t.Errorf("read after remove: 0x%X", code)
+		}
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		s.InjectFault(Fault{Command: CmdReadState, Drop: true, Count: 1})
+		if _, err := c.request(CmdReadState, nil, 200*time.Millisecond); err == nil {
+			t.Error("dropped request was answered")
+		}
+		if code, _ := c.call(CmdReadState, nil); code != 0 {
+			t.Errorf("read state after drop: 0x%X", code)
+		}
+	})
+
+	t.Run("Delay", func(t *testing.T) {
+		s.InjectFault(Fault{Command: CmdReadState, Delay: 150 * time.Millisecond})
+		start := time.Now()
+		c.call(CmdReadState, nil)
+		if d := time.Since(start); d < 150*time.Millisecond {
+			t.Errorf("delayed request answered after %v", d)
+		}
+		s.ClearFaults()
+		start = time.Now()
+		c.call(CmdReadState, nil)
+		if d := time.Since(start); d >= 150*time.Millisecond {
+			t.Errorf("request after ClearFaults answered after %v", d)
+		}
+	})
+
+	t.Run("Hold", func(t *testing.T) {
+		hold := make(chan struct{})
+		s.InjectFault(Fault{Command: CmdRead, Hold: hold, Count: 1})
+		reads := s.Requests(CmdRead)
+		resp := c.send(CmdRead, le.AppendUint32(le.AppendUint32(le.AppendUint32(nil, IndexGroupData), 0), 4))
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.WaitRequests(ctx, CmdRead, reads+1); err != nil {
+			t.Fatal(err)
+		}
+		// Other requests are served while one is held.
+		c.call(CmdReadState, nil)
+		select {
+		case <-resp:
+			t.Fatal("held request was answered")
+		case <-time.After(50 * time.Millisecond):
+		}
+		close(hold)
+		select {
+		case p := <-resp:
+			if le.Uint32(p.data) != 0 {
+				t.Errorf("held read: 0x%X", le.Uint32(p.data))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("held request not answered after release")
+		}
+	})
+
+	t.Run("notification samples", func(t *testing.T) {
+		remove := s.InjectFault(Fault{Command: CmdDeviceNotification, Symbol: "MAIN.a", Drop: true})
+		if code, _ := c.addNotification(IndexGroupData, 0, 4); code != 0 {
+			t.Fatalf("add notification: 0x%X", code)
+		}
+		select {
+		case p := <-c.notes:
+			t.Errorf("suppressed sample delivered: % x", p.data)
+		case <-time.After(50 * time.Millisecond):
+		}
+		remove()
+		if err := s.SetValue("MAIN.a", 2); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, data := c.sample(); le.Uint32(data) != 2 {
+			t.Errorf("sample after remove = % x", data)
+		}
+	})
+
+	t.Run("CloseConnection", func(t *testing.T) {
+		s.InjectFault(Fault{Command: CmdRead, CloseConnection: true, Count: 1})
+		if _, err := c.request(CmdRead, le.AppendUint32(le.AppendUint32(le.AppendUint32(nil, IndexGroupData), 0), 4), 5*time.Second); err == nil || err.Error() != "connection closed" {
+			t.Errorf("read: error = %v, want the connection closed", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.WaitConnections(ctx, 0); err != nil {
+			t.Fatal(err)
+		}
+		// The fault was used up, so a new connection is served.
+		c2 := dial(t, s)
+		if code, _ := c2.read(IndexGroupData, 0, 4); code != 0 {
+			t.Errorf("read on a new connection: 0x%X", code)
+		}
+	})
+}
@@ -0,0 +1,417 @@
+package adstest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Index groups served by the mock.
+const (
+	IndexGroupData          uint32 = 0x4040 // symbol values, addressed by offset
+	IndexGroupHandleByName  uint32 = 0xF003
+	IndexGroupValueByName   uint32 = 0xF004
+	IndexGroupValueByHandle uint32 = 0xF005
+	IndexGroupReleaseHandle uint32 = 0xF006
+	IndexGroupSymVersion    uint32 = 0xF008
+	IndexGroupInfoByNameEx  uint32 = 0xF009
+	IndexGroupSymUpload     uint32 = 0xF00B
+	IndexGroupDataTypes     uint32 = 0xF00E
+	IndexGroupUploadInfo2   uint32 = 0xF00F
+	IndexGroupSumRead       uint32 = 0xF080
+	IndexGroupSumWrite      uint32 = 0xF081
+	IndexGroupSumReadWrite  uint32 = 0xF082
+	IndexGroupSumAddNotify  uint32 = 0xF085
+	IndexGroupSumDelNotify  uint32 = 0xF086
+)
+
+// ADS data type IDs (ADST_*).
+const (
+	adstInt16   = 2
+	adstInt32   = 3
+	adstReal32  = 4
+	adstReal64  = 5
+	adstInt8    = 16
+	adstUInt8   = 17
+	adstUInt16  = 18
+	adstUInt32  = 19
+	adstInt64   = 20
+	adstUInt64  = 21
+	adstString  = 30
+	adstBit     = 33
+	adstBigType = 65
+)
+
+// DataType is a struct type for AddType. Fields are laid out in order, each aligned to its
+// own size up to 8 bytes, the TwinCAT 3 default on x64 targets.
+type DataType struct {
+	Name   string
+	Fields []Field
+}
+
+// Field is one member of a DataType. Type is any type accepted by AddSymbol.
+type Field struct {
+	Name string
+	Type string
+}
+
+type typeInfo struct {
+	name   string
+	size   uint32
+	align  uint32
+	id     uint32
+	fields []fieldInfo // structs
+	elem   *typeInfo   // arrays
+	lbound int32
+	count  uint32
+}
+
+type fieldInfo struct {
+	name   string
+	offset uint32
+	typ    *typeInfo
+}
+
+var primitives = map[string]struct {
+	size uint32
+	id   uint32
+}{
+	"BOOL": {1, adstBit}, "BYTE": {1, adstUInt8}, "USINT": {1, adstUInt8}, "SINT": {1, adstInt8},
+	"WORD": {2, adstUInt16}, "UINT": {2, adstUInt16}, "INT": {2, adstInt16},
+	"DWORD": {4, adstUInt32}, "UDINT": {4, adstUInt32}, "DINT": {4, adstInt32}, "REAL": {4, adstReal32},
+	"TIME": {4, adstUInt32}, "TOD": {4, adstUInt32}, "DATE": {4, adstUInt32}, "DT": {4, adstUInt32},
+	"LWORD": {8, adstUInt64}, "ULINT": {8, adstUInt64}, "LINT": {8, adstInt64}, "LREAL": {8, adstReal64},
+}
+
+// typeOf resolves a type name: a primitive, STRING(n), ARRAY [l..u] OF T or a type added with
+// AddType. Must be called with s.mu held.
+func (s *Server) typeOf(name string) (*typeInfo, error) {
+	name = strings.TrimSpace(name)
+	upper := strings.ToUpper(name)
+	if p, ok := primitives[upper]; ok {
+		return &typeInfo{name: upper, size: p.size, align: p.size, id: p.id}, nil
+	}
+	if upper == "STRING" || strings.HasPrefix(upper, "STRING(") {
+		n := 80
+		if open := strings.IndexByte(upper, '('); open >= 0 {
+			v, err := strconv.Atoi(strings.TrimSuffix(upper[open+1:], ")"))
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("invalid string type %q", name)
+			}
+			n = v
+		}
+		return &typeInfo{name: fmt.Sprintf("STRING(%d)", n), size: uint32(n + 1), align: 1, id: adstString}, nil
+	}
+	if strings.HasPrefix(upper, "ARRAY") {
+		return s.arrayType(name)
+	}
+	if t, ok := s.types[strings.ToLower(name)]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", name)
+}
+
+// arrayType parses a one-dimensional ARRAY [l..u] OF T.
+func (s *Server) arrayType(name string) (*typeInfo, error) {
+	open, close := strings.IndexByte(name, '['), strings.IndexByte(name, ']')
+	of := strings.Index(strings.ToUpper(name), " OF ")
+	if open < 0 || close < open || of < close {
+		return nil, fmt.Errorf("invalid array type %q", name)
+	}
+	lo, hi, ok := strings.Cut(name[open+1:close], "..")
+	l, err1 := strconv.Atoi(strings.TrimSpace(lo))
+	u, err2 := strconv.Atoi(strings.TrimSpace(hi))
+	if !ok || err1 != nil || err2 != nil || u < l {
+		return nil, fmt.Errorf("invalid array bounds in %q", name)
+	}
+	elem, err := s.typeOf(name[of+4:])
+	if err != nil {
+		return nil, err
+	}
+	count := uint32(u - l + 1)
+	return &typeInfo{
+		name:   fmt.Sprintf("ARRAY [%d..%d] OF %s", l, u, elem.name),
+		size:   elem.size * count,
+		align:  elem.align,
+		id:     elem.id,
+		elem:   elem,
+		lbound: int32(l),
+		count:  count,
+	}, nil
+}
+
+// structType lays out a DataType. Must be called with s.mu held.
+func (s *Server) structType(dt DataType) (*typeInfo, error) {
+	t := &typeInfo{name: dt.Name, align: 1, id: adstBigType}
+	for _, f := range dt.Fields {
+		ft, err := s.typeOf(f.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", dt.Name, f.Name, err)
+		}
+		align := min(ft.align, 8)
+		t.size = (t.size + align - 1) / align * align
+		t.fields = append(t.fields, fieldInfo{name: f.Name, offset: t.size, typ: ft})
+		t.size += ft.size
+		t.align = max(t.align, align)
+	}
+	t.size = (t.size + t.align - 1) / t.align * t.align
+	return t, nil
+}
+
+// symbol is a variable in the data area.
+type symbol struct {
+	name   string
+	typ    *typeInfo
+	offset uint32
+	attrs  [][2]string // name and value of the symbol's attributes, in the order set
+}
+
+// ref is a resolved symbol path such as MAIN.s.values[2]: a typed range of the data area.
+type ref struct {
+	name   string
+	typ    *typeInfo
+	offset uint32
+}
+
+// resolve looks up a symbol or a member of one. Must be called with s.mu held.
+func (s *Server) resolve(path string) (ref, bool) {
+	lower := strings.ToLower(strings.TrimSpace(path))
+	for _, sym := range s.symbols {
+		prefix := strings.ToLower(sym.name)
+		if !strings.HasPrefix(lower, prefix) {
+			continue
+		}
+		rest := path[len(prefix):]
+		if rest != "" && rest[0] != '.' && rest[0] != '[' {
+			continue
+		}
+		r := ref{name: path, typ: sym.typ, offset: sym.offset}
+		if r, ok := walk(r, rest); ok {
+			return r, true
+		}
+	}
+	return ref{}, false
+}
+
+// walk follows .member and [index] selectors from r.
+func walk(r ref, rest string) (ref, bool) {
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			member := rest[:end]
+			rest = rest[end:]
+			found := false
+			for _, f := range r.typ.fields {
+				if strings.EqualFold(f.name, member) {
+					r.typ, r.offset, found = f.typ, r.offset+f.offset, true
+					break
+				}
+			}
+			if !found {
+				return r, false
+			}
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 || r.typ.elem == nil {
+				return r, false
+			}
+			i, err := strconv.Atoi(strings.TrimSpace(rest[1:end]))
+			idx := i - int(r.typ.lbound)
+			if err != nil || idx < 0 || idx >= int(r.typ.count) {
+				return r, false
+			}
+			r.offset += uint32(idx) * r.typ.elem.size
+			r.typ = r.typ.elem
+			rest = rest[end+1:]
+		default:
+			return r, false
+		}
+	}
+	return r, true
+}
+
+// encode converts v into the PLC representation of t. []byte values are used as is; strings
+// are parsed for numeric types.
+func encode(t *typeInfo, v any) ([]byte, error) {
+	if b, ok := v.([]byte); ok {
+		if uint32(len(b)) != t.size {
+			return nil, fmt.Errorf("%s needs %d bytes, got %d", t.name, t.size, len(b))
+		}
+		return append([]byte(nil), b...), nil
+	}
+	if t.id == adstString {
+		str, ok := v.(string)
+		if !ok {
+			str = fmt.Sprint(v)
+		}
+		if uint32(len(str)) >= t.size {
+			return nil, fmt.Errorf("%q exceeds %s", str, t.name)
+		}
+		buf := make([]byte, t.size)
+		copy(buf, str)
+		return buf, nil
+	}
+	if t.fields != nil || t.elem != nil {
+		return nil, fmt.Errorf("set members of %s individually or pass []byte", t.name)
+	}
+
+	var f float64
+	var i int64
+	var u uint64
+	isFloat := false
+	switch x := v.(type) {
+	case bool:
+		if x {
+			i, u, f = 1, 1, 1
+		}
+	case int:
+		i, u, f = int64(x), uint64(x), float64(x)
+	case int8:
+		i, u, f = int64(x), uint64(x), float64(x)
+	case int16:
+		i, u, f = int64(x), uint64(x), float64(x)
+	case int32:
+		i, u, f = int64(x), uint64(x), float64(x)
+	case int64:
+		i, u, f = x, uint64(x), float64(x)
+	case uint8:
+		i, u, f = int64(x), uint64(x), float64(x)
+	case uint16:
+		i, u, f = int64(x), uint64(x), float64(x)
+	case uint32:
+		i, u, f = int64(x), uint64(x), float64(x)
+	case uint64:
+		i, u, f = int64(x), x, float64(x)
+	case float32:
+		f, isFloat = float64(x), true
+	case float64:
+		f, isFloat = x, true
+	case time.Duration:
+		i, u, f = x.Milliseconds(), uint64(x.Milliseconds()), float64(x.Milliseconds())
+	case string:
+		var err error
+		if f, err = strconv.ParseFloat(x, 64); err != nil {
+			if b, berr := strconv.ParseBool(x); berr == nil && b {
+				f = 1
+			} else if berr != nil {
+				return nil, fmt.Errorf("invalid %s value %q", t.name, x)
+			}
+		}
+		isFloat = true
+	default:
+		return nil, fmt.Errorf("unsupported value type %T for %s", v, t.name)
+	}
+	if isFloat {
+		i, u = int64(f), uint64(f)
+	}
+
+	le := binary.LittleEndian
+	buf := make([]byte, t.size)
+	switch t.id {
+	case adstReal32:
+		le.PutUint32(buf, math.Float32bits(float32(f)))
+	case adstReal64:
+		le.PutUint64(buf, math.Float64bits(f))
+	case adstBit:
+		if u != 0 {
+			buf[0] = 1
+		}
+	case adstInt8, adstInt16, adstInt32, adstInt64:
+		copy(buf, le.AppendUint64(nil, uint64(i)))
+	default:
+		copy(buf, le.AppendUint64(nil, u))
+	}
+	return buf, nil
+}
+
+// symbolEntry encodes an AdsSymbolEntry.
+func symbolEntry(name string, t *typeInfo, group, offset uint32, attrs [][2]string) []byte {
+	le := binary.LittleEndian
+	var flags uint32
+	var tail []byte
+	if len(attrs) > 0 {
+		flags |= symbolFlagAttributes
+		tail = le.AppendUint16(nil, uint16(len(attrs)))
+		for _, a := range attrs {
+			tail = append(tail, byte(len(a[0])), byte(len(a[1])))
+			tail = append(append(tail, a[0]...), 0)
+			tail = append(append(tail, a[1]...), 0)
+		}
+	}
+	entryLen := 30 + len(name) + 1 + len(t.name) + 1 + 1 + len(tail)
+	buf := le.AppendUint32(nil, uint32(entryLen))
+	buf = le.AppendUint32(buf, group)
+	buf = le.AppendUint32(buf, offset)
+	buf = le.AppendUint32(buf, t.size)
+	buf = le.AppendUint32(buf, t.id)
+	buf = le.AppendUint32(buf, flags)
+	buf = le.AppendUint16(buf, uint16(len(name)))
+	buf = le.AppendUint16(buf, uint16(len(t.name)))
+	buf = le.AppendUint16(buf, 0)
+	buf = append(buf, name...)
+	buf = append(buf, 0)
+	buf = append(buf, t.name...)
+	buf = append(buf, 0, 0)
+	return append(buf, tail...)
+}
+
+// symbolFlagAttributes marks an AdsSymbolEntry followed by attribute pairs.
+const symbolFlagAttributes = 0x1000
+
+// Flags of AdsDatatypeEntry.
+const (
+	datatypeFlagDataType = 0x1
+	datatypeFlagDataItem = 0x2
+)
+
+// dataTypeEntry encodes an AdsDatatypeEntry. For a struct member, name is the member name,
+// offset its offset and flags datatypeFlagDataItem.
+func dataTypeEntry(name string, t *typeInfo, offset, flags uint32) []byte {
+	typeName := ""
+	var arrays []byte
+	var subs []byte
+	subCount := 0
+	le := binary.LittleEndian
+	switch {
+	case t.elem != nil:
+		typeName = t.elem.name
+		arrays = le.AppendUint32(arrays, uint32(t.lbound))
+		arrays = le.AppendUint32(arrays, t.count)
+	case flags == datatypeFlagDataItem:
+		typeName = t.name
+	}
+	if flags == datatypeFlagDataType {
+		for _, f := range t.fields {
+			subs = append(subs, dataTypeEntry(f.name, f.typ, f.offset, datatypeFlagDataItem)...)
+			subCount++
+		}
+	}
+	entryLen := 42 + len(name) + 1 + len(typeName) + 1 + 1 + len(arrays) + len(subs)
+	buf := le.AppendUint32(nil, uint32(entryLen))
+	buf = le.AppendUint32(buf, 1) // version
+	buf = le.AppendUint32(buf, 0) // hash
+	buf = le.AppendUint32(buf, 0) // type hash
+	buf = le.AppendUint32(buf, t.size)
+	buf = le.AppendUint32(buf, offset)
+	buf = le.AppendUint32(buf, t.id)
+	buf = le.AppendUint32(buf, flags)
+	buf = le.AppendUint16(buf, uint16(len(name)))
+	buf = le.AppendUint16(buf, uint16(len(typeName)))
+	buf = le.AppendUint16(buf, 0)
+	buf = le.AppendUint16(buf, uint16(len(arrays)/8))
+	buf = le.AppendUint16(buf, uint16(subCount))
+	buf = append(buf, name...)
+	buf = append(buf, 0)
+	buf = append(buf, typeName...)
+	buf = append(buf, 0, 0)
+	buf = append(buf, arrays...)
+	return append(buf, subs...)
+}
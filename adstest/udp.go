@@ -0,0 +1,121 @@
+package adstest
+
+import "encoding/binary"
+
+// The UDP route service, answered on Config.UDPAddr.
+const (
+	udpMagic            = 0x71146603
+	udpServiceInfo      = 1
+	udpServiceAddRoute  = 6
+	udpResponse         = 0x80000000
+	udpTagStatus        = 1
+	udpTagPassword      = 2
+	udpTagOSVersion     = 3
+	udpTagTCVersion     = 4
+	udpTagHost          = 5
+	udpTagNetID         = 7
+	udpTagRouteName     = 12
+	udpTagUsername      = 13
+	udpStatusAccessDeny = 0x704
+)
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if reply := s.udpReply(buf[:n]); reply != nil {
+			_, _ = s.udp.WriteTo(reply, addr)
+		}
+	}
+}
+
+// udpReply answers a UDP service request, or returns nil for requests it ignores.
+func (s *Server) udpReply(req []byte) []byte {
+	le := binary.LittleEndian
+	if len(req) < 24 || le.Uint32(req) != udpMagic {
+		return nil
+	}
+	invokeID, service := le.Uint32(req[4:]), le.Uint32(req[8:])
+	tags := map[uint16][]byte{}
+	pos := 24
+	for i := le.Uint32(req[20:]); i > 0 && pos+4 <= len(req); i-- {
+		id, n := le.Uint16(req[pos:]), int(le.Uint16(req[pos+2:]))
+		pos += 4
+		if pos+n > len(req) {
+			return nil
+		}
+		tags[id] = req[pos : pos+n]
+		pos += n
+	}
+
+	var out [][]byte
+	switch service {
+	case udpServiceInfo:
+		out = append(out, stringTag(udpTagHost, s.cfg.Hostname))
+		out = append(out, tag(udpTagTCVersion, le.AppendUint16([]byte{3, 1}, 4024)))
+		out = append(out, tag(udpTagOSVersion, osVersion()))
+	case udpServiceAddRoute:
+		status := uint32(0)
+		user, pass := tagString(tags[udpTagUsername]), tagString(tags[udpTagPassword])
+		if (s.cfg.RouteUsername != "" || s.cfg.RoutePassword != "") && (user != s.cfg.RouteUsername || pass != s.cfg.RoutePassword) {
+			status = udpStatusAccessDeny
+		} else if id := tags[udpTagNetID]; len(id) == 6 {
+			var nid netID
+			copy(nid[:], id)
+			s.mu.Lock()
+			s.addRoute(Route{Name: tagString(tags[udpTagRouteName]), NetID: nid.String(), Address: tagString(tags[udpTagHost])})
+			s.mu.Unlock()
+		} else {
+			status = udpStatusAccessDeny
+		}
+		out = append(out, tag(udpTagStatus, le.AppendUint32(nil, status)))
+	default:
+		return nil
+	}
+
+	buf := le.AppendUint32(nil, udpMagic)
+	buf = le.AppendUint32(buf, invokeID)
+	buf = le.AppendUint32(buf, service|udpResponse)
+	buf = append(buf, s.netID[:]...)
+	buf = le.AppendUint16(buf, systemServicePort)
+	buf = le.AppendUint32(buf, uint32(len(out)))
+	for _, t := range out {
+		buf = append(buf, t...)
+	}
+	return buf
+}
+
+func tag(id uint16, data []byte) []byte {
+	buf := binary.LittleEndian.AppendUint16(nil, id)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(data)))
+	return append(buf, data...)
+}
+
+func stringTag(id uint16, s string) []byte {
+	return tag(id, append([]byte(s), 0))
+}
+
+func tagString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// osVersion encodes an OSVERSIONINFO of Windows 10 build 17763, as TwinCAT 3 on a typical IPC
+// reports it.
+func osVersion() []byte {
+	le := binary.LittleEndian
+	buf := le.AppendUint32(nil, 22)
+	buf = le.AppendUint32(buf, 10)
+	buf = le.AppendUint32(buf, 0)
+	buf = le.AppendUint32(buf, 17763)
+	buf = le.AppendUint32(buf, 2)
+	return le.AppendUint16(buf, 0) // no service pack
+}
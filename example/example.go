@@ -8,9 +8,9 @@ import (
 	// Import full suite of FOSS connect plugins
 	_ "github.com/redpanda-data/connect/public/bundle/free/v4"
 
-	_ "github.com/RuneRoven/benthosADS"
+	"github.com/RuneRoven/benthosADS"
 )
 
 func main() {
-	service.RunCLI(context.Background())
+	service.RunCLI(context.Background(), service.CLIOptAddCommand(benthosADS.CLICommand()))
 }